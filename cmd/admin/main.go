@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/joshbarros/golang-ecommerce-api/config"
+	"github.com/joshbarros/golang-ecommerce-api/db"
+	"github.com/joshbarros/golang-ecommerce-api/service/user"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// Creates the first administrator, or promotes an existing user:
+//
+//	ADMIN_PASSWORD=... go run cmd/admin/main.go -email admin@example.com
+func main() {
+	email := flag.String("email", "", "email of the admin account")
+	firstName := flag.String("first-name", "Admin", "first name for a new account")
+	lastName := flag.String("last-name", "Admin", "last name for a new account")
+	flag.Parse()
+
+	if *email == "" {
+		log.Fatal("-email is required")
+	}
+
+	db, err := db.NewMySQLStorage(mysql.Config{
+		User:                 config.Envs.DBUser,
+		Passwd:               config.Envs.DBPassword,
+		Addr:                 config.Envs.DBAddress,
+		DBName:               config.Envs.DBName,
+		Net:                  "tcp",
+		AllowNativePasswords: true,
+		ParseTime:            true,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	// The password is read from the environment so it doesn't end up in the
+	// shell history. It is only needed when creating a new account.
+	u, err := user.CreateAdmin(user.NewStore(db), types.RegisterUserPayload{
+		FirstName: *firstName,
+		LastName:  *lastName,
+		Email:     *email,
+		Password:  os.Getenv("ADMIN_PASSWORD"),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("User %d (%s) is now an admin", u.ID, u.Email)
+}
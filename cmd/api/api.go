@@ -1,15 +1,29 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/config"
+	"github.com/joshbarros/golang-ecommerce-api/db"
+	"github.com/joshbarros/golang-ecommerce-api/mail"
+	"github.com/joshbarros/golang-ecommerce-api/service/address"
+	"github.com/joshbarros/golang-ecommerce-api/service/apikey"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
 	"github.com/joshbarros/golang-ecommerce-api/service/cart"
+	"github.com/joshbarros/golang-ecommerce-api/service/category"
+	"github.com/joshbarros/golang-ecommerce-api/service/idempotency"
+	"github.com/joshbarros/golang-ecommerce-api/service/inventory"
 	"github.com/joshbarros/golang-ecommerce-api/service/order"
 	"github.com/joshbarros/golang-ecommerce-api/service/product"
+	"github.com/joshbarros/golang-ecommerce-api/service/search"
+	"github.com/joshbarros/golang-ecommerce-api/service/token"
 	"github.com/joshbarros/golang-ecommerce-api/service/user"
+	"github.com/joshbarros/golang-ecommerce-api/types"
 )
 
 type APIServer struct {
@@ -25,23 +39,88 @@ func NewAPIServer(address string, db *sql.DB) *APIServer {
 }
 
 func (s *APIServer) Run() error {
+	keyring, err := auth.LoadKeyring()
+	if err != nil {
+		return err
+	}
+	auth.UseKeyring(keyring)
+
+	allocation, err := inventory.LoadStrategy()
+	if err != nil {
+		return err
+	}
+
 	router := mux.NewRouter()
+	router.HandleFunc("/.well-known/jwks.json", auth.HandleJWKS).Methods(http.MethodGet)
+
 	subrouter := router.PathPrefix("/api/v1").Subrouter()
 
+	txManager := db.NewTxManager(s.db, newStores)
+	idempotencyStore := idempotency.NewStore(s.db)
+	go idempotency.RunExpiredKeySweep(context.Background(), idempotencyStore, time.Hour)
+
 	userStore := user.NewStore(s.db)
-	userHandler := user.NewHandler(userStore)
+	tokenStore := token.NewStore(s.db)
+	var mailer types.Mailer = mail.NewLogMailer()
+	if config.Envs.MailFile != "" {
+		mailer = mail.NewFileMailer(config.Envs.MailFile)
+	}
+
+	userHandler := user.NewHandler(userStore, tokenStore, txManager, mailer, auth.NewDefaultLoginThrottle())
 	userHandler.RegisterRoutes(subrouter)
 
+	// Accounts whose deletion grace period has ended are erased in the
+	// background.
+	go user.RunUserErasure(context.Background(), txManager, time.Hour)
+
+	tokenHandler := token.NewHandler(txManager, userStore, tokenStore)
+	tokenHandler.RegisterRoutes(subrouter)
+
+	apiKeyStore := apikey.NewStore(s.db)
+	apiKeyHandler := apikey.NewHandler(apiKeyStore, userStore, tokenStore)
+	apiKeyHandler.RegisterRoutes(subrouter)
+
 	productStore := product.NewStore(s.db)
-	productHandler := product.NewHandler(productStore)
+	productHandler := product.NewHandler(productStore, productStore, txManager, userStore, tokenStore, apiKeyStore, search.NewMySQLIndex(s.db))
 	productHandler.RegisterRoutes(subrouter)
 
+	categoryStore := category.NewStore(s.db)
+	categoryHandler := category.NewHandler(categoryStore, productStore, txManager, userStore, tokenStore, apiKeyStore)
+	categoryHandler.RegisterRoutes(subrouter)
+
+	inventoryStore := inventory.NewStore(s.db)
+	inventoryHandler := inventory.NewHandler(inventoryStore, txManager, userStore, tokenStore, apiKeyStore)
+	inventoryHandler.RegisterRoutes(subrouter)
+
 	orderStore := order.NewStore(s.db)
+	orderHandler := order.NewHandler(orderStore, txManager, userStore, tokenStore, idempotencyStore, apiKeyStore)
+	orderHandler.RegisterRoutes(subrouter)
 
-	cartHandler := cart.NewHandler(orderStore, productStore, userStore)
+	addressStore := address.NewStore(s.db)
+	addressHandler := address.NewHandler(addressStore, txManager, userStore, tokenStore, idempotencyStore)
+	addressHandler.RegisterRoutes(subrouter)
+
+	cartHandler := cart.NewHandler(txManager, addressStore, userStore, tokenStore, idempotencyStore, allocation)
 	cartHandler.RegisterRoutes(subrouter)
 
 	log.Println("Server Listening on", s.address)
 
 	return http.ListenAndServe(s.address, router)
 }
+
+// newStores binds every store to the same executor so they can share a
+// transaction.
+func newStores(tx db.DBTX) types.Stores {
+	return types.Stores{
+		Users:      user.NewStore(tx),
+		Products:   product.NewStore(tx),
+		Variants:   product.NewStore(tx),
+		Orders:     order.NewStore(tx),
+		Addresses:  address.NewStore(tx),
+		Tokens:     token.NewStore(tx),
+		UserTokens: token.NewStore(tx),
+		TwoFactor:  user.NewStore(tx),
+		Categories: category.NewStore(tx),
+		Inventory:  inventory.NewStore(tx),
+	}
+}
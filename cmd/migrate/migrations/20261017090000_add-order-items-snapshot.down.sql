@@ -0,0 +1,4 @@
+ALTER TABLE order_items
+  DROP COLUMN `productName`,
+  DROP COLUMN `productImage`,
+  DROP COLUMN `createdAt`;
@@ -0,0 +1,10 @@
+ALTER TABLE order_items
+  ADD COLUMN `productName` VARCHAR(255) NOT NULL DEFAULT '' AFTER `productId`,
+  ADD COLUMN `productImage` VARCHAR(255) NOT NULL DEFAULT '' AFTER `productName`,
+  ADD COLUMN `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+UPDATE order_items
+  JOIN products ON products.id = order_items.productId
+SET
+  order_items.productName = products.name,
+  order_items.productImage = products.image;
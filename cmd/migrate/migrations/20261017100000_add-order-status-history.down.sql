@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS order_status_history;
+
+UPDATE orders SET `status` = 'pending' WHERE `status` IN ('paid', 'fulfilled', 'shipped');
+UPDATE orders SET `status` = 'completed' WHERE `status` = 'delivered';
+UPDATE orders SET `status` = 'cancelled' WHERE `status` = 'refunded';
+
+ALTER TABLE orders
+  MODIFY `status` ENUM ('pending', 'completed', 'cancelled') NOT NULL DEFAULT 'pending';
@@ -0,0 +1,25 @@
+ALTER TABLE orders
+  MODIFY `status` ENUM (
+    'pending',
+    'paid',
+    'fulfilled',
+    'shipped',
+    'delivered',
+    'completed',
+    'cancelled',
+    'refunded'
+  ) NOT NULL DEFAULT 'pending';
+
+CREATE TABLE
+  IF NOT EXISTS order_status_history (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `orderId` INT UNSIGNED NOT NULL,
+    `fromStatus` VARCHAR(32) NOT NULL,
+    `toStatus` VARCHAR(32) NOT NULL,
+    `changedBy` INT UNSIGNED NULL,
+    `note` VARCHAR(255) NOT NULL DEFAULT '',
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    FOREIGN KEY (`orderId`) REFERENCES orders (`id`),
+    FOREIGN KEY (`changedBy`) REFERENCES users (`id`)
+  );
@@ -0,0 +1,14 @@
+CREATE TABLE
+  IF NOT EXISTS idempotency_keys (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `userId` INT UNSIGNED NOT NULL,
+    `key` VARCHAR(255) NOT NULL,
+    `requestHash` CHAR(64) NOT NULL,
+    `statusCode` INT NOT NULL DEFAULT 0,
+    `responseBody` MEDIUMBLOB NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `expiresAt` TIMESTAMP NOT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY (`userId`, `key`),
+    FOREIGN KEY (`userId`) REFERENCES users (`id`)
+  );
@@ -0,0 +1,2 @@
+ALTER TABLE products
+  ADD COLUMN `deletedAt` TIMESTAMP NULL DEFAULT NULL;
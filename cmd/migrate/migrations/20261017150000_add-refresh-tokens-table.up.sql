@@ -0,0 +1,26 @@
+CREATE TABLE
+  IF NOT EXISTS refresh_tokens (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `userId` INT UNSIGNED NOT NULL,
+    `familyId` CHAR(32) NOT NULL,
+    `tokenHash` CHAR(64) NOT NULL,
+    `accessTokenId` CHAR(32) NOT NULL,
+    `accessExpiresAt` TIMESTAMP NOT NULL,
+    `expiresAt` TIMESTAMP NOT NULL,
+    `usedAt` TIMESTAMP NULL DEFAULT NULL,
+    `revokedAt` TIMESTAMP NULL DEFAULT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY (`tokenHash`),
+    KEY (`accessTokenId`),
+    KEY (`familyId`),
+    FOREIGN KEY (`userId`) REFERENCES users (`id`)
+  );
+
+CREATE TABLE
+  IF NOT EXISTS revoked_tokens (
+    `jti` CHAR(32) NOT NULL,
+    `expiresAt` TIMESTAMP NOT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`jti`)
+  );
@@ -0,0 +1,5 @@
+ALTER TABLE users
+  ADD COLUMN `verifiedAt` TIMESTAMP NULL DEFAULT NULL AFTER `role`;
+
+-- Accounts created before verification existed are trusted as they are.
+UPDATE users SET verifiedAt = createdAt;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS recovery_codes;
+
+ALTER TABLE users
+  DROP COLUMN `twoFactorEnabledAt`,
+  DROP COLUMN `totpLastStep`,
+  DROP COLUMN `totpSecret`;
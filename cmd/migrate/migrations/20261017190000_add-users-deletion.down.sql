@@ -0,0 +1,4 @@
+ALTER TABLE users
+  DROP KEY `deletionDueAt`,
+  DROP COLUMN `deletedAt`,
+  DROP COLUMN `deletionDueAt`;
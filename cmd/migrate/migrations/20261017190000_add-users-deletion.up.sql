@@ -0,0 +1,4 @@
+ALTER TABLE users
+  ADD COLUMN `deletionDueAt` TIMESTAMP NULL DEFAULT NULL AFTER `twoFactorEnabledAt`,
+  ADD COLUMN `deletedAt` TIMESTAMP NULL DEFAULT NULL AFTER `deletionDueAt`,
+  ADD KEY (`deletionDueAt`);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS api_keys;
+
+ALTER TABLE users
+  DROP COLUMN `serviceAccount`;
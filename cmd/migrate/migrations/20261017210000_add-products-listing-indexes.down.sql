@@ -0,0 +1,4 @@
+ALTER TABLE products
+  DROP KEY `createdAt`,
+  DROP KEY `price`,
+  DROP KEY `name`;
@@ -0,0 +1,4 @@
+ALTER TABLE products
+  ADD KEY `name` (`name`, `id`),
+  ADD KEY `price` (`price`, `id`),
+  ADD KEY `createdAt` (`createdAt`, `id`);
@@ -0,0 +1,3 @@
+ALTER TABLE products
+  DROP KEY `nameDescriptionFulltext`,
+  DROP KEY `nameFulltext`;
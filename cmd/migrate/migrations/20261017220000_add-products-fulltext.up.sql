@@ -0,0 +1,3 @@
+ALTER TABLE products
+  ADD FULLTEXT KEY `nameFulltext` (`name`),
+  ADD FULLTEXT KEY `nameDescriptionFulltext` (`name`, `description`);
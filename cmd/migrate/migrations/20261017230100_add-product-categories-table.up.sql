@@ -0,0 +1,9 @@
+CREATE TABLE
+  IF NOT EXISTS product_categories (
+    `productId` INT UNSIGNED NOT NULL,
+    `categoryId` INT UNSIGNED NOT NULL,
+    PRIMARY KEY (`productId`, `categoryId`),
+    KEY (`categoryId`),
+    FOREIGN KEY (`productId`) REFERENCES products (`id`),
+    FOREIGN KEY (`categoryId`) REFERENCES categories (`id`) ON DELETE CASCADE
+  );
@@ -0,0 +1,11 @@
+ALTER TABLE products
+  ADD COLUMN `quantity` INT UNSIGNED NOT NULL DEFAULT 0 AFTER `price`;
+
+UPDATE products
+SET
+  quantity = (
+    SELECT COALESCE(SUM(quantity), 0) FROM product_variants
+    WHERE product_variants.productId = products.id AND product_variants.deletedAt IS NULL
+  );
+
+DROP TABLE IF EXISTS product_variants;
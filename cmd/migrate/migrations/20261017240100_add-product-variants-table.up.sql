@@ -0,0 +1,23 @@
+CREATE TABLE
+  IF NOT EXISTS product_variants (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `productId` INT UNSIGNED NOT NULL,
+    `sku` VARCHAR(64) NOT NULL,
+    `price` DECIMAL(10, 2) NULL DEFAULT NULL,
+    `quantity` INT UNSIGNED NOT NULL,
+    `image` VARCHAR(255) NOT NULL DEFAULT '',
+    `options` JSON NOT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `deletedAt` TIMESTAMP NULL DEFAULT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY (`sku`),
+    KEY (`productId`),
+    FOREIGN KEY (`productId`) REFERENCES products (`id`)
+  );
+
+INSERT INTO product_variants (productId, sku, quantity, options, createdAt, deletedAt)
+SELECT id, CONCAT('P', id), quantity, JSON_OBJECT(), createdAt, deletedAt
+FROM products;
+
+ALTER TABLE products
+  DROP COLUMN `quantity`;
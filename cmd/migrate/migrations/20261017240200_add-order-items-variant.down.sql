@@ -0,0 +1,5 @@
+ALTER TABLE order_items
+  DROP FOREIGN KEY `order_items_variantId`,
+  DROP COLUMN `variantId`,
+  DROP COLUMN `sku`,
+  DROP COLUMN `options`;
@@ -0,0 +1,11 @@
+ALTER TABLE order_items
+  ADD COLUMN `variantId` INT UNSIGNED NULL DEFAULT NULL AFTER `productId`,
+  ADD COLUMN `sku` VARCHAR(64) NOT NULL DEFAULT '' AFTER `variantId`,
+  ADD COLUMN `options` JSON NULL DEFAULT NULL AFTER `productImage`,
+  ADD CONSTRAINT `order_items_variantId` FOREIGN KEY (`variantId`) REFERENCES product_variants (`id`);
+
+UPDATE order_items
+  JOIN product_variants ON product_variants.productId = order_items.productId
+SET
+  order_items.variantId = product_variants.id,
+  order_items.sku = product_variants.sku;
@@ -0,0 +1,10 @@
+CREATE TABLE
+  IF NOT EXISTS warehouses (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `code` VARCHAR(32) NOT NULL,
+    `name` VARCHAR(255) NOT NULL,
+    `priority` INT NOT NULL DEFAULT 0,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY (`code`)
+  );
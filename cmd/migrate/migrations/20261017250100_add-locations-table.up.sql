@@ -0,0 +1,11 @@
+CREATE TABLE
+  IF NOT EXISTS locations (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `warehouseId` INT UNSIGNED NOT NULL,
+    `code` VARCHAR(32) NOT NULL,
+    `name` VARCHAR(255) NOT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY (`warehouseId`, `code`),
+    FOREIGN KEY (`warehouseId`) REFERENCES warehouses (`id`)
+  );
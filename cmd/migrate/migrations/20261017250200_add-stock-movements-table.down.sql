@@ -0,0 +1,11 @@
+ALTER TABLE product_variants
+  ADD COLUMN `quantity` INT UNSIGNED NOT NULL DEFAULT 0 AFTER `price`;
+
+UPDATE product_variants
+SET
+  quantity = (
+    SELECT GREATEST(COALESCE(SUM(stock_movements.quantity), 0), 0) FROM stock_movements
+    WHERE stock_movements.variantId = product_variants.id
+  );
+
+DROP TABLE IF EXISTS stock_movements;
@@ -0,0 +1,37 @@
+CREATE TABLE
+  IF NOT EXISTS stock_movements (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `variantId` INT UNSIGNED NOT NULL,
+    `locationId` INT UNSIGNED NOT NULL,
+    `type` ENUM('receipt', 'sale', 'return', 'adjustment', 'transfer') NOT NULL,
+    `quantity` INT NOT NULL,
+    `orderId` INT UNSIGNED NULL DEFAULT NULL,
+    `note` VARCHAR(255) NOT NULL DEFAULT '',
+    `createdBy` INT UNSIGNED NULL DEFAULT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    KEY (`variantId`, `locationId`),
+    KEY (`locationId`),
+    KEY (`orderId`),
+    FOREIGN KEY (`variantId`) REFERENCES product_variants (`id`),
+    FOREIGN KEY (`locationId`) REFERENCES locations (`id`),
+    FOREIGN KEY (`orderId`) REFERENCES orders (`id`),
+    FOREIGN KEY (`createdBy`) REFERENCES users (`id`)
+  );
+
+-- Existing stock is received into a main warehouse, and from then on only
+-- changes through movements.
+INSERT INTO warehouses (code, name) VALUES ('MAIN', 'Main warehouse');
+
+INSERT INTO locations (warehouseId, code, name)
+SELECT id, 'DEFAULT', 'Default location' FROM warehouses WHERE code = 'MAIN';
+
+INSERT INTO stock_movements (variantId, locationId, type, quantity, note)
+SELECT product_variants.id, locations.id, 'receipt', product_variants.quantity, 'Opening stock'
+FROM product_variants
+  JOIN locations ON locations.code = 'DEFAULT'
+  JOIN warehouses ON warehouses.id = locations.warehouseId AND warehouses.code = 'MAIN'
+WHERE product_variants.quantity > 0;
+
+ALTER TABLE product_variants
+  DROP COLUMN `quantity`;
@@ -9,14 +9,38 @@ import (
 )
 
 type Config struct {
-	PublicHost             string
-	Port                   string
-	DBUser                 string
-	DBPassword             string
-	DBAddress              string
-	DBName                 string
-	JWTExpirationInSeconds int64
-	JWTSecret              string
+	PublicHost                               string
+	Port                                     string
+	DBUser                                   string
+	DBPassword                               string
+	DBAddress                                string
+	DBName                                   string
+	JWTExpirationInSeconds                   int64
+	JWTSecret                                string
+	JWTAlgorithm                             string
+	JWTSigningKeyFile                        string
+	JWTEphemeralKey                          bool
+	JWTVerificationKeyFiles                  string
+	RefreshTokenExpInSeconds                 int64
+	JWTIssuer                                string
+	JWTAudience                              string
+	JWTLeewayInSeconds                       int64
+	IdempotencyTTLInSeconds                  int64
+	PasswordResetURL                         string
+	PasswordResetTTLInSeconds                int64
+	EmailVerificationURL                     string
+	EmailVerificationTTLInSeconds            int64
+	EmailVerificationResendIntervalInSeconds int64
+	CheckoutRequiresVerifiedEmail            bool
+	MailFile                                 string
+	LoginMaxAttempts                         int64
+	LoginIPMaxAttempts                       int64
+	LoginLockoutInSeconds                    int64
+	TrustedProxies                           string
+	TwoFactorIssuer                          string
+	TwoFactorRequiredRoles                   string
+	AccountDeletionGracePeriodInSeconds      int64
+	InventoryAllocationStrategy              string
 }
 
 var Envs = initConfig()
@@ -25,14 +49,38 @@ func initConfig() Config {
 	godotenv.Load()
 
 	return Config{
-		PublicHost:             getEnv("PUBLIC_HOST", "http://localhost"),
-		Port:                   getEnv("PORT", "8080"),
-		DBUser:                 getEnv("DB_USER", "josuebarros1995"),
-		DBPassword:             getEnv("DB_PASSWORD", "12345678"),
-		DBAddress:              fmt.Sprintf("%s:%s", getEnv("DB_HOST", "127.0.0.1"), getEnv("DB_PORT", "3306")),
-		DBName:                 getEnv("DB_NAME", "golang-ecommerce-api"),
-		JWTExpirationInSeconds: getEnvAsInt("JWT_EXP", 3600*24*7),
-		JWTSecret:              getEnv("JWT_SECRET", "please-dont-tell-anyone"),
+		PublicHost:                               getEnv("PUBLIC_HOST", "http://localhost"),
+		Port:                                     getEnv("PORT", "8080"),
+		DBUser:                                   getEnv("DB_USER", "josuebarros1995"),
+		DBPassword:                               getEnv("DB_PASSWORD", "12345678"),
+		DBAddress:                                fmt.Sprintf("%s:%s", getEnv("DB_HOST", "127.0.0.1"), getEnv("DB_PORT", "3306")),
+		DBName:                                   getEnv("DB_NAME", "golang-ecommerce-api"),
+		JWTExpirationInSeconds:                   getEnvAsInt("JWT_EXP", 60*15),
+		RefreshTokenExpInSeconds:                 getEnvAsInt("REFRESH_TOKEN_EXP", 3600*24*30),
+		JWTSecret:                                getEnv("JWT_SECRET", "please-dont-tell-anyone"),
+		JWTAlgorithm:                             getEnv("JWT_ALGORITHM", "EdDSA"),
+		JWTSigningKeyFile:                        getEnv("JWT_SIGNING_KEY_FILE", ""),
+		JWTEphemeralKey:                          getEnvAsBool("JWT_EPHEMERAL_KEY", false),
+		JWTVerificationKeyFiles:                  getEnv("JWT_VERIFICATION_KEY_FILES", ""),
+		JWTIssuer:                                getEnv("JWT_ISSUER", "golang-ecommerce-api"),
+		JWTAudience:                              getEnv("JWT_AUDIENCE", "golang-ecommerce-api"),
+		JWTLeewayInSeconds:                       getEnvAsInt("JWT_LEEWAY", 30),
+		IdempotencyTTLInSeconds:                  getEnvAsInt("IDEMPOTENCY_TTL", 3600*24),
+		PasswordResetURL:                         getEnv("PASSWORD_RESET_URL", "http://localhost:3000/reset-password"),
+		PasswordResetTTLInSeconds:                getEnvAsInt("PASSWORD_RESET_TTL", 3600),
+		EmailVerificationURL:                     getEnv("EMAIL_VERIFICATION_URL", "http://localhost:8080/api/v1/verify-email"),
+		EmailVerificationTTLInSeconds:            getEnvAsInt("EMAIL_VERIFICATION_TTL", 3600*24),
+		EmailVerificationResendIntervalInSeconds: getEnvAsInt("EMAIL_VERIFICATION_RESEND_INTERVAL", 60),
+		CheckoutRequiresVerifiedEmail:            getEnvAsBool("CHECKOUT_REQUIRES_VERIFIED_EMAIL", false),
+		MailFile:                                 getEnv("MAIL_FILE", ""),
+		LoginMaxAttempts:                         getEnvAsInt("LOGIN_MAX_ATTEMPTS", 10),
+		LoginIPMaxAttempts:                       getEnvAsInt("LOGIN_IP_MAX_ATTEMPTS", 100),
+		LoginLockoutInSeconds:                    getEnvAsInt("LOGIN_LOCKOUT", 60*15),
+		TrustedProxies:                           getEnv("TRUSTED_PROXIES", ""),
+		TwoFactorIssuer:                          getEnv("TWO_FACTOR_ISSUER", "Golang Ecommerce"),
+		TwoFactorRequiredRoles:                   getEnv("TWO_FACTOR_REQUIRED_ROLES", ""),
+		AccountDeletionGracePeriodInSeconds:      getEnvAsInt("ACCOUNT_DELETION_GRACE_PERIOD", 3600*24*30),
+		InventoryAllocationStrategy:              getEnv("INVENTORY_ALLOCATION_STRATEGY", "priority"),
 	}
 }
 
@@ -55,3 +103,15 @@ func getEnvAsInt(key string, fallback int64) int64 {
 
 	return fallback
 }
+
+func getEnvAsBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fallback
+		}
+		return b
+	}
+
+	return fallback
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// DBTX is satisfied by both *sql.DB and *sql.Tx, so stores built on it can run
+// either directly against the pool or inside a transaction.
+type DBTX interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type TxManager struct {
+	db        *sql.DB
+	newStores func(DBTX) types.Stores
+}
+
+// NewTxManager returns a TxManager that builds a fresh set of stores bound to
+// each transaction it opens.
+func NewTxManager(db *sql.DB, newStores func(DBTX) types.Stores) *TxManager {
+	return &TxManager{db: db, newStores: newStores}
+}
+
+// WithTx runs fn inside a transaction. The transaction is committed if fn
+// returns nil and rolled back if it returns an error or panics.
+func (m *TxManager) WithTx(ctx context.Context, fn func(types.Stores) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(m.newStores(tx)); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
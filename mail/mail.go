@@ -0,0 +1,96 @@
+// Package mail holds the types.Mailer implementations.
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// LogMailer logs every email instead of sending it, for when no mail server
+// is configured. Only the recipient and subject are logged: the body carries
+// single-use links, such as password resets, that must not end up in logs.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Send(ctx context.Context, email types.Email) error {
+	log.Printf("Mail to %s: %s (body withheld, set MAIL_FILE to keep it)", email.To, email.Subject)
+	return nil
+}
+
+// FileMailer appends every email to a file, so links can be copied out of it
+// during local development.
+type FileMailer struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileMailer(path string) *FileMailer {
+	return &FileMailer{path: path}
+}
+
+func (m *FileMailer) Send(ctx context.Context, email types.Email) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(f, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), email.To, email.Subject, email.Body)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// MemoryMailer keeps every email in memory. It is meant for tests.
+type MemoryMailer struct {
+	mu   sync.Mutex
+	sent []types.Email
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(ctx context.Context, email types.Email) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, email)
+	return nil
+}
+
+// Sent returns the emails sent so far, oldest first.
+func (m *MemoryMailer) Sent() []types.Email {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]types.Email(nil), m.sent...)
+}
+
+// Last returns the most recent email sent to the address, if any.
+func (m *MemoryMailer) Last(to string) (types.Email, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].To == to {
+			return m.sent[i], true
+		}
+	}
+
+	return types.Email{}, false
+}
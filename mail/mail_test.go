@@ -0,0 +1,71 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+func TestLogMailerWithholdsTheBody(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	err := NewLogMailer().Send(context.Background(), types.Email{To: "jane@example.com", Subject: "Hello", Body: "Link: http://localhost?token=abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if logged := buf.String(); !strings.Contains(logged, "jane@example.com") || strings.Contains(logged, "token=abc") {
+		t.Errorf("Expected the recipient but not the body to be logged, got %q", logged)
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	mailer := NewFileMailer(path)
+
+	for _, to := range []string{"jane@example.com", "john@example.com"} {
+		err := mailer.Send(context.Background(), types.Email{To: to, Subject: "Hello", Body: "Link: http://localhost?token=abc"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"To: jane@example.com", "To: john@example.com", "Subject: Hello", "token=abc"} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("Expected the file to contain %q, got %q", expected, data)
+		}
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	mailer := NewMemoryMailer()
+
+	mailer.Send(context.Background(), types.Email{To: "jane@example.com", Subject: "First"})
+	mailer.Send(context.Background(), types.Email{To: "john@example.com", Subject: "Second"})
+	mailer.Send(context.Background(), types.Email{To: "jane@example.com", Subject: "Third"})
+
+	if sent := mailer.Sent(); len(sent) != 3 || sent[0].Subject != "First" {
+		t.Errorf("Expected 3 emails in order, got %+v", sent)
+	}
+
+	if email, ok := mailer.Last("jane@example.com"); !ok || email.Subject != "Third" {
+		t.Errorf("Expected the latest email to jane, got %+v", email)
+	}
+
+	if _, ok := mailer.Last("nobody@example.com"); ok {
+		t.Error("Expected no email to nobody")
+	}
+}
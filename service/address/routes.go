@@ -0,0 +1,200 @@
+package address
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/service/idempotency"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+	"github.com/joshbarros/golang-ecommerce-api/utils"
+)
+
+type Handler struct {
+	store            types.AddressStore
+	txManager        types.TxManager
+	userStore        types.UserStore
+	tokenStore       types.TokenStore
+	idempotencyStore types.IdempotencyStore
+}
+
+func NewHandler(store types.AddressStore, txManager types.TxManager, userStore types.UserStore, tokenStore types.TokenStore, idempotencyStore types.IdempotencyStore) *Handler {
+	return &Handler{
+		store:            store,
+		txManager:        txManager,
+		userStore:        userStore,
+		tokenStore:       tokenStore,
+		idempotencyStore: idempotencyStore,
+	}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/me/addresses", auth.WithJWTAuth(h.handleGetAddresses, h.userStore, h.tokenStore)).Methods(http.MethodGet)
+	router.HandleFunc("/me/addresses", auth.WithJWTAuth(idempotency.WithIdempotencyKey(h.handleCreateAddress, h.idempotencyStore), h.userStore, h.tokenStore)).Methods(http.MethodPost)
+	router.HandleFunc("/me/addresses/{id}", auth.WithJWTAuth(h.handleGetAddress, h.userStore, h.tokenStore)).Methods(http.MethodGet)
+	router.HandleFunc("/me/addresses/{id}", auth.WithJWTAuth(h.handleUpdateAddress, h.userStore, h.tokenStore)).Methods(http.MethodPut)
+	router.HandleFunc("/me/addresses/{id}", auth.WithJWTAuth(h.handleDeleteAddress, h.userStore, h.tokenStore)).Methods(http.MethodDelete)
+}
+
+func (h *Handler) handleGetAddresses(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	addresses, err := h.store.GetAddressesByUserID(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, addresses)
+}
+
+func (h *Handler) handleCreateAddress(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	payload, ok := parseAddressPayload(w, r)
+	if !ok {
+		return
+	}
+
+	address := payload.ToAddress(userID)
+
+	err := h.txManager.WithTx(r.Context(), func(stores types.Stores) error {
+		// The first address a user saves becomes their default for both.
+		existing, err := stores.Addresses.GetAddressesByUserID(userID)
+		if err != nil {
+			return err
+		}
+		if len(existing) == 0 {
+			address.IsDefaultShipping = true
+			address.IsDefaultBilling = true
+		}
+
+		return saveAddress(stores.Addresses, &address)
+	})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, address)
+}
+
+func (h *Handler) handleGetAddress(w http.ResponseWriter, r *http.Request) {
+	address, ok := h.getOwnedAddress(w, r)
+	if !ok {
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, address)
+}
+
+func (h *Handler) handleUpdateAddress(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.getOwnedAddress(w, r)
+	if !ok {
+		return
+	}
+
+	payload, ok := parseAddressPayload(w, r)
+	if !ok {
+		return
+	}
+
+	address := payload.ToAddress(existing.UserID)
+	address.ID = existing.ID
+	address.CreatedAt = existing.CreatedAt
+
+	err := h.txManager.WithTx(r.Context(), func(stores types.Stores) error {
+		return saveAddress(stores.Addresses, &address)
+	})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, address)
+}
+
+func (h *Handler) handleDeleteAddress(w http.ResponseWriter, r *http.Request) {
+	address, ok := h.getOwnedAddress(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.DeleteAddress(address.ID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getOwnedAddress loads the address named in the URL, writing a 404 if it
+// does not exist or belongs to another user.
+func (h *Handler) getOwnedAddress(w http.ResponseWriter, r *http.Request) (*types.Address, bool) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid address ID"))
+		return nil, false
+	}
+
+	address, err := h.store.GetAddressByID(id)
+	if errors.Is(err, types.ErrAddressNotFound) {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return nil, false
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	if address.UserID != userID {
+		utils.WriteError(w, http.StatusNotFound, types.ErrAddressNotFound)
+		return nil, false
+	}
+
+	return address, true
+}
+
+func parseAddressPayload(w http.ResponseWriter, r *http.Request) (types.AddressPayload, bool) {
+	var payload types.AddressPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return payload, false
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid payload %v", errors))
+		return payload, false
+	}
+
+	return payload, true
+}
+
+// saveAddress creates or updates an address, first clearing the user's other
+// defaults so each user keeps at most one default per kind.
+func saveAddress(store types.AddressStore, address *types.Address) error {
+	if address.IsDefaultShipping {
+		if err := store.ClearDefaultShipping(address.UserID); err != nil {
+			return err
+		}
+	}
+
+	if address.IsDefaultBilling {
+		if err := store.ClearDefaultBilling(address.UserID); err != nil {
+			return err
+		}
+	}
+
+	if address.ID == 0 {
+		return store.CreateAddress(address)
+	}
+
+	return store.UpdateAddress(*address)
+}
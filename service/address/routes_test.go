@@ -0,0 +1,232 @@
+package address
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// Mock implementation of the AddressStore interface
+type mockAddressStore struct {
+	addresses map[int]types.Address
+	nextID    int
+}
+
+func (m *mockAddressStore) GetAddressesByUserID(userID int) ([]types.Address, error) {
+	result := []types.Address{}
+	for _, a := range m.addresses {
+		if a.UserID == userID {
+			result = append(result, a)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+func (m *mockAddressStore) GetAddressByID(id int) (*types.Address, error) {
+	if a, ok := m.addresses[id]; ok {
+		return &a, nil
+	}
+	return nil, types.ErrAddressNotFound
+}
+
+func (m *mockAddressStore) CreateAddress(address *types.Address) error {
+	m.nextID++
+	address.ID = m.nextID
+	m.addresses[address.ID] = *address
+	return nil
+}
+
+func (m *mockAddressStore) UpdateAddress(address types.Address) error {
+	m.addresses[address.ID] = address
+	return nil
+}
+
+func (m *mockAddressStore) DeleteAddress(id int) error {
+	delete(m.addresses, id)
+	return nil
+}
+
+func (m *mockAddressStore) ClearDefaultShipping(userID int) error {
+	for id, a := range m.addresses {
+		if a.UserID == userID {
+			a.IsDefaultShipping = false
+			m.addresses[id] = a
+		}
+	}
+	return nil
+}
+
+func (m *mockAddressStore) ClearDefaultBilling(userID int) error {
+	for id, a := range m.addresses {
+		if a.UserID == userID {
+			a.IsDefaultBilling = false
+			m.addresses[id] = a
+		}
+	}
+	return nil
+}
+
+// Mock TxManager running the unit of work directly against the mock store
+type mockTxManager struct {
+	addresses *mockAddressStore
+}
+
+func (m *mockTxManager) WithTx(ctx context.Context, fn func(types.Stores) error) error {
+	return fn(types.Stores{Addresses: m.addresses})
+}
+
+func serve(t *testing.T, method string, path string, pattern string, handler http.HandlerFunc, payload any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	if payload != nil {
+		json.NewEncoder(&body).Encode(payload)
+	}
+
+	req, err := http.NewRequest(method, path, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(context.WithValue(req.Context(), auth.UserKey, 1))
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc(pattern, handler)
+	router.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func TestAddressServiceHandlers(t *testing.T) {
+	store := &mockAddressStore{addresses: map[int]types.Address{}}
+	handler := NewHandler(store, &mockTxManager{addresses: store}, nil, nil, nil)
+
+	home := types.AddressPayload{
+		Name:       "Jane Doe",
+		Line1:      "10 Rua Augusta",
+		City:       "Lisbon",
+		PostalCode: "1100-053",
+		Country:    "PT",
+	}
+
+	t.Run("Should make the first address the default for both", func(t *testing.T) {
+		rr := serve(t, http.MethodPost, "/me/addresses", "/me/addresses", handler.handleCreateAddress, home)
+
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+		}
+
+		var address types.Address
+		if err := json.NewDecoder(rr.Body).Decode(&address); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+		if address.ID != 1 || address.UserID != 1 || !address.IsDefaultShipping || !address.IsDefaultBilling {
+			t.Errorf("Unexpected address %+v", address)
+		}
+	})
+
+	t.Run("Should move the default shipping address", func(t *testing.T) {
+		work := home
+		work.Name = "Work"
+		work.IsDefaultShipping = true
+
+		rr := serve(t, http.MethodPost, "/me/addresses", "/me/addresses", handler.handleCreateAddress, work)
+
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+		if store.addresses[1].IsDefaultShipping || !store.addresses[1].IsDefaultBilling {
+			t.Errorf("Expected address 1 to stay default for billing only, got %+v", store.addresses[1])
+		}
+		if !store.addresses[2].IsDefaultShipping || store.addresses[2].IsDefaultBilling {
+			t.Errorf("Expected address 2 to be default for shipping only, got %+v", store.addresses[2])
+		}
+	})
+
+	t.Run("Should fail if the address is invalid", func(t *testing.T) {
+		invalid := home
+		invalid.Country = "Portugal"
+
+		rr := serve(t, http.MethodPost, "/me/addresses", "/me/addresses", handler.handleCreateAddress, invalid)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("Should list only the caller's addresses", func(t *testing.T) {
+		store.addresses[99] = types.Address{ID: 99, UserID: 2, Name: "Other"}
+		defer delete(store.addresses, 99)
+
+		rr := serve(t, http.MethodGet, "/me/addresses", "/me/addresses", handler.handleGetAddresses, nil)
+
+		var addresses []types.Address
+		if err := json.NewDecoder(rr.Body).Decode(&addresses); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+		if len(addresses) != 2 {
+			t.Errorf("Expected 2 addresses, got %d", len(addresses))
+		}
+	})
+
+	t.Run("Should update an address", func(t *testing.T) {
+		updated := home
+		updated.City = "Porto"
+
+		rr := serve(t, http.MethodPut, "/me/addresses/1", "/me/addresses/{id}", handler.handleUpdateAddress, updated)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if store.addresses[1].City != "Porto" || store.addresses[1].UserID != 1 {
+			t.Errorf("Unexpected address %+v", store.addresses[1])
+		}
+	})
+
+	t.Run("Should not expose another user's address", func(t *testing.T) {
+		store.addresses[99] = types.Address{ID: 99, UserID: 2, Name: "Other"}
+		defer delete(store.addresses, 99)
+
+		for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+			var h http.HandlerFunc
+			switch method {
+			case http.MethodGet:
+				h = handler.handleGetAddress
+			case http.MethodPut:
+				h = handler.handleUpdateAddress
+			case http.MethodDelete:
+				h = handler.handleDeleteAddress
+			}
+
+			rr := serve(t, method, "/me/addresses/99", "/me/addresses/{id}", h, home)
+
+			if rr.Code != http.StatusNotFound {
+				t.Errorf("%s: expected status code %d, got %d", method, http.StatusNotFound, rr.Code)
+			}
+		}
+
+		if store.addresses[99].Name != "Other" {
+			t.Error("Expected the address to be left untouched")
+		}
+	})
+
+	t.Run("Should delete an address", func(t *testing.T) {
+		rr := serve(t, http.MethodDelete, "/me/addresses/2", "/me/addresses/{id}", handler.handleDeleteAddress, nil)
+
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("Expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+		if _, ok := store.addresses[2]; ok {
+			t.Error("Expected the address to be deleted")
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package address
+
+import (
+	"database/sql"
+
+	"github.com/joshbarros/golang-ecommerce-api/db"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+type Store struct {
+	db db.DBTX
+}
+
+func NewStore(db db.DBTX) *Store {
+	return &Store{db: db}
+}
+
+const addressColumns = "id, userId, name, line1, line2, city, region, postalCode, country, isDefaultShipping, isDefaultBilling, createdAt"
+
+func (s *Store) GetAddressesByUserID(userID int) ([]types.Address, error) {
+	rows, err := s.db.Query("SELECT "+addressColumns+" FROM addresses WHERE userId = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := make([]types.Address, 0)
+	for rows.Next() {
+		a, err := scanRowIntoAddress(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		addresses = append(addresses, *a)
+	}
+
+	return addresses, rows.Err()
+}
+
+func (s *Store) GetAddressByID(id int) (*types.Address, error) {
+	rows, err := s.db.Query("SELECT "+addressColumns+" FROM addresses WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, types.ErrAddressNotFound
+	}
+
+	return scanRowIntoAddress(rows)
+}
+
+func (s *Store) CreateAddress(address *types.Address) error {
+	res, err := s.db.Exec(
+		"INSERT INTO addresses (userId, name, line1, line2, city, region, postalCode, country, isDefaultShipping, isDefaultBilling) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		address.UserID, address.Name, address.Line1, address.Line2, address.City, address.Region,
+		address.PostalCode, address.Country, address.IsDefaultShipping, address.IsDefaultBilling,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	address.ID = int(id)
+	return nil
+}
+
+func (s *Store) UpdateAddress(address types.Address) error {
+	_, err := s.db.Exec(
+		"UPDATE addresses SET name = ?, line1 = ?, line2 = ?, city = ?, region = ?, postalCode = ?, country = ?, isDefaultShipping = ?, isDefaultBilling = ? WHERE id = ?",
+		address.Name, address.Line1, address.Line2, address.City, address.Region, address.PostalCode,
+		address.Country, address.IsDefaultShipping, address.IsDefaultBilling, address.ID,
+	)
+	return err
+}
+
+func (s *Store) DeleteAddress(id int) error {
+	_, err := s.db.Exec("DELETE FROM addresses WHERE id = ?", id)
+	return err
+}
+
+func (s *Store) ClearDefaultShipping(userID int) error {
+	_, err := s.db.Exec("UPDATE addresses SET isDefaultShipping = FALSE WHERE userId = ?", userID)
+	return err
+}
+
+func (s *Store) ClearDefaultBilling(userID int) error {
+	_, err := s.db.Exec("UPDATE addresses SET isDefaultBilling = FALSE WHERE userId = ?", userID)
+	return err
+}
+
+func scanRowIntoAddress(rows *sql.Rows) (*types.Address, error) {
+	address := new(types.Address)
+
+	err := rows.Scan(
+		&address.ID,
+		&address.UserID,
+		&address.Name,
+		&address.Line1,
+		&address.Line2,
+		&address.City,
+		&address.Region,
+		&address.PostalCode,
+		&address.Country,
+		&address.IsDefaultShipping,
+		&address.IsDefaultBilling,
+		&address.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return address, nil
+}
@@ -0,0 +1,237 @@
+package apikey
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+	"github.com/joshbarros/golang-ecommerce-api/utils"
+)
+
+type Handler struct {
+	store      types.APIKeyStore
+	userStore  types.UserStore
+	tokenStore types.TokenStore
+}
+
+func NewHandler(store types.APIKeyStore, userStore types.UserStore, tokenStore types.TokenStore) *Handler {
+	return &Handler{store: store, userStore: userStore, tokenStore: tokenStore}
+}
+
+// RegisterRoutes registers the key management endpoints. They need a login:
+// an API key cannot be used to create or revoke keys. Creating a key goes
+// through RequireRole so the two-factor policy applies to it.
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api-keys", auth.WithJWTAuth(auth.RequireRole(h.handleCreateAPIKey, types.RoleCustomer, types.RoleStaff, types.RoleAdmin), h.userStore, h.tokenStore)).Methods(http.MethodPost)
+	router.HandleFunc("/api-keys", auth.WithJWTAuth(h.handleGetAPIKeys, h.userStore, h.tokenStore)).Methods(http.MethodGet)
+	router.HandleFunc("/api-keys/{id}", auth.WithJWTAuth(h.handleRevokeAPIKey, h.userStore, h.tokenStore)).Methods(http.MethodDelete)
+	router.HandleFunc("/service-accounts", auth.WithJWTAuth(auth.RequireRole(h.handleCreateServiceAccount, types.RoleAdmin), h.userStore, h.tokenStore)).Methods(http.MethodPost)
+}
+
+// handleCreateAPIKey creates a key for the caller. Admins may also create
+// keys for service accounts, but not for other people, as a key would let
+// them act as its owner. The key itself is only ever shown in this response.
+func (h *Handler) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	role := auth.GetRoleFromContext(r.Context())
+
+	var payload types.CreateAPIKeyPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid payload %v", errors))
+		return
+	}
+
+	for _, scope := range payload.Scopes {
+		if !scope.IsValid() {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid scope %q", scope))
+			return
+		}
+	}
+
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Expiry must be in the future"))
+		return
+	}
+
+	ownerID, ownerRole := userID, role
+	if payload.UserID != 0 && payload.UserID != userID {
+		if role != types.RoleAdmin {
+			utils.WriteError(w, http.StatusForbidden, fmt.Errorf("Permission denied"))
+			return
+		}
+
+		owner, err := h.userStore.GetUserByID(payload.UserID)
+		if err != nil || owner.DeletedAt != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("User %d not found", payload.UserID))
+			return
+		}
+		if !owner.ServiceAccount {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("User %d is not a service account", payload.UserID))
+			return
+		}
+		ownerID, ownerRole = owner.ID, owner.Role
+	}
+
+	for _, scope := range payload.Scopes {
+		if !ownerRole.AllowsScope(scope) {
+			utils.WriteError(w, http.StatusForbidden, fmt.Errorf("The %s scope is not available to the %s role", scope, ownerRole))
+			return
+		}
+	}
+
+	key, prefix, hash, err := auth.NewAPIKey()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	k := types.APIKey{
+		UserID:    ownerID,
+		Name:      payload.Name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    payload.Scopes,
+		ExpiresAt: payload.ExpiresAt,
+	}
+	if err := h.store.CreateAPIKey(&k); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, types.CreatedAPIKey{APIKey: k, Key: key})
+}
+
+// handleGetAPIKeys lists the caller's keys, or with ?userID= another user's
+// keys for admins.
+func (h *Handler) handleGetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	role := auth.GetRoleFromContext(r.Context())
+
+	ownerID := userID
+	if v := r.URL.Query().Get("userID"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid user ID"))
+			return
+		}
+
+		if id != userID && role != types.RoleAdmin {
+			utils.WriteError(w, http.StatusForbidden, fmt.Errorf("Permission denied"))
+			return
+		}
+		ownerID = id
+	}
+
+	keys, err := h.store.GetAPIKeysByUserID(ownerID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, keys)
+}
+
+func (h *Handler) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	role := auth.GetRoleFromContext(r.Context())
+
+	keyID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid API key ID"))
+		return
+	}
+
+	k, err := h.store.GetAPIKeyByID(keyID)
+	if errors.Is(err, types.ErrAPIKeyNotFound) {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Someone else's key is reported as missing so IDs can't be probed.
+	if k.UserID != userID && role != types.RoleAdmin {
+		utils.WriteError(w, http.StatusNotFound, types.ErrAPIKeyNotFound)
+		return
+	}
+
+	if err := h.store.RevokeAPIKey(k.ID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCreateServiceAccount creates a user for an integration to own API
+// keys. It is marked as a service account, so it can neither log in nor be
+// given a password through a reset.
+func (h *Handler) handleCreateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	var payload types.CreateServiceAccountPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid payload %v", errors))
+		return
+	}
+
+	if !payload.Role.IsValid() {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid role %q", payload.Role))
+		return
+	}
+
+	if _, err := h.userStore.GetUserByEmail(payload.Email); err == nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("User with email %s already exists", payload.Email))
+		return
+	}
+
+	err := h.userStore.CreateUser(types.User{
+		FirstName:      payload.Name,
+		LastName:       "Service Account",
+		Email:          payload.Email,
+		Role:           payload.Role,
+		ServiceAccount: true,
+	})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	u, err := h.userStore.GetUserByEmail(payload.Email)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// The email is chosen by an admin, so there is nothing to verify.
+	if err := h.userStore.MarkUserVerified(u.ID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	u, err = h.userStore.GetUserByID(u.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, u)
+}
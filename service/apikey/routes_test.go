@@ -0,0 +1,276 @@
+package apikey
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// Mock implementation of the APIKeyStore interface with in-memory data
+type mockAPIKeyStore struct {
+	keys []*types.APIKey
+}
+
+func (m *mockAPIKeyStore) CreateAPIKey(k *types.APIKey) error {
+	k.ID = len(m.keys) + 1
+	k.CreatedAt = time.Now()
+	m.keys = append(m.keys, k)
+	return nil
+}
+
+func (m *mockAPIKeyStore) GetAPIKeyByHash(hash string) (*types.APIKey, error) {
+	for _, k := range m.keys {
+		if k.KeyHash == hash {
+			return k, nil
+		}
+	}
+	return nil, types.ErrAPIKeyNotFound
+}
+
+func (m *mockAPIKeyStore) GetAPIKeyByID(id int) (*types.APIKey, error) {
+	for _, k := range m.keys {
+		if k.ID == id {
+			return k, nil
+		}
+	}
+	return nil, types.ErrAPIKeyNotFound
+}
+
+func (m *mockAPIKeyStore) GetAPIKeysByUserID(userID int) ([]types.APIKey, error) {
+	keys := []types.APIKey{}
+	for _, k := range m.keys {
+		if k.UserID == userID {
+			keys = append(keys, *k)
+		}
+	}
+	return keys, nil
+}
+
+func (m *mockAPIKeyStore) RevokeAPIKey(id int) error {
+	k, err := m.GetAPIKeyByID(id)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	k.RevokedAt = &now
+	return nil
+}
+
+func (m *mockAPIKeyStore) TouchAPIKey(id int) error {
+	return nil
+}
+
+// Mock implementation of the UserStore interface with in-memory data
+type mockUserStore struct {
+	users map[int]*types.User
+}
+
+func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+	for _, u := range m.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, errors.New("user not found")
+}
+
+func (m *mockUserStore) GetUserByID(id int) (*types.User, error) {
+	if u, ok := m.users[id]; ok {
+		return u, nil
+	}
+	return nil, errors.New("user not found")
+}
+
+func (m *mockUserStore) CreateUser(user types.User) error {
+	user.ID = len(m.users) + 1
+	m.users[user.ID] = &user
+	return nil
+}
+
+func (m *mockUserStore) UpdateUserRole(userID int, role types.Role) error {
+	return nil
+}
+
+func (m *mockUserStore) UpdatePassword(userID int, password string) error {
+	return nil
+}
+
+func (m *mockUserStore) MarkUserVerified(userID int) error {
+	now := time.Now()
+	m.users[userID].VerifiedAt = &now
+	return nil
+}
+
+func (m *mockUserStore) UpdateProfile(userID int, firstName string, lastName string) error {
+	return nil
+}
+
+func (m *mockUserStore) UpdateEmail(userID int, email string) error {
+	return nil
+}
+
+func (m *mockUserStore) ScheduleDeletion(userID int, dueAt time.Time) error {
+	return nil
+}
+
+func (m *mockUserStore) CancelDeletion(userID int) error {
+	return nil
+}
+
+func (m *mockUserStore) GetUsersDueForDeletion(now time.Time) ([]types.User, error) {
+	return nil, nil
+}
+
+func (m *mockUserStore) AnonymizeUser(userID int) error {
+	return nil
+}
+
+func TestAPIKeyHandlers(t *testing.T) {
+	userStore := &mockUserStore{users: map[int]*types.User{
+		1: {ID: 1, Email: "admin@example.com", Role: types.RoleAdmin},
+		2: {ID: 2, Email: "jane@example.com", Role: types.RoleCustomer},
+	}}
+	apiKeyStore := &mockAPIKeyStore{}
+	handler := NewHandler(apiKeyStore, userStore, nil)
+
+	as := func(userID int, role types.Role, h http.HandlerFunc, method string, path string, payload any) *httptest.ResponseRecorder {
+		marshalled, _ := json.Marshal(payload)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(marshalled))
+		ctx := context.WithValue(req.Context(), auth.UserKey, userID)
+		ctx = context.WithValue(ctx, auth.RoleKey, role)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		router.HandleFunc("/api-keys", h)
+		router.HandleFunc("/api-keys/{id}", h)
+		router.HandleFunc("/service-accounts", h)
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Should create a key and only show it once", func(t *testing.T) {
+		rr := as(2, types.RoleCustomer, handler.handleCreateAPIKey, http.MethodPost, "/api-keys", types.CreateAPIKeyPayload{
+			Name:   "Reporting",
+			Scopes: []types.Scope{types.ScopeOrdersRead},
+		})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+		}
+
+		var created types.CreatedAPIKey
+		if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+		if created.UserID != 2 || created.Key == "" || created.Prefix != created.Key[:len(created.Prefix)] {
+			t.Errorf("Unexpected key %+v", created)
+		}
+		if apiKeyStore.keys[0].KeyHash != auth.HashOpaqueToken(created.Key) {
+			t.Error("Expected only the hash of the key to be stored")
+		}
+
+		rr = as(2, types.RoleCustomer, handler.handleGetAPIKeys, http.MethodGet, "/api-keys", nil)
+		if bytes.Contains(rr.Body.Bytes(), []byte(created.Key)) {
+			t.Error("Expected the key to be left out of the listing")
+		}
+
+		var keys []types.APIKey
+		if err := json.NewDecoder(rr.Body).Decode(&keys); err != nil || len(keys) != 1 {
+			t.Errorf("Expected 1 key, got %s", rr.Body.String())
+		}
+	})
+
+	t.Run("Should fail if a scope is unknown", func(t *testing.T) {
+		rr := as(2, types.RoleCustomer, handler.handleCreateAPIKey, http.MethodPost, "/api-keys", types.CreateAPIKeyPayload{
+			Name:   "Everything",
+			Scopes: []types.Scope{"*"},
+		})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("Should fail if the owner's role does not allow a scope", func(t *testing.T) {
+		rr := as(2, types.RoleCustomer, handler.handleCreateAPIKey, http.MethodPost, "/api-keys", types.CreateAPIKeyPayload{
+			Name:   "Catalog",
+			Scopes: []types.Scope{types.ScopeOrdersRead, types.ScopeProductsWrite},
+		})
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	t.Run("Should not let admins create keys for people", func(t *testing.T) {
+		rr := as(1, types.RoleAdmin, handler.handleCreateAPIKey, http.MethodPost, "/api-keys", types.CreateAPIKeyPayload{
+			Name:   "Impersonation",
+			Scopes: []types.Scope{types.ScopeOrdersRead},
+			UserID: 2,
+		})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+		if keys, _ := apiKeyStore.GetAPIKeysByUserID(2); len(keys) != 1 {
+			t.Errorf("Expected no key to be created for the customer, got %d keys", len(keys))
+		}
+	})
+
+	t.Run("Should only let admins create keys for other users", func(t *testing.T) {
+		payload := types.CreateAPIKeyPayload{Name: "Warehouse", Scopes: []types.Scope{types.ScopeProductsWrite}, UserID: 1}
+		if rr := as(2, types.RoleCustomer, handler.handleCreateAPIKey, http.MethodPost, "/api-keys", payload); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+
+		rr := as(1, types.RoleAdmin, handler.handleCreateServiceAccount, http.MethodPost, "/service-accounts", types.CreateServiceAccountPayload{
+			Name:  "Warehouse",
+			Email: "warehouse@example.com",
+			Role:  types.RoleAdmin,
+		})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+		}
+
+		var account types.User
+		if err := json.NewDecoder(rr.Body).Decode(&account); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+		if !account.ServiceAccount || account.VerifiedAt == nil || userStore.users[account.ID].Password != "" {
+			t.Errorf("Expected a verified service account without a password, got %+v", userStore.users[account.ID])
+		}
+
+		payload.UserID = account.ID
+		if rr := as(1, types.RoleAdmin, handler.handleCreateAPIKey, http.MethodPost, "/api-keys", payload); rr.Code != http.StatusCreated {
+			t.Errorf("Expected status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+
+		keys, _ := apiKeyStore.GetAPIKeysByUserID(account.ID)
+		if len(keys) != 1 {
+			t.Errorf("Expected the service account to own 1 key, got %d", len(keys))
+		}
+	})
+
+	t.Run("Should only let the owner or an admin revoke a key", func(t *testing.T) {
+		if rr := as(3, types.RoleCustomer, handler.handleRevokeAPIKey, http.MethodDelete, "/api-keys/1", nil); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+
+		if rr := as(2, types.RoleCustomer, handler.handleRevokeAPIKey, http.MethodDelete, "/api-keys/1", nil); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+		if apiKeyStore.keys[0].RevokedAt == nil {
+			t.Error("Expected the key to be revoked")
+		}
+
+		if rr := as(1, types.RoleAdmin, handler.handleRevokeAPIKey, http.MethodDelete, "/api-keys/2", nil); rr.Code != http.StatusNoContent {
+			t.Errorf("Expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+	})
+}
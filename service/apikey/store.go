@@ -0,0 +1,146 @@
+package apikey
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/joshbarros/golang-ecommerce-api/db"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+type Store struct {
+	db db.DBTX
+}
+
+func NewStore(db db.DBTX) *Store {
+	return &Store{db: db}
+}
+
+const apiKeyColumns = "id, userId, name, prefix, keyHash, scopes, lastUsedAt, expiresAt, revokedAt, createdAt"
+
+// CreateAPIKey stores a key. createdAt is set from the application clock so
+// it can be returned without reading the key back.
+func (s *Store) CreateAPIKey(k *types.APIKey) error {
+	if k.CreatedAt.IsZero() {
+		k.CreatedAt = time.Now()
+	}
+
+	res, err := s.db.Exec(
+		"INSERT INTO api_keys (userId, name, prefix, keyHash, scopes, expiresAt, createdAt) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		k.UserID, k.Name, k.Prefix, k.KeyHash, joinScopes(k.Scopes), k.ExpiresAt, k.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	k.ID = int(id)
+	return nil
+}
+
+func (s *Store) GetAPIKeyByHash(hash string) (*types.APIKey, error) {
+	return s.getAPIKey("SELECT "+apiKeyColumns+" FROM api_keys WHERE keyHash = ?", hash)
+}
+
+func (s *Store) GetAPIKeyByID(id int) (*types.APIKey, error) {
+	return s.getAPIKey("SELECT "+apiKeyColumns+" FROM api_keys WHERE id = ?", id)
+}
+
+func (s *Store) getAPIKey(query string, arg any) (*types.APIKey, error) {
+	rows, err := s.db.Query(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, types.ErrAPIKeyNotFound
+	}
+
+	return scanRowIntoAPIKey(rows)
+}
+
+func (s *Store) GetAPIKeysByUserID(userID int) ([]types.APIKey, error) {
+	rows, err := s.db.Query("SELECT "+apiKeyColumns+" FROM api_keys WHERE userId = ? ORDER BY createdAt DESC, id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []types.APIKey{}
+	for rows.Next() {
+		k, err := scanRowIntoAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+
+	return keys, rows.Err()
+}
+
+func (s *Store) RevokeAPIKey(id int) error {
+	_, err := s.db.Exec("UPDATE api_keys SET revokedAt = CURRENT_TIMESTAMP WHERE id = ? AND revokedAt IS NULL", id)
+	return err
+}
+
+// TouchAPIKey updates lastUsedAt at most once a minute, so busy integrations
+// do not write on every request.
+func (s *Store) TouchAPIKey(id int) error {
+	_, err := s.db.Exec(
+		"UPDATE api_keys SET lastUsedAt = CURRENT_TIMESTAMP WHERE id = ? AND (lastUsedAt IS NULL OR lastUsedAt < CURRENT_TIMESTAMP - INTERVAL 1 MINUTE)",
+		id,
+	)
+	return err
+}
+
+func scanRowIntoAPIKey(rows *sql.Rows) (*types.APIKey, error) {
+	k := new(types.APIKey)
+
+	var scopes string
+	err := rows.Scan(
+		&k.ID,
+		&k.UserID,
+		&k.Name,
+		&k.Prefix,
+		&k.KeyHash,
+		&scopes,
+		&k.LastUsedAt,
+		&k.ExpiresAt,
+		&k.RevokedAt,
+		&k.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	k.Scopes = splitScopes(scopes)
+	return k, nil
+}
+
+// Scopes are stored comma-separated.
+func joinScopes(scopes []types.Scope) string {
+	s := make([]string, len(scopes))
+	for i, scope := range scopes {
+		s[i] = string(scope)
+	}
+	return strings.Join(s, ",")
+}
+
+func splitScopes(s string) []types.Scope {
+	scopes := []types.Scope{}
+	for _, scope := range strings.Split(s, ",") {
+		if scope != "" {
+			scopes = append(scopes, types.Scope(scope))
+		}
+	}
+	return scopes
+}
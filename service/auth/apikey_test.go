@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joshbarros/golang-ecommerce-api/config"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// Mock implementation of the APIKeyStore interface, recording key uses
+type mockAPIKeyStore struct {
+	keys    map[int]*types.APIKey
+	touched []int
+}
+
+func (m *mockAPIKeyStore) CreateAPIKey(k *types.APIKey) error {
+	k.ID = len(m.keys) + 1
+	m.keys[k.ID] = k
+	return nil
+}
+
+func (m *mockAPIKeyStore) GetAPIKeyByHash(hash string) (*types.APIKey, error) {
+	for _, k := range m.keys {
+		if k.KeyHash == hash {
+			return k, nil
+		}
+	}
+	return nil, types.ErrAPIKeyNotFound
+}
+
+func (m *mockAPIKeyStore) GetAPIKeyByID(id int) (*types.APIKey, error) {
+	if k, exists := m.keys[id]; exists {
+		return k, nil
+	}
+	return nil, types.ErrAPIKeyNotFound
+}
+
+func (m *mockAPIKeyStore) GetAPIKeysByUserID(userID int) ([]types.APIKey, error) {
+	return nil, nil
+}
+
+func (m *mockAPIKeyStore) RevokeAPIKey(id int) error {
+	now := time.Now()
+	m.keys[id].RevokedAt = &now
+	return nil
+}
+
+func (m *mockAPIKeyStore) TouchAPIKey(id int) error {
+	m.touched = append(m.touched, id)
+	return nil
+}
+
+func TestNewAPIKey(t *testing.T) {
+	key, prefix, hash, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(key, "eck_") || !strings.HasPrefix(key, prefix) || len(prefix) != 12 {
+		t.Errorf("Unexpected key %q with prefix %q", key, prefix)
+	}
+	if hash != HashOpaqueToken(key) {
+		t.Error("Expected the hash of the key")
+	}
+}
+
+func TestWithJWTOrAPIKeyAuth(t *testing.T) {
+	config.Envs.JWTSecret = "secret"
+	config.Envs.TwoFactorRequiredRoles = "admin"
+	defer func() { config.Envs.TwoFactorRequiredRoles = "" }()
+
+	// Service accounts cannot enroll in two-factor authentication, so their
+	// keys must get through the policy, unlike those of users without it.
+	now := time.Now()
+	userStore := &mockUserStore{
+		users: map[int]*types.User{
+			1: {ID: 1, Email: "warehouse@example.com", Role: types.RoleAdmin, ServiceAccount: true},
+			2: {ID: 2, Email: "gone@example.com", Role: types.RoleAdmin, DeletedAt: &now},
+			3: {ID: 3, Email: "admin@example.com", Role: types.RoleAdmin, TwoFactorEnabledAt: &now},
+			4: {ID: 4, Email: "promoted@example.com", Role: types.RoleAdmin},
+		},
+	}
+	apiKeyStore := &mockAPIKeyStore{keys: map[int]*types.APIKey{}}
+
+	newKey := func(userID int, scopes ...types.Scope) (string, *types.APIKey) {
+		key, prefix, hash, err := NewAPIKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		k := &types.APIKey{UserID: userID, Prefix: prefix, KeyHash: hash, Scopes: scopes}
+		apiKeyStore.CreateAPIKey(k)
+		return key, k
+	}
+
+	var gotUserID int
+	var gotKey *types.APIKey
+	handler := WithJWTOrAPIKeyAuth(RequireRole(func(w http.ResponseWriter, r *http.Request) {
+		gotUserID = GetUserIDFromContext(r.Context())
+		gotKey = GetAPIKeyFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}, types.RoleAdmin), types.ScopeProductsWrite, userStore, newMockTokenStore(), apiKeyStore)
+
+	do := func(header string, value string) int {
+		req := httptest.NewRequest(http.MethodPost, "/products", nil)
+		if value != "" {
+			req.Header.Set(header, value)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	t.Run("Should act as the owner of a key with the scope", func(t *testing.T) {
+		key, k := newKey(1, types.ScopeOrdersRead, types.ScopeProductsWrite)
+
+		if code := do("X-API-Key", key); code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, code)
+		}
+		if gotUserID != 1 || gotKey == nil || gotKey.ID != k.ID {
+			t.Errorf("Expected user 1 with key %d, got user %d with %+v", k.ID, gotUserID, gotKey)
+		}
+		if len(apiKeyStore.touched) != 1 || apiKeyStore.touched[0] != k.ID {
+			t.Errorf("Expected the use to be recorded, got %v", apiKeyStore.touched)
+		}
+	})
+
+	t.Run("Should follow the two-factor policy of the key's owner", func(t *testing.T) {
+		enrolled, _ := newKey(3, types.ScopeProductsWrite)
+		if code := do("X-API-Key", enrolled); code != http.StatusOK {
+			t.Errorf("Expected the key of an admin with two-factor authentication to pass, got %d", code)
+		}
+
+		promoted, _ := newKey(4, types.ScopeProductsWrite)
+		if code := do("X-API-Key", promoted); code != http.StatusForbidden {
+			t.Errorf("Expected the key of an admin without two-factor authentication to fail with %d, got %d", http.StatusForbidden, code)
+		}
+	})
+
+	t.Run("Should reject a key without the scope", func(t *testing.T) {
+		key, _ := newKey(1, types.ScopeOrdersRead)
+
+		if code := do("X-API-Key", key); code != http.StatusForbidden {
+			t.Errorf("Expected status code %d, got %d", http.StatusForbidden, code)
+		}
+	})
+
+	t.Run("Should reject unknown, revoked and expired keys", func(t *testing.T) {
+		revoked, k := newKey(1, types.ScopeProductsWrite)
+		apiKeyStore.RevokeAPIKey(k.ID)
+
+		expired, k := newKey(1, types.ScopeProductsWrite)
+		expiresAt := time.Now().Add(-time.Minute)
+		k.ExpiresAt = &expiresAt
+
+		deleted, _ := newKey(2, types.ScopeProductsWrite)
+
+		for name, key := range map[string]string{"unknown": "eck_unknown", "revoked": revoked, "expired": expired, "deleted owner": deleted} {
+			if code := do("X-API-Key", key); code != http.StatusForbidden {
+				t.Errorf("Expected the %s key to fail with %d, got %d", name, http.StatusForbidden, code)
+			}
+		}
+	})
+
+	t.Run("Should fall back to the JWT", func(t *testing.T) {
+		token, _ := CreateJWT(3, types.RoleAdmin)
+
+		if code := do("Authorization", token); code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, code)
+		}
+		if gotUserID != 3 || gotKey != nil {
+			t.Errorf("Expected user 3 without a key, got user %d with %+v", gotUserID, gotKey)
+		}
+		if code := do("Authorization", "Bearer "+token); code != http.StatusOK {
+			t.Errorf("Expected a Bearer token to pass with %d, got %d", http.StatusOK, code)
+		}
+		if code := do("Authorization", ""); code != http.StatusForbidden {
+			t.Errorf("Expected status code %d, got %d", http.StatusForbidden, code)
+		}
+	})
+}
@@ -2,13 +2,16 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/joshbarros/golang-ecommerce-api/config"
 	"github.com/joshbarros/golang-ecommerce-api/types"
 	"github.com/joshbarros/golang-ecommerce-api/utils"
@@ -16,46 +19,100 @@ import (
 
 type contextKey = string
 
-const UserKey contextKey = "userID"
+const (
+	UserKey      contextKey = "userID"
+	RoleKey      contextKey = "role"
+	ClaimsKey    contextKey = "claims"
+	VerifiedKey  contextKey = "verified"
+	TwoFactorKey contextKey = "twoFactor"
+	APIKeyKey    contextKey = "apiKey"
+)
+
+// Claims are the claims of an access token. The user ID is carried in the
+// standard sub claim.
+type Claims struct {
+	Role types.Role `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// UserID returns the user the token was issued to.
+func (c *Claims) UserID() (int, error) {
+	userID, err := strconv.Atoi(c.Subject)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid subject %q", c.Subject)
+	}
 
-func CreateJWT(secret []byte, userID int) (string, error) {
+	return userID, nil
+}
+
+// CreateJWT signs an access token with the active keyring.
+func CreateJWT(userID int, role types.Role) (string, error) {
+	token, _, err := createJWT(userID, role)
+	return token, err
+}
+
+func createJWT(userID int, role types.Role) (string, *Claims, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
 	expiration := time.Second * time.Duration(config.Envs.JWTExpirationInSeconds)
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userID":    strconv.Itoa(userID),
-		"expiredAt": time.Now().Add(expiration).Unix(),
-	})
+	claims := &Claims{
+		Role: role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    config.Envs.JWTIssuer,
+			Subject:   strconv.Itoa(userID),
+			Audience:  jwt.ClaimStrings{config.Envs.JWTAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiration)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        jti,
+		},
+	}
 
-	tokenString, err := token.SignedString(secret)
+	tokenString, err := currentKeyring().sign(claims)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return tokenString, nil
+	return tokenString, claims, nil
 }
 
-func WithJWTAuth(handlerFunc http.HandlerFunc, store types.UserStore) http.HandlerFunc {
+// WithJWTAuth only lets requests with a valid access token through, and
+// rejects tokens revoked by logging out.
+func WithJWTAuth(handlerFunc http.HandlerFunc, store types.UserStore, tokenStore types.TokenStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tokenString := getTokenFromRequest(r)
 
-		token, err := validateToken(tokenString)
-
+		claims, err := validateToken(tokenString)
 		if err != nil {
 			log.Printf("Failed to validate token: %v", err)
 			permissionDenied(w)
 			return
 		}
 
-		if !token.Valid {
-			log.Println("Invalid token")
+		userID, err := claims.UserID()
+		if err != nil {
+			log.Printf("Failed to validate token: %v", err)
 			permissionDenied(w)
 			return
 		}
 
-		claims := token.Claims.(jwt.MapClaims)
-		str := claims["userID"].(string)
+		revoked, err := tokenStore.IsAccessTokenRevoked(claims.ID)
+		if err != nil {
+			log.Printf("Failed to check token revocation: %v", err)
+			permissionDenied(w)
+			return
+		}
 
-		userID, _ := strconv.Atoi(str)
+		if revoked {
+			log.Printf("Revoked token %s used by user %d", claims.ID, userID)
+			permissionDenied(w)
+			return
+		}
 
 		u, err := store.GetUserByID(userID)
 		if err != nil {
@@ -64,19 +121,87 @@ func WithJWTAuth(handlerFunc http.HandlerFunc, store types.UserStore) http.Handl
 			return
 		}
 
+		if u.DeletedAt != nil {
+			log.Printf("Token of deleted user %d used", userID)
+			permissionDenied(w)
+			return
+		}
+
+		// The role is read from the database rather than the token, so a
+		// demotion takes effect without waiting for the token to expire.
 		ctx := r.Context()
 		ctx = context.WithValue(ctx, UserKey, u.ID)
+		ctx = context.WithValue(ctx, RoleKey, u.Role)
+		ctx = context.WithValue(ctx, ClaimsKey, claims)
+		ctx = context.WithValue(ctx, VerifiedKey, u.VerifiedAt != nil)
+		ctx = context.WithValue(ctx, TwoFactorKey, u.TwoFactorEnabledAt != nil)
 		r = r.WithContext(ctx)
 
 		handlerFunc(w, r)
 	}
 }
 
+// RequireRole only lets users with one of the given roles through. Roles
+// listed in TWO_FACTOR_REQUIRED_ROLES must also have two-factor
+// authentication enabled. It must run inside WithJWTAuth so the user's role is
+// known.
+func RequireRole(handlerFunc http.HandlerFunc, roles ...types.Role) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role := GetRoleFromContext(r.Context())
+
+		for _, allowed := range roles {
+			if role != allowed {
+				continue
+			}
+
+			if twoFactorRequired(role) && !HasTwoFactorFromContext(r.Context()) {
+				log.Printf("User %d with role %q needs two-factor authentication for %s", GetUserIDFromContext(r.Context()), role, r.URL.Path)
+				utils.WriteError(w, http.StatusForbidden, fmt.Errorf("Two-factor authentication must be enabled for this account"))
+				return
+			}
+
+			handlerFunc(w, r)
+			return
+		}
+
+		log.Printf("User %d with role %q denied access to %s", GetUserIDFromContext(r.Context()), role, r.URL.Path)
+		permissionDenied(w)
+	}
+}
+
+// RequireVerifiedEmail only lets users who verified their email through. It
+// must run inside WithJWTAuth.
+func RequireVerifiedEmail(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !IsVerifiedFromContext(r.Context()) {
+			utils.WriteError(w, http.StatusForbidden, fmt.Errorf("Please verify your email first"))
+			return
+		}
+
+		handlerFunc(w, r)
+	}
+}
+
+// twoFactorRequired reports whether TWO_FACTOR_REQUIRED_ROLES lists role.
+func twoFactorRequired(role types.Role) bool {
+	for _, r := range strings.Split(config.Envs.TwoFactorRequiredRoles, ",") {
+		if types.Role(strings.TrimSpace(r)) == role {
+			return true
+		}
+	}
+	return false
+}
+
+// getTokenFromRequest returns the token of an Authorization header, with or
+// without its Bearer scheme, or else of the token query parameter.
 func getTokenFromRequest(r *http.Request) string {
 	tokenAuth := r.Header.Get("Authorization")
 	tokenQuery := r.URL.Query().Get("token")
 
 	if tokenAuth != "" {
+		if scheme, token, ok := strings.Cut(tokenAuth, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
 		return tokenAuth
 	}
 
@@ -87,14 +212,53 @@ func getTokenFromRequest(r *http.Request) string {
 	return ""
 }
 
-func validateToken(t string) (*jwt.Token, error) {
-	return jwt.Parse(t, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", t.Header["alg"])
-		}
+// validateToken checks the signature against the keyring and every registered claim: exp, nbf and
+// iat against the current time with JWT_LEEWAY of clock skew, iss and aud
+// against the configured values, and that sub and jti are set.
+func validateToken(t string) (*Claims, error) {
+	claims := new(Claims)
+
+	keyring := currentKeyring()
+
+	token, err := jwt.ParseWithClaims(t, claims, keyring.keyFunc,
+		jwt.WithValidMethods(keyring.methods()),
+		jwt.WithIssuer(config.Envs.JWTIssuer),
+		jwt.WithAudience(config.Envs.JWTAudience),
+		jwt.WithLeeway(time.Second*time.Duration(config.Envs.JWTLeewayInSeconds)),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, fmt.Errorf("Invalid token")
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("Token has no subject")
+	}
 
-		return []byte(config.Envs.JWTSecret), nil
-	})
+	if claims.ID == "" {
+		return nil, fmt.Errorf("Token has no ID")
+	}
+
+	if claims.NotBefore == nil || claims.IssuedAt == nil {
+		return nil, fmt.Errorf("Token has no nbf or iat claim")
+	}
+
+	return claims, nil
+}
+
+// newTokenID returns a random token ID for the jti claim.
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
 }
 
 func permissionDenied(w http.ResponseWriter) {
@@ -109,3 +273,33 @@ func GetUserIDFromContext(ctx context.Context) int {
 
 	return userID
 }
+
+func GetRoleFromContext(ctx context.Context) types.Role {
+	role, ok := ctx.Value(RoleKey).(types.Role)
+	if !ok {
+		return ""
+	}
+
+	return role
+}
+
+// GetClaimsFromContext returns the claims of the access token that
+// authenticated the request, or nil outside WithJWTAuth.
+func GetClaimsFromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(ClaimsKey).(*Claims)
+	return claims
+}
+
+// IsVerifiedFromContext reports whether the authenticated user has verified
+// their email.
+func IsVerifiedFromContext(ctx context.Context) bool {
+	verified, _ := ctx.Value(VerifiedKey).(bool)
+	return verified
+}
+
+// HasTwoFactorFromContext reports whether the authenticated user has
+// two-factor authentication enabled.
+func HasTwoFactorFromContext(ctx context.Context) bool {
+	enabled, _ := ctx.Value(TwoFactorKey).(bool)
+	return enabled
+}
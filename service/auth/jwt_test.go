@@ -5,10 +5,10 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
-	"strconv"
 	"testing"
+	"time"
 
-	"github.com/golang-jwt/jwt"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/joshbarros/golang-ecommerce-api/config"
 	"github.com/joshbarros/golang-ecommerce-api/types"
 )
@@ -42,12 +42,51 @@ func (m *mockUserStore) CreateUser(user types.User) error {
 	return nil
 }
 
+func (m *mockUserStore) UpdateUserRole(userID int, role types.Role) error {
+	if user, exists := m.users[userID]; exists {
+		user.Role = role
+		return nil
+	}
+	return errors.New("user not found")
+}
+
+func (m *mockUserStore) UpdatePassword(userID int, password string) error {
+	return nil
+}
+
+func (m *mockUserStore) MarkUserVerified(userID int) error {
+	return nil
+}
+
+func (m *mockUserStore) UpdateProfile(userID int, firstName string, lastName string) error {
+	return nil
+}
+
+func (m *mockUserStore) UpdateEmail(userID int, email string) error {
+	return nil
+}
+
+func (m *mockUserStore) ScheduleDeletion(userID int, dueAt time.Time) error {
+	return nil
+}
+
+func (m *mockUserStore) CancelDeletion(userID int) error {
+	return nil
+}
+
+func (m *mockUserStore) GetUsersDueForDeletion(now time.Time) ([]types.User, error) {
+	return nil, nil
+}
+
+func (m *mockUserStore) AnonymizeUser(userID int) error {
+	return nil
+}
+
 func TestCreateJWT(t *testing.T) {
-	secret := []byte("secret")
 	config.Envs.JWTSecret = "secret"          // Set the secret in the config
 	config.Envs.JWTExpirationInSeconds = 3600 // 1 hour for testing
 
-	token, err := CreateJWT(secret, 1)
+	token, err := CreateJWT(1, types.RoleCustomer)
 
 	if err != nil {
 		t.Errorf("Error creating JWT: %v", err)
@@ -57,29 +96,121 @@ func TestCreateJWT(t *testing.T) {
 		t.Error("Expected token to be not empty")
 	}
 
-	parsedToken, err := validateToken(token)
+	claims, err := validateToken(token)
 	if err != nil {
-		t.Errorf("Error validating JWT: %v", err)
+		t.Fatalf("Error validating JWT: %v", err)
 	}
 
-	if !parsedToken.Valid {
-		t.Error("Expected token to be valid")
+	userID, err := claims.UserID()
+	if err != nil || userID != 1 {
+		t.Errorf("Expected userID to be 1, got %d", userID)
 	}
 
-	claims, ok := parsedToken.Claims.(jwt.MapClaims)
-	if !ok || !parsedToken.Valid {
-		t.Error("Expected valid claims")
+	if claims.Issuer != config.Envs.JWTIssuer || claims.ID == "" || claims.Role != types.RoleCustomer {
+		t.Errorf("Unexpected claims %+v", claims)
 	}
 
-	userID, err := strconv.Atoi(claims["userID"].(string))
-	if err != nil || userID != 1 {
-		t.Errorf("Expected userID to be 1, got %d", userID)
+	if got := claims.ExpiresAt.Sub(claims.IssuedAt.Time); got != time.Hour {
+		t.Errorf("Expected the token to expire after an hour, got %v", got)
 	}
 }
 
-func TestWithJWTAuth(t *testing.T) {
+// signClaims signs claims derived from a valid set, changed by modify.
+func signClaims(t *testing.T, method jwt.SigningMethod, key any, modify func(*Claims)) string {
+	t.Helper()
+
+	now := time.Now()
+	claims := Claims{
+		Role: types.RoleCustomer,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    config.Envs.JWTIssuer,
+			Subject:   "1",
+			Audience:  jwt.ClaimStrings{config.Envs.JWTAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        "test-jti",
+		},
+	}
+	if modify != nil {
+		modify(&claims)
+	}
+
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestValidateToken(t *testing.T) {
+	config.Envs.JWTSecret = "secret"
+	config.Envs.JWTIssuer = "test-issuer"
+	config.Envs.JWTAudience = "test-audience"
+	config.Envs.JWTLeewayInSeconds = 30
+
 	secret := []byte("secret")
-	config.Envs.JWTSecret = string(secret)
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		token  string
+		wantOK bool
+	}{
+		{"Valid", signClaims(t, jwt.SigningMethodHS256, secret, nil), true},
+		{"Expired", signClaims(t, jwt.SigningMethodHS256, secret, func(c *Claims) {
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+		}), false},
+		{"Expired within the leeway", signClaims(t, jwt.SigningMethodHS256, secret, func(c *Claims) {
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-10 * time.Second))
+		}), true},
+		{"Not yet valid", signClaims(t, jwt.SigningMethodHS256, secret, func(c *Claims) {
+			c.NotBefore = jwt.NewNumericDate(now.Add(time.Minute))
+		}), false},
+		{"Not yet valid within the leeway", signClaims(t, jwt.SigningMethodHS256, secret, func(c *Claims) {
+			c.NotBefore = jwt.NewNumericDate(now.Add(10 * time.Second))
+			c.IssuedAt = jwt.NewNumericDate(now.Add(10 * time.Second))
+		}), true},
+		{"Issued in the future", signClaims(t, jwt.SigningMethodHS256, secret, func(c *Claims) {
+			c.IssuedAt = jwt.NewNumericDate(now.Add(time.Minute))
+		}), false},
+		{"Wrong audience", signClaims(t, jwt.SigningMethodHS256, secret, func(c *Claims) {
+			c.Audience = jwt.ClaimStrings{"another-service"}
+		}), false},
+		{"Wrong issuer", signClaims(t, jwt.SigningMethodHS256, secret, func(c *Claims) {
+			c.Issuer = "someone-else"
+		}), false},
+		{"Missing expiry", signClaims(t, jwt.SigningMethodHS256, secret, func(c *Claims) {
+			c.ExpiresAt = nil
+		}), false},
+		{"Missing not before", signClaims(t, jwt.SigningMethodHS256, secret, func(c *Claims) {
+			c.NotBefore = nil
+		}), false},
+		{"Missing subject", signClaims(t, jwt.SigningMethodHS256, secret, func(c *Claims) {
+			c.Subject = ""
+		}), false},
+		{"Missing token ID", signClaims(t, jwt.SigningMethodHS256, secret, func(c *Claims) {
+			c.ID = ""
+		}), false},
+		{"Wrong secret", signClaims(t, jwt.SigningMethodHS256, []byte("other"), nil), false},
+		{"Other HMAC algorithm", signClaims(t, jwt.SigningMethodHS512, secret, nil), false},
+		{"Unsigned", signClaims(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, nil), false},
+	}
+
+	for _, tt := range tests {
+		_, err := validateToken(tt.token)
+		if tt.wantOK && err != nil {
+			t.Errorf("%s: expected the token to be valid, got %v", tt.name, err)
+		}
+		if !tt.wantOK && err == nil {
+			t.Errorf("%s: expected the token to be rejected", tt.name)
+		}
+	}
+}
+
+func TestWithJWTAuth(t *testing.T) {
+	config.Envs.JWTSecret = "secret"
 
 	mockStore := &mockUserStore{
 		users: map[int]*types.User{
@@ -88,7 +219,7 @@ func TestWithJWTAuth(t *testing.T) {
 	}
 
 	// Create a valid JWT for testing
-	token, _ := CreateJWT(secret, 1)
+	token, _ := CreateJWT(1, types.RoleCustomer)
 
 	tests := []struct {
 		name           string
@@ -109,7 +240,7 @@ func TestWithJWTAuth(t *testing.T) {
 		rr := httptest.NewRecorder()
 		handler := WithJWTAuth(func(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusOK)
-		}, mockStore)
+		}, mockStore, newMockTokenStore())
 
 		handler.ServeHTTP(rr, req)
 
@@ -119,6 +250,151 @@ func TestWithJWTAuth(t *testing.T) {
 	}
 }
 
+func TestWithJWTAuthRejectsRevokedTokens(t *testing.T) {
+	config.Envs.JWTSecret = "secret"
+
+	mockStore := &mockUserStore{
+		users: map[int]*types.User{
+			1: {ID: 1, Email: "user@example.com", Role: types.RoleCustomer},
+		},
+	}
+	tokenStore := newMockTokenStore()
+
+	handler := WithJWTAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, mockStore, tokenStore)
+
+	token, _ := CreateJWT(1, types.RoleCustomer)
+	claims, _ := validateToken(token)
+	tokenStore.RevokeAccessToken(claims.ID, claims.ExpiresAt.Time)
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", token)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rr.Code)
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	config.Envs.JWTSecret = "secret"
+
+	mockStore := &mockUserStore{
+		users: map[int]*types.User{
+			1: {ID: 1, Email: "customer@example.com", Role: types.RoleCustomer},
+			2: {ID: 2, Email: "staff@example.com", Role: types.RoleStaff},
+			3: {ID: 3, Email: "admin@example.com", Role: types.RoleAdmin},
+		},
+	}
+
+	handler := WithJWTAuth(RequireRole(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, types.RoleStaff, types.RoleAdmin), mockStore, newMockTokenStore())
+
+	tests := []struct {
+		name           string
+		userID         int
+		expectedStatus int
+	}{
+		{"Customer", 1, http.StatusForbidden},
+		{"Staff", 2, http.StatusOK},
+		{"Admin", 3, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		token, _ := CreateJWT(tt.userID, mockStore.users[tt.userID].Role)
+
+		req := httptest.NewRequest("POST", "/test", nil)
+		req.Header.Set("Authorization", token)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.expectedStatus {
+			t.Errorf("%s: expected status code %d, got %d", tt.name, tt.expectedStatus, rr.Code)
+		}
+	}
+
+	t.Run("Should use the current role rather than the one in the token", func(t *testing.T) {
+		token, _ := CreateJWT(1, types.RoleAdmin)
+
+		req := httptest.NewRequest("POST", "/test", nil)
+		req.Header.Set("Authorization", token)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	t.Run("Should require two-factor authentication for the configured roles", func(t *testing.T) {
+		config.Envs.TwoFactorRequiredRoles = "staff, admin"
+		defer func() { config.Envs.TwoFactorRequiredRoles = "" }()
+
+		enabledAt := time.Now()
+		mockStore.users[3].TwoFactorEnabledAt = &enabledAt
+		defer func() { mockStore.users[3].TwoFactorEnabledAt = nil }()
+
+		for userID, expectedStatus := range map[int]int{2: http.StatusForbidden, 3: http.StatusOK} {
+			token, _ := CreateJWT(userID, mockStore.users[userID].Role)
+
+			req := httptest.NewRequest("POST", "/test", nil)
+			req.Header.Set("Authorization", token)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != expectedStatus {
+				t.Errorf("User %d: expected status code %d, got %d", userID, expectedStatus, rr.Code)
+			}
+		}
+	})
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	config.Envs.JWTSecret = "secret"
+
+	verifiedAt := time.Now()
+	mockStore := &mockUserStore{
+		users: map[int]*types.User{
+			1: {ID: 1, Email: "unverified@example.com", Role: types.RoleCustomer},
+			2: {ID: 2, Email: "verified@example.com", Role: types.RoleCustomer, VerifiedAt: &verifiedAt},
+		},
+	}
+
+	handler := WithJWTAuth(RequireVerifiedEmail(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), mockStore, newMockTokenStore())
+
+	tests := []struct {
+		name           string
+		userID         int
+		expectedStatus int
+	}{
+		{"Unverified", 1, http.StatusForbidden},
+		{"Verified", 2, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		token, _ := CreateJWT(tt.userID, types.RoleCustomer)
+
+		req := httptest.NewRequest("POST", "/test", nil)
+		req.Header.Set("Authorization", token)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.expectedStatus {
+			t.Errorf("%s: expected status code %d, got %d", tt.name, tt.expectedStatus, rr.Code)
+		}
+	}
+}
+
 func TestGetUserIDFromContext(t *testing.T) {
 	ctx := context.WithValue(context.Background(), UserKey, 1)
 	userID := GetUserIDFromContext(ctx)
@@ -143,7 +419,9 @@ func TestGetTokenFromRequest(t *testing.T) {
 		queryParam    string
 		expectedToken string
 	}{
-		{"Token in Authorization Header", "Bearer testtoken", "", "Bearer testtoken"},
+		{"Token in Authorization Header", "testtoken", "", "testtoken"},
+		{"Bearer Token in Authorization Header", "Bearer testtoken", "", "testtoken"},
+		{"Lowercase Bearer Scheme", "bearer testtoken", "", "testtoken"},
 		{"Token in Query Parameter", "", "testtoken", "testtoken"},
 		{"No Token Provided", "", "", ""},
 	}
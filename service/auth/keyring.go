@@ -0,0 +1,391 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/joshbarros/golang-ecommerce-api/config"
+	"github.com/joshbarros/golang-ecommerce-api/utils"
+)
+
+// Keyring holds the key access tokens are signed with and every key they are
+// still accepted with.
+//
+// To rotate an asymmetric key, make the new key the signing key and list the
+// old one in JWT_VERIFICATION_KEY_FILES until the tokens it signed have
+// expired (JWT_EXP plus JWT_LEEWAY), then drop it. Keys are told apart by the
+// kid header, which is the key's RFC 7638 thumbprint.
+type Keyring struct {
+	signing      signingKey
+	verification map[string]verificationKey
+}
+
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	key    any
+}
+
+type verificationKey struct {
+	method jwt.SigningMethod
+	key    any
+}
+
+// NewHMACKeyring signs and verifies HS256 tokens with a shared secret. Tokens
+// carry no kid.
+func NewHMACKeyring(secret []byte) *Keyring {
+	return &Keyring{
+		signing: signingKey{method: jwt.SigningMethodHS256, key: secret},
+		verification: map[string]verificationKey{
+			"": {method: jwt.SigningMethodHS256, key: secret},
+		},
+	}
+}
+
+// NewKeyring signs with an RSA (RS256) or Ed25519 (EdDSA) private key. The
+// signing key is always accepted for verification, along with any previous
+// public keys still in rotation.
+func NewKeyring(signer crypto.Signer, previous ...crypto.PublicKey) (*Keyring, error) {
+	method, err := methodForKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	kid, err := thumbprint(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	k := &Keyring{
+		signing:      signingKey{kid: kid, method: method, key: signer},
+		verification: map[string]verificationKey{},
+	}
+
+	for _, pub := range append([]crypto.PublicKey{signer.Public()}, previous...) {
+		method, err := methodForKey(pub)
+		if err != nil {
+			return nil, err
+		}
+
+		kid, err := thumbprint(pub)
+		if err != nil {
+			return nil, err
+		}
+
+		k.verification[kid] = verificationKey{method: method, key: pub}
+	}
+
+	return k, nil
+}
+
+// LoadKeyring builds the keyring described by the JWT_* settings. With
+// JWT_ALGORITHM=HS256 tokens are signed with JWT_SECRET. Otherwise the
+// signing key is read from JWT_SIGNING_KEY_FILE. A throwaway key is only
+// generated when JWT_EPHEMERAL_KEY is set for development, since its tokens
+// do not survive a restart and are rejected by other replicas.
+func LoadKeyring() (*Keyring, error) {
+	algorithm := config.Envs.JWTAlgorithm
+
+	if algorithm == jwt.SigningMethodHS256.Alg() {
+		return NewHMACKeyring([]byte(config.Envs.JWTSecret)), nil
+	}
+
+	if algorithm != jwt.SigningMethodRS256.Alg() && algorithm != jwt.SigningMethodEdDSA.Alg() {
+		return nil, fmt.Errorf("Unsupported JWT algorithm %q", algorithm)
+	}
+
+	var signer crypto.Signer
+	if config.Envs.JWTSigningKeyFile == "" {
+		if !config.Envs.JWTEphemeralKey {
+			return nil, fmt.Errorf("JWT_SIGNING_KEY_FILE must be set to sign tokens with %s, or JWT_EPHEMERAL_KEY=true for a temporary key", algorithm)
+		}
+
+		log.Printf("JWT_SIGNING_KEY_FILE is not set, signing tokens with a temporary %s key", algorithm)
+
+		var err error
+		signer, err = generateKey(algorithm)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		keys, err := readPEMKeys(config.Envs.JWTSigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		var ok bool
+		signer, ok = keys[0].(crypto.Signer)
+		if len(keys) != 1 || !ok {
+			return nil, fmt.Errorf("%s must contain exactly one private key", config.Envs.JWTSigningKeyFile)
+		}
+	}
+
+	method, err := methodForKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	if method.Alg() != algorithm {
+		return nil, fmt.Errorf("JWT_ALGORITHM is %s but the signing key is for %s", algorithm, method.Alg())
+	}
+
+	var previous []crypto.PublicKey
+	for _, file := range strings.Split(config.Envs.JWTVerificationKeyFiles, ",") {
+		file = strings.TrimSpace(file)
+		if file == "" {
+			continue
+		}
+
+		keys, err := readPEMKeys(file)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			if signer, ok := key.(crypto.Signer); ok {
+				key = signer.Public()
+			}
+			previous = append(previous, key)
+		}
+	}
+
+	return NewKeyring(signer, previous...)
+}
+
+var (
+	keyringMu     sync.RWMutex
+	activeKeyring *Keyring
+)
+
+// UseKeyring sets the keyring used to sign and verify access tokens. Until it
+// is called, HS256 with JWT_SECRET is used.
+func UseKeyring(k *Keyring) {
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+	activeKeyring = k
+}
+
+func currentKeyring() *Keyring {
+	keyringMu.RLock()
+	defer keyringMu.RUnlock()
+
+	if activeKeyring == nil {
+		return NewHMACKeyring([]byte(config.Envs.JWTSecret))
+	}
+	return activeKeyring
+}
+
+func (k *Keyring) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.signing.method, claims)
+	if k.signing.kid != "" {
+		token.Header["kid"] = k.signing.kid
+	}
+
+	return token.SignedString(k.signing.key)
+}
+
+// keyFunc picks the verification key named by the token's kid and makes sure
+// the token uses that key's algorithm.
+func (k *Keyring) keyFunc(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	key, ok := k.verification[kid]
+	if !ok {
+		return nil, fmt.Errorf("Unknown signing key %q", kid)
+	}
+
+	if t.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("Unexpected signing method: %v", t.Header["alg"])
+	}
+
+	return key.key, nil
+}
+
+func (k *Keyring) methods() []string {
+	seen := map[string]bool{}
+	methods := []string{}
+	for _, key := range k.verification {
+		if !seen[key.method.Alg()] {
+			seen[key.method.Alg()] = true
+			methods = append(methods, key.method.Alg())
+		}
+	}
+	return methods
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public verification keys. It is empty for HS256, whose
+// secret must never be published.
+func (k *Keyring) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+
+	for kid, key := range k.verification {
+		jwk, ok := toJWK(key.key)
+		if !ok {
+			continue
+		}
+
+		jwk.Use = "sig"
+		jwk.Alg = key.method.Alg()
+		jwk.Kid = kid
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// HandleJWKS serves the active keyring's public keys so other services can
+// verify access tokens without holding a secret.
+func HandleJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.WriteJSON(w, http.StatusOK, currentKeyring().JWKS())
+}
+
+func toJWK(key any) (JWK, bool) {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}, true
+	}
+
+	return JWK{}, false
+}
+
+// thumbprint computes the RFC 7638 thumbprint of a public key: the SHA-256 of
+// its required JWK members, serialized in lexicographic order.
+func thumbprint(key crypto.PublicKey) (string, error) {
+	jwk, ok := toJWK(key)
+	if !ok {
+		return "", fmt.Errorf("Unsupported key type %T", key)
+	}
+
+	var members any
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+
+	b, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func methodForKey(key crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA keys must be at least 2048 bits")
+		}
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported key type %T", key)
+}
+
+func generateKey(algorithm string) (crypto.Signer, error) {
+	if algorithm == jwt.SigningMethodRS256.Alg() {
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	return key, err
+}
+
+// readPEMKeys reads every key in a PEM file. Private keys may be PKCS #8 or
+// PKCS #1, public keys PKIX or PKCS #1.
+func readPEMKeys(file string) ([]any, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []any
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var key any
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s contains no keys", file)
+	}
+
+	return keys, nil
+}
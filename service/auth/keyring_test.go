@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/joshbarros/golang-ecommerce-api/config"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+func newEd25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func useKeyring(t *testing.T, k *Keyring) {
+	t.Helper()
+
+	UseKeyring(k)
+	t.Cleanup(func() { UseKeyring(nil) })
+}
+
+func TestAsymmetricSigning(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		key  func(*testing.T) *Keyring
+		alg  string
+	}{
+		{"EdDSA", func(t *testing.T) *Keyring { k, _ := NewKeyring(newEd25519Key(t)); return k }, "EdDSA"},
+		{"RS256", func(t *testing.T) *Keyring { k, _ := NewKeyring(newRSAKey(t)); return k }, "RS256"},
+	} {
+		t.Run("Should sign and verify with "+tc.name, func(t *testing.T) {
+			keyring := tc.key(t)
+			useKeyring(t, keyring)
+
+			token, err := CreateJWT(1, types.RoleCustomer)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.Header["alg"] != tc.alg || parsed.Header["kid"] != keyring.signing.kid {
+				t.Errorf("Unexpected header %v", parsed.Header)
+			}
+
+			if _, err := validateToken(token); err != nil {
+				t.Errorf("Expected the token to be valid, got %v", err)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := newEd25519Key(t)
+	newKey := newRSAKey(t)
+
+	oldKeyring, _ := NewKeyring(oldKey)
+	useKeyring(t, oldKeyring)
+	oldToken, _ := CreateJWT(1, types.RoleCustomer)
+
+	t.Run("Should accept tokens signed with a previous key", func(t *testing.T) {
+		rotated, err := NewKeyring(newKey, oldKey.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		useKeyring(t, rotated)
+
+		if _, err := validateToken(oldToken); err != nil {
+			t.Errorf("Expected the old token to be valid, got %v", err)
+		}
+
+		newToken, _ := CreateJWT(1, types.RoleCustomer)
+		if _, err := validateToken(newToken); err != nil {
+			t.Errorf("Expected the new token to be valid, got %v", err)
+		}
+	})
+
+	t.Run("Should reject tokens once the previous key is dropped", func(t *testing.T) {
+		rotated, _ := NewKeyring(newKey)
+		useKeyring(t, rotated)
+
+		if _, err := validateToken(oldToken); err == nil {
+			t.Error("Expected the old token to be rejected")
+		}
+	})
+
+	t.Run("Should reject HS256 tokens when signing asymmetrically", func(t *testing.T) {
+		useKeyring(t, oldKeyring)
+
+		token := signClaims(t, jwt.SigningMethodHS256, []byte(config.Envs.JWTSecret), nil)
+		if _, err := validateToken(token); err == nil {
+			t.Error("Expected the HS256 token to be rejected")
+		}
+	})
+}
+
+func TestJWKS(t *testing.T) {
+	edKey := newEd25519Key(t)
+	rsaKey := newRSAKey(t)
+
+	keyring, err := NewKeyring(edKey, rsaKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeyring(t, keyring)
+
+	rr := httptest.NewRecorder()
+	HandleJWKS(rr, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+
+	var set JWKS
+	if err := json.NewDecoder(rr.Body).Decode(&set); err != nil {
+		t.Fatal("Failed to decode JSON response")
+	}
+
+	if len(set.Keys) != 2 {
+		t.Fatalf("Expected 2 keys, got %d", len(set.Keys))
+	}
+
+	for _, key := range set.Keys {
+		if _, ok := keyring.verification[key.Kid]; !ok {
+			t.Errorf("Unexpected kid %q", key.Kid)
+		}
+		switch key.Kty {
+		case "OKP":
+			if key.Alg != "EdDSA" || key.Crv != "Ed25519" || key.X == "" {
+				t.Errorf("Unexpected Ed25519 key %+v", key)
+			}
+		case "RSA":
+			if key.Alg != "RS256" || key.N == "" || key.E != "AQAB" {
+				t.Errorf("Unexpected RSA key %+v", key)
+			}
+		default:
+			t.Errorf("Unexpected key type %q", key.Kty)
+		}
+	}
+
+	t.Run("Should never publish an HMAC secret", func(t *testing.T) {
+		if keys := NewHMACKeyring([]byte("secret")).JWKS().Keys; len(keys) != 0 {
+			t.Errorf("Expected no keys, got %d", len(keys))
+		}
+	})
+}
+
+func TestThumbprint(t *testing.T) {
+	// The example key from RFC 7638, section 3.1.
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kid, err := thumbprint(&rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if kid != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("Unexpected thumbprint %q", kid)
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir := t.TempDir()
+
+	writePEM := func(name string, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	signingKey := newEd25519Key(t)
+	der, _ := x509.MarshalPKCS8PrivateKey(signingKey)
+	signingFile := writePEM("signing.pem", "PRIVATE KEY", der)
+
+	previousKey := newRSAKey(t)
+	der, _ = x509.MarshalPKIXPublicKey(previousKey.Public())
+	previousFile := writePEM("previous.pem", "PUBLIC KEY", der)
+
+	t.Cleanup(func() {
+		config.Envs.JWTAlgorithm = "EdDSA"
+		config.Envs.JWTSigningKeyFile = ""
+		config.Envs.JWTVerificationKeyFiles = ""
+		config.Envs.JWTEphemeralKey = false
+	})
+
+	t.Run("Should load the signing key and previous keys", func(t *testing.T) {
+		config.Envs.JWTAlgorithm = "EdDSA"
+		config.Envs.JWTSigningKeyFile = signingFile
+		config.Envs.JWTVerificationKeyFiles = previousFile
+
+		keyring, err := LoadKeyring()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(keyring.verification) != 2 || keyring.signing.method != jwt.SigningMethodEdDSA {
+			t.Errorf("Unexpected keyring %+v", keyring)
+		}
+	})
+
+	t.Run("Should fail if the key does not match the algorithm", func(t *testing.T) {
+		config.Envs.JWTAlgorithm = "RS256"
+		config.Envs.JWTSigningKeyFile = signingFile
+		config.Envs.JWTVerificationKeyFiles = ""
+
+		if _, err := LoadKeyring(); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+
+	t.Run("Should fail without a signing key unless a temporary one is allowed", func(t *testing.T) {
+		config.Envs.JWTAlgorithm = "EdDSA"
+		config.Envs.JWTSigningKeyFile = ""
+		config.Envs.JWTVerificationKeyFiles = ""
+
+		if _, err := LoadKeyring(); err == nil {
+			t.Error("Expected an error without JWT_SIGNING_KEY_FILE")
+		}
+
+		config.Envs.JWTEphemeralKey = true
+		keyring, err := LoadKeyring()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if keyring.signing.method != jwt.SigningMethodEdDSA {
+			t.Errorf("Expected EdDSA, got %s", keyring.signing.method.Alg())
+		}
+	})
+
+	t.Run("Should use the shared secret for HS256", func(t *testing.T) {
+		config.Envs.JWTAlgorithm = "HS256"
+
+		keyring, err := LoadKeyring()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if keyring.signing.method != jwt.SigningMethodHS256 {
+			t.Errorf("Expected HS256, got %s", keyring.signing.method.Alg())
+		}
+	})
+}
@@ -1,6 +1,10 @@
 package auth
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
 
 func HashPassword(password string) (string, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -15,3 +19,19 @@ func ComparePasswords(hashed string, plain []byte) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hashed), plain)
 	return err == nil
 }
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// CompareDummyPassword takes as long as ComparePasswords does against a real
+// hash. Call it when no user has the given email, so response times do not
+// reveal which emails are registered.
+func CompareDummyPassword(plain []byte) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+	})
+
+	bcrypt.CompareHashAndPassword(dummyHash, plain)
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/joshbarros/golang-ecommerce-api/config"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// ErrInvalidRefreshToken is returned for refresh tokens that are unknown,
+// expired or revoked.
+var ErrInvalidRefreshToken = errors.New("Invalid or expired refresh token")
+
+// ErrRefreshTokenReused is returned when a refresh token that was already
+// exchanged is presented again. It means the token leaked, so the whole family
+// has been revoked and the user must log in again.
+var ErrRefreshTokenReused = errors.New("Refresh token was already used, please log in again")
+
+// IssueTokens creates an access token and a refresh token for user. An empty
+// familyID starts a new family, as on login.
+func IssueTokens(store types.TokenStore, user *types.User, familyID string) (*types.TokenPair, error) {
+	if familyID == "" {
+		var err error
+		familyID, err = newTokenID()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	accessToken, claims, err := createJWT(user.ID, user.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, refreshTokenHash, err := NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.CreateRefreshToken(&types.RefreshToken{
+		UserID:          user.ID,
+		FamilyID:        familyID,
+		TokenHash:       refreshTokenHash,
+		AccessTokenID:   claims.ID,
+		AccessExpiresAt: claims.ExpiresAt.Time,
+		ExpiresAt:       time.Now().Add(time.Second * time.Duration(config.Envs.RefreshTokenExpInSeconds)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.TokenPair{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    config.Envs.JWTExpirationInSeconds,
+	}, nil
+}
+
+// RefreshTokens exchanges a refresh token for a new pair in the same family.
+// Each refresh token works once: presenting it again revokes the family and
+// returns ErrRefreshTokenReused. Run it in a transaction, but commit the
+// revocation even when ErrRefreshTokenReused is returned.
+func RefreshTokens(stores types.Stores, refreshToken string) (*types.TokenPair, error) {
+	t, err := stores.Tokens.GetRefreshTokenByHash(HashOpaqueToken(refreshToken))
+	if errors.Is(err, types.ErrRefreshTokenNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if t.RevokedAt != nil || !t.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	err = stores.Tokens.MarkRefreshTokenUsed(t.ID)
+	if errors.Is(err, types.ErrRefreshTokenUsed) {
+		if err := stores.Tokens.RevokeRefreshTokenFamily(t.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := stores.Users.GetUserByID(t.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return IssueTokens(stores.Tokens, user, t.FamilyID)
+}
+
+// NewOpaqueToken returns a random URL-safe token to hand to the user, and the
+// hash to store in its place.
+func NewOpaqueToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, HashOpaqueToken(token), nil
+}
+
+// HashOpaqueToken is how refresh, password reset and other emailed tokens are
+// stored. They are random and long, so a plain SHA-256 is enough.
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/joshbarros/golang-ecommerce-api/config"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// Mock implementation of the TokenStore interface
+type mockTokenStore struct {
+	refreshTokens []*types.RefreshToken
+	revoked       map[string]time.Time
+}
+
+func newMockTokenStore() *mockTokenStore {
+	return &mockTokenStore{revoked: map[string]time.Time{}}
+}
+
+func (m *mockTokenStore) CreateRefreshToken(t *types.RefreshToken) error {
+	t.ID = len(m.refreshTokens) + 1
+	copied := *t
+	m.refreshTokens = append(m.refreshTokens, &copied)
+	return nil
+}
+
+func (m *mockTokenStore) GetRefreshTokenByHash(hash string) (*types.RefreshToken, error) {
+	for _, t := range m.refreshTokens {
+		if t.TokenHash == hash {
+			copied := *t
+			return &copied, nil
+		}
+	}
+	return nil, types.ErrRefreshTokenNotFound
+}
+
+func (m *mockTokenStore) GetRefreshTokenByAccessTokenID(jti string) (*types.RefreshToken, error) {
+	for _, t := range m.refreshTokens {
+		if t.AccessTokenID == jti {
+			copied := *t
+			return &copied, nil
+		}
+	}
+	return nil, types.ErrRefreshTokenNotFound
+}
+
+func (m *mockTokenStore) MarkRefreshTokenUsed(id int) error {
+	t := m.refreshTokens[id-1]
+	if t.UsedAt != nil || t.RevokedAt != nil {
+		return types.ErrRefreshTokenUsed
+	}
+	now := time.Now()
+	t.UsedAt = &now
+	return nil
+}
+
+func (m *mockTokenStore) revokeWhere(match func(*types.RefreshToken) bool) {
+	now := time.Now()
+	for _, t := range m.refreshTokens {
+		if match(t) {
+			m.revoked[t.AccessTokenID] = t.AccessExpiresAt
+			if t.RevokedAt == nil {
+				t.RevokedAt = &now
+			}
+		}
+	}
+}
+
+func (m *mockTokenStore) RevokeRefreshTokenFamily(familyID string) error {
+	m.revokeWhere(func(t *types.RefreshToken) bool { return t.FamilyID == familyID })
+	return nil
+}
+
+func (m *mockTokenStore) RevokeUserRefreshTokens(userID int) error {
+	m.revokeWhere(func(t *types.RefreshToken) bool { return t.UserID == userID })
+	return nil
+}
+
+func (m *mockTokenStore) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	m.revoked[jti] = expiresAt
+	return nil
+}
+
+func (m *mockTokenStore) IsAccessTokenRevoked(jti string) (bool, error) {
+	_, ok := m.revoked[jti]
+	return ok, nil
+}
+
+func TestRefreshTokens(t *testing.T) {
+	config.Envs.JWTSecret = "secret"
+	config.Envs.JWTExpirationInSeconds = 900
+	config.Envs.RefreshTokenExpInSeconds = 3600
+
+	user := &types.User{ID: 1, Email: "user@example.com", Role: types.RoleCustomer}
+
+	setup := func() (*mockTokenStore, types.Stores) {
+		tokenStore := newMockTokenStore()
+		userStore := &mockUserStore{users: map[int]*types.User{1: user}}
+		return tokenStore, types.Stores{Users: userStore, Tokens: tokenStore}
+	}
+
+	t.Run("Should rotate the refresh token within the family", func(t *testing.T) {
+		tokenStore, stores := setup()
+
+		first, err := IssueTokens(tokenStore, user, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		second, err := RefreshTokens(stores, first.RefreshToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if second.RefreshToken == first.RefreshToken || second.Token == first.Token {
+			t.Error("Expected a new token pair")
+		}
+		if tokenStore.refreshTokens[0].FamilyID != tokenStore.refreshTokens[1].FamilyID {
+			t.Error("Expected the new refresh token to stay in the same family")
+		}
+		if tokenStore.refreshTokens[0].TokenHash == first.RefreshToken {
+			t.Error("Expected the refresh token to be stored hashed")
+		}
+		if _, err := validateToken(second.Token); err != nil {
+			t.Errorf("Expected a valid access token, got %v", err)
+		}
+	})
+
+	t.Run("Should revoke the family when a refresh token is reused", func(t *testing.T) {
+		tokenStore, stores := setup()
+
+		first, _ := IssueTokens(tokenStore, user, "")
+		other, _ := IssueTokens(tokenStore, user, "")
+		second, _ := RefreshTokens(stores, first.RefreshToken)
+
+		_, err := RefreshTokens(stores, first.RefreshToken)
+		if !errors.Is(err, ErrRefreshTokenReused) {
+			t.Fatalf("Expected ErrRefreshTokenReused, got %v", err)
+		}
+
+		if _, err := RefreshTokens(stores, second.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("Expected the rotated token to be revoked too, got %v", err)
+		}
+		claims, _ := validateToken(second.Token)
+		if revoked, _ := tokenStore.IsAccessTokenRevoked(claims.ID); !revoked {
+			t.Error("Expected the family's access token to be revoked")
+		}
+
+		if _, err := RefreshTokens(stores, other.RefreshToken); err != nil {
+			t.Errorf("Expected other sessions to keep working, got %v", err)
+		}
+	})
+
+	t.Run("Should reject unknown and expired refresh tokens", func(t *testing.T) {
+		tokenStore, stores := setup()
+
+		if _, err := RefreshTokens(stores, "not-a-token"); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("Expected ErrInvalidRefreshToken, got %v", err)
+		}
+
+		pair, _ := IssueTokens(tokenStore, user, "")
+		tokenStore.refreshTokens[0].ExpiresAt = time.Now().Add(-time.Second)
+
+		if _, err := RefreshTokens(stores, pair.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("Expected ErrInvalidRefreshToken, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joshbarros/golang-ecommerce-api/config"
+)
+
+// Clock tells the time. Tests swap it for a fake one.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ThrottlePolicy says how hard failed logins are slowed down.
+type ThrottlePolicy struct {
+	// FreeAttempts is how many failures are allowed before any delay.
+	FreeAttempts int
+	// BaseDelay is the wait after the first failure past FreeAttempts. It
+	// doubles with every further failure.
+	BaseDelay time.Duration
+	// LockoutAfter failures block logins for LockoutDuration. Failures are
+	// also forgotten once LockoutDuration passes without a new one.
+	LockoutAfter    int
+	LockoutDuration time.Duration
+}
+
+// LoginThrottle slows down password guessing. Failed logins are counted per
+// account and per client IP, each with its own policy, and a login is refused
+// while either is blocked. Counters live in memory, so every API process
+// keeps its own.
+type LoginThrottle struct {
+	mu       sync.Mutex
+	clock    Clock
+	account  ThrottlePolicy
+	ip       ThrottlePolicy
+	failures map[string]*loginFailures
+	calls    int
+}
+
+type loginFailures struct {
+	count        int
+	last         time.Time
+	blockedUntil time.Time
+	policy       ThrottlePolicy
+}
+
+func NewLoginThrottle(clock Clock, account ThrottlePolicy, ip ThrottlePolicy) *LoginThrottle {
+	return &LoginThrottle{
+		clock:    clock,
+		account:  account,
+		ip:       ip,
+		failures: map[string]*loginFailures{},
+	}
+}
+
+// NewDefaultLoginThrottle uses the system clock and the LOGIN_* settings.
+// Backoff starts after 3 failures at one second.
+func NewDefaultLoginThrottle() *LoginThrottle {
+	lockout := time.Second * time.Duration(config.Envs.LoginLockoutInSeconds)
+
+	return NewLoginThrottle(systemClock{},
+		ThrottlePolicy{FreeAttempts: 3, BaseDelay: time.Second, LockoutAfter: int(config.Envs.LoginMaxAttempts), LockoutDuration: lockout},
+		ThrottlePolicy{FreeAttempts: 3, BaseDelay: time.Second, LockoutAfter: int(config.Envs.LoginIPMaxAttempts), LockoutDuration: lockout},
+	)
+}
+
+// NewPasswordResetThrottle limits password reset requests, each counted as a
+// failure would be, so nobody can flood an inbox with reset links. Each link
+// to an email makes the next one wait, a minute at first and twice as long
+// every time, and the email is locked for an hour after 5. Backoff for an IP
+// starts after 10 requests, and it is locked after 50.
+func NewPasswordResetThrottle(clock Clock) *LoginThrottle {
+	return NewLoginThrottle(clock,
+		ThrottlePolicy{FreeAttempts: 0, BaseDelay: time.Minute, LockoutAfter: 5, LockoutDuration: time.Hour},
+		ThrottlePolicy{FreeAttempts: 10, BaseDelay: time.Second, LockoutAfter: 50, LockoutDuration: time.Hour},
+	)
+}
+
+// NewDefaultPasswordResetThrottle is NewPasswordResetThrottle on the system
+// clock.
+func NewDefaultPasswordResetThrottle() *LoginThrottle {
+	return NewPasswordResetThrottle(systemClock{})
+}
+
+// Wait returns how long the client must wait before trying to log in to the
+// account again, or 0 if it may try now.
+func (t *LoginThrottle) Wait(email string, ip string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.clock.Now()
+
+	var wait time.Duration
+	for _, key := range []string{accountKey(email), ipKey(ip)} {
+		if f, ok := t.failures[key]; ok {
+			if d := f.blockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+
+	return wait
+}
+
+// Fail records a failed login, whether the account exists or not.
+func (t *LoginThrottle) Fail(email string, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.clock.Now()
+	t.fail(accountKey(email), t.account, now)
+	t.fail(ipKey(ip), t.ip, now)
+
+	t.calls++
+	if t.calls%1000 == 0 {
+		t.prune(now)
+	}
+}
+
+// Succeed forgets the account's failures. The IP's are kept, so an attacker
+// cannot reset them by logging in to an account of their own.
+func (t *LoginThrottle) Succeed(email string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.failures, accountKey(email))
+}
+
+func (t *LoginThrottle) fail(key string, policy ThrottlePolicy, now time.Time) {
+	f, ok := t.failures[key]
+	if !ok || now.Sub(f.last) >= policy.LockoutDuration {
+		f = &loginFailures{policy: policy}
+		t.failures[key] = f
+	}
+
+	f.count++
+	f.last = now
+
+	switch {
+	case f.count >= policy.LockoutAfter:
+		f.blockedUntil = now.Add(policy.LockoutDuration)
+	case f.count > policy.FreeAttempts:
+		delay := policy.LockoutDuration
+		if shift := f.count - policy.FreeAttempts - 1; shift < 32 && policy.BaseDelay<<shift < delay {
+			delay = policy.BaseDelay << shift
+		}
+		f.blockedUntil = now.Add(delay)
+	}
+}
+
+// prune drops counters that have been forgotten, so the map does not grow
+// with every address ever tried.
+func (t *LoginThrottle) prune(now time.Time) {
+	for key, f := range t.failures {
+		if now.Sub(f.last) >= f.policy.LockoutDuration && !now.Before(f.blockedUntil) {
+			delete(t.failures, key)
+		}
+	}
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
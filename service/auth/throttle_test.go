@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestThrottle() (*LoginThrottle, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)}
+
+	throttle := NewLoginThrottle(clock,
+		ThrottlePolicy{FreeAttempts: 3, BaseDelay: time.Second, LockoutAfter: 6, LockoutDuration: 15 * time.Minute},
+		ThrottlePolicy{FreeAttempts: 3, BaseDelay: time.Second, LockoutAfter: 20, LockoutDuration: 15 * time.Minute},
+	)
+
+	return throttle, clock
+}
+
+func TestLoginThrottleBackoff(t *testing.T) {
+	throttle, clock := newTestThrottle()
+
+	expected := []time.Duration{0, 0, 0, time.Second, 2 * time.Second, 15 * time.Minute}
+
+	for i, wait := range expected {
+		throttle.Fail("jane@example.com", "10.0.0.1")
+
+		if got := throttle.Wait("jane@example.com", "10.0.0.2"); got != wait {
+			t.Fatalf("After %d failures: expected a wait of %v, got %v", i+1, wait, got)
+		}
+
+		clock.Advance(wait)
+	}
+
+	if got := throttle.Wait("jane@example.com", "10.0.0.2"); got != 0 {
+		t.Errorf("Expected the lockout to be over, got a wait of %v", got)
+	}
+
+	throttle.Fail("jane@example.com", "10.0.0.1")
+	if got := throttle.Wait("jane@example.com", "10.0.0.2"); got != 0 {
+		t.Errorf("Expected failures to be forgotten after the lockout, got a wait of %v", got)
+	}
+}
+
+func TestLoginThrottleIsCaseInsensitive(t *testing.T) {
+	throttle, _ := newTestThrottle()
+
+	for i := 0; i < 4; i++ {
+		throttle.Fail("Jane@Example.com ", "10.0.0.1")
+	}
+
+	if got := throttle.Wait("jane@example.com", "10.0.0.2"); got != time.Second {
+		t.Errorf("Expected a wait of 1s, got %v", got)
+	}
+}
+
+func TestLoginThrottleBlocksIPsAcrossAccounts(t *testing.T) {
+	throttle, _ := newTestThrottle()
+
+	for i := 0; i < 20; i++ {
+		throttle.Fail("user"+string(rune('a'+i))+"@example.com", "10.0.0.1")
+	}
+
+	if got := throttle.Wait("new@example.com", "10.0.0.1"); got != 15*time.Minute {
+		t.Errorf("Expected the IP to be locked for 15m, got %v", got)
+	}
+
+	if got := throttle.Wait("new@example.com", "10.0.0.2"); got != 0 {
+		t.Errorf("Expected other IPs not to wait, got %v", got)
+	}
+}
+
+func TestLoginThrottleSucceed(t *testing.T) {
+	throttle, clock := newTestThrottle()
+
+	for i := 0; i < 4; i++ {
+		throttle.Fail("jane@example.com", "10.0.0.1")
+	}
+	clock.Advance(time.Second)
+
+	throttle.Succeed("jane@example.com")
+	throttle.Fail("jane@example.com", "10.0.0.2")
+
+	if got := throttle.Wait("jane@example.com", "10.0.0.2"); got != 0 {
+		t.Errorf("Expected the account's failures to be reset, got a wait of %v", got)
+	}
+
+	if throttle.failures[ipKey("10.0.0.1")].count != 4 {
+		t.Error("Expected the IP's failures to be kept")
+	}
+}
+
+func TestLoginThrottlePrune(t *testing.T) {
+	throttle, clock := newTestThrottle()
+
+	throttle.Fail("jane@example.com", "10.0.0.1")
+	clock.Advance(15 * time.Minute)
+	throttle.prune(clock.Now())
+
+	if len(throttle.failures) != 0 {
+		t.Errorf("Expected forgotten counters to be dropped, %d left", len(throttle.failures))
+	}
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key used by the RFC 4226 and RFC 6238 test vectors.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestHOTPVectors(t *testing.T) {
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+	for counter, code := range expected {
+		if got := hotp(rfcSecret, uint64(counter), 6); got != code {
+			t.Errorf("Counter %d: expected %s, got %s", counter, code, got)
+		}
+	}
+}
+
+func TestTOTPVectors(t *testing.T) {
+	tests := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+
+	secret := totpEncoding.EncodeToString(rfcSecret)
+
+	for unix, code := range tests {
+		if got := hotp(rfcSecret, uint64(unix/totpPeriod), 8); got != code {
+			t.Errorf("T=%d: expected %s, got %s", unix, code, got)
+		}
+
+		got, err := TOTPCode(secret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != code[2:] {
+			t.Errorf("T=%d: expected the 6 digit code %s, got %s", unix, code[2:], got)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1234567890, 0)
+	code, _ := TOTPCode(secret, now)
+
+	tests := []struct {
+		name  string
+		at    time.Time
+		valid bool
+	}{
+		{"Same period", now, true},
+		{"Previous period", now.Add(-totpPeriod * time.Second), true},
+		{"Next period", now.Add(totpPeriod * time.Second), true},
+		{"Two periods later", now.Add(2 * totpPeriod * time.Second), false},
+	}
+
+	for _, tt := range tests {
+		step, ok := ValidateTOTP(secret, code, tt.at)
+		if ok != tt.valid {
+			t.Errorf("%s: expected valid %v, got %v", tt.name, tt.valid, ok)
+		}
+		if ok && step != now.Unix()/totpPeriod {
+			t.Errorf("%s: expected step %d, got %d", tt.name, now.Unix()/totpPeriod, step)
+		}
+	}
+
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := ValidateTOTP(secret, code, now); ok {
+			t.Errorf("Expected %q to be rejected", code)
+		}
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(TOTPURI("JBSWY3DPEHPK3PXP", "jane@example.com", "Golang Ecommerce"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" {
+		t.Errorf("Unexpected URI %s", uri)
+	}
+	if uri.Path != "/Golang Ecommerce:jane@example.com" {
+		t.Errorf("Unexpected label %q", uri.Path)
+	}
+
+	q := uri.Query()
+	if q.Get("secret") != "JBSWY3DPEHPK3PXP" || q.Get("issuer") != "Golang Ecommerce" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("Unexpected parameters %v", q)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 19 || strings.Count(code, "-") != 3 {
+			t.Errorf("Unexpected code format %q", code)
+		}
+		if seen[code] {
+			t.Errorf("Duplicate code %q", code)
+		}
+		seen[code] = true
+	}
+
+	loose := strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))
+	if HashRecoveryCode(loose) != HashRecoveryCode(codes[0]) {
+		t.Error("Expected case, spaces and dashes to be ignored")
+	}
+}
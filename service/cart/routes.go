@@ -1,36 +1,47 @@
 package cart
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 
+	"github.com/joshbarros/golang-ecommerce-api/config"
 	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/service/idempotency"
 	"github.com/joshbarros/golang-ecommerce-api/types"
 	"github.com/joshbarros/golang-ecommerce-api/utils"
 )
 
 type Handler struct {
-	store        types.OrderStore
-	productStore types.ProductStore
-	userStore    types.UserStore
+	txManager        types.TxManager
+	addressStore     types.AddressStore
+	userStore        types.UserStore
+	tokenStore       types.TokenStore
+	idempotencyStore types.IdempotencyStore
+	allocation       types.AllocationStrategy
 }
 
-func NewHandler(store types.OrderStore, productStore types.ProductStore, userStore types.UserStore) *Handler {
+func NewHandler(txManager types.TxManager, addressStore types.AddressStore, userStore types.UserStore, tokenStore types.TokenStore, idempotencyStore types.IdempotencyStore, allocation types.AllocationStrategy) *Handler {
 	return &Handler{
-		store:        store,
-		productStore: productStore,
-		userStore:    userStore,
+		txManager:        txManager,
+		addressStore:     addressStore,
+		userStore:        userStore,
+		tokenStore:       tokenStore,
+		idempotencyStore: idempotencyStore,
+		allocation:       allocation,
 	}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc(
-		"/cart/checkout",
-		auth.WithJWTAuth(h.handleCheckout, h.userStore),
-	).Methods(http.MethodPost)
+	checkout := idempotency.WithIdempotencyKey(h.handleCheckout, h.idempotencyStore)
+	if config.Envs.CheckoutRequiresVerifiedEmail {
+		checkout = auth.RequireVerifiedEmail(checkout)
+	}
+
+	router.HandleFunc("/cart/checkout", auth.WithJWTAuth(checkout, h.userStore, h.tokenStore)).Methods(http.MethodPost)
 }
 
 func (h *Handler) handleCheckout(w http.ResponseWriter, r *http.Request) {
@@ -49,23 +60,33 @@ func (h *Handler) handleCheckout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	productIDs, err := getCartItemsID(cart.Items)
-	if err != nil {
+	var cartErr *cartError
+
+	address, err := resolveShippingAddress(h.addressStore, cart, userID)
+	if errors.As(err, &cartErr) {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
-
-	products, err := h.productStore.GetProductsByID(productIDs)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	orderID, totalPrice, err := h.createOrder(products, cart.Items, userID)
-	if err != nil {
+	var orderID int
+	var totalPrice types.Money
+	err = h.txManager.WithTx(r.Context(), func(stores types.Stores) error {
+		var err error
+		orderID, totalPrice, err = createOrder(r.Context(), stores, h.allocation, cart.Items, userID, address)
+		return err
+	})
+	if errors.As(err, &cartErr) {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
 
 	utils.WriteJSON(w, http.StatusOK, map[string]any{
 		"total_price": totalPrice,
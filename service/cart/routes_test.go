@@ -0,0 +1,1022 @@
+package cart
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"testing/quick"
+
+	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/service/inventory"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+var errInjected = errors.New("injected failure")
+
+// Mock implementation of ProductStore. Reads return copies.
+type mockProductStore struct {
+	mu       sync.Mutex
+	products map[int]types.Product
+}
+
+func (m *mockProductStore) GetProducts(q types.ProductQuery) ([]types.Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	products := make([]types.Product, 0, len(m.products))
+	for _, p := range m.products {
+		products = append(products, p)
+	}
+	return products, nil
+}
+
+func (m *mockProductStore) CountProducts(q types.ProductQuery) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.products), nil
+}
+
+func (m *mockProductStore) GetProductsByID(ids []int) ([]types.Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []types.Product
+	for _, id := range ids {
+		if p, ok := m.products[id]; ok {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockProductStore) GetProductByID(id int) (*types.Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p, ok := m.products[id]; ok {
+		return &p, nil
+	}
+	return nil, types.ErrProductNotFound
+}
+
+func (m *mockProductStore) CreateProduct(product *types.Product) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	product.ID = len(m.products) + 1
+	m.products[product.ID] = *product
+	return nil
+}
+
+func (m *mockProductStore) UpdateProduct(product types.Product) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.products[product.ID] = product
+	return nil
+}
+
+func (m *mockProductStore) DeleteProduct(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.products, id)
+	return nil
+}
+
+// Mock implementation of VariantStore with failure injection. Reads return
+// copies, with their stock on hand in the inventory.
+type mockVariantStore struct {
+	mu        sync.Mutex
+	variants  map[int]types.ProductVariant
+	inventory *mockInventoryStore
+	failGet   bool
+}
+
+func (m *mockVariantStore) GetProductOptions(productID int) ([]types.ProductOption, error) {
+	return nil, nil
+}
+
+func (m *mockVariantStore) SetProductOptions(productID int, options []types.ProductOption) error {
+	return nil
+}
+
+func (m *mockVariantStore) GetVariantsByProductID(productID int) ([]types.ProductVariant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := []types.ProductVariant{}
+	for _, v := range m.variants {
+		if v.ProductID == productID {
+			v.Quantity = m.inventory.onHand(v.ID)
+			result = append(result, v)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockVariantStore) GetVariantsByID(ids []int) ([]types.ProductVariant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failGet {
+		return nil, errInjected
+	}
+	var result []types.ProductVariant
+	for _, id := range ids {
+		if v, ok := m.variants[id]; ok {
+			v.Quantity = m.inventory.onHand(v.ID)
+			result = append(result, v)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockVariantStore) GetVariantBySKU(sku string) (*types.ProductVariant, error) {
+	return nil, types.ErrVariantNotFound
+}
+
+func (m *mockVariantStore) CreateVariant(variant *types.ProductVariant) error {
+	return nil
+}
+
+func (m *mockVariantStore) UpdateVariant(variant types.ProductVariant) error {
+	return nil
+}
+
+func (m *mockVariantStore) DeleteVariant(id int) error {
+	return nil
+}
+
+// Mock implementation of InventoryStore with failure injection, deriving
+// stock levels from its ledger like the real store.
+type mockInventoryStore struct {
+	mu         sync.Mutex
+	warehouses []types.Warehouse
+	locations  []types.Location
+	movements  []types.StockMovement
+	failAdd    bool
+}
+
+func (m *mockInventoryStore) GetWarehouses() ([]types.Warehouse, error) {
+	return m.warehouses, nil
+}
+
+func (m *mockInventoryStore) GetWarehouseByID(id int) (*types.Warehouse, error) {
+	for _, w := range m.warehouses {
+		if w.ID == id {
+			return &w, nil
+		}
+	}
+	return nil, types.ErrWarehouseNotFound
+}
+
+func (m *mockInventoryStore) GetWarehouseByCode(code string) (*types.Warehouse, error) {
+	return nil, types.ErrWarehouseNotFound
+}
+
+func (m *mockInventoryStore) CreateWarehouse(w *types.Warehouse) error {
+	return nil
+}
+
+func (m *mockInventoryStore) GetLocations() ([]types.Location, error) {
+	return m.locations, nil
+}
+
+func (m *mockInventoryStore) GetLocationByID(id int) (*types.Location, error) {
+	for _, l := range m.locations {
+		if l.ID == id {
+			return &l, nil
+		}
+	}
+	return nil, types.ErrLocationNotFound
+}
+
+func (m *mockInventoryStore) CreateLocation(l *types.Location) error {
+	return nil
+}
+
+func (m *mockInventoryStore) LockVariants(ctx context.Context, variantIDs []int, productIDs []int) error {
+	return nil
+}
+
+func (m *mockInventoryStore) GetStockLevels(variantIDs []int) ([]types.StockLevel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Like an IN clause, each variant is listed once.
+	ids := slices.Clone(variantIDs)
+	slices.Sort(ids)
+
+	var levels []types.StockLevel
+	for _, id := range slices.Compact(ids) {
+		for _, l := range m.locations {
+			w, _ := m.GetWarehouseByID(l.WarehouseID)
+			level := types.StockLevel{VariantID: id, LocationID: l.ID, WarehouseID: w.ID, Priority: w.Priority}
+			held := false
+			for _, mv := range m.movements {
+				if mv.VariantID == id && mv.LocationID == l.ID {
+					level.Quantity += mv.Quantity
+					held = true
+				}
+			}
+			if held {
+				levels = append(levels, level)
+			}
+		}
+	}
+	return levels, nil
+}
+
+func (m *mockInventoryStore) AddMovements(ctx context.Context, movements []types.StockMovement) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failAdd {
+		return errInjected
+	}
+	for _, mv := range movements {
+		mv.ID = len(m.movements) + 1
+		m.movements = append(m.movements, mv)
+	}
+	return nil
+}
+
+func (m *mockInventoryStore) GetMovements(q types.MovementQuery) ([]types.StockMovement, error) {
+	return nil, nil
+}
+
+func (m *mockInventoryStore) CountMovements(q types.MovementQuery) (int, error) {
+	return 0, nil
+}
+
+func (m *mockInventoryStore) GetMovementsByOrderID(orderID int) ([]types.StockMovement, error) {
+	return nil, nil
+}
+
+// onHandAt sums the movements of a variant at a location, or at every
+// location when locationID is 0.
+func (m *mockInventoryStore) onHandAt(variantID int, locationID int) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	quantity := 0
+	for _, mv := range m.movements {
+		if mv.VariantID == variantID && (locationID == 0 || mv.LocationID == locationID) {
+			quantity += mv.Quantity
+		}
+	}
+	return quantity
+}
+
+func (m *mockInventoryStore) onHand(variantID int) int {
+	return m.onHandAt(variantID, 0)
+}
+
+// lockingInventoryStore holds a lock from LockVariants until its transaction
+// ends, like the row locks of the real store.
+type lockingInventoryStore struct {
+	*mockInventoryStore
+	lock   *sync.Mutex
+	locked bool
+}
+
+func (m *lockingInventoryStore) LockVariants(ctx context.Context, variantIDs []int, productIDs []int) error {
+	m.lock.Lock()
+	m.locked = true
+	return nil
+}
+
+// The recording stores log the reads of a checkout, and its locks, in the
+// order they are made.
+type recordingVariantStore struct {
+	*mockVariantStore
+	calls *[]string
+}
+
+func (m *recordingVariantStore) GetVariantsByProductID(productID int) ([]types.ProductVariant, error) {
+	*m.calls = append(*m.calls, fmt.Sprintf("GetVariantsByProductID(%d)", productID))
+	return m.mockVariantStore.GetVariantsByProductID(productID)
+}
+
+func (m *recordingVariantStore) GetVariantsByID(ids []int) ([]types.ProductVariant, error) {
+	*m.calls = append(*m.calls, fmt.Sprintf("GetVariantsByID(%v)", ids))
+	return m.mockVariantStore.GetVariantsByID(ids)
+}
+
+type recordingProductStore struct {
+	*mockProductStore
+	calls *[]string
+}
+
+func (m *recordingProductStore) GetProductsByID(ids []int) ([]types.Product, error) {
+	*m.calls = append(*m.calls, fmt.Sprintf("GetProductsByID(%v)", ids))
+	return m.mockProductStore.GetProductsByID(ids)
+}
+
+type recordingInventoryStore struct {
+	*mockInventoryStore
+	calls *[]string
+}
+
+func (m *recordingInventoryStore) LockVariants(ctx context.Context, variantIDs []int, productIDs []int) error {
+	*m.calls = append(*m.calls, fmt.Sprintf("LockVariants(%v, %v)", variantIDs, productIDs))
+	return m.mockInventoryStore.LockVariants(ctx, variantIDs, productIDs)
+}
+
+func (m *recordingInventoryStore) GetStockLevels(variantIDs []int) ([]types.StockLevel, error) {
+	*m.calls = append(*m.calls, fmt.Sprintf("GetStockLevels(%v)", variantIDs))
+	return m.mockInventoryStore.GetStockLevels(variantIDs)
+}
+
+// Mock implementation of OrderStore with failure injection
+type mockOrderStore struct {
+	mu              sync.Mutex
+	orders          []types.Order
+	items           []types.OrderItem
+	failCreateOrder bool
+	failCreateItem  int // fail the n-th call to CreateOrderItem (1-based), 0 disables
+	itemCalls       int
+}
+
+func (m *mockOrderStore) CreateOrder(order types.Order) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failCreateOrder {
+		return 0, errInjected
+	}
+	order.ID = len(m.orders) + 1
+	m.orders = append(m.orders, order)
+	return order.ID, nil
+}
+
+func (m *mockOrderStore) CreateOrderItem(item types.OrderItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.itemCalls++
+	if m.failCreateItem == m.itemCalls {
+		return errInjected
+	}
+	m.items = append(m.items, item)
+	return nil
+}
+
+func (m *mockOrderStore) GetOrdersByUserID(userID int, limit int, offset int) ([]types.Order, error) {
+	return nil, nil
+}
+
+func (m *mockOrderStore) CountOrdersByUserID(userID int) (int, error) {
+	return 0, nil
+}
+
+func (m *mockOrderStore) GetOrderByID(id int) (*types.Order, error) {
+	return nil, types.ErrOrderNotFound
+}
+
+func (m *mockOrderStore) GetOrderItems(orderID int) ([]types.OrderItem, error) {
+	return nil, nil
+}
+
+func (m *mockOrderStore) UpdateOrderStatus(orderID int, from types.OrderStatus, to types.OrderStatus) error {
+	return nil
+}
+
+func (m *mockOrderStore) CreateOrderStatusChange(change types.OrderStatusChange) error {
+	return nil
+}
+
+// Mock implementation of AddressStore
+type mockAddressStore struct {
+	addresses []types.Address
+}
+
+func (m *mockAddressStore) GetAddressesByUserID(userID int) ([]types.Address, error) {
+	var result []types.Address
+	for _, a := range m.addresses {
+		if a.UserID == userID {
+			result = append(result, a)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockAddressStore) GetAddressByID(id int) (*types.Address, error) {
+	for _, a := range m.addresses {
+		if a.ID == id {
+			return &a, nil
+		}
+	}
+	return nil, types.ErrAddressNotFound
+}
+
+func (m *mockAddressStore) CreateAddress(address *types.Address) error {
+	address.ID = len(m.addresses) + 1
+	m.addresses = append(m.addresses, *address)
+	return nil
+}
+
+func (m *mockAddressStore) UpdateAddress(address types.Address) error {
+	return nil
+}
+
+func (m *mockAddressStore) DeleteAddress(id int) error {
+	return nil
+}
+
+func (m *mockAddressStore) ClearDefaultShipping(userID int) error {
+	return nil
+}
+
+func (m *mockAddressStore) ClearDefaultBilling(userID int) error {
+	return nil
+}
+
+func newTestAddressStore() *mockAddressStore {
+	return &mockAddressStore{
+		addresses: []types.Address{
+			{ID: 1, UserID: 1, Name: "Home", Line1: "1 Main St", City: "Lisbon", PostalCode: "1000-001", Country: "PT", IsDefaultShipping: true},
+			{ID: 2, UserID: 1, Name: "Work", Line1: "2 Side St", City: "Porto", PostalCode: "4000-001", Country: "PT"},
+			{ID: 3, UserID: 2, Name: "Other", Line1: "3 Far St", City: "Faro", PostalCode: "8000-001", Country: "PT", IsDefaultShipping: true},
+		},
+	}
+}
+
+// Mock TxManager that snapshots the mock stores and restores them on error,
+// mirroring a database rollback.
+type mockTxManager struct {
+	products  *mockProductStore
+	variants  *mockVariantStore
+	inventory *mockInventoryStore
+	orders    *mockOrderStore
+}
+
+func (m *mockTxManager) WithTx(ctx context.Context, fn func(types.Stores) error) error {
+	movements := append([]types.StockMovement(nil), m.inventory.movements...)
+	orders := append([]types.Order(nil), m.orders.orders...)
+	items := append([]types.OrderItem(nil), m.orders.items...)
+
+	err := fn(types.Stores{Products: m.products, Variants: m.variants, Inventory: m.inventory, Orders: m.orders})
+	if err != nil {
+		m.inventory.movements = movements
+		m.orders.orders = orders
+		m.orders.items = items
+	}
+	return err
+}
+
+// Mock TxManager without rollback, used where many checkouts run at once and
+// a snapshot restore would clobber the writes of other goroutines. Locked
+// variants stay locked until the unit of work returns.
+type passthroughTxManager struct {
+	products  *mockProductStore
+	variants  *mockVariantStore
+	inventory *mockInventoryStore
+	orders    *mockOrderStore
+	lock      sync.Mutex
+}
+
+func (m *passthroughTxManager) WithTx(ctx context.Context, fn func(types.Stores) error) error {
+	inventory := &lockingInventoryStore{mockInventoryStore: m.inventory, lock: &m.lock}
+	defer func() {
+		if inventory.locked {
+			m.lock.Unlock()
+		}
+	}()
+
+	return fn(types.Stores{Products: m.products, Variants: m.variants, Inventory: inventory, Orders: m.orders})
+}
+
+// newTestInventory has a main warehouse with locations 1 and 2, and an outlet
+// allocated from after it with location 3.
+func newTestInventory(movements ...types.StockMovement) *mockInventoryStore {
+	return &mockInventoryStore{
+		warehouses: []types.Warehouse{
+			{ID: 1, Code: "MAIN", Priority: 0},
+			{ID: 2, Code: "OUTLET", Priority: 1},
+		},
+		locations: []types.Location{
+			{ID: 1, WarehouseID: 1, Code: "A"},
+			{ID: 2, WarehouseID: 1, Code: "B"},
+			{ID: 3, WarehouseID: 2, Code: "A"},
+		},
+		movements: movements,
+	}
+}
+
+func receipt(variantID int, locationID int, quantity int) types.StockMovement {
+	return types.StockMovement{VariantID: variantID, LocationID: locationID, Type: types.MovementReceipt, Quantity: quantity}
+}
+
+// newTestStores sells products 1 and 2 as single variants with the same IDs,
+// and a shirt, product 3, in sizes M and L as variants 31 and 32. Variant 1
+// is stocked in both warehouses, the others in the main one.
+func newTestStores() (*mockInventoryStore, *mockOrderStore, *mockTxManager) {
+	productStore := &mockProductStore{
+		products: map[int]types.Product{
+			1: {ID: 1, Name: "Test Product 1", Price: types.NewMoney(1000, types.DefaultCurrency)},
+			2: {ID: 2, Name: "Test Product 2", Price: types.NewMoney(250, types.DefaultCurrency)},
+			3: {ID: 3, Name: "Shirt", Image: "shirt.png", Price: types.NewMoney(2000, types.DefaultCurrency)},
+		},
+	}
+	inventoryStore := newTestInventory(
+		receipt(1, 1, 2),
+		receipt(1, 3, 3),
+		receipt(2, 2, 3),
+		receipt(31, 1, 2),
+		receipt(32, 1, 1),
+	)
+	largePrice := types.NewMoney(2200, types.DefaultCurrency)
+	variantStore := &mockVariantStore{
+		variants: map[int]types.ProductVariant{
+			1:  {ID: 1, ProductID: 1, SKU: "P1"},
+			2:  {ID: 2, ProductID: 2, SKU: "P2"},
+			31: {ID: 31, ProductID: 3, SKU: "SHIRT-M", Options: map[string]string{"Size": "M"}},
+			32: {ID: 32, ProductID: 3, SKU: "SHIRT-L", Price: &largePrice, Image: "shirt-l.png", Options: map[string]string{"Size": "L"}},
+		},
+		inventory: inventoryStore,
+	}
+	orderStore := &mockOrderStore{}
+	return inventoryStore, orderStore, &mockTxManager{products: productStore, variants: variantStore, inventory: inventoryStore, orders: orderStore}
+}
+
+func checkout(t *testing.T, handler *Handler, payload types.CartCheckoutPayload) *httptest.ResponseRecorder {
+	t.Helper()
+
+	marshalled, _ := json.Marshal(payload)
+	req, err := http.NewRequest(http.MethodPost, "/cart/checkout", bytes.NewBuffer(marshalled))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(context.WithValue(req.Context(), auth.UserKey, 1))
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/cart/checkout", handler.handleCheckout)
+	router.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func TestCartServiceHandlers(t *testing.T) {
+	cart := types.CartCheckoutPayload{
+		Items: []types.CartItem{
+			{ProductID: 1, Quantity: 2},
+			{ProductID: 2, Quantity: 1},
+		},
+	}
+
+	t.Run("Should create the order and record the sales", func(t *testing.T) {
+		inventoryStore, orderStore, txManager := newTestStores()
+		handler := NewHandler(txManager, newTestAddressStore(), nil, nil, nil, inventory.PriorityStrategy{})
+
+		rr := checkout(t, handler, cart)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+
+		var response struct {
+			TotalPrice types.Money `json:"total_price"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+		if want := types.NewMoney(2250, types.DefaultCurrency); response.TotalPrice != want {
+			t.Errorf("Expected total price %v, got %v", want, response.TotalPrice)
+		}
+		if orderStore.orders[0].Total != response.TotalPrice {
+			t.Errorf("Expected order total %v, got %v", response.TotalPrice, orderStore.orders[0].Total)
+		}
+
+		if inventoryStore.onHand(1) != 3 || inventoryStore.onHand(2) != 2 {
+			t.Errorf("Expected stock to be taken, got %+v", inventoryStore.movements)
+		}
+		for _, m := range inventoryStore.movements[5:] {
+			if m.Type != types.MovementSale || m.OrderID == nil || *m.OrderID != orderStore.orders[0].ID || m.CreatedBy == nil || *m.CreatedBy != 1 {
+				t.Errorf("Expected a sale of the order by the user, got %+v", m)
+			}
+		}
+		if len(orderStore.orders) != 1 || len(orderStore.items) != 2 {
+			t.Errorf("Expected 1 order with 2 items, got %d orders and %d items", len(orderStore.orders), len(orderStore.items))
+		}
+	})
+
+	t.Run("Should reject the checkout if stock is insufficient", func(t *testing.T) {
+		inventoryStore, orderStore, txManager := newTestStores()
+		handler := NewHandler(txManager, newTestAddressStore(), nil, nil, nil, inventory.PriorityStrategy{})
+
+		rr := checkout(t, handler, types.CartCheckoutPayload{
+			Items: []types.CartItem{{ProductID: 1, Quantity: 6}},
+		})
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+		if inventoryStore.onHand(1) != 5 || len(orderStore.orders) != 0 {
+			t.Error("Expected no changes to stock or orders")
+		}
+	})
+
+	t.Run("Should not oversell a product listed on several cart lines", func(t *testing.T) {
+		inventoryStore, orderStore, txManager := newTestStores()
+		handler := NewHandler(txManager, newTestAddressStore(), nil, nil, nil, inventory.PriorityStrategy{})
+
+		rr := checkout(t, handler, types.CartCheckoutPayload{
+			Items: []types.CartItem{{ProductID: 1, Quantity: 3}, {ProductID: 1, Quantity: 3}},
+		})
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+		if inventoryStore.onHand(1) != 5 || len(orderStore.orders) != 0 {
+			t.Error("Expected no changes to stock or orders")
+		}
+	})
+
+	t.Run("Should reject an empty cart", func(t *testing.T) {
+		_, orderStore, txManager := newTestStores()
+		handler := NewHandler(txManager, newTestAddressStore(), nil, nil, nil, inventory.PriorityStrategy{})
+
+		rr := checkout(t, handler, types.CartCheckoutPayload{Items: []types.CartItem{}})
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+		if len(orderStore.orders) != 0 {
+			t.Error("Expected no order to be created")
+		}
+	})
+
+	t.Run("Should ship to the default shipping address", func(t *testing.T) {
+		_, orderStore, txManager := newTestStores()
+		handler := NewHandler(txManager, newTestAddressStore(), nil, nil, nil, inventory.PriorityStrategy{})
+
+		rr := checkout(t, handler, cart)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if want := "Home\n1 Main St\nLisbon 1000-001\nPT"; orderStore.orders[0].Address != want {
+			t.Errorf("Expected address %q, got %q", want, orderStore.orders[0].Address)
+		}
+	})
+
+	t.Run("Should ship to a saved address", func(t *testing.T) {
+		_, orderStore, txManager := newTestStores()
+		handler := NewHandler(txManager, newTestAddressStore(), nil, nil, nil, inventory.PriorityStrategy{})
+
+		rr := checkout(t, handler, types.CartCheckoutPayload{Items: cart.Items, ShippingAddressID: 2})
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if want := "Work\n2 Side St\nPorto 4000-001\nPT"; orderStore.orders[0].Address != want {
+			t.Errorf("Expected address %q, got %q", want, orderStore.orders[0].Address)
+		}
+	})
+
+	t.Run("Should ship to an address given at checkout", func(t *testing.T) {
+		_, orderStore, txManager := newTestStores()
+		handler := NewHandler(txManager, newTestAddressStore(), nil, nil, nil, inventory.PriorityStrategy{})
+
+		rr := checkout(t, handler, types.CartCheckoutPayload{
+			Items: cart.Items,
+			ShippingAddress: &types.AddressPayload{
+				Name:       "Jane Doe",
+				Line1:      "10 Rua Augusta",
+				Line2:      "Apt 2",
+				City:       "Lisbon",
+				Region:     "Lisboa",
+				PostalCode: "1100-053",
+				Country:    "PT",
+			},
+		})
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if want := "Jane Doe\n10 Rua Augusta\nApt 2\nLisbon, Lisboa 1100-053\nPT"; orderStore.orders[0].Address != want {
+			t.Errorf("Expected address %q, got %q", want, orderStore.orders[0].Address)
+		}
+	})
+
+	t.Run("Should reject an invalid address given at checkout", func(t *testing.T) {
+		_, orderStore, txManager := newTestStores()
+		handler := NewHandler(txManager, newTestAddressStore(), nil, nil, nil, inventory.PriorityStrategy{})
+
+		rr := checkout(t, handler, types.CartCheckoutPayload{
+			Items:           cart.Items,
+			ShippingAddress: &types.AddressPayload{Name: "Jane Doe", Country: "Portugal"},
+		})
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+		if len(orderStore.orders) != 0 {
+			t.Error("Expected no order to be created")
+		}
+	})
+
+	t.Run("Should reject both an address and an address ID", func(t *testing.T) {
+		_, _, txManager := newTestStores()
+		handler := NewHandler(txManager, newTestAddressStore(), nil, nil, nil, inventory.PriorityStrategy{})
+
+		rr := checkout(t, handler, types.CartCheckoutPayload{
+			Items:             cart.Items,
+			ShippingAddressID: 2,
+			ShippingAddress: &types.AddressPayload{
+				Name: "Jane Doe", Line1: "10 Rua Augusta", City: "Lisbon", PostalCode: "1100-053", Country: "PT",
+			},
+		})
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("Should reject another user's saved address", func(t *testing.T) {
+		_, orderStore, txManager := newTestStores()
+		handler := NewHandler(txManager, newTestAddressStore(), nil, nil, nil, inventory.PriorityStrategy{})
+
+		rr := checkout(t, handler, types.CartCheckoutPayload{Items: cart.Items, ShippingAddressID: 3})
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+		if len(orderStore.orders) != 0 {
+			t.Error("Expected no order to be created")
+		}
+	})
+
+	t.Run("Should require an address when there is no default", func(t *testing.T) {
+		_, _, txManager := newTestStores()
+		handler := NewHandler(txManager, &mockAddressStore{}, nil, nil, nil, inventory.PriorityStrategy{})
+
+		rr := checkout(t, handler, cart)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	failures := []struct {
+		name   string
+		inject func(*mockTxManager)
+	}{
+		{"loading variants", func(tx *mockTxManager) { tx.variants.failGet = true }},
+		{"recording the sales", func(tx *mockTxManager) { tx.inventory.failAdd = true }},
+		{"creating the order", func(tx *mockTxManager) { tx.orders.failCreateOrder = true }},
+		{"creating the first order item", func(tx *mockTxManager) { tx.orders.failCreateItem = 1 }},
+		{"creating the second order item", func(tx *mockTxManager) { tx.orders.failCreateItem = 2 }},
+	}
+
+	for _, f := range failures {
+		t.Run("Should roll back everything when "+f.name+" fails", func(t *testing.T) {
+			inventoryStore, orderStore, txManager := newTestStores()
+			f.inject(txManager)
+			handler := NewHandler(txManager, newTestAddressStore(), nil, nil, nil, inventory.PriorityStrategy{})
+
+			rr := checkout(t, handler, cart)
+
+			if rr.Code != http.StatusInternalServerError {
+				t.Errorf("Expected status code %d, got %d", http.StatusInternalServerError, rr.Code)
+			}
+			if len(inventoryStore.movements) != 5 {
+				t.Errorf("Expected stock to be untouched, got %+v", inventoryStore.movements)
+			}
+			if len(orderStore.orders) != 0 || len(orderStore.items) != 0 {
+				t.Errorf("Expected no orders or items, got %d orders and %d items", len(orderStore.orders), len(orderStore.items))
+			}
+		})
+	}
+}
+
+func TestVariantCheckout(t *testing.T) {
+	t.Run("Should sell a variant at its own price and record it", func(t *testing.T) {
+		inventoryStore, orderStore, txManager := newTestStores()
+		handler := NewHandler(txManager, newTestAddressStore(), nil, nil, nil, inventory.PriorityStrategy{})
+
+		rr := checkout(t, handler, types.CartCheckoutPayload{
+			Items: []types.CartItem{{VariantID: 32, Quantity: 1}, {ProductID: 3, VariantID: 31, Quantity: 2}},
+		})
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		if want := types.NewMoney(6200, types.DefaultCurrency); orderStore.orders[0].Total != want {
+			t.Errorf("Expected total price %v, got %v", want, orderStore.orders[0].Total)
+		}
+		if inventoryStore.onHand(31) != 0 || inventoryStore.onHand(32) != 0 {
+			t.Errorf("Expected the variants to sell out, got %+v", inventoryStore.movements)
+		}
+
+		large := orderStore.items[0]
+		if large.ProductID != 3 || large.VariantID != 32 || large.SKU != "SHIRT-L" || large.Options["Size"] != "L" {
+			t.Errorf("Expected the item to record the variant, got %+v", large)
+		}
+		if large.ProductImage != "shirt-l.png" || orderStore.items[1].ProductImage != "shirt.png" {
+			t.Errorf("Expected the variant's image, or else the product's, got %q and %q", large.ProductImage, orderStore.items[1].ProductImage)
+		}
+	})
+
+	t.Run("Should resolve a product sold as a single variant", func(t *testing.T) {
+		inventoryStore, _, txManager := newTestStores()
+		handler := NewHandler(txManager, newTestAddressStore(), nil, nil, nil, inventory.PriorityStrategy{})
+
+		if rr := checkout(t, handler, types.CartCheckoutPayload{Items: []types.CartItem{{ProductID: 2, Quantity: 1}}}); rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		if q := inventoryStore.onHand(2); q != 2 {
+			t.Errorf("Expected a unit of variant 2 to be sold, got %d left", q)
+		}
+	})
+
+	for name, item := range map[string]types.CartItem{
+		"a product with several variants": {ProductID: 3, Quantity: 1},
+		"a variant of another product":    {ProductID: 1, VariantID: 32, Quantity: 1},
+		"an unknown variant":              {VariantID: 99, Quantity: 1},
+		"more than a variant has":         {VariantID: 31, Quantity: 3},
+	} {
+		t.Run("Should reject "+name, func(t *testing.T) {
+			inventoryStore, orderStore, txManager := newTestStores()
+			handler := NewHandler(txManager, newTestAddressStore(), nil, nil, nil, inventory.PriorityStrategy{})
+
+			if rr := checkout(t, handler, types.CartCheckoutPayload{Items: []types.CartItem{item}}); rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+			}
+			if inventoryStore.onHand(31) != 2 || len(orderStore.orders) != 0 {
+				t.Error("Expected no changes to stock or orders")
+			}
+		})
+	}
+}
+
+func TestCheckoutAllocation(t *testing.T) {
+	// Variant 1 has 2 units in the main warehouse and 3 in the outlet.
+	tests := []struct {
+		name     string
+		strategy types.AllocationStrategy
+		items    []types.CartItem
+		main     int
+		outlet   int
+	}{
+		{"priority takes from the main warehouse first", inventory.PriorityStrategy{}, []types.CartItem{{VariantID: 1, Quantity: 2}}, 0, 3},
+		{"priority moves on to the outlet", inventory.PriorityStrategy{}, []types.CartItem{{VariantID: 1, Quantity: 4}}, 0, 1},
+		{"largest takes from the outlet first", inventory.LargestFirstStrategy{}, []types.CartItem{{VariantID: 1, Quantity: 2}}, 2, 1},
+		{"lines of the same variant share the stock", inventory.PriorityStrategy{}, []types.CartItem{{VariantID: 1, Quantity: 1}, {VariantID: 1, Quantity: 4}}, 0, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run("Should allocate as "+tc.name, func(t *testing.T) {
+			inventoryStore, orderStore, txManager := newTestStores()
+			handler := NewHandler(txManager, newTestAddressStore(), nil, nil, nil, tc.strategy)
+
+			if rr := checkout(t, handler, types.CartCheckoutPayload{Items: tc.items}); rr.Code != http.StatusOK {
+				t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+			}
+
+			if main, outlet := inventoryStore.onHandAt(1, 1), inventoryStore.onHandAt(1, 3); main != tc.main || outlet != tc.outlet {
+				t.Errorf("Expected %d left in the main warehouse and %d in the outlet, got %d and %d", tc.main, tc.outlet, main, outlet)
+			}
+			if len(orderStore.items) != len(tc.items) {
+				t.Errorf("Expected %d order items, got %d", len(tc.items), len(orderStore.items))
+			}
+		})
+	}
+}
+
+func TestConcurrentCheckout(t *testing.T) {
+	const stock = 10
+	const buyers = 50
+
+	productStore := &mockProductStore{
+		products: map[int]types.Product{
+			1: {ID: 1, Name: "Limited Product", Price: types.NewMoney(1000, types.DefaultCurrency)},
+		},
+	}
+	inventoryStore := newTestInventory(receipt(1, 1, stock/2), receipt(1, 3, stock-stock/2))
+	variantStore := &mockVariantStore{
+		variants: map[int]types.ProductVariant{
+			1: {ID: 1, ProductID: 1, SKU: "P1"},
+		},
+		inventory: inventoryStore,
+	}
+	orderStore := &mockOrderStore{}
+	txManager := &passthroughTxManager{products: productStore, variants: variantStore, inventory: inventoryStore, orders: orderStore}
+	handler := NewHandler(txManager, newTestAddressStore(), nil, nil, nil, inventory.PriorityStrategy{})
+
+	cart := types.CartCheckoutPayload{
+		Items: []types.CartItem{{ProductID: 1, Quantity: 1}},
+	}
+
+	var wg sync.WaitGroup
+	codes := make(chan int, buyers)
+	for i := 0; i < buyers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- checkout(t, handler, cart).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	sold := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			sold++
+		case http.StatusBadRequest:
+		default:
+			t.Errorf("Unexpected status code %d", code)
+		}
+	}
+
+	if sold != stock {
+		t.Errorf("Expected exactly %d successful checkouts, got %d", stock, sold)
+	}
+	if q := inventoryStore.onHand(1); q != 0 {
+		t.Errorf("Expected stock to end at 0, got %d", q)
+	}
+	if len(orderStore.orders) != stock {
+		t.Errorf("Expected %d orders, got %d", stock, len(orderStore.orders))
+	}
+}
+
+// TestCheckoutLocksBeforeReading checks that a checkout takes its locks
+// before its first read, which would otherwise fix a snapshot of the stock
+// taken before the lock was granted.
+func TestCheckoutLocksBeforeReading(t *testing.T) {
+	tests := []struct {
+		name string
+		item types.CartItem
+		lock string
+	}{
+		{"a variant", types.CartItem{VariantID: 1, Quantity: 1}, "LockVariants([1], [])"},
+		{"a product sold as a single variant", types.CartItem{ProductID: 1, Quantity: 1}, "LockVariants([], [1])"},
+	}
+
+	for _, tc := range tests {
+		t.Run("Should lock before reading when buying "+tc.name, func(t *testing.T) {
+			_, _, txManager := newTestStores()
+
+			var calls []string
+			stores := types.Stores{
+				Products:  &recordingProductStore{mockProductStore: txManager.products, calls: &calls},
+				Variants:  &recordingVariantStore{mockVariantStore: txManager.variants, calls: &calls},
+				Inventory: &recordingInventoryStore{mockInventoryStore: txManager.inventory, calls: &calls},
+				Orders:    txManager.orders,
+			}
+
+			if _, _, err := createOrder(context.Background(), stores, inventory.PriorityStrategy{}, []types.CartItem{tc.item}, 1, "address"); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(calls) < 2 || calls[0] != tc.lock || slices.Contains(calls[1:], tc.lock) {
+				t.Errorf("Expected %s once, before any read, got %v", tc.lock, calls)
+			}
+		})
+	}
+}
+
+// TestCalculateTotalPriceMatchesLineSums checks that the order total is
+// exactly the sum of its line totals, computed independently from the decimal
+// prices.
+func TestCalculateTotalPriceMatchesLineSums(t *testing.T) {
+	type line struct {
+		Cents    uint32
+		Quantity uint8
+	}
+
+	property := func(lines []line) bool {
+		products := map[int]types.Product{}
+		variants := map[int]types.ProductVariant{}
+		items := []types.CartItem{}
+		expected := new(big.Rat)
+
+		for i, l := range lines {
+			// Every other variant overrides its product's price.
+			price := types.NewMoney(int64(l.Cents), types.DefaultCurrency)
+			products[i+1] = types.Product{ID: i + 1, Price: price}
+			variants[i+1] = types.ProductVariant{ID: i + 1, ProductID: i + 1}
+			if i%2 == 1 {
+				products[i+1] = types.Product{ID: i + 1, Price: types.NewMoney(1, types.DefaultCurrency)}
+				variants[i+1] = types.ProductVariant{ID: i + 1, ProductID: i + 1, Price: &price}
+			}
+			items = append(items, types.CartItem{VariantID: i + 1, Quantity: int(l.Quantity)})
+
+			decimal, _ := new(big.Rat).SetString(price.String())
+			expected.Add(expected, decimal.Mul(decimal, big.NewRat(int64(l.Quantity), 1)))
+		}
+
+		total, err := calculateTotalPrice(items, variants, products)
+		if err != nil {
+			return false
+		}
+
+		actual, ok := new(big.Rat).SetString(total.String())
+		return ok && actual.Cmp(expected) == 0 && total.Currency == types.DefaultCurrency
+	}
+
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,92 +1,326 @@
 package cart
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"slices"
 
 	"github.com/joshbarros/golang-ecommerce-api/types"
 )
 
+// cartError marks errors caused by the contents of the cart rather than by a
+// failing store, so the handler can answer with a client error.
+type cartError struct {
+	err error
+}
+
+func (e *cartError) Error() string {
+	return e.err.Error()
+}
+
+// resolveVariants fills in the variant of items naming only a product, which
+// is allowed for products sold as a single variant.
+func resolveVariants(store types.VariantStore, items []types.CartItem) ([]types.CartItem, error) {
+	resolved := slices.Clone(items)
+	for i, item := range resolved {
+		if item.VariantID != 0 {
+			continue
+		}
+		if item.ProductID == 0 {
+			return nil, &cartError{fmt.Errorf("Cart items need a variantID")}
+		}
+
+		variants, err := store.GetVariantsByProductID(item.ProductID)
+		if err != nil {
+			return nil, err
+		}
+
+		switch len(variants) {
+		case 0:
+			return nil, &cartError{fmt.Errorf("Product %d is not available in the store, please refresh your cart", item.ProductID)}
+		case 1:
+			resolved[i].VariantID = variants[0].ID
+		default:
+			return nil, &cartError{fmt.Errorf("Product %d comes in several variants, please pick one", item.ProductID)}
+		}
+	}
+
+	return resolved, nil
+}
+
 func getCartItemsID(items []types.CartItem) ([]int, error) {
-	productsIds := make([]int, len(items))
+	variantIDs := make([]int, len(items))
 	for i, item := range items {
 		if item.Quantity <= 0 {
-			return nil, fmt.Errorf("Invalid quantity for the product %d", item.ProductID)
+			return nil, fmt.Errorf("Invalid quantity for the variant %d", item.VariantID)
 		}
 
-		productsIds[i] = item.ProductID
+		variantIDs[i] = item.VariantID
 	}
 
-	return productsIds, nil
+	return variantIDs, nil
 }
 
-func (h *Handler) createOrder(products []types.Product, items []types.CartItem, userID int) (int, float64, error) {
+// createOrder takes stock, from the locations picked by the allocation
+// strategy, and writes the order with its items using the given stores. It
+// must run inside a transaction: any error returned means the caller has to
+// roll back every write made so far.
+func createOrder(ctx context.Context, stores types.Stores, allocation types.AllocationStrategy, items []types.CartItem, userID int, address string) (int, types.Money, error) {
+	if len(items) == 0 {
+		return 0, types.Money{}, &cartError{fmt.Errorf("Cart is empty")}
+	}
+
+	// Stock is the sum of the ledger, so concurrent checkouts of the same
+	// variants are serialized by locking them before anything is read: the
+	// first plain read fixes the transaction's snapshot, which must not predate
+	// the sales of the checkout that held the lock. Items naming a product lock
+	// each of its variants, as the one sold is not known yet.
+	var lockedVariants, lockedProducts []int
+	for _, item := range items {
+		if item.VariantID != 0 {
+			lockedVariants = append(lockedVariants, item.VariantID)
+		} else {
+			lockedProducts = append(lockedProducts, item.ProductID)
+		}
+	}
+	if err := stores.Inventory.LockVariants(ctx, lockedVariants, lockedProducts); err != nil {
+		return 0, types.Money{}, err
+	}
+
+	items, err := resolveVariants(stores.Variants, items)
+	if err != nil {
+		return 0, types.Money{}, err
+	}
+
+	variantIDs, err := getCartItemsID(items)
+	if err != nil {
+		return 0, types.Money{}, &cartError{err}
+	}
+
+	variants, err := stores.Variants.GetVariantsByID(variantIDs)
+	if err != nil {
+		return 0, types.Money{}, err
+	}
+
+	variantMap := make(map[int]types.ProductVariant)
+	productIDs := []int{}
+	for _, variant := range variants {
+		variantMap[variant.ID] = variant
+		productIDs = append(productIDs, variant.ProductID)
+	}
+
 	productMap := make(map[int]types.Product)
-	for _, product := range products {
-		productMap[product.ID] = product
+	if len(productIDs) > 0 {
+		products, err := stores.Products.GetProductsByID(productIDs)
+		if err != nil {
+			return 0, types.Money{}, err
+		}
+
+		for _, product := range products {
+			productMap[product.ID] = product
+		}
 	}
 
-	if err := checkIfCartIsInStock(items, productMap); err != nil {
-		return 0, 0, nil
+	if err := checkIfCartIsInStock(items, variantMap, productMap); err != nil {
+		return 0, types.Money{}, &cartError{err}
 	}
 
-	totalPrice := calculateTotalPrice(items, productMap)
+	totalPrice, err := calculateTotalPrice(items, variantMap, productMap)
+	if err != nil {
+		return 0, types.Money{}, &cartError{err}
+	}
 
-	for _, item := range items {
-		product := productMap[item.ProductID]
-		product.Quantity -= item.Quantity
+	levels, err := stores.Inventory.GetStockLevels(variantIDs)
+	if err != nil {
+		return 0, types.Money{}, err
+	}
 
-		h.productStore.UpdateProduct(product)
+	allocations, err := allocateItems(allocation, items, levels)
+	if errors.Is(err, types.ErrInsufficientStock) {
+		variant := variantMap[items[len(allocations)].VariantID]
+		return 0, types.Money{}, &cartError{fmt.Errorf("Product %s is not available in the quantity requested", variantName(productMap[variant.ProductID], variant))}
+	}
+	if err != nil {
+		return 0, types.Money{}, err
 	}
 
-	orderID, err := h.store.CreateOrder(types.Order{
+	orderID, err := stores.Orders.CreateOrder(types.Order{
 		UserID:  userID,
 		Total:   totalPrice,
-		Status:  "pending",
-		Address: "address",
+		Status:  types.OrderStatusPending,
+		Address: address,
 	})
-
 	if err != nil {
-		return 0, 0, err
+		return 0, types.Money{}, err
+	}
+
+	var sales []types.StockMovement
+	for i, item := range items {
+		for _, a := range allocations[i] {
+			sales = append(sales, types.StockMovement{
+				VariantID:  item.VariantID,
+				LocationID: a.LocationID,
+				Type:       types.MovementSale,
+				Quantity:   -a.Quantity,
+				OrderID:    &orderID,
+				CreatedBy:  &userID,
+			})
+		}
+	}
+
+	if err := stores.Inventory.AddMovements(ctx, sales); err != nil {
+		return 0, types.Money{}, err
 	}
 
 	for _, item := range items {
-		h.store.CreateOrderItem(types.OrderItem{
-			OrderID:   orderID,
-			ProductID: item.ProductID,
-			Quantity:  item.Quantity,
-			Price:     productMap[item.ProductID].Price,
+		variant := variantMap[item.VariantID]
+		product := productMap[variant.ProductID]
+
+		image := variant.Image
+		if image == "" {
+			image = product.Image
+		}
+
+		err := stores.Orders.CreateOrderItem(types.OrderItem{
+			OrderID:      orderID,
+			ProductID:    product.ID,
+			VariantID:    variant.ID,
+			SKU:          variant.SKU,
+			ProductName:  product.Name,
+			ProductImage: image,
+			Options:      variant.Options,
+			Quantity:     item.Quantity,
+			Price:        variant.UnitPrice(product),
 		})
+		if err != nil {
+			return 0, types.Money{}, err
+		}
 	}
 
 	return orderID, totalPrice, nil
 }
 
-func checkIfCartIsInStock(cartItems []types.CartItem, products map[int]types.Product) error {
+// allocateItems allocates the stock of every item, in order, taking what
+// earlier items of the same variant were allocated out of the levels. On
+// error it returns the allocations of the items before the failing one.
+func allocateItems(strategy types.AllocationStrategy, items []types.CartItem, levels []types.StockLevel) ([][]types.Allocation, error) {
+	remaining := make(map[int][]types.StockLevel)
+	for _, l := range levels {
+		remaining[l.VariantID] = append(remaining[l.VariantID], l)
+	}
+
+	allocations := make([][]types.Allocation, 0, len(items))
+	for _, item := range items {
+		allocated, err := strategy.Allocate(remaining[item.VariantID], item.Quantity)
+		if err != nil {
+			return allocations, err
+		}
+
+		for _, a := range allocated {
+			for i := range remaining[item.VariantID] {
+				if remaining[item.VariantID][i].LocationID == a.LocationID {
+					remaining[item.VariantID][i].Quantity -= a.Quantity
+				}
+			}
+		}
+
+		allocations = append(allocations, allocated)
+	}
+
+	return allocations, nil
+}
+
+// resolveShippingAddress picks the address an order ships to: the one given
+// in the payload, the saved address it references, or else the user's default
+// shipping address.
+func resolveShippingAddress(store types.AddressStore, cart types.CartCheckoutPayload, userID int) (string, error) {
+	if cart.ShippingAddress != nil {
+		return cart.ShippingAddress.ToAddress(userID).Format(), nil
+	}
+
+	if cart.ShippingAddressID != 0 {
+		address, err := store.GetAddressByID(cart.ShippingAddressID)
+		if errors.Is(err, types.ErrAddressNotFound) || (err == nil && address.UserID != userID) {
+			return "", &cartError{types.ErrAddressNotFound}
+		}
+		if err != nil {
+			return "", err
+		}
+		return address.Format(), nil
+	}
+
+	addresses, err := store.GetAddressesByUserID(userID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, address := range addresses {
+		if address.IsDefaultShipping {
+			return address.Format(), nil
+		}
+	}
+
+	return "", &cartError{fmt.Errorf("A shipping address is required")}
+}
+
+// checkIfCartIsInStock checks every item is for a variant on sale, of the
+// product it names if any, with enough stock left.
+func checkIfCartIsInStock(cartItems []types.CartItem, variants map[int]types.ProductVariant, products map[int]types.Product) error {
 	if len(cartItems) == 0 {
 		return fmt.Errorf("Cart is empty")
 	}
 
 	for _, item := range cartItems {
-		product, ok := products[item.ProductID]
+		variant, ok := variants[item.VariantID]
+		if !ok {
+			return fmt.Errorf("Variant %d is not available in the store, please refresh your cart", item.VariantID)
+		}
+
+		if item.ProductID != 0 && item.ProductID != variant.ProductID {
+			return fmt.Errorf("Variant %d is not a variant of product %d", variant.ID, item.ProductID)
+		}
+
+		product, ok := products[variant.ProductID]
 		if !ok {
-			return fmt.Errorf("Product %d is not available in the store, please refresh your cart", item.ProductID)
+			return fmt.Errorf("Product %d is not available in the store, please refresh your cart", variant.ProductID)
 		}
 
-		if product.Quantity < item.Quantity {
-			return fmt.Errorf("Product %s is not available in the quantity requested", product.Name)
+		if variant.Quantity < item.Quantity {
+			return fmt.Errorf("Product %s is not available in the quantity requested", variantName(product, variant))
 		}
 	}
 
 	return nil
 }
 
-func calculateTotalPrice(cartItems []types.CartItem, products map[int]types.Product) float64 {
-	var total float64
+// calculateTotalPrice sums the line totals exactly in minor units. It fails if
+// the variants are priced in different currencies or the total overflows.
+func calculateTotalPrice(cartItems []types.CartItem, variants map[int]types.ProductVariant, products map[int]types.Product) (types.Money, error) {
+	total := types.NewMoney(0, types.DefaultCurrency)
 
 	for _, item := range cartItems {
-		product := products[item.ProductID]
-		total += product.Price * float64(item.Quantity)
+		variant := variants[item.VariantID]
+
+		line, err := variant.UnitPrice(products[variant.ProductID]).Mul(int64(item.Quantity))
+		if err != nil {
+			return types.Money{}, err
+		}
+
+		total, err = total.Add(line)
+		if err != nil {
+			return types.Money{}, err
+		}
 	}
 
-	return total
+	return total, nil
+}
+
+// variantName names a variant in messages to customers: by its product's name,
+// followed by its SKU when the product comes in several variants.
+func variantName(product types.Product, variant types.ProductVariant) string {
+	if len(variant.Options) == 0 {
+		return product.Name
+	}
+	return fmt.Sprintf("%s (%s)", product.Name, variant.SKU)
 }
@@ -0,0 +1,295 @@
+package category
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+	"github.com/joshbarros/golang-ecommerce-api/utils"
+)
+
+type Handler struct {
+	store        types.CategoryStore
+	productStore types.ProductStore
+	txManager    types.TxManager
+	userStore    types.UserStore
+	tokenStore   types.TokenStore
+	apiKeyStore  types.APIKeyStore
+}
+
+func NewHandler(store types.CategoryStore, productStore types.ProductStore, txManager types.TxManager, userStore types.UserStore, tokenStore types.TokenStore, apiKeyStore types.APIKeyStore) *Handler {
+	return &Handler{
+		store:        store,
+		productStore: productStore,
+		txManager:    txManager,
+		userStore:    userStore,
+		tokenStore:   tokenStore,
+		apiKeyStore:  apiKeyStore,
+	}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/categories", h.handleGetCategories).Methods(http.MethodGet)
+	router.HandleFunc("/categories", h.adminOnly(h.handleCreateCategory)).Methods(http.MethodPost)
+	router.HandleFunc("/categories/{id}", h.handleGetCategory).Methods(http.MethodGet)
+	router.HandleFunc("/categories/{id}", h.adminOnly(h.handleUpdateCategory)).Methods(http.MethodPut)
+	router.HandleFunc("/categories/{id}", h.adminOnly(h.handleDeleteCategory)).Methods(http.MethodDelete)
+	router.HandleFunc("/products/{id}/categories", h.handleGetProductCategories).Methods(http.MethodGet)
+	router.HandleFunc("/products/{id}/categories", h.adminOnly(h.handleSetProductCategories)).Methods(http.MethodPut)
+}
+
+// adminOnly guards category changes like other catalog writes: an admin's
+// login or a products:write API key owned by an admin.
+func (h *Handler) adminOnly(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return auth.WithJWTOrAPIKeyAuth(auth.RequireRole(handlerFunc, types.RoleAdmin), types.ScopeProductsWrite, h.userStore, h.tokenStore, h.apiKeyStore)
+}
+
+// handleGetCategories returns the whole category tree, for navigation.
+func (h *Handler) handleGetCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := h.store.GetCategories()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, buildTree(categories))
+}
+
+func (h *Handler) handleGetCategory(w http.ResponseWriter, r *http.Request) {
+	category, ok := h.getCategory(w, r)
+	if !ok {
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, category)
+}
+
+func (h *Handler) handleCreateCategory(w http.ResponseWriter, r *http.Request) {
+	category, ok := parseCategoryPayload(w, r)
+	if !ok {
+		return
+	}
+
+	h.saveCategory(w, r, &category, http.StatusCreated)
+}
+
+// handleUpdateCategory replaces a category. Changing its parent moves it,
+// with its subcategories, elsewhere in the tree.
+func (h *Handler) handleUpdateCategory(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.getCategory(w, r)
+	if !ok {
+		return
+	}
+
+	category, ok := parseCategoryPayload(w, r)
+	if !ok {
+		return
+	}
+
+	category.ID = existing.ID
+
+	h.saveCategory(w, r, &category, http.StatusOK)
+}
+
+func (h *Handler) saveCategory(w http.ResponseWriter, r *http.Request, category *types.Category, status int) {
+	err := h.txManager.WithTx(r.Context(), func(stores types.Stores) error {
+		if err := saveCategory(stores.Categories, category); err != nil {
+			return err
+		}
+
+		saved, err := stores.Categories.GetCategoryByID(category.ID)
+		if err != nil {
+			return err
+		}
+
+		*category = *saved
+		return nil
+	})
+	if errors.Is(err, ErrSlugTaken) || errors.Is(err, ErrParentNotFound) || errors.Is(err, ErrCategoryCycle) {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, status, category)
+}
+
+// handleDeleteCategory deletes a category once it has no subcategories. Its
+// products stay in the catalog.
+func (h *Handler) handleDeleteCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid category ID"))
+		return
+	}
+
+	err = h.txManager.WithTx(r.Context(), func(stores types.Stores) error {
+		return deleteCategory(stores.Categories, id)
+	})
+	if errors.Is(err, types.ErrCategoryNotFound) {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	if errors.Is(err, ErrHasSubcategories) {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handleGetProductCategories(w http.ResponseWriter, r *http.Request) {
+	product, ok := h.getProduct(w, r)
+	if !ok {
+		return
+	}
+
+	categories, err := h.store.GetCategoriesByProductID(product.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, categories)
+}
+
+// handleSetProductCategories replaces the categories a product is in, and
+// returns them.
+func (h *Handler) handleSetProductCategories(w http.ResponseWriter, r *http.Request) {
+	product, ok := h.getProduct(w, r)
+	if !ok {
+		return
+	}
+
+	var payload types.SetProductCategoriesPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid payload %v", errors))
+		return
+	}
+
+	categoryIDs := slices.Clone(payload.CategoryIDs)
+	slices.Sort(categoryIDs)
+	categoryIDs = slices.Compact(categoryIDs)
+
+	var categories []types.Category
+	var missing int
+	err := h.txManager.WithTx(r.Context(), func(stores types.Stores) error {
+		for _, id := range categoryIDs {
+			if _, err := stores.Categories.GetCategoryByID(id); err != nil {
+				missing = id
+				return err
+			}
+		}
+
+		if err := stores.Categories.SetProductCategories(product.ID, categoryIDs); err != nil {
+			return err
+		}
+
+		var err error
+		categories, err = stores.Categories.GetCategoriesByProductID(product.ID)
+		return err
+	})
+	if errors.Is(err, types.ErrCategoryNotFound) {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Category %d not found", missing))
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, categories)
+}
+
+// getCategory loads the category named in the URL, writing a 404 if it does
+// not exist.
+func (h *Handler) getCategory(w http.ResponseWriter, r *http.Request) (*types.Category, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid category ID"))
+		return nil, false
+	}
+
+	category, err := h.store.GetCategoryByID(id)
+	if errors.Is(err, types.ErrCategoryNotFound) {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return nil, false
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	return category, true
+}
+
+// getProduct loads the product named in the URL, writing a 404 if it does
+// not exist or was deleted.
+func (h *Handler) getProduct(w http.ResponseWriter, r *http.Request) (*types.Product, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid product ID"))
+		return nil, false
+	}
+
+	product, err := h.productStore.GetProductByID(id)
+	if errors.Is(err, types.ErrProductNotFound) {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return nil, false
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	return product, true
+}
+
+func parseCategoryPayload(w http.ResponseWriter, r *http.Request) (types.Category, bool) {
+	var payload types.CategoryPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return types.Category{}, false
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid payload %v", errors))
+		return types.Category{}, false
+	}
+
+	slug := payload.Slug
+	if slug == "" {
+		slug = slugify(payload.Name)
+	}
+	if !slugPattern.MatchString(slug) {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid slug %q, expected lowercase letters and digits separated by hyphens", slug))
+		return types.Category{}, false
+	}
+
+	return types.Category{
+		ParentID: payload.ParentID,
+		Name:     payload.Name,
+		Slug:     slug,
+		Position: payload.Position,
+	}, true
+}
@@ -0,0 +1,283 @@
+package category
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// Mock implementation of the CategoryStore interface with in-memory data
+type mockCategoryStore struct {
+	categories        []types.Category
+	productCategories map[int][]int
+}
+
+func (m *mockCategoryStore) GetCategories() ([]types.Category, error) {
+	categories := slices.Clone(m.categories)
+	slices.SortStableFunc(categories, func(a, b types.Category) int {
+		if a.Position != b.Position {
+			return a.Position - b.Position
+		}
+		return a.ID - b.ID
+	})
+	return categories, nil
+}
+
+func (m *mockCategoryStore) GetCategoryByID(id int) (*types.Category, error) {
+	for _, c := range m.categories {
+		if c.ID == id {
+			return &c, nil
+		}
+	}
+	return nil, types.ErrCategoryNotFound
+}
+
+func (m *mockCategoryStore) GetCategoryBySlug(slug string) (*types.Category, error) {
+	for _, c := range m.categories {
+		if c.Slug == slug {
+			return &c, nil
+		}
+	}
+	return nil, types.ErrCategoryNotFound
+}
+
+func (m *mockCategoryStore) CreateCategory(c *types.Category) error {
+	c.ID = len(m.categories) + 1
+	c.CreatedAt = time.Now()
+	m.categories = append(m.categories, *c)
+	return nil
+}
+
+func (m *mockCategoryStore) UpdateCategory(c types.Category) error {
+	for i := range m.categories {
+		if m.categories[i].ID == c.ID {
+			c.CreatedAt = m.categories[i].CreatedAt
+			m.categories[i] = c
+		}
+	}
+	return nil
+}
+
+func (m *mockCategoryStore) DeleteCategory(id int) error {
+	for i, c := range m.categories {
+		if c.ID == id {
+			m.categories = slices.Delete(m.categories, i, i+1)
+			return nil
+		}
+	}
+	return types.ErrCategoryNotFound
+}
+
+func (m *mockCategoryStore) GetCategoriesByProductID(productID int) ([]types.Category, error) {
+	categories := []types.Category{}
+	for _, id := range m.productCategories[productID] {
+		c, err := m.GetCategoryByID(id)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, *c)
+	}
+	return categories, nil
+}
+
+func (m *mockCategoryStore) SetProductCategories(productID int, categoryIDs []int) error {
+	m.productCategories[productID] = categoryIDs
+	return nil
+}
+
+// Mock implementation of the ProductStore interface knowing a single product
+type mockProductStore struct{}
+
+func (m *mockProductStore) GetProducts(q types.ProductQuery) ([]types.Product, error) {
+	return nil, nil
+}
+
+func (m *mockProductStore) CountProducts(q types.ProductQuery) (int, error) {
+	return 0, nil
+}
+
+func (m *mockProductStore) GetProductsByID(ps []int) ([]types.Product, error) {
+	return nil, nil
+}
+
+func (m *mockProductStore) GetProductByID(id int) (*types.Product, error) {
+	if id != 1 {
+		return nil, types.ErrProductNotFound
+	}
+	return &types.Product{ID: 1, Name: "Travel Mug"}, nil
+}
+
+func (m *mockProductStore) CreateProduct(product *types.Product) error {
+	return nil
+}
+
+func (m *mockProductStore) UpdateProduct(product types.Product) error {
+	return nil
+}
+
+func (m *mockProductStore) DeleteProduct(id int) error {
+	return nil
+}
+
+// Mock TxManager running the unit of work directly against the mock store
+type mockTxManager struct {
+	categories *mockCategoryStore
+}
+
+func (m *mockTxManager) WithTx(ctx context.Context, fn func(types.Stores) error) error {
+	return fn(types.Stores{Categories: m.categories})
+}
+
+func serve(t *testing.T, method string, path string, handler http.HandlerFunc, payload any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	if payload != nil {
+		json.NewEncoder(&body).Encode(payload)
+	}
+
+	req, err := http.NewRequest(method, path, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/categories", handler)
+	router.HandleFunc("/categories/{id}", handler)
+	router.HandleFunc("/products/{id}/categories", handler)
+	router.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func TestCategoryHandlers(t *testing.T) {
+	store := &mockCategoryStore{productCategories: map[int][]int{}}
+	handler := NewHandler(store, &mockProductStore{}, &mockTxManager{categories: store}, nil, nil, nil)
+
+	create := func(t *testing.T, payload types.CategoryPayload) types.Category {
+		t.Helper()
+
+		rr := serve(t, http.MethodPost, "/categories", handler.handleCreateCategory, payload)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+		}
+
+		var c types.Category
+		if err := json.NewDecoder(rr.Body).Decode(&c); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+		return c
+	}
+
+	kitchen := create(t, types.CategoryPayload{Name: "Kitchen & Dining", Position: 2})
+	garden := create(t, types.CategoryPayload{Name: "Garden", Position: 1})
+	mugs := create(t, types.CategoryPayload{Name: "Mugs", ParentID: &kitchen.ID})
+	travel := create(t, types.CategoryPayload{Name: "Travel Mugs", Slug: "travel", ParentID: &mugs.ID})
+
+	t.Run("Should derive slugs from names", func(t *testing.T) {
+		if kitchen.Slug != "kitchen-dining" || travel.Slug != "travel" {
+			t.Errorf("Unexpected slugs %q and %q", kitchen.Slug, travel.Slug)
+		}
+	})
+
+	t.Run("Should return the nested tree", func(t *testing.T) {
+		rr := serve(t, http.MethodGet, "/categories", handler.handleGetCategories, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var tree []types.CategoryNode
+		if err := json.NewDecoder(rr.Body).Decode(&tree); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+
+		if len(tree) != 2 || tree[0].ID != garden.ID || tree[1].ID != kitchen.ID {
+			t.Fatalf("Expected garden then kitchen at the top, got %+v", tree)
+		}
+		if len(tree[1].Children) != 1 || len(tree[1].Children[0].Children) != 1 || tree[1].Children[0].Children[0].ID != travel.ID {
+			t.Errorf("Expected kitchen > mugs > travel mugs, got %+v", tree[1])
+		}
+		if tree[0].Children == nil {
+			t.Error("Expected leaves to have an empty list of children")
+		}
+	})
+
+	t.Run("Should reject taken slugs and missing parents", func(t *testing.T) {
+		missing := 99
+		for _, payload := range []types.CategoryPayload{
+			{Name: "Garden"},
+			{Name: "Tools", ParentID: &missing},
+			{Name: "Tools", Slug: "Tools!"},
+			{Name: "???"},
+		} {
+			if rr := serve(t, http.MethodPost, "/categories", handler.handleCreateCategory, payload); rr.Code != http.StatusBadRequest {
+				t.Errorf("%+v: expected status code %d, got %d", payload, http.StatusBadRequest, rr.Code)
+			}
+		}
+	})
+
+	t.Run("Should move a category but not under itself", func(t *testing.T) {
+		rr := serve(t, http.MethodPut, "/categories/1", handler.handleUpdateCategory, types.CategoryPayload{Name: "Kitchen", ParentID: &travel.ID})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+
+		rr = serve(t, http.MethodPut, "/categories/3", handler.handleUpdateCategory, types.CategoryPayload{Name: "Mugs", ParentID: &garden.ID})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+
+		moved, _ := store.GetCategoryByID(mugs.ID)
+		if *moved.ParentID != garden.ID || moved.Slug != "mugs" {
+			t.Errorf("Expected mugs to move under garden, got %+v", moved)
+		}
+	})
+
+	t.Run("Should assign categories to a product", func(t *testing.T) {
+		rr := serve(t, http.MethodPut, "/products/1/categories", handler.handleSetProductCategories, types.SetProductCategoriesPayload{CategoryIDs: []int{travel.ID, kitchen.ID, travel.ID}})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		if ids := store.productCategories[1]; !slices.Equal(ids, []int{kitchen.ID, travel.ID}) {
+			t.Errorf("Expected categories %d and %d, got %v", kitchen.ID, travel.ID, ids)
+		}
+
+		rr = serve(t, http.MethodGet, "/products/1/categories", handler.handleGetProductCategories, nil)
+		var categories []types.Category
+		if err := json.NewDecoder(rr.Body).Decode(&categories); err != nil || len(categories) != 2 {
+			t.Errorf("Expected 2 categories, got %s", rr.Body.String())
+		}
+
+		rr = serve(t, http.MethodPut, "/products/1/categories", handler.handleSetProductCategories, types.SetProductCategoriesPayload{CategoryIDs: []int{99}})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+
+		rr = serve(t, http.MethodPut, "/products/2/categories", handler.handleSetProductCategories, types.SetProductCategoriesPayload{CategoryIDs: []int{}})
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("Should only delete categories without subcategories", func(t *testing.T) {
+		if rr := serve(t, http.MethodDelete, "/categories/3", handler.handleDeleteCategory, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+
+		if rr := serve(t, http.MethodDelete, "/categories/4", handler.handleDeleteCategory, nil); rr.Code != http.StatusNoContent {
+			t.Errorf("Expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+		if rr := serve(t, http.MethodDelete, "/categories/4", handler.handleDeleteCategory, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
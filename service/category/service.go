@@ -0,0 +1,136 @@
+package category
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+var (
+	ErrSlugTaken        = errors.New("Slug is already taken")
+	ErrParentNotFound   = errors.New("Parent category not found")
+	ErrCategoryCycle    = errors.New("Category cannot be moved under itself")
+	ErrHasSubcategories = errors.New("Category has subcategories")
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// slugify derives a slug from a category name: lowercase ASCII letters and
+// digits, with runs of anything else turned into a single hyphen.
+func slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+
+	for _, r := range strings.ToLower(name) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+
+	return b.String()
+}
+
+// buildTree nests categories under their parents, keeping the order they are
+// given in among siblings.
+func buildTree(categories []types.Category) []types.CategoryNode {
+	children := map[int][]types.Category{}
+	roots := []types.Category{}
+	for _, c := range categories {
+		if c.ParentID == nil {
+			roots = append(roots, c)
+		} else {
+			children[*c.ParentID] = append(children[*c.ParentID], c)
+		}
+	}
+
+	var build func([]types.Category) []types.CategoryNode
+	build = func(categories []types.Category) []types.CategoryNode {
+		nodes := make([]types.CategoryNode, len(categories))
+		for i, c := range categories {
+			nodes[i] = types.CategoryNode{Category: c, Children: build(children[c.ID])}
+		}
+		return nodes
+	}
+
+	return build(roots)
+}
+
+// isDescendant reports whether the category id is ancestorID or one of its
+// descendants.
+func isDescendant(categories []types.Category, id int, ancestorID int) bool {
+	parents := make(map[int]*int, len(categories))
+	for _, c := range categories {
+		parents[c.ID] = c.ParentID
+	}
+
+	// The tree has no cycles, but stop after visiting every category anyway.
+	for range len(categories) + 1 {
+		if id == ancestorID {
+			return true
+		}
+
+		parent := parents[id]
+		if parent == nil {
+			return false
+		}
+		id = *parent
+	}
+
+	return false
+}
+
+// saveCategory creates a category, or updates it if it has an ID, after
+// checking its slug is free and its parent exists and is not below it.
+func saveCategory(store types.CategoryStore, c *types.Category) error {
+	existing, err := store.GetCategoryBySlug(c.Slug)
+	if err == nil && existing.ID != c.ID {
+		return ErrSlugTaken
+	}
+	if err != nil && !errors.Is(err, types.ErrCategoryNotFound) {
+		return err
+	}
+
+	if c.ParentID != nil {
+		categories, err := store.GetCategories()
+		if err != nil {
+			return err
+		}
+
+		if !slices.ContainsFunc(categories, func(p types.Category) bool { return p.ID == *c.ParentID }) {
+			return ErrParentNotFound
+		}
+		if c.ID != 0 && isDescendant(categories, *c.ParentID, c.ID) {
+			return ErrCategoryCycle
+		}
+	}
+
+	if c.ID == 0 {
+		return store.CreateCategory(c)
+	}
+
+	return store.UpdateCategory(*c)
+}
+
+// deleteCategory deletes a category, refusing while it has subcategories.
+func deleteCategory(store types.CategoryStore, id int) error {
+	categories, err := store.GetCategories()
+	if err != nil {
+		return err
+	}
+
+	if slices.ContainsFunc(categories, func(c types.Category) bool { return c.ParentID != nil && *c.ParentID == id }) {
+		return ErrHasSubcategories
+	}
+
+	return store.DeleteCategory(id)
+}
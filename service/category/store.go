@@ -0,0 +1,168 @@
+package category
+
+import (
+	"database/sql"
+
+	"github.com/joshbarros/golang-ecommerce-api/db"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+type Store struct {
+	db db.DBTX
+}
+
+func NewStore(db db.DBTX) *Store {
+	return &Store{db: db}
+}
+
+const categoryColumns = "id, parentId, name, slug, position, createdAt"
+
+// GetCategories returns every category, siblings in display order.
+func (s *Store) GetCategories() ([]types.Category, error) {
+	rows, err := s.db.Query("SELECT " + categoryColumns + " FROM categories ORDER BY position, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRowsIntoCategories(rows)
+}
+
+func (s *Store) GetCategoryByID(id int) (*types.Category, error) {
+	rows, err := s.db.Query("SELECT "+categoryColumns+" FROM categories WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRowIntoCategory(rows)
+}
+
+func (s *Store) GetCategoryBySlug(slug string) (*types.Category, error) {
+	rows, err := s.db.Query("SELECT "+categoryColumns+" FROM categories WHERE slug = ?", slug)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRowIntoCategory(rows)
+}
+
+func (s *Store) CreateCategory(c *types.Category) error {
+	res, err := s.db.Exec(
+		"INSERT INTO categories (parentId, name, slug, position) VALUES (?, ?, ?, ?)",
+		c.ParentID, c.Name, c.Slug, c.Position,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	c.ID = int(id)
+	return nil
+}
+
+func (s *Store) UpdateCategory(c types.Category) error {
+	_, err := s.db.Exec(
+		"UPDATE categories SET parentId = ?, name = ?, slug = ?, position = ? WHERE id = ?",
+		c.ParentID, c.Name, c.Slug, c.Position, c.ID,
+	)
+	return err
+}
+
+// DeleteCategory deletes a category without subcategories. Its products are
+// taken out of it.
+func (s *Store) DeleteCategory(id int) error {
+	res, err := s.db.Exec("DELETE FROM categories WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return types.ErrCategoryNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) GetCategoriesByProductID(productID int) ([]types.Category, error) {
+	rows, err := s.db.Query(
+		"SELECT c.id, c.parentId, c.name, c.slug, c.position, c.createdAt FROM categories c "+
+			"JOIN product_categories pc ON pc.categoryId = c.id WHERE pc.productId = ? ORDER BY c.position, c.id",
+		productID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRowsIntoCategories(rows)
+}
+
+// SetProductCategories replaces the categories of a product. It should run
+// in a transaction.
+func (s *Store) SetProductCategories(productID int, categoryIDs []int) error {
+	if _, err := s.db.Exec("DELETE FROM product_categories WHERE productId = ?", productID); err != nil {
+		return err
+	}
+
+	for _, categoryID := range categoryIDs {
+		_, err := s.db.Exec("INSERT INTO product_categories (productId, categoryId) VALUES (?, ?)", productID, categoryID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func scanRowIntoCategory(rows *sql.Rows) (*types.Category, error) {
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, types.ErrCategoryNotFound
+	}
+
+	return scanCategory(rows)
+}
+
+func scanRowsIntoCategories(rows *sql.Rows) ([]types.Category, error) {
+	categories := make([]types.Category, 0)
+	for rows.Next() {
+		c, err := scanCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		categories = append(categories, *c)
+	}
+
+	return categories, rows.Err()
+}
+
+func scanCategory(rows *sql.Rows) (*types.Category, error) {
+	c := new(types.Category)
+
+	var parentID sql.NullInt64
+	err := rows.Scan(&c.ID, &parentID, &c.Name, &c.Slug, &c.Position, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		c.ParentID = &id
+	}
+
+	return c, nil
+}
@@ -0,0 +1,193 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/joshbarros/golang-ecommerce-api/config"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+	"github.com/joshbarros/golang-ecommerce-api/utils"
+)
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+
+	maxKeyLength = 255
+)
+
+// WithIdempotencyKey makes a mutating handler safe to retry. The first
+// response to a request carrying an Idempotency-Key header is stored per user
+// and key and replayed for every retry until it expires. Reusing a key for a
+// different request is rejected with 409. It must run inside auth.WithJWTAuth
+// so the user is known.
+func WithIdempotencyKey(handlerFunc http.HandlerFunc, store types.IdempotencyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(HeaderKey)
+		if key == "" {
+			handlerFunc(w, r)
+			return
+		}
+
+		if len(key) > maxKeyLength {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("%s must be at most %d characters", HeaderKey, maxKeyLength))
+			return
+		}
+
+		userID := auth.GetUserIDFromContext(r.Context())
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := fingerprintRequest(r, body)
+
+		existing, err := store.GetIdempotencyKey(userID, key)
+		if err != nil && !errors.Is(err, types.ErrIdempotencyKeyNotFound) {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		now := time.Now()
+		if existing != nil && !existing.ExpiresAt.After(now) {
+			if err := store.DeleteIdempotencyKey(userID, key); err != nil {
+				utils.WriteError(w, http.StatusInternalServerError, err)
+				return
+			}
+			existing = nil
+		}
+
+		if existing != nil {
+			replay(w, existing, fingerprint)
+			return
+		}
+
+		err = store.CreateIdempotencyKey(types.IdempotencyKey{
+			UserID:      userID,
+			Key:         key,
+			RequestHash: fingerprint,
+			ExpiresAt:   now.Add(time.Second * time.Duration(config.Envs.IdempotencyTTLInSeconds)),
+		})
+		if errors.Is(err, types.ErrIdempotencyKeyExists) {
+			utils.WriteError(w, http.StatusConflict, fmt.Errorf("A request with this %s is already in progress", HeaderKey))
+			return
+		}
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		// A panicking handler must not leave the key in flight, or every retry
+		// would be rejected until the key expires.
+		defer func() {
+			if p := recover(); p != nil {
+				release(store, userID, key)
+				panic(p)
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		handlerFunc(rec, r)
+
+		// Server errors are not stored so the client can retry them.
+		if rec.status >= http.StatusInternalServerError {
+			release(store, userID, key)
+			return
+		}
+
+		if err := store.CompleteIdempotencyKey(userID, key, rec.status, rec.body.Bytes()); err != nil {
+			log.Printf("Failed to store idempotent response: %v", err)
+		}
+	}
+}
+
+func release(store types.IdempotencyStore, userID int, key string) {
+	if err := store.DeleteIdempotencyKey(userID, key); err != nil {
+		log.Printf("Failed to release idempotency key: %v", err)
+	}
+}
+
+// RunExpiredKeySweep deletes expired idempotency keys every interval until ctx
+// is done. Keys are otherwise only removed when the same key is sent again.
+func RunExpiredKeySweep(ctx context.Context, store types.IdempotencyStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := store.DeleteExpiredIdempotencyKeys(time.Now())
+		if err != nil {
+			log.Printf("Failed to delete expired idempotency keys: %v", err)
+		} else if n > 0 {
+			log.Printf("Deleted %d expired idempotency keys", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func replay(w http.ResponseWriter, k *types.IdempotencyKey, fingerprint string) {
+	if k.RequestHash != fingerprint {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("%s was already used for a different request", HeaderKey))
+		return
+	}
+
+	if k.StatusCode == 0 {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("A request with this %s is already in progress", HeaderKey))
+		return
+	}
+
+	w.Header().Set(HeaderReplayed, "true")
+	if len(k.ResponseBody) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(k.StatusCode)
+	w.Write(k.ResponseBody)
+}
+
+// fingerprintRequest identifies a request by method, path and body, so a key
+// cannot be replayed against a different request.
+func fingerprintRequest(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.Path)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder passes the response through while keeping a copy of the
+// status code and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,256 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/joshbarros/golang-ecommerce-api/config"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+	"github.com/joshbarros/golang-ecommerce-api/utils"
+)
+
+// Mock implementation of the IdempotencyStore interface
+type mockIdempotencyStore struct {
+	keys map[string]*types.IdempotencyKey
+}
+
+func mockKey(userID int, key string) string {
+	return fmt.Sprintf("%d:%s", userID, key)
+}
+
+func (m *mockIdempotencyStore) GetIdempotencyKey(userID int, key string) (*types.IdempotencyKey, error) {
+	if k, ok := m.keys[mockKey(userID, key)]; ok {
+		copied := *k
+		return &copied, nil
+	}
+	return nil, types.ErrIdempotencyKeyNotFound
+}
+
+func (m *mockIdempotencyStore) CreateIdempotencyKey(k types.IdempotencyKey) error {
+	if _, ok := m.keys[mockKey(k.UserID, k.Key)]; ok {
+		return types.ErrIdempotencyKeyExists
+	}
+	m.keys[mockKey(k.UserID, k.Key)] = &k
+	return nil
+}
+
+func (m *mockIdempotencyStore) CompleteIdempotencyKey(userID int, key string, statusCode int, body []byte) error {
+	k := m.keys[mockKey(userID, key)]
+	k.StatusCode = statusCode
+	k.ResponseBody = body
+	return nil
+}
+
+func (m *mockIdempotencyStore) DeleteIdempotencyKey(userID int, key string) error {
+	delete(m.keys, mockKey(userID, key))
+	return nil
+}
+
+func (m *mockIdempotencyStore) DeleteExpiredIdempotencyKeys(now time.Time) (int64, error) {
+	var n int64
+	for id, k := range m.keys {
+		if !k.ExpiresAt.After(now) {
+			delete(m.keys, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func newRequest(key string, body string, userID int) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/cart/checkout", bytes.NewBufferString(body))
+	if key != "" {
+		req.Header.Set(HeaderKey, key)
+	}
+	return req.WithContext(context.WithValue(req.Context(), auth.UserKey, userID))
+}
+
+func TestWithIdempotencyKey(t *testing.T) {
+	config.Envs.IdempotencyTTLInSeconds = 3600
+
+	calls := 0
+	status := http.StatusCreated
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		utils.WriteJSON(w, status, map[string]int{"order_id": calls})
+	}
+
+	setup := func() (*mockIdempotencyStore, http.HandlerFunc) {
+		calls = 0
+		status = http.StatusCreated
+		store := &mockIdempotencyStore{keys: map[string]*types.IdempotencyKey{}}
+		return store, WithIdempotencyKey(handler, store)
+	}
+
+	t.Run("Should pass through requests without a key", func(t *testing.T) {
+		_, h := setup()
+
+		for i := 0; i < 2; i++ {
+			h.ServeHTTP(httptest.NewRecorder(), newRequest("", `{"items":[]}`, 1))
+		}
+
+		if calls != 2 {
+			t.Errorf("Expected the handler to run twice, ran %d times", calls)
+		}
+	})
+
+	t.Run("Should replay the first response on retries", func(t *testing.T) {
+		_, h := setup()
+
+		first := httptest.NewRecorder()
+		h.ServeHTTP(first, newRequest("abc", `{"items":[]}`, 1))
+
+		retry := httptest.NewRecorder()
+		h.ServeHTTP(retry, newRequest("abc", `{"items":[]}`, 1))
+
+		if calls != 1 {
+			t.Errorf("Expected the handler to run once, ran %d times", calls)
+		}
+		if retry.Code != http.StatusCreated {
+			t.Errorf("Expected status code %d, got %d", http.StatusCreated, retry.Code)
+		}
+		if retry.Body.String() != first.Body.String() {
+			t.Errorf("Expected replayed body %q, got %q", first.Body.String(), retry.Body.String())
+		}
+		if retry.Header().Get(HeaderReplayed) != "true" {
+			t.Errorf("Expected the %s header to be set", HeaderReplayed)
+		}
+	})
+
+	t.Run("Should scope keys per user", func(t *testing.T) {
+		_, h := setup()
+
+		h.ServeHTTP(httptest.NewRecorder(), newRequest("abc", `{"items":[]}`, 1))
+		h.ServeHTTP(httptest.NewRecorder(), newRequest("abc", `{"items":[]}`, 2))
+
+		if calls != 2 {
+			t.Errorf("Expected the handler to run for each user, ran %d times", calls)
+		}
+	})
+
+	t.Run("Should reject a key reused with a different body", func(t *testing.T) {
+		_, h := setup()
+
+		h.ServeHTTP(httptest.NewRecorder(), newRequest("abc", `{"items":[]}`, 1))
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, newRequest("abc", `{"items":[{"productID":1}]}`, 1))
+
+		if rr.Code != http.StatusConflict {
+			t.Errorf("Expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+		if calls != 1 {
+			t.Errorf("Expected the handler to run once, ran %d times", calls)
+		}
+	})
+
+	t.Run("Should reject a retry while the first request is in flight", func(t *testing.T) {
+		store, h := setup()
+		store.keys[mockKey(1, "abc")] = &types.IdempotencyKey{
+			UserID:      1,
+			Key:         "abc",
+			RequestHash: fingerprintRequest(newRequest("abc", "", 1), []byte(`{"items":[]}`)),
+			ExpiresAt:   time.Now().Add(time.Hour),
+		}
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, newRequest("abc", `{"items":[]}`, 1))
+
+		if rr.Code != http.StatusConflict {
+			t.Errorf("Expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+		if calls != 0 {
+			t.Errorf("Expected the handler not to run, ran %d times", calls)
+		}
+	})
+
+	t.Run("Should run the request again once the key has expired", func(t *testing.T) {
+		store, h := setup()
+
+		h.ServeHTTP(httptest.NewRecorder(), newRequest("abc", `{"items":[]}`, 1))
+		store.keys[mockKey(1, "abc")].ExpiresAt = time.Now().Add(-time.Second)
+
+		h.ServeHTTP(httptest.NewRecorder(), newRequest("abc", `{"items":[]}`, 1))
+
+		if calls != 2 {
+			t.Errorf("Expected the handler to run twice, ran %d times", calls)
+		}
+	})
+
+	t.Run("Should not store server errors", func(t *testing.T) {
+		store, h := setup()
+		status = http.StatusInternalServerError
+
+		h.ServeHTTP(httptest.NewRecorder(), newRequest("abc", `{"items":[]}`, 1))
+
+		if _, ok := store.keys[mockKey(1, "abc")]; ok {
+			t.Error("Expected the key to be released")
+		}
+
+		status = http.StatusCreated
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, newRequest("abc", `{"items":[]}`, 1))
+
+		if rr.Code != http.StatusCreated || calls != 2 {
+			t.Errorf("Expected the retry to run the handler, got status %d after %d calls", rr.Code, calls)
+		}
+	})
+
+	t.Run("Should release the key when the handler panics", func(t *testing.T) {
+		store := &mockIdempotencyStore{keys: map[string]*types.IdempotencyKey{}}
+		h := WithIdempotencyKey(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}, store)
+
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("Expected the panic to be passed on")
+				}
+			}()
+			h.ServeHTTP(httptest.NewRecorder(), newRequest("abc", `{"items":[]}`, 1))
+		}()
+
+		if _, ok := store.keys[mockKey(1, "abc")]; ok {
+			t.Error("Expected the key to be released")
+		}
+	})
+
+	t.Run("Should store client errors", func(t *testing.T) {
+		_, h := setup()
+		status = http.StatusBadRequest
+
+		h.ServeHTTP(httptest.NewRecorder(), newRequest("abc", `{"items":[]}`, 1))
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, newRequest("abc", `{"items":[]}`, 1))
+
+		if rr.Code != http.StatusBadRequest || calls != 1 {
+			t.Errorf("Expected the 400 to be replayed, got status %d after %d calls", rr.Code, calls)
+		}
+	})
+}
+
+func TestRunExpiredKeySweep(t *testing.T) {
+	store := &mockIdempotencyStore{keys: map[string]*types.IdempotencyKey{
+		mockKey(1, "old"): {UserID: 1, Key: "old", ExpiresAt: time.Now().Add(-time.Minute)},
+		mockKey(1, "new"): {UserID: 1, Key: "new", ExpiresAt: time.Now().Add(time.Hour)},
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	RunExpiredKeySweep(ctx, store, time.Hour)
+
+	if _, ok := store.keys[mockKey(1, "old")]; ok {
+		t.Error("Expected the expired key to be deleted")
+	}
+	if _, ok := store.keys[mockKey(1, "new")]; !ok {
+		t.Error("Expected the live key to be kept")
+	}
+}
@@ -0,0 +1,95 @@
+package idempotency
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/joshbarros/golang-ecommerce-api/db"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// mysqlDuplicateEntry is the MySQL error number for a unique key violation.
+const mysqlDuplicateEntry = 1062
+
+type Store struct {
+	db db.DBTX
+}
+
+func NewStore(db db.DBTX) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) GetIdempotencyKey(userID int, key string) (*types.IdempotencyKey, error) {
+	rows, err := s.db.Query(
+		"SELECT userId, `key`, requestHash, statusCode, responseBody, createdAt, expiresAt FROM idempotency_keys WHERE userId = ? AND `key` = ?",
+		userID, key,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, types.ErrIdempotencyKeyNotFound
+	}
+
+	k := new(types.IdempotencyKey)
+	err = rows.Scan(
+		&k.UserID,
+		&k.Key,
+		&k.RequestHash,
+		&k.StatusCode,
+		&k.ResponseBody,
+		&k.CreatedAt,
+		&k.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// CreateIdempotencyKey reserves a key for an in-flight request. The unique
+// index on (userId, key) makes this the point where concurrent requests with
+// the same key are told apart.
+func (s *Store) CreateIdempotencyKey(k types.IdempotencyKey) error {
+	_, err := s.db.Exec(
+		"INSERT INTO idempotency_keys (userId, `key`, requestHash, expiresAt) VALUES (?, ?, ?, ?)",
+		k.UserID, k.Key, k.RequestHash, k.ExpiresAt,
+	)
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		return types.ErrIdempotencyKeyExists
+	}
+
+	return err
+}
+
+func (s *Store) CompleteIdempotencyKey(userID int, key string, statusCode int, body []byte) error {
+	_, err := s.db.Exec(
+		"UPDATE idempotency_keys SET statusCode = ?, responseBody = ? WHERE userId = ? AND `key` = ?",
+		statusCode, body, userID, key,
+	)
+	return err
+}
+
+func (s *Store) DeleteIdempotencyKey(userID int, key string) error {
+	_, err := s.db.Exec("DELETE FROM idempotency_keys WHERE userId = ? AND `key` = ?", userID, key)
+	return err
+}
+
+// DeleteExpiredIdempotencyKeys deletes every key that expired at or before now
+// and returns how many were deleted.
+func (s *Store) DeleteExpiredIdempotencyKeys(now time.Time) (int64, error) {
+	res, err := s.db.Exec("DELETE FROM idempotency_keys WHERE expiresAt <= ?", now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,88 @@
+package inventory
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/joshbarros/golang-ecommerce-api/config"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// PriorityStrategy takes stock from the warehouses with the lowest priority
+// first, and within a warehouse from its oldest locations first.
+type PriorityStrategy struct{}
+
+func (PriorityStrategy) Allocate(levels []types.StockLevel, quantity int) ([]types.Allocation, error) {
+	levels = slices.Clone(levels)
+	slices.SortStableFunc(levels, func(a, b types.StockLevel) int {
+		if a.Priority != b.Priority {
+			return a.Priority - b.Priority
+		}
+		if a.WarehouseID != b.WarehouseID {
+			return a.WarehouseID - b.WarehouseID
+		}
+		return a.LocationID - b.LocationID
+	})
+
+	return allocateInOrder(levels, quantity)
+}
+
+// LargestFirstStrategy takes stock from the locations holding the most
+// first, so an order is shipped from as few places as possible.
+type LargestFirstStrategy struct{}
+
+func (LargestFirstStrategy) Allocate(levels []types.StockLevel, quantity int) ([]types.Allocation, error) {
+	levels = slices.Clone(levels)
+	slices.SortStableFunc(levels, func(a, b types.StockLevel) int {
+		if a.Quantity != b.Quantity {
+			return b.Quantity - a.Quantity
+		}
+		if a.Priority != b.Priority {
+			return a.Priority - b.Priority
+		}
+		return a.LocationID - b.LocationID
+	})
+
+	return allocateInOrder(levels, quantity)
+}
+
+// allocateInOrder takes as much as it can from each location in turn until
+// quantity units are allocated.
+func allocateInOrder(levels []types.StockLevel, quantity int) ([]types.Allocation, error) {
+	var allocations []types.Allocation
+	for _, l := range levels {
+		if quantity == 0 {
+			break
+		}
+		if l.Quantity <= 0 {
+			continue
+		}
+
+		take := min(l.Quantity, quantity)
+		allocations = append(allocations, types.Allocation{LocationID: l.LocationID, Quantity: take})
+		quantity -= take
+	}
+
+	if quantity > 0 {
+		return nil, types.ErrInsufficientStock
+	}
+
+	return allocations, nil
+}
+
+// NewStrategy returns the allocation strategy with the given name.
+func NewStrategy(name string) (types.AllocationStrategy, error) {
+	switch name {
+	case "priority":
+		return PriorityStrategy{}, nil
+	case "largest":
+		return LargestFirstStrategy{}, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported inventory allocation strategy %q", name)
+}
+
+// LoadStrategy returns the strategy named by INVENTORY_ALLOCATION_STRATEGY.
+func LoadStrategy() (types.AllocationStrategy, error) {
+	return NewStrategy(config.Envs.InventoryAllocationStrategy)
+}
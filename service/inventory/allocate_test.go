@@ -0,0 +1,70 @@
+package inventory
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+func TestAllocationStrategies(t *testing.T) {
+	// Location 3 is in the warehouse allocated from first, and location 2
+	// holds the most.
+	levels := []types.StockLevel{
+		{VariantID: 1, LocationID: 1, WarehouseID: 1, Priority: 5, Quantity: 2},
+		{VariantID: 1, LocationID: 2, WarehouseID: 1, Priority: 5, Quantity: 6},
+		{VariantID: 1, LocationID: 3, WarehouseID: 2, Priority: 1, Quantity: 3},
+		{VariantID: 1, LocationID: 4, WarehouseID: 2, Priority: 1, Quantity: 0},
+	}
+
+	tests := []struct {
+		name     string
+		strategy types.AllocationStrategy
+		quantity int
+		expected []types.Allocation
+	}{
+		{"priority from a single location", PriorityStrategy{}, 3, []types.Allocation{{LocationID: 3, Quantity: 3}}},
+		{"priority across warehouses", PriorityStrategy{}, 6, []types.Allocation{{LocationID: 3, Quantity: 3}, {LocationID: 1, Quantity: 2}, {LocationID: 2, Quantity: 1}}},
+		{"largest from a single location", LargestFirstStrategy{}, 6, []types.Allocation{{LocationID: 2, Quantity: 6}}},
+		{"largest across locations", LargestFirstStrategy{}, 10, []types.Allocation{{LocationID: 2, Quantity: 6}, {LocationID: 3, Quantity: 3}, {LocationID: 1, Quantity: 1}}},
+		{"everything", PriorityStrategy{}, 11, []types.Allocation{{LocationID: 3, Quantity: 3}, {LocationID: 1, Quantity: 2}, {LocationID: 2, Quantity: 6}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			allocations, err := tc.strategy.Allocate(levels, tc.quantity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(allocations, tc.expected) {
+				t.Errorf("Expected %+v, got %+v", tc.expected, allocations)
+			}
+		})
+	}
+
+	for _, strategy := range []types.AllocationStrategy{PriorityStrategy{}, LargestFirstStrategy{}} {
+		if _, err := strategy.Allocate(levels, 12); !errors.Is(err, types.ErrInsufficientStock) {
+			t.Errorf("%T: expected insufficient stock, got %v", strategy, err)
+		}
+		if _, err := strategy.Allocate(nil, 1); !errors.Is(err, types.ErrInsufficientStock) {
+			t.Errorf("%T: expected insufficient stock without levels, got %v", strategy, err)
+		}
+	}
+
+	if levels[0].LocationID != 1 {
+		t.Error("Expected the levels to be left in their order")
+	}
+}
+
+func TestNewStrategy(t *testing.T) {
+	if s, err := NewStrategy("priority"); err != nil || s != (PriorityStrategy{}) {
+		t.Errorf("Expected the priority strategy, got %v, %v", s, err)
+	}
+	if s, err := NewStrategy("largest"); err != nil || s != (LargestFirstStrategy{}) {
+		t.Errorf("Expected the largest first strategy, got %v, %v", s, err)
+	}
+	if _, err := NewStrategy("random"); err == nil {
+		t.Error("Expected an unknown strategy to fail")
+	}
+}
@@ -0,0 +1,270 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+	"github.com/joshbarros/golang-ecommerce-api/utils"
+)
+
+type Handler struct {
+	store       types.InventoryStore
+	txManager   types.TxManager
+	userStore   types.UserStore
+	tokenStore  types.TokenStore
+	apiKeyStore types.APIKeyStore
+}
+
+func NewHandler(store types.InventoryStore, txManager types.TxManager, userStore types.UserStore, tokenStore types.TokenStore, apiKeyStore types.APIKeyStore) *Handler {
+	return &Handler{
+		store:       store,
+		txManager:   txManager,
+		userStore:   userStore,
+		tokenStore:  tokenStore,
+		apiKeyStore: apiKeyStore,
+	}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/warehouses", h.adminOnly(h.handleGetWarehouses)).Methods(http.MethodGet)
+	router.HandleFunc("/warehouses", h.adminOnly(h.handleCreateWarehouse)).Methods(http.MethodPost)
+	router.HandleFunc("/warehouses/{id}/locations", h.adminOnly(h.handleCreateLocation)).Methods(http.MethodPost)
+	router.HandleFunc("/inventory/stock", h.adminOnly(h.handleGetStock)).Methods(http.MethodGet)
+	router.HandleFunc("/inventory/movements", h.adminOnly(h.handleGetMovements)).Methods(http.MethodGet)
+	router.HandleFunc("/inventory/adjustments", h.adminOnly(h.handleAdjustStock)).Methods(http.MethodPost)
+	router.HandleFunc("/inventory/transfers", h.adminOnly(h.handleTransferStock)).Methods(http.MethodPost)
+}
+
+// adminOnly guards the inventory like other catalog writes: an admin's login
+// or a products:write API key owned by an admin.
+func (h *Handler) adminOnly(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return auth.WithJWTOrAPIKeyAuth(auth.RequireRole(handlerFunc, types.RoleAdmin), types.ScopeProductsWrite, h.userStore, h.tokenStore, h.apiKeyStore)
+}
+
+// handleGetWarehouses returns every warehouse with its locations, in the
+// order checkout allocates from them by priority.
+func (h *Handler) handleGetWarehouses(w http.ResponseWriter, r *http.Request) {
+	warehouses, err := h.store.GetWarehouses()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	locations, err := h.store.GetLocations()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, buildWarehouseDetails(warehouses, locations))
+}
+
+func (h *Handler) handleCreateWarehouse(w http.ResponseWriter, r *http.Request) {
+	var payload types.WarehousePayload
+	if !parsePayload(w, r, &payload) {
+		return
+	}
+
+	warehouse := types.Warehouse{Code: payload.Code, Name: payload.Name, Priority: payload.Priority}
+
+	err := h.txManager.WithTx(r.Context(), func(stores types.Stores) error {
+		if err := createWarehouse(stores.Inventory, &warehouse); err != nil {
+			return err
+		}
+
+		created, err := stores.Inventory.GetWarehouseByID(warehouse.ID)
+		if err != nil {
+			return err
+		}
+
+		warehouse = *created
+		return nil
+	})
+	if errors.Is(err, ErrCodeTaken) {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, warehouse)
+}
+
+func (h *Handler) handleCreateLocation(w http.ResponseWriter, r *http.Request) {
+	warehouseID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid warehouse ID"))
+		return
+	}
+
+	var payload types.LocationPayload
+	if !parsePayload(w, r, &payload) {
+		return
+	}
+
+	location := types.Location{WarehouseID: warehouseID, Code: payload.Code, Name: payload.Name}
+
+	err = h.txManager.WithTx(r.Context(), func(stores types.Stores) error {
+		if err := createLocation(stores.Inventory, &location); err != nil {
+			return err
+		}
+
+		created, err := stores.Inventory.GetLocationByID(location.ID)
+		if err != nil {
+			return err
+		}
+
+		location = *created
+		return nil
+	})
+	if errors.Is(err, types.ErrWarehouseNotFound) {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	if errors.Is(err, ErrCodeTaken) {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, location)
+}
+
+// handleGetStock returns the stock on hand of a variant at every location
+// that holds or held some.
+func (h *Handler) handleGetStock(w http.ResponseWriter, r *http.Request) {
+	variantID, err := strconv.Atoi(r.URL.Query().Get("variant"))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid variant ID"))
+		return
+	}
+
+	levels, err := h.store.GetStockLevels([]int{variantID})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, levels)
+}
+
+// handleGetMovements returns a page of the ledger, newest first, optionally
+// of a single variant or location.
+func (h *Handler) handleGetMovements(w http.ResponseWriter, r *http.Request) {
+	page, limit, err := utils.ParsePagination(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	q := types.MovementQuery{Limit: limit, Offset: (page - 1) * limit}
+	if v := r.URL.Query().Get("variant"); v != "" {
+		if q.VariantID, err = strconv.Atoi(v); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid variant ID"))
+			return
+		}
+	}
+	if v := r.URL.Query().Get("location"); v != "" {
+		if q.LocationID, err = strconv.Atoi(v); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid location ID"))
+			return
+		}
+	}
+
+	movements, err := h.store.GetMovements(q)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	total, err := h.store.CountMovements(q)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"movements": movements,
+		"page":      page,
+		"limit":     limit,
+		"total":     total,
+	})
+}
+
+// handleAdjustStock records a receipt or an adjustment, and returns the
+// movement posted to the ledger.
+func (h *Handler) handleAdjustStock(w http.ResponseWriter, r *http.Request) {
+	var payload types.StockAdjustmentPayload
+	if !parsePayload(w, r, &payload) {
+		return
+	}
+
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	var movements []types.StockMovement
+	err := h.txManager.WithTx(r.Context(), func(stores types.Stores) error {
+		var err error
+		movements, err = adjust(r.Context(), stores, payload, userID)
+		return err
+	})
+	h.writeMovements(w, movements, err)
+}
+
+// handleTransferStock moves stock between two locations, and returns the two
+// movements posted to the ledger.
+func (h *Handler) handleTransferStock(w http.ResponseWriter, r *http.Request) {
+	var payload types.StockTransferPayload
+	if !parsePayload(w, r, &payload) {
+		return
+	}
+
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	var movements []types.StockMovement
+	err := h.txManager.WithTx(r.Context(), func(stores types.Stores) error {
+		var err error
+		movements, err = transfer(r.Context(), stores, payload, userID)
+		return err
+	})
+	h.writeMovements(w, movements, err)
+}
+
+func (h *Handler) writeMovements(w http.ResponseWriter, movements []types.StockMovement, err error) {
+	if errors.Is(err, types.ErrVariantNotFound) || errors.Is(err, types.ErrLocationNotFound) ||
+		errors.Is(err, types.ErrInsufficientStock) || errors.Is(err, ErrInvalidReceipt) {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, movements)
+}
+
+func parsePayload(w http.ResponseWriter, r *http.Request, payload any) bool {
+	if err := utils.ParseJSON(r, payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return false
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid payload %v", errors))
+		return false
+	}
+
+	return true
+}
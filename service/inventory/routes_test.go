@@ -0,0 +1,479 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// Mock implementation of the InventoryStore interface with in-memory data,
+// deriving stock levels from its ledger like the real store
+type mockInventoryStore struct {
+	warehouses []types.Warehouse
+	locations  []types.Location
+	movements  []types.StockMovement
+}
+
+func (m *mockInventoryStore) GetWarehouses() ([]types.Warehouse, error) {
+	warehouses := slices.Clone(m.warehouses)
+	slices.SortStableFunc(warehouses, func(a, b types.Warehouse) int {
+		if a.Priority != b.Priority {
+			return a.Priority - b.Priority
+		}
+		return a.ID - b.ID
+	})
+	return warehouses, nil
+}
+
+func (m *mockInventoryStore) GetWarehouseByID(id int) (*types.Warehouse, error) {
+	for _, w := range m.warehouses {
+		if w.ID == id {
+			return &w, nil
+		}
+	}
+	return nil, types.ErrWarehouseNotFound
+}
+
+func (m *mockInventoryStore) GetWarehouseByCode(code string) (*types.Warehouse, error) {
+	for _, w := range m.warehouses {
+		if w.Code == code {
+			return &w, nil
+		}
+	}
+	return nil, types.ErrWarehouseNotFound
+}
+
+func (m *mockInventoryStore) CreateWarehouse(w *types.Warehouse) error {
+	w.ID = len(m.warehouses) + 1
+	w.CreatedAt = time.Now()
+	m.warehouses = append(m.warehouses, *w)
+	return nil
+}
+
+func (m *mockInventoryStore) GetLocations() ([]types.Location, error) {
+	warehouses, _ := m.GetWarehouses()
+
+	var locations []types.Location
+	for _, w := range warehouses {
+		for _, l := range m.locations {
+			if l.WarehouseID == w.ID {
+				locations = append(locations, l)
+			}
+		}
+	}
+	return locations, nil
+}
+
+func (m *mockInventoryStore) GetLocationByID(id int) (*types.Location, error) {
+	for _, l := range m.locations {
+		if l.ID == id {
+			return &l, nil
+		}
+	}
+	return nil, types.ErrLocationNotFound
+}
+
+func (m *mockInventoryStore) CreateLocation(l *types.Location) error {
+	l.ID = len(m.locations) + 1
+	l.CreatedAt = time.Now()
+	m.locations = append(m.locations, *l)
+	return nil
+}
+
+func (m *mockInventoryStore) LockVariants(ctx context.Context, variantIDs []int, productIDs []int) error {
+	return nil
+}
+
+func (m *mockInventoryStore) GetStockLevels(variantIDs []int) ([]types.StockLevel, error) {
+	locations, _ := m.GetLocations()
+
+	levels := []types.StockLevel{}
+	for _, id := range variantIDs {
+		for _, l := range locations {
+			w, _ := m.GetWarehouseByID(l.WarehouseID)
+			level := types.StockLevel{VariantID: id, LocationID: l.ID, WarehouseID: w.ID, Priority: w.Priority}
+			held := false
+			for _, mv := range m.movements {
+				if mv.VariantID == id && mv.LocationID == l.ID {
+					level.Quantity += mv.Quantity
+					held = true
+				}
+			}
+			if held {
+				levels = append(levels, level)
+			}
+		}
+	}
+	return levels, nil
+}
+
+func (m *mockInventoryStore) AddMovements(ctx context.Context, movements []types.StockMovement) error {
+	for i := range movements {
+		movements[i].ID = len(m.movements) + 1
+		movements[i].CreatedAt = time.Now()
+		m.movements = append(m.movements, movements[i])
+	}
+	return nil
+}
+
+func (m *mockInventoryStore) GetMovements(q types.MovementQuery) ([]types.StockMovement, error) {
+	var result []types.StockMovement
+	for _, mv := range m.movements {
+		if (q.VariantID == 0 || mv.VariantID == q.VariantID) && (q.LocationID == 0 || mv.LocationID == q.LocationID) {
+			result = append(result, mv)
+		}
+	}
+	slices.Reverse(result)
+
+	if q.Offset >= len(result) {
+		return []types.StockMovement{}, nil
+	}
+	result = result[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(result) {
+		result = result[:q.Limit]
+	}
+	return result, nil
+}
+
+func (m *mockInventoryStore) CountMovements(q types.MovementQuery) (int, error) {
+	movements, _ := m.GetMovements(types.MovementQuery{VariantID: q.VariantID, LocationID: q.LocationID})
+	return len(movements), nil
+}
+
+func (m *mockInventoryStore) GetMovementsByOrderID(orderID int) ([]types.StockMovement, error) {
+	return nil, nil
+}
+
+// onHand sums the movements of a variant at a location.
+func (m *mockInventoryStore) onHand(variantID int, locationID int) int {
+	quantity := 0
+	for _, mv := range m.movements {
+		if mv.VariantID == variantID && mv.LocationID == locationID {
+			quantity += mv.Quantity
+		}
+	}
+	return quantity
+}
+
+// Mock implementation of the VariantStore interface knowing variants 1 and 2
+type mockVariantStore struct{}
+
+func (m *mockVariantStore) GetProductOptions(productID int) ([]types.ProductOption, error) {
+	return nil, nil
+}
+
+func (m *mockVariantStore) SetProductOptions(productID int, options []types.ProductOption) error {
+	return nil
+}
+
+func (m *mockVariantStore) GetVariantsByProductID(productID int) ([]types.ProductVariant, error) {
+	return nil, nil
+}
+
+func (m *mockVariantStore) GetVariantsByID(ids []int) ([]types.ProductVariant, error) {
+	variants := []types.ProductVariant{}
+	for _, id := range ids {
+		if id == 1 || id == 2 {
+			variants = append(variants, types.ProductVariant{ID: id, ProductID: id, SKU: fmt.Sprintf("P%d", id)})
+		}
+	}
+	return variants, nil
+}
+
+func (m *mockVariantStore) GetVariantBySKU(sku string) (*types.ProductVariant, error) {
+	return nil, types.ErrVariantNotFound
+}
+
+func (m *mockVariantStore) CreateVariant(variant *types.ProductVariant) error {
+	return nil
+}
+
+func (m *mockVariantStore) UpdateVariant(variant types.ProductVariant) error {
+	return nil
+}
+
+func (m *mockVariantStore) DeleteVariant(id int) error {
+	return nil
+}
+
+// Mock TxManager running the unit of work directly against the mock stores
+type mockTxManager struct {
+	inventory *mockInventoryStore
+}
+
+func (m *mockTxManager) WithTx(ctx context.Context, fn func(types.Stores) error) error {
+	return fn(types.Stores{Inventory: m.inventory, Variants: &mockVariantStore{}})
+}
+
+func serve(t *testing.T, method string, path string, handler http.HandlerFunc, payload any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	if payload != nil {
+		json.NewEncoder(&body).Encode(payload)
+	}
+
+	req, err := http.NewRequest(method, path, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(context.WithValue(req.Context(), auth.UserKey, 7))
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/warehouses", handler)
+	router.HandleFunc("/warehouses/{id}/locations", handler)
+	router.HandleFunc("/inventory/stock", handler)
+	router.HandleFunc("/inventory/movements", handler)
+	router.HandleFunc("/inventory/adjustments", handler)
+	router.HandleFunc("/inventory/transfers", handler)
+	router.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func TestWarehouseHandlers(t *testing.T) {
+	store := &mockInventoryStore{}
+	handler := NewHandler(store, &mockTxManager{inventory: store}, nil, nil, nil)
+
+	t.Run("Should create warehouses with unique codes", func(t *testing.T) {
+		for _, payload := range []types.WarehousePayload{
+			{Code: "MAIN", Name: "Main warehouse", Priority: 2},
+			{Code: "CITY", Name: "City store", Priority: 1},
+		} {
+			if rr := serve(t, http.MethodPost, "/warehouses", handler.handleCreateWarehouse, payload); rr.Code != http.StatusCreated {
+				t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+			}
+		}
+
+		for _, payload := range []types.WarehousePayload{
+			{Code: "MAIN", Name: "Another main warehouse"},
+			{Name: "No code"},
+		} {
+			if rr := serve(t, http.MethodPost, "/warehouses", handler.handleCreateWarehouse, payload); rr.Code != http.StatusBadRequest {
+				t.Errorf("%+v: expected status code %d, got %d", payload, http.StatusBadRequest, rr.Code)
+			}
+		}
+	})
+
+	t.Run("Should create locations with codes unique in their warehouse", func(t *testing.T) {
+		for _, path := range []string{"/warehouses/1/locations", "/warehouses/2/locations"} {
+			rr := serve(t, http.MethodPost, path, handler.handleCreateLocation, types.LocationPayload{Code: "A1", Name: "Aisle 1"})
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+			}
+		}
+
+		if rr := serve(t, http.MethodPost, "/warehouses/1/locations", handler.handleCreateLocation, types.LocationPayload{Code: "A1", Name: "Aisle 1 again"}); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+		if rr := serve(t, http.MethodPost, "/warehouses/42/locations", handler.handleCreateLocation, types.LocationPayload{Code: "A1", Name: "Aisle 1"}); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("Should list warehouses with their locations by priority", func(t *testing.T) {
+		rr := serve(t, http.MethodGet, "/warehouses", handler.handleGetWarehouses, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var warehouses []types.WarehouseDetail
+		if err := json.NewDecoder(rr.Body).Decode(&warehouses); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+
+		if len(warehouses) != 2 || warehouses[0].Code != "CITY" || warehouses[1].Code != "MAIN" {
+			t.Fatalf("Expected the city store then the main warehouse, got %+v", warehouses)
+		}
+		if len(warehouses[0].Locations) != 1 || warehouses[0].Locations[0].WarehouseID != 2 {
+			t.Errorf("Expected the city store's location, got %+v", warehouses[0].Locations)
+		}
+	})
+}
+
+func TestStockHandlers(t *testing.T) {
+	store := &mockInventoryStore{
+		warehouses: []types.Warehouse{{ID: 1, Code: "MAIN"}},
+		locations:  []types.Location{{ID: 1, WarehouseID: 1, Code: "A"}, {ID: 2, WarehouseID: 1, Code: "B"}},
+	}
+	handler := NewHandler(store, &mockTxManager{inventory: store}, nil, nil, nil)
+
+	post := func(t *testing.T, path string, handlerFunc http.HandlerFunc, payload any) []types.StockMovement {
+		t.Helper()
+
+		rr := serve(t, http.MethodPost, path, handlerFunc, payload)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+		}
+
+		var movements []types.StockMovement
+		if err := json.NewDecoder(rr.Body).Decode(&movements); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+		return movements
+	}
+
+	t.Run("Should receive stock into a location", func(t *testing.T) {
+		movements := post(t, "/inventory/adjustments", handler.handleAdjustStock, types.StockAdjustmentPayload{
+			VariantID: 1, LocationID: 1, Type: types.MovementReceipt, Quantity: 10, Note: "Delivery 42",
+		})
+
+		if len(movements) != 1 || movements[0].ID == 0 || movements[0].Quantity != 10 || *movements[0].CreatedBy != 7 {
+			t.Errorf("Expected a receipt of 10 by user 7, got %+v", movements)
+		}
+		if q := store.onHand(1, 1); q != 10 {
+			t.Errorf("Expected 10 units on hand, got %d", q)
+		}
+	})
+
+	t.Run("Should correct stock down but not below zero", func(t *testing.T) {
+		post(t, "/inventory/adjustments", handler.handleAdjustStock, types.StockAdjustmentPayload{
+			VariantID: 1, LocationID: 1, Type: types.MovementAdjustment, Quantity: -2, Note: "Damaged",
+		})
+		if q := store.onHand(1, 1); q != 8 {
+			t.Errorf("Expected 8 units on hand, got %d", q)
+		}
+
+		rr := serve(t, http.MethodPost, "/inventory/adjustments", handler.handleAdjustStock, types.StockAdjustmentPayload{
+			VariantID: 1, LocationID: 1, Type: types.MovementAdjustment, Quantity: -9,
+		})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("Should reject invalid adjustments", func(t *testing.T) {
+		for name, payload := range map[string]types.StockAdjustmentPayload{
+			"negative receipt": {VariantID: 1, LocationID: 1, Type: types.MovementReceipt, Quantity: -1},
+			"no change":        {VariantID: 1, LocationID: 1, Type: types.MovementAdjustment},
+			"sale":             {VariantID: 1, LocationID: 1, Type: types.MovementSale, Quantity: -1},
+			"unknown variant":  {VariantID: 9, LocationID: 1, Type: types.MovementReceipt, Quantity: 1},
+			"unknown location": {VariantID: 1, LocationID: 9, Type: types.MovementReceipt, Quantity: 1},
+		} {
+			if rr := serve(t, http.MethodPost, "/inventory/adjustments", handler.handleAdjustStock, payload); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status code %d, got %d", name, http.StatusBadRequest, rr.Code)
+			}
+		}
+
+		if len(store.movements) != 2 {
+			t.Errorf("Expected the ledger to be left untouched, got %+v", store.movements)
+		}
+	})
+
+	t.Run("Should transfer stock between locations", func(t *testing.T) {
+		movements := post(t, "/inventory/transfers", handler.handleTransferStock, types.StockTransferPayload{
+			VariantID: 1, FromLocationID: 1, ToLocationID: 2, Quantity: 3,
+		})
+
+		if len(movements) != 2 || movements[0].Type != types.MovementTransfer || movements[0].Quantity != -3 || movements[1].Quantity != 3 {
+			t.Errorf("Expected a movement out of A and one into B, got %+v", movements)
+		}
+		if a, b := store.onHand(1, 1), store.onHand(1, 2); a != 5 || b != 3 {
+			t.Errorf("Expected 5 units in A and 3 in B, got %d and %d", a, b)
+		}
+	})
+
+	t.Run("Should reject invalid transfers", func(t *testing.T) {
+		for name, payload := range map[string]types.StockTransferPayload{
+			"more than on hand": {VariantID: 1, FromLocationID: 2, ToLocationID: 1, Quantity: 4},
+			"same location":     {VariantID: 1, FromLocationID: 1, ToLocationID: 1, Quantity: 1},
+			"negative quantity": {VariantID: 1, FromLocationID: 2, ToLocationID: 1, Quantity: -1},
+			"unknown location":  {VariantID: 1, FromLocationID: 1, ToLocationID: 9, Quantity: 1},
+		} {
+			if rr := serve(t, http.MethodPost, "/inventory/transfers", handler.handleTransferStock, payload); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status code %d, got %d", name, http.StatusBadRequest, rr.Code)
+			}
+		}
+
+		if len(store.movements) != 4 {
+			t.Errorf("Expected the ledger to be left untouched, got %+v", store.movements)
+		}
+	})
+
+	t.Run("Should return the stock of a variant by location", func(t *testing.T) {
+		rr := serve(t, http.MethodGet, "/inventory/stock?variant=1", handler.handleGetStock, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var levels []types.StockLevel
+		if err := json.NewDecoder(rr.Body).Decode(&levels); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+		if len(levels) != 2 || levels[0].Quantity != 5 || levels[1].Quantity != 3 {
+			t.Errorf("Expected 5 units in A and 3 in B, got %+v", levels)
+		}
+
+		if rr := serve(t, http.MethodGet, "/inventory/stock", handler.handleGetStock, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("Should page through the ledger, newest first", func(t *testing.T) {
+		rr := serve(t, http.MethodGet, "/inventory/movements?location=1&limit=2", handler.handleGetMovements, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var page struct {
+			Movements []types.StockMovement `json:"movements"`
+			Total     int                   `json:"total"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+		if page.Total != 3 || len(page.Movements) != 2 || page.Movements[0].Type != types.MovementTransfer {
+			t.Errorf("Expected the transfer first of 3 movements, got %+v", page)
+		}
+
+		if rr := serve(t, http.MethodGet, "/inventory/movements?variant=one", handler.handleGetMovements, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+}
+
+func TestInventoryRequiresAuthentication(t *testing.T) {
+	store := &mockInventoryStore{}
+	router := mux.NewRouter()
+	NewHandler(store, &mockTxManager{inventory: store}, nil, nil, nil).RegisterRoutes(router)
+
+	for _, tc := range []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/warehouses"},
+		{http.MethodPost, "/warehouses"},
+		{http.MethodPost, "/warehouses/1/locations"},
+		{http.MethodGet, "/inventory/stock?variant=1"},
+		{http.MethodGet, "/inventory/movements"},
+		{http.MethodPost, "/inventory/adjustments"},
+		{http.MethodPost, "/inventory/transfers"},
+	} {
+		req, err := http.NewRequest(tc.method, tc.path, bytes.NewBufferString(`{"code":"SNEAKY","name":"Sneaky"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected status code %d, got %d", tc.method, tc.path, http.StatusForbidden, rr.Code)
+		}
+	}
+
+	if len(store.warehouses) != 0 {
+		t.Error("Expected no warehouse to be created")
+	}
+}
@@ -0,0 +1,149 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+var (
+	ErrCodeTaken      = errors.New("Code is already taken")
+	ErrInvalidReceipt = errors.New("A receipt must add stock")
+)
+
+// createWarehouse creates a warehouse after checking its code is free.
+func createWarehouse(store types.InventoryStore, w *types.Warehouse) error {
+	_, err := store.GetWarehouseByCode(w.Code)
+	if err == nil {
+		return ErrCodeTaken
+	}
+	if !errors.Is(err, types.ErrWarehouseNotFound) {
+		return err
+	}
+
+	return store.CreateWarehouse(w)
+}
+
+// createLocation creates a location after checking its warehouse exists and
+// has no other location with the same code.
+func createLocation(store types.InventoryStore, l *types.Location) error {
+	if _, err := store.GetWarehouseByID(l.WarehouseID); err != nil {
+		return err
+	}
+
+	locations, err := store.GetLocations()
+	if err != nil {
+		return err
+	}
+
+	if slices.ContainsFunc(locations, func(o types.Location) bool { return o.WarehouseID == l.WarehouseID && o.Code == l.Code }) {
+		return ErrCodeTaken
+	}
+
+	return store.CreateLocation(l)
+}
+
+// buildWarehouseDetails groups locations under their warehouses, keeping the
+// order both are given in.
+func buildWarehouseDetails(warehouses []types.Warehouse, locations []types.Location) []types.WarehouseDetail {
+	details := make([]types.WarehouseDetail, len(warehouses))
+	for i, w := range warehouses {
+		details[i] = types.WarehouseDetail{Warehouse: w, Locations: []types.Location{}}
+		for _, l := range locations {
+			if l.WarehouseID == w.ID {
+				details[i].Locations = append(details[i].Locations, l)
+			}
+		}
+	}
+
+	return details
+}
+
+// adjust records stock received into a location, or a correction of its
+// stock, which cannot take it below zero. It must run inside a transaction.
+func adjust(ctx context.Context, stores types.Stores, payload types.StockAdjustmentPayload, userID int) ([]types.StockMovement, error) {
+	if payload.Type == types.MovementReceipt && payload.Quantity <= 0 {
+		return nil, ErrInvalidReceipt
+	}
+
+	movement := types.StockMovement{
+		VariantID:  payload.VariantID,
+		LocationID: payload.LocationID,
+		Type:       payload.Type,
+		Quantity:   payload.Quantity,
+		Note:       payload.Note,
+		CreatedBy:  &userID,
+	}
+
+	return addMovements(ctx, stores, payload.VariantID, []types.StockMovement{movement})
+}
+
+// transfer moves stock of a variant between two locations, as a movement out
+// of one and a movement into the other. It must run inside a transaction.
+func transfer(ctx context.Context, stores types.Stores, payload types.StockTransferPayload, userID int) ([]types.StockMovement, error) {
+	movements := []types.StockMovement{
+		{
+			VariantID:  payload.VariantID,
+			LocationID: payload.FromLocationID,
+			Type:       types.MovementTransfer,
+			Quantity:   -payload.Quantity,
+			Note:       payload.Note,
+			CreatedBy:  &userID,
+		},
+		{
+			VariantID:  payload.VariantID,
+			LocationID: payload.ToLocationID,
+			Type:       types.MovementTransfer,
+			Quantity:   payload.Quantity,
+			Note:       payload.Note,
+			CreatedBy:  &userID,
+		},
+	}
+
+	return addMovements(ctx, stores, payload.VariantID, movements)
+}
+
+// addMovements appends movements of a variant to the ledger once its
+// locations exist and none of them would be left with negative stock.
+func addMovements(ctx context.Context, stores types.Stores, variantID int, movements []types.StockMovement) ([]types.StockMovement, error) {
+	if err := stores.Inventory.LockVariants(ctx, []int{variantID}, nil); err != nil {
+		return nil, err
+	}
+
+	variants, err := stores.Variants.GetVariantsByID([]int{variantID})
+	if err != nil {
+		return nil, err
+	}
+	if len(variants) == 0 {
+		return nil, types.ErrVariantNotFound
+	}
+
+	levels, err := stores.Inventory.GetStockLevels([]int{variantID})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range movements {
+		if _, err := stores.Inventory.GetLocationByID(m.LocationID); err != nil {
+			return nil, err
+		}
+
+		onHand := m.Quantity
+		for _, l := range levels {
+			if l.LocationID == m.LocationID {
+				onHand += l.Quantity
+			}
+		}
+		if onHand < 0 {
+			return nil, types.ErrInsufficientStock
+		}
+	}
+
+	if err := stores.Inventory.AddMovements(ctx, movements); err != nil {
+		return nil, err
+	}
+
+	return movements, nil
+}
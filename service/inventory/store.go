@@ -0,0 +1,364 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/joshbarros/golang-ecommerce-api/db"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+type Store struct {
+	db db.DBTX
+}
+
+func NewStore(db db.DBTX) *Store {
+	return &Store{db: db}
+}
+
+const (
+	warehouseColumns = "id, code, name, priority, createdAt"
+	locationColumns  = "locations.id, locations.warehouseId, locations.code, locations.name, locations.createdAt"
+	movementColumns  = "id, variantId, locationId, type, quantity, orderId, note, createdBy, createdAt"
+)
+
+// GetWarehouses returns every warehouse, those allocated from first ahead.
+func (s *Store) GetWarehouses() ([]types.Warehouse, error) {
+	rows, err := s.db.Query("SELECT " + warehouseColumns + " FROM warehouses ORDER BY priority, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	warehouses := make([]types.Warehouse, 0)
+	for rows.Next() {
+		w, err := scanWarehouse(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		warehouses = append(warehouses, *w)
+	}
+
+	return warehouses, rows.Err()
+}
+
+func (s *Store) GetWarehouseByID(id int) (*types.Warehouse, error) {
+	rows, err := s.db.Query("SELECT "+warehouseColumns+" FROM warehouses WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRowIntoWarehouse(rows)
+}
+
+func (s *Store) GetWarehouseByCode(code string) (*types.Warehouse, error) {
+	rows, err := s.db.Query("SELECT "+warehouseColumns+" FROM warehouses WHERE code = ?", code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRowIntoWarehouse(rows)
+}
+
+func (s *Store) CreateWarehouse(w *types.Warehouse) error {
+	res, err := s.db.Exec(
+		"INSERT INTO warehouses (code, name, priority) VALUES (?, ?, ?)",
+		w.Code, w.Name, w.Priority,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	w.ID = int(id)
+	return nil
+}
+
+// GetLocations returns every location, those of warehouses allocated from
+// first ahead.
+func (s *Store) GetLocations() ([]types.Location, error) {
+	rows, err := s.db.Query(
+		"SELECT " + locationColumns + " FROM locations " +
+			"JOIN warehouses ON warehouses.id = locations.warehouseId ORDER BY warehouses.priority, warehouses.id, locations.id",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locations := make([]types.Location, 0)
+	for rows.Next() {
+		l, err := scanLocation(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		locations = append(locations, *l)
+	}
+
+	return locations, rows.Err()
+}
+
+func (s *Store) GetLocationByID(id int) (*types.Location, error) {
+	rows, err := s.db.Query("SELECT "+locationColumns+" FROM locations WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, types.ErrLocationNotFound
+	}
+
+	return scanLocation(rows)
+}
+
+func (s *Store) CreateLocation(l *types.Location) error {
+	res, err := s.db.Exec(
+		"INSERT INTO locations (warehouseId, code, name) VALUES (?, ?, ?)",
+		l.WarehouseID, l.Code, l.Name,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	l.ID = int(id)
+	return nil
+}
+
+// LockVariants locks the rows of the variants, and of every variant of the
+// products, in ID order so concurrent checkouts cannot deadlock, until the
+// transaction ends. Stock is derived from the ledger, so it is the variant row
+// that serializes its movements.
+func (s *Store) LockVariants(ctx context.Context, variantIDs []int, productIDs []int) error {
+	var conditions []string
+	var args []any
+	if len(variantIDs) > 0 {
+		conditions = append(conditions, fmt.Sprintf("id IN (?%s)", strings.Repeat(",?", len(variantIDs)-1)))
+		args = append(args, intArgs(variantIDs)...)
+	}
+	if len(productIDs) > 0 {
+		conditions = append(conditions, fmt.Sprintf("productId IN (?%s)", strings.Repeat(",?", len(productIDs)-1)))
+		args = append(args, intArgs(productIDs)...)
+	}
+	if len(conditions) == 0 {
+		return nil
+	}
+
+	query := "SELECT id FROM product_variants WHERE " + strings.Join(conditions, " OR ") + " ORDER BY id FOR UPDATE"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+	}
+
+	return rows.Err()
+}
+
+// GetStockLevels returns the stock on hand of the variants at each location
+// that ever held any, including locations where it has run out. It is a
+// locking read, so inside a transaction it sees the latest committed movements
+// rather than the transaction's snapshot.
+func (s *Store) GetStockLevels(variantIDs []int) ([]types.StockLevel, error) {
+	if len(variantIDs) == 0 {
+		return []types.StockLevel{}, nil
+	}
+
+	placeholders := strings.Repeat(",?", len(variantIDs)-1)
+	query := fmt.Sprintf(
+		"SELECT m.variantId, m.locationId, l.warehouseId, w.priority, SUM(m.quantity) FROM stock_movements m "+
+			"JOIN locations l ON l.id = m.locationId JOIN warehouses w ON w.id = l.warehouseId "+
+			"WHERE m.variantId IN (?%s) GROUP BY m.variantId, m.locationId, l.warehouseId, w.priority "+
+			"ORDER BY m.variantId, w.priority, w.id, m.locationId FOR SHARE OF m",
+		placeholders,
+	)
+
+	rows, err := s.db.Query(query, intArgs(variantIDs)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	levels := make([]types.StockLevel, 0)
+	for rows.Next() {
+		var l types.StockLevel
+		if err := rows.Scan(&l.VariantID, &l.LocationID, &l.WarehouseID, &l.Priority, &l.Quantity); err != nil {
+			return nil, err
+		}
+
+		levels = append(levels, l)
+	}
+
+	return levels, rows.Err()
+}
+
+// AddMovements appends movements to the ledger. It should run in a
+// transaction with the checks that allowed them.
+func (s *Store) AddMovements(ctx context.Context, movements []types.StockMovement) error {
+	for i := range movements {
+		m := &movements[i]
+		res, err := s.db.ExecContext(
+			ctx,
+			"INSERT INTO stock_movements (variantId, locationId, type, quantity, orderId, note, createdBy) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			m.VariantID, m.LocationID, m.Type, m.Quantity, m.OrderID, m.Note, m.CreatedBy,
+		)
+		if err != nil {
+			return err
+		}
+
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		m.ID = int(id)
+	}
+
+	return nil
+}
+
+func (s *Store) GetMovements(q types.MovementQuery) ([]types.StockMovement, error) {
+	where, args := movementFilter(q)
+
+	query := "SELECT " + movementColumns + " FROM stock_movements" + where + " ORDER BY id DESC"
+	if q.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, q.Limit, q.Offset)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRowsIntoMovements(rows)
+}
+
+func (s *Store) CountMovements(q types.MovementQuery) (int, error) {
+	where, args := movementFilter(q)
+
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM stock_movements"+where, args...).Scan(&count)
+	return count, err
+}
+
+// GetMovementsByOrderID returns the sales and returns of an order, oldest
+// first.
+func (s *Store) GetMovementsByOrderID(orderID int) ([]types.StockMovement, error) {
+	rows, err := s.db.Query("SELECT "+movementColumns+" FROM stock_movements WHERE orderId = ? ORDER BY id", orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRowsIntoMovements(rows)
+}
+
+func movementFilter(q types.MovementQuery) (string, []any) {
+	var conditions []string
+	var args []any
+
+	if q.VariantID != 0 {
+		conditions = append(conditions, "variantId = ?")
+		args = append(args, q.VariantID)
+	}
+	if q.LocationID != 0 {
+		conditions = append(conditions, "locationId = ?")
+		args = append(args, q.LocationID)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func intArgs(ids []int) []any {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
+
+func scanRowIntoWarehouse(rows *sql.Rows) (*types.Warehouse, error) {
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, types.ErrWarehouseNotFound
+	}
+
+	return scanWarehouse(rows)
+}
+
+func scanWarehouse(rows *sql.Rows) (*types.Warehouse, error) {
+	w := new(types.Warehouse)
+
+	err := rows.Scan(&w.ID, &w.Code, &w.Name, &w.Priority, &w.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func scanLocation(rows *sql.Rows) (*types.Location, error) {
+	l := new(types.Location)
+
+	err := rows.Scan(&l.ID, &l.WarehouseID, &l.Code, &l.Name, &l.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func scanRowsIntoMovements(rows *sql.Rows) ([]types.StockMovement, error) {
+	movements := make([]types.StockMovement, 0)
+	for rows.Next() {
+		m := types.StockMovement{}
+
+		var orderID, createdBy sql.NullInt64
+		err := rows.Scan(&m.ID, &m.VariantID, &m.LocationID, &m.Type, &m.Quantity, &orderID, &m.Note, &createdBy, &m.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if orderID.Valid {
+			id := int(orderID.Int64)
+			m.OrderID = &id
+		}
+		if createdBy.Valid {
+			id := int(createdBy.Int64)
+			m.CreatedBy = &id
+		}
+
+		movements = append(movements, m)
+	}
+
+	return movements, rows.Err()
+}
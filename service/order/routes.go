@@ -0,0 +1,190 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/service/idempotency"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+	"github.com/joshbarros/golang-ecommerce-api/utils"
+)
+
+type Handler struct {
+	store            types.OrderStore
+	txManager        types.TxManager
+	userStore        types.UserStore
+	tokenStore       types.TokenStore
+	idempotencyStore types.IdempotencyStore
+	apiKeyStore      types.APIKeyStore
+}
+
+func NewHandler(store types.OrderStore, txManager types.TxManager, userStore types.UserStore, tokenStore types.TokenStore, idempotencyStore types.IdempotencyStore, apiKeyStore types.APIKeyStore) *Handler {
+	return &Handler{
+		store:            store,
+		txManager:        txManager,
+		userStore:        userStore,
+		tokenStore:       tokenStore,
+		idempotencyStore: idempotencyStore,
+		apiKeyStore:      apiKeyStore,
+	}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/orders", h.withAuth(h.handleGetOrders, types.ScopeOrdersRead)).Methods(http.MethodGet)
+	router.HandleFunc("/orders/{id}", h.withAuth(h.handleGetOrder, types.ScopeOrdersRead)).Methods(http.MethodGet)
+	router.HandleFunc("/orders/{id}/cancel", h.withAuth(idempotency.WithIdempotencyKey(h.handleCancelOrder, h.idempotencyStore), types.ScopeOrdersWrite)).Methods(http.MethodPost)
+	router.HandleFunc("/orders/{id}/status", h.withAuth(auth.RequireRole(h.handleUpdateOrderStatus, types.RoleStaff, types.RoleAdmin), types.ScopeOrdersWrite)).Methods(http.MethodPut)
+}
+
+// withAuth lets in a logged-in user, or an integration with an API key that
+// has the scope.
+func (h *Handler) withAuth(handlerFunc http.HandlerFunc, scope types.Scope) http.HandlerFunc {
+	return auth.WithJWTOrAPIKeyAuth(handlerFunc, scope, h.userStore, h.tokenStore, h.apiKeyStore)
+}
+
+func (h *Handler) handleGetOrders(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	page, limit, err := utils.ParsePagination(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	orders, err := h.store.GetOrdersByUserID(userID, limit, (page-1)*limit)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	total, err := h.store.CountOrdersByUserID(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"orders": orders,
+		"page":   page,
+		"limit":  limit,
+		"total":  total,
+	})
+}
+
+func (h *Handler) handleGetOrder(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	orderID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid order ID"))
+		return
+	}
+
+	order, err := h.store.GetOrderByID(orderID)
+	if errors.Is(err, types.ErrOrderNotFound) {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Someone else's order is reported as missing so IDs can't be probed.
+	if order.UserID != userID {
+		utils.WriteError(w, http.StatusNotFound, types.ErrOrderNotFound)
+		return
+	}
+
+	items, err := h.store.GetOrderItems(order.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.OrderDetail{Order: *order, Items: items})
+}
+
+func (h *Handler) handleCancelOrder(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	orderID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid order ID"))
+		return
+	}
+
+	var order *types.Order
+	err = h.txManager.WithTx(r.Context(), func(stores types.Stores) error {
+		var err error
+		order, err = CancelOrder(r.Context(), stores, orderID, userID)
+		return err
+	})
+
+	var transitionErr *TransitionError
+	switch {
+	case errors.Is(err, types.ErrOrderNotFound):
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	case errors.As(err, &transitionErr), errors.Is(err, types.ErrOrderStatusConflict):
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	case err != nil:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, order)
+}
+
+func (h *Handler) handleUpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	orderID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid order ID"))
+		return
+	}
+
+	var payload types.UpdateOrderStatusPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid payload %v", err))
+		return
+	}
+
+	if !payload.Status.IsValid() {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid order status %q", payload.Status))
+		return
+	}
+
+	var order *types.Order
+	err = h.txManager.WithTx(r.Context(), func(stores types.Stores) error {
+		var err error
+		order, err = UpdateOrderStatus(r.Context(), stores, orderID, payload.Status, userID, payload.Note)
+		return err
+	})
+
+	var transitionErr *TransitionError
+	switch {
+	case errors.Is(err, types.ErrOrderNotFound):
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	case errors.As(err, &transitionErr), errors.Is(err, types.ErrOrderStatusConflict):
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	case err != nil:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, order)
+}
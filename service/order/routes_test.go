@@ -0,0 +1,552 @@
+package order
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// Mock implementation of the OrderStore interface
+type mockOrderStore struct {
+	orders  []types.Order
+	items   []types.OrderItem
+	history []types.OrderStatusChange
+}
+
+func (m *mockOrderStore) CreateOrder(order types.Order) (int, error) {
+	order.ID = len(m.orders) + 1
+	m.orders = append(m.orders, order)
+	return order.ID, nil
+}
+
+func (m *mockOrderStore) CreateOrderItem(item types.OrderItem) error {
+	item.ID = len(m.items) + 1
+	m.items = append(m.items, item)
+	return nil
+}
+
+func (m *mockOrderStore) GetOrdersByUserID(userID int, limit int, offset int) ([]types.Order, error) {
+	var result []types.Order
+	for _, o := range m.orders {
+		if o.UserID == userID {
+			result = append(result, o)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.After(result[j].CreatedAt) })
+
+	if offset >= len(result) {
+		return []types.Order{}, nil
+	}
+	result = result[offset:]
+	if limit < len(result) {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func (m *mockOrderStore) CountOrdersByUserID(userID int) (int, error) {
+	count := 0
+	for _, o := range m.orders {
+		if o.UserID == userID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *mockOrderStore) GetOrderByID(id int) (*types.Order, error) {
+	for _, o := range m.orders {
+		if o.ID == id {
+			return &o, nil
+		}
+	}
+	return nil, types.ErrOrderNotFound
+}
+
+func (m *mockOrderStore) GetOrderItems(orderID int) ([]types.OrderItem, error) {
+	result := []types.OrderItem{}
+	for _, item := range m.items {
+		if item.OrderID == orderID {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockOrderStore) UpdateOrderStatus(orderID int, from types.OrderStatus, to types.OrderStatus) error {
+	for i, o := range m.orders {
+		if o.ID == orderID {
+			if o.Status != from {
+				return types.ErrOrderStatusConflict
+			}
+			m.orders[i].Status = to
+			return nil
+		}
+	}
+	return types.ErrOrderStatusConflict
+}
+
+func (m *mockOrderStore) CreateOrderStatusChange(change types.OrderStatusChange) error {
+	change.ID = len(m.history) + 1
+	m.history = append(m.history, change)
+	return nil
+}
+
+// Mock implementation of the InventoryStore interface, only keeping the
+// ledger
+type mockInventoryStore struct {
+	locations []types.Location
+	movements []types.StockMovement
+}
+
+func (m *mockInventoryStore) GetWarehouses() ([]types.Warehouse, error) {
+	return nil, nil
+}
+
+func (m *mockInventoryStore) GetWarehouseByID(id int) (*types.Warehouse, error) {
+	return nil, types.ErrWarehouseNotFound
+}
+
+func (m *mockInventoryStore) GetWarehouseByCode(code string) (*types.Warehouse, error) {
+	return nil, types.ErrWarehouseNotFound
+}
+
+func (m *mockInventoryStore) CreateWarehouse(w *types.Warehouse) error {
+	return nil
+}
+
+func (m *mockInventoryStore) GetLocations() ([]types.Location, error) {
+	return m.locations, nil
+}
+
+func (m *mockInventoryStore) GetLocationByID(id int) (*types.Location, error) {
+	return nil, types.ErrLocationNotFound
+}
+
+func (m *mockInventoryStore) CreateLocation(l *types.Location) error {
+	return nil
+}
+
+func (m *mockInventoryStore) LockVariants(ctx context.Context, variantIDs []int, productIDs []int) error {
+	return nil
+}
+
+func (m *mockInventoryStore) GetStockLevels(variantIDs []int) ([]types.StockLevel, error) {
+	return nil, nil
+}
+
+func (m *mockInventoryStore) AddMovements(ctx context.Context, movements []types.StockMovement) error {
+	for _, mv := range movements {
+		mv.ID = len(m.movements) + 1
+		m.movements = append(m.movements, mv)
+	}
+	return nil
+}
+
+func (m *mockInventoryStore) GetMovements(q types.MovementQuery) ([]types.StockMovement, error) {
+	return nil, nil
+}
+
+func (m *mockInventoryStore) CountMovements(q types.MovementQuery) (int, error) {
+	return 0, nil
+}
+
+func (m *mockInventoryStore) GetMovementsByOrderID(orderID int) ([]types.StockMovement, error) {
+	var result []types.StockMovement
+	for _, mv := range m.movements {
+		if mv.OrderID != nil && *mv.OrderID == orderID {
+			result = append(result, mv)
+		}
+	}
+	return result, nil
+}
+
+// onHand sums the movements of a variant at a location.
+func (m *mockInventoryStore) onHand(variantID int, locationID int) int {
+	quantity := 0
+	for _, mv := range m.movements {
+		if mv.VariantID == variantID && mv.LocationID == locationID {
+			quantity += mv.Quantity
+		}
+	}
+	return quantity
+}
+
+// Mock TxManager running the unit of work directly against the mock stores
+type mockTxManager struct {
+	orders    *mockOrderStore
+	inventory *mockInventoryStore
+}
+
+func (m *mockTxManager) WithTx(ctx context.Context, fn func(types.Stores) error) error {
+	return fn(types.Stores{Orders: m.orders, Inventory: m.inventory})
+}
+
+// newTestInventory has two locations. Orders 1 and 3 took their items from
+// them, while order 2 was placed before the ledger and has no sales.
+func newTestInventory() *mockInventoryStore {
+	order1, order3 := 1, 3
+	return &mockInventoryStore{
+		locations: []types.Location{{ID: 1, WarehouseID: 1, Code: "A"}, {ID: 2, WarehouseID: 1, Code: "B"}},
+		movements: []types.StockMovement{
+			{VariantID: 1, LocationID: 1, Type: types.MovementReceipt, Quantity: 5},
+			{VariantID: 2, LocationID: 1, Type: types.MovementReceipt, Quantity: 3},
+			{VariantID: 2, LocationID: 2, Type: types.MovementReceipt, Quantity: 3},
+			{VariantID: 1, LocationID: 1, Type: types.MovementSale, Quantity: -1, OrderID: &order1},
+			{VariantID: 2, LocationID: 1, Type: types.MovementSale, Quantity: -1, OrderID: &order3},
+			{VariantID: 2, LocationID: 2, Type: types.MovementSale, Quantity: -2, OrderID: &order3},
+		},
+	}
+}
+
+func newTestStore() *mockOrderStore {
+	now := time.Now()
+	return &mockOrderStore{
+		orders: []types.Order{
+			{ID: 1, UserID: 1, Total: types.NewMoney(1000, types.DefaultCurrency), Status: types.OrderStatusPending, CreatedAt: now.Add(-3 * time.Hour)},
+			{ID: 2, UserID: 2, Total: types.NewMoney(2000, types.DefaultCurrency), Status: types.OrderStatusPending, CreatedAt: now.Add(-2 * time.Hour)},
+			{ID: 3, UserID: 1, Total: types.NewMoney(3000, types.DefaultCurrency), Status: types.OrderStatusPending, CreatedAt: now.Add(-1 * time.Hour)},
+		},
+		items: []types.OrderItem{
+			{ID: 1, OrderID: 1, ProductID: 1, VariantID: 1, SKU: "P1", ProductName: "Test Product 1", Quantity: 1, Price: types.NewMoney(1000, types.DefaultCurrency)},
+			{ID: 2, OrderID: 2, ProductID: 1, VariantID: 1, SKU: "P1", ProductName: "Test Product 1", Quantity: 2, Price: types.NewMoney(1000, types.DefaultCurrency)},
+			{ID: 3, OrderID: 3, ProductID: 2, VariantID: 2, SKU: "P2", ProductName: "Test Product 2", Quantity: 3, Price: types.NewMoney(1000, types.DefaultCurrency)},
+		},
+	}
+}
+
+func serve(t *testing.T, method string, path string, pattern string, handler http.HandlerFunc, userID int) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, err := http.NewRequest(method, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(context.WithValue(req.Context(), auth.UserKey, userID))
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc(pattern, handler)
+	router.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func TestOrderServiceHandlers(t *testing.T) {
+	handler := NewHandler(newTestStore(), nil, nil, nil, nil, nil)
+
+	t.Run("Should list only the caller's orders, newest first", func(t *testing.T) {
+		rr := serve(t, http.MethodGet, "/orders", "/orders", handler.handleGetOrders, 1)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var response struct {
+			Orders []types.Order `json:"orders"`
+			Total  int           `json:"total"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+
+		if response.Total != 2 || len(response.Orders) != 2 {
+			t.Fatalf("Expected 2 orders, got %d (total %d)", len(response.Orders), response.Total)
+		}
+		if response.Orders[0].ID != 3 || response.Orders[1].ID != 1 {
+			t.Errorf("Expected orders [3 1], got [%d %d]", response.Orders[0].ID, response.Orders[1].ID)
+		}
+	})
+
+	t.Run("Should paginate the order list", func(t *testing.T) {
+		rr := serve(t, http.MethodGet, "/orders?page=2&limit=1", "/orders", handler.handleGetOrders, 1)
+
+		var response struct {
+			Orders []types.Order `json:"orders"`
+			Page   int           `json:"page"`
+			Limit  int           `json:"limit"`
+			Total  int           `json:"total"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+
+		if len(response.Orders) != 1 || response.Orders[0].ID != 1 {
+			t.Errorf("Expected only order 1 on page 2, got %+v", response.Orders)
+		}
+		if response.Page != 2 || response.Limit != 1 || response.Total != 2 {
+			t.Errorf("Unexpected pagination metadata %+v", response)
+		}
+	})
+
+	t.Run("Should fail with an invalid page", func(t *testing.T) {
+		rr := serve(t, http.MethodGet, "/orders?page=0", "/orders", handler.handleGetOrders, 1)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("Should get an order with its items", func(t *testing.T) {
+		rr := serve(t, http.MethodGet, "/orders/3", "/orders/{id}", handler.handleGetOrder, 1)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var order types.OrderDetail
+		if err := json.NewDecoder(rr.Body).Decode(&order); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+
+		if order.ID != 3 || len(order.Items) != 1 {
+			t.Fatalf("Expected order 3 with 1 item, got order %d with %d items", order.ID, len(order.Items))
+		}
+		if order.Items[0].ProductName != "Test Product 2" {
+			t.Errorf("Expected the product snapshot, got %q", order.Items[0].ProductName)
+		}
+	})
+
+	t.Run("Should not expose another user's order", func(t *testing.T) {
+		rr := serve(t, http.MethodGet, "/orders/2", "/orders/{id}", handler.handleGetOrder, 1)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("Should fail if the order does not exist", func(t *testing.T) {
+		rr := serve(t, http.MethodGet, "/orders/42", "/orders/{id}", handler.handleGetOrder, 1)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("Should fail if the order ID is invalid", func(t *testing.T) {
+		rr := serve(t, http.MethodGet, "/orders/abc", "/orders/{id}", handler.handleGetOrder, 1)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+}
+
+func TestCancelOrder(t *testing.T) {
+	setup := func() (*Handler, *mockOrderStore, *mockInventoryStore) {
+		orderStore := newTestStore()
+		inventoryStore := newTestInventory()
+		txManager := &mockTxManager{orders: orderStore, inventory: inventoryStore}
+		return NewHandler(orderStore, txManager, nil, nil, nil, nil), orderStore, inventoryStore
+	}
+
+	t.Run("Should cancel a pending order and return its items where they were taken from", func(t *testing.T) {
+		handler, orderStore, inventoryStore := setup()
+
+		rr := serve(t, http.MethodPost, "/orders/3/cancel", "/orders/{id}/cancel", handler.handleCancelOrder, 1)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		if orderStore.orders[2].Status != types.OrderStatusCancelled {
+			t.Errorf("Expected order to be cancelled, got %s", orderStore.orders[2].Status)
+		}
+		if a, b := inventoryStore.onHand(2, 1), inventoryStore.onHand(2, 2); a != 3 || b != 3 {
+			t.Errorf("Expected 3 units of variant 2 at both locations, got %d and %d", a, b)
+		}
+		for _, m := range inventoryStore.movements[6:] {
+			if m.Type != types.MovementReturn || *m.OrderID != 3 || *m.CreatedBy != 1 {
+				t.Errorf("Expected a return of order 3 by user 1, got %+v", m)
+			}
+		}
+		if len(orderStore.history) != 1 || orderStore.history[0].ChangedBy != 1 {
+			t.Errorf("Expected an audit entry by user 1, got %+v", orderStore.history)
+		}
+	})
+
+	t.Run("Should not cancel or restock twice", func(t *testing.T) {
+		handler, orderStore, inventoryStore := setup()
+
+		serve(t, http.MethodPost, "/orders/3/cancel", "/orders/{id}/cancel", handler.handleCancelOrder, 1)
+		rr := serve(t, http.MethodPost, "/orders/3/cancel", "/orders/{id}/cancel", handler.handleCancelOrder, 1)
+
+		if rr.Code != http.StatusConflict {
+			t.Errorf("Expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+		if len(inventoryStore.movements) != 8 {
+			t.Errorf("Expected the items to be returned once, got %+v", inventoryStore.movements)
+		}
+		if len(orderStore.history) != 1 {
+			t.Errorf("Expected a single audit entry, got %d", len(orderStore.history))
+		}
+	})
+
+	t.Run("Should not cancel an order that has shipped", func(t *testing.T) {
+		handler, orderStore, inventoryStore := setup()
+		orderStore.orders[2].Status = types.OrderStatusShipped
+
+		rr := serve(t, http.MethodPost, "/orders/3/cancel", "/orders/{id}/cancel", handler.handleCancelOrder, 1)
+
+		if rr.Code != http.StatusConflict {
+			t.Errorf("Expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+		if orderStore.orders[2].Status != types.OrderStatusShipped || len(inventoryStore.movements) != 6 {
+			t.Error("Expected the order and stock to be left untouched")
+		}
+	})
+
+	t.Run("Should not cancel a paid order", func(t *testing.T) {
+		handler, orderStore, _ := setup()
+		orderStore.orders[2].Status = types.OrderStatusPaid
+
+		rr := serve(t, http.MethodPost, "/orders/3/cancel", "/orders/{id}/cancel", handler.handleCancelOrder, 1)
+
+		if rr.Code != http.StatusConflict {
+			t.Errorf("Expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+
+	t.Run("Should not cancel another user's order", func(t *testing.T) {
+		handler, orderStore, inventoryStore := setup()
+
+		rr := serve(t, http.MethodPost, "/orders/2/cancel", "/orders/{id}/cancel", handler.handleCancelOrder, 1)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+		if orderStore.orders[1].Status != types.OrderStatusPending || len(inventoryStore.movements) != 6 {
+			t.Error("Expected the order and stock to be left untouched")
+		}
+	})
+}
+
+func TestUpdateOrderStatus(t *testing.T) {
+	setup := func() (*Handler, *mockOrderStore, *mockInventoryStore) {
+		orderStore := newTestStore()
+		inventoryStore := newTestInventory()
+		txManager := &mockTxManager{orders: orderStore, inventory: inventoryStore}
+		return NewHandler(orderStore, txManager, nil, nil, nil, nil), orderStore, inventoryStore
+	}
+
+	update := func(t *testing.T, handler *Handler, path string, payload types.UpdateOrderStatusPayload) *httptest.ResponseRecorder {
+		t.Helper()
+
+		marshalled, _ := json.Marshal(payload)
+		req, err := http.NewRequest(http.MethodPut, path, bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserKey, 9))
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		router.HandleFunc("/orders/{id}/status", handler.handleUpdateOrderStatus)
+		router.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	t.Run("Should move an order to the next status", func(t *testing.T) {
+		handler, orderStore, _ := setup()
+
+		rr := update(t, handler, "/orders/2/status", types.UpdateOrderStatusPayload{Status: types.OrderStatusPaid, Note: "Paid by bank transfer"})
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		if orderStore.orders[1].Status != types.OrderStatusPaid {
+			t.Errorf("Expected order to be paid, got %s", orderStore.orders[1].Status)
+		}
+		if len(orderStore.history) != 1 || orderStore.history[0].ChangedBy != 9 || orderStore.history[0].Note != "Paid by bank transfer" {
+			t.Errorf("Expected an audit entry by user 9, got %+v", orderStore.history)
+		}
+	})
+
+	t.Run("Should return the items of an order without sales to the first location", func(t *testing.T) {
+		handler, _, inventoryStore := setup()
+
+		rr := update(t, handler, "/orders/2/status", types.UpdateOrderStatusPayload{Status: types.OrderStatusCancelled})
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if q := inventoryStore.onHand(1, 1); q != 6 {
+			t.Errorf("Expected 6 units of variant 1 at the first location, got %d", q)
+		}
+		if m := inventoryStore.movements[len(inventoryStore.movements)-1]; m.Type != types.MovementReturn || *m.OrderID != 2 || *m.CreatedBy != 9 {
+			t.Errorf("Expected a return of order 2 by user 9, got %+v", m)
+		}
+	})
+
+	t.Run("Should return the items of an order refunded before it shipped", func(t *testing.T) {
+		handler, orderStore, inventoryStore := setup()
+		orderStore.orders[2].Status = types.OrderStatusFulfilled
+
+		rr := update(t, handler, "/orders/3/status", types.UpdateOrderStatusPayload{Status: types.OrderStatusRefunded})
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		if a, b := inventoryStore.onHand(2, 1), inventoryStore.onHand(2, 2); a != 3 || b != 3 {
+			t.Errorf("Expected 3 units back at each location, got %d and %d", a, b)
+		}
+		if m := inventoryStore.movements[len(inventoryStore.movements)-1]; m.Type != types.MovementReturn || m.Note != "Order refunded" {
+			t.Errorf("Expected a return for the refund, got %+v", m)
+		}
+	})
+
+	t.Run("Should keep the stock of an order refunded after it was delivered", func(t *testing.T) {
+		handler, orderStore, inventoryStore := setup()
+		orderStore.orders[2].Status = types.OrderStatusDelivered
+		movements := len(inventoryStore.movements)
+
+		if rr := update(t, handler, "/orders/3/status", types.UpdateOrderStatusPayload{Status: types.OrderStatusRefunded}); rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		if len(inventoryStore.movements) != movements {
+			t.Errorf("Expected no stock to be returned, got %+v", inventoryStore.movements[movements:])
+		}
+	})
+
+	t.Run("Should reject a transition the table does not allow", func(t *testing.T) {
+		handler, orderStore, _ := setup()
+
+		rr := update(t, handler, "/orders/2/status", types.UpdateOrderStatusPayload{Status: types.OrderStatusShipped})
+
+		if rr.Code != http.StatusConflict {
+			t.Errorf("Expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+		if orderStore.orders[1].Status != types.OrderStatusPending {
+			t.Error("Expected the order to be left untouched")
+		}
+	})
+
+	t.Run("Should reject an unknown status", func(t *testing.T) {
+		handler, _, _ := setup()
+
+		rr := update(t, handler, "/orders/2/status", types.UpdateOrderStatusPayload{Status: "lost"})
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("Should fail if the order does not exist", func(t *testing.T) {
+		handler, _, _ := setup()
+
+		rr := update(t, handler, "/orders/42/status", types.UpdateOrderStatusPayload{Status: types.OrderStatusPaid})
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package order
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// TransitionError is returned when an order is asked to move to a status the
+// transition table does not allow from its current one.
+type TransitionError struct {
+	From types.OrderStatus
+	To   types.OrderStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("Cannot change order status from %s to %s", e.From, e.To)
+}
+
+// TransitionOrder moves an order to a new status and records the change in
+// its history. It should run inside a transaction so the status and the
+// history entry are written together. changedBy is 0 for system changes.
+func TransitionOrder(stores types.Stores, orderID int, to types.OrderStatus, changedBy int, note string) (*types.Order, error) {
+	if !to.IsValid() {
+		return nil, fmt.Errorf("Invalid order status %q", to)
+	}
+
+	order, err := stores.Orders.GetOrderByID(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := transition(stores, order, to, changedBy, note); err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// transition is TransitionOrder for an order already read, which it updates.
+func transition(stores types.Stores, order *types.Order, to types.OrderStatus, changedBy int, note string) error {
+	if !order.Status.CanTransitionTo(to) {
+		return &TransitionError{From: order.Status, To: to}
+	}
+
+	if err := stores.Orders.UpdateOrderStatus(order.ID, order.Status, to); err != nil {
+		return err
+	}
+
+	err := stores.Orders.CreateOrderStatusChange(types.OrderStatusChange{
+		OrderID:    order.ID,
+		FromStatus: order.Status,
+		ToStatus:   to,
+		ChangedBy:  changedBy,
+		Note:       note,
+	})
+	if err != nil {
+		return err
+	}
+
+	order.Status = to
+	return nil
+}
+
+// CancelOrder lets a customer cancel one of their own orders while it is
+// still pending, returning every item's quantity to stock. It must run inside
+// a transaction: the status flip guards against a second cancellation, so
+// stock is only ever restored once.
+func CancelOrder(ctx context.Context, stores types.Stores, orderID int, userID int) (*types.Order, error) {
+	order, err := stores.Orders.GetOrderByID(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if order.UserID != userID {
+		return nil, types.ErrOrderNotFound
+	}
+
+	if order.Status != types.OrderStatusPending {
+		return nil, &TransitionError{From: order.Status, To: types.OrderStatusCancelled}
+	}
+
+	return UpdateOrderStatus(ctx, stores, order.ID, types.OrderStatusCancelled, userID, "Cancelled by customer")
+}
+
+// UpdateOrderStatus is TransitionOrder for order management: cancelling an
+// order, or refunding it before it shipped, also returns its items to stock.
+// It must run inside a transaction.
+func UpdateOrderStatus(ctx context.Context, stores types.Stores, orderID int, to types.OrderStatus, changedBy int, note string) (*types.Order, error) {
+	if !to.IsValid() {
+		return nil, fmt.Errorf("Invalid order status %q", to)
+	}
+
+	order, err := stores.Orders.GetOrderByID(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	from := order.Status
+	if err := transition(stores, order, to, changedBy, note); err != nil {
+		return nil, err
+	}
+
+	if !from.ReturnsStock(to) {
+		return order, nil
+	}
+
+	if err := returnStock(ctx, stores, order.ID, changedBy, fmt.Sprintf("Order %s", to)); err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// returnStock posts a return for every sale of an order, to the location it
+// was taken from. Orders placed before the stock ledger have no sales, and
+// their items are returned to the first location checkout allocates from.
+func returnStock(ctx context.Context, stores types.Stores, orderID int, changedBy int, note string) error {
+	sales, err := stores.Inventory.GetMovementsByOrderID(orderID)
+	if err != nil {
+		return err
+	}
+
+	var createdBy *int
+	if changedBy != 0 {
+		createdBy = &changedBy
+	}
+
+	var returns []types.StockMovement
+	for _, m := range sales {
+		if m.Type != types.MovementSale {
+			continue
+		}
+
+		returns = append(returns, types.StockMovement{
+			VariantID:  m.VariantID,
+			LocationID: m.LocationID,
+			Type:       types.MovementReturn,
+			Quantity:   -m.Quantity,
+			OrderID:    &orderID,
+			Note:       note,
+			CreatedBy:  createdBy,
+		})
+	}
+
+	if len(sales) == 0 {
+		items, err := stores.Orders.GetOrderItems(orderID)
+		if err != nil {
+			return err
+		}
+
+		locations, err := stores.Inventory.GetLocations()
+		if err != nil {
+			return err
+		}
+		if len(items) > 0 && len(locations) == 0 {
+			return types.ErrLocationNotFound
+		}
+
+		for _, item := range items {
+			returns = append(returns, types.StockMovement{
+				VariantID:  item.VariantID,
+				LocationID: locations[0].ID,
+				Type:       types.MovementReturn,
+				Quantity:   item.Quantity,
+				OrderID:    &orderID,
+				Note:       note,
+				CreatedBy:  createdBy,
+			})
+		}
+	}
+
+	return stores.Inventory.AddMovements(ctx, returns)
+}
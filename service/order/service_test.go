@@ -0,0 +1,86 @@
+package order
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+func TestTransitionOrder(t *testing.T) {
+	tests := []struct {
+		from  types.OrderStatus
+		to    types.OrderStatus
+		legal bool
+	}{
+		{types.OrderStatusPending, types.OrderStatusPaid, true},
+		{types.OrderStatusPending, types.OrderStatusCancelled, true},
+		{types.OrderStatusPaid, types.OrderStatusFulfilled, true},
+		{types.OrderStatusPaid, types.OrderStatusRefunded, true},
+		{types.OrderStatusFulfilled, types.OrderStatusShipped, true},
+		{types.OrderStatusShipped, types.OrderStatusDelivered, true},
+		{types.OrderStatusShipped, types.OrderStatusCompleted, true},
+		{types.OrderStatusDelivered, types.OrderStatusCompleted, true},
+		{types.OrderStatusCompleted, types.OrderStatusRefunded, true},
+		{types.OrderStatusPending, types.OrderStatusShipped, false},
+		{types.OrderStatusPaid, types.OrderStatusCancelled, false},
+		{types.OrderStatusShipped, types.OrderStatusCancelled, false},
+		{types.OrderStatusCancelled, types.OrderStatusPending, false},
+		{types.OrderStatusRefunded, types.OrderStatusPaid, false},
+		{types.OrderStatusPending, types.OrderStatusPending, false},
+	}
+
+	for _, tt := range tests {
+		store := &mockOrderStore{
+			orders: []types.Order{{ID: 1, UserID: 1, Status: tt.from}},
+		}
+
+		order, err := TransitionOrder(types.Stores{Orders: store}, 1, tt.to, 7, "note")
+
+		if tt.legal {
+			if err != nil {
+				t.Errorf("%s -> %s: expected transition to succeed, got %v", tt.from, tt.to, err)
+				continue
+			}
+			if order.Status != tt.to || store.orders[0].Status != tt.to {
+				t.Errorf("%s -> %s: expected status %s, got %s", tt.from, tt.to, tt.to, store.orders[0].Status)
+			}
+			if len(store.history) != 1 {
+				t.Errorf("%s -> %s: expected 1 history entry, got %d", tt.from, tt.to, len(store.history))
+				continue
+			}
+			change := store.history[0]
+			if change.FromStatus != tt.from || change.ToStatus != tt.to || change.ChangedBy != 7 || change.Note != "note" {
+				t.Errorf("%s -> %s: unexpected history entry %+v", tt.from, tt.to, change)
+			}
+			continue
+		}
+
+		var transitionErr *TransitionError
+		if !errors.As(err, &transitionErr) {
+			t.Errorf("%s -> %s: expected a TransitionError, got %v", tt.from, tt.to, err)
+		}
+		if store.orders[0].Status != tt.from || len(store.history) != 0 {
+			t.Errorf("%s -> %s: expected the order to be left untouched", tt.from, tt.to)
+		}
+	}
+}
+
+func TestTransitionOrderRejectsUnknownStatus(t *testing.T) {
+	store := &mockOrderStore{
+		orders: []types.Order{{ID: 1, UserID: 1, Status: types.OrderStatusPending}},
+	}
+
+	if _, err := TransitionOrder(types.Stores{Orders: store}, 1, "lost", 1, ""); err == nil {
+		t.Error("Expected an error for an unknown status")
+	}
+}
+
+func TestTransitionOrderNotFound(t *testing.T) {
+	store := &mockOrderStore{}
+
+	_, err := TransitionOrder(types.Stores{Orders: store}, 1, types.OrderStatusPaid, 1, "")
+	if !errors.Is(err, types.ErrOrderNotFound) {
+		t.Errorf("Expected ErrOrderNotFound, got %v", err)
+	}
+}
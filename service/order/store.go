@@ -2,15 +2,17 @@ package order
 
 import (
 	"database/sql"
+	"encoding/json"
 
+	"github.com/joshbarros/golang-ecommerce-api/db"
 	"github.com/joshbarros/golang-ecommerce-api/types"
 )
 
 type Store struct {
-	db *sql.DB
+	db db.DBTX
 }
 
-func NewStore(db *sql.DB) *Store {
+func NewStore(db db.DBTX) *Store {
 	return &Store{db: db}
 }
 
@@ -33,9 +35,161 @@ func (s *Store) CreateOrder(order types.Order) (int, error) {
 }
 
 func (s *Store) CreateOrderItem(orderItem types.OrderItem) error {
+	options, err := json.Marshal(orderItem.Options)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(
+		"INSERT INTO order_items (orderId, productId, variantId, sku, productName, productImage, options, quantity, price) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		orderItem.OrderID, orderItem.ProductID, orderItem.VariantID, orderItem.SKU, orderItem.ProductName, orderItem.ProductImage, options, orderItem.Quantity, orderItem.Price,
+	)
+	return err
+}
+
+func (s *Store) GetOrdersByUserID(userID int, limit int, offset int) ([]types.Order, error) {
+	rows, err := s.db.Query(
+		"SELECT id, userId, total, status, address, createdAt FROM orders WHERE userId = ? ORDER BY createdAt DESC, id DESC LIMIT ? OFFSET ?",
+		userID, limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := make([]types.Order, 0)
+	for rows.Next() {
+		o, err := scanRowIntoOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		orders = append(orders, *o)
+	}
+
+	return orders, rows.Err()
+}
+
+func (s *Store) CountOrdersByUserID(userID int) (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM orders WHERE userId = ?", userID).Scan(&count)
+	return count, err
+}
+
+func (s *Store) GetOrderByID(id int) (*types.Order, error) {
+	rows, err := s.db.Query("SELECT id, userId, total, status, address, createdAt FROM orders WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, types.ErrOrderNotFound
+	}
+
+	return scanRowIntoOrder(rows)
+}
+
+func (s *Store) GetOrderItems(orderID int) ([]types.OrderItem, error) {
+	rows, err := s.db.Query(
+		"SELECT id, orderId, productId, variantId, sku, productName, productImage, options, quantity, price, createdAt FROM order_items WHERE orderId = ? ORDER BY id",
+		orderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]types.OrderItem, 0)
+	for rows.Next() {
+		item := types.OrderItem{}
+
+		// Items ordered before products had variants may have neither a
+		// variant nor options.
+		var variantID sql.NullInt64
+		var options []byte
+		err := rows.Scan(
+			&item.ID,
+			&item.OrderID,
+			&item.ProductID,
+			&variantID,
+			&item.SKU,
+			&item.ProductName,
+			&item.ProductImage,
+			&options,
+			&item.Quantity,
+			&item.Price,
+			&item.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		item.VariantID = int(variantID.Int64)
+		if options != nil {
+			if err := json.Unmarshal(options, &item.Options); err != nil {
+				return nil, err
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// UpdateOrderStatus moves an order from one status to another. The update only
+// applies while the order is still in the from status, so two concurrent
+// transitions cannot both succeed.
+func (s *Store) UpdateOrderStatus(orderID int, from types.OrderStatus, to types.OrderStatus) error {
+	res, err := s.db.Exec("UPDATE orders SET status = ? WHERE id = ? AND status = ?", to, orderID, from)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return types.ErrOrderStatusConflict
+	}
+
+	return nil
+}
+
+func (s *Store) CreateOrderStatusChange(change types.OrderStatusChange) error {
+	var changedBy sql.NullInt64
+	if change.ChangedBy != 0 {
+		changedBy = sql.NullInt64{Int64: int64(change.ChangedBy), Valid: true}
+	}
+
 	_, err := s.db.Exec(
-		"INSERT INTO order_items (orderId, productId, quantity, price) VALUES (?, ?, ?, ?)",
-		orderItem.OrderID, orderItem.ProductID, orderItem.Quantity, orderItem.Price,
+		"INSERT INTO order_status_history (orderId, fromStatus, toStatus, changedBy, note) VALUES (?, ?, ?, ?, ?)",
+		change.OrderID, change.FromStatus, change.ToStatus, changedBy, change.Note,
 	)
 	return err
 }
+
+func scanRowIntoOrder(rows *sql.Rows) (*types.Order, error) {
+	order := new(types.Order)
+
+	err := rows.Scan(
+		&order.ID,
+		&order.UserID,
+		&order.Total,
+		&order.Status,
+		&order.Address,
+		&order.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
@@ -0,0 +1,177 @@
+package product
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// productFields are the JSON fields a listing can be projected to.
+var productFields = []string{"id", "name", "description", "image", "price", "quantity", "createdAt"}
+
+// parseProductQuery reads the filters, sort and cursor of a product listing.
+// Paging by page number is left to utils.ParsePagination.
+func parseProductQuery(r *http.Request) (types.ProductQuery, error) {
+	var q types.ProductQuery
+	values := r.URL.Query()
+
+	for _, param := range []string{"minPrice", "maxPrice"} {
+		v := values.Get(param)
+		if v == "" {
+			continue
+		}
+
+		price, err := types.ParseMoney(v, types.DefaultCurrency)
+		if err != nil || price.Amount < 0 {
+			return q, fmt.Errorf("Invalid %s %q", param, v)
+		}
+
+		if param == "minPrice" {
+			q.MinPrice = &price
+		} else {
+			q.MaxPrice = &price
+		}
+	}
+
+	if v := values.Get("inStock"); v != "" {
+		inStock, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, fmt.Errorf("Invalid inStock %q", v)
+		}
+		q.InStock = inStock
+	}
+
+	if v := values.Get("createdSince"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return q, fmt.Errorf("Invalid createdSince %q, expected a time like 2006-01-02T15:04:05Z", v)
+		}
+		q.CreatedSince = &since
+	}
+
+	if v := values.Get("category"); v != "" {
+		categoryID, err := strconv.Atoi(v)
+		if err != nil || categoryID < 1 {
+			return q, fmt.Errorf("Invalid category %q", v)
+		}
+		q.CategoryID = categoryID
+	}
+
+	q.SortBy = "id"
+	if v := values.Get("sort"); v != "" {
+		q.SortBy, q.Desc = strings.TrimPrefix(v, "-"), strings.HasPrefix(v, "-")
+		if !slices.Contains(types.ProductSortKeys, q.SortBy) {
+			return q, fmt.Errorf("Invalid sort %q, expected one of %s, optionally prefixed with -", v, strings.Join(types.ProductSortKeys, ", "))
+		}
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		if values.Has("page") {
+			return q, fmt.Errorf("Use either page or cursor")
+		}
+
+		cursor, err := decodeCursor(v)
+		if err != nil {
+			return q, err
+		}
+		if cursor.SortBy != q.SortBy || cursor.Desc != q.Desc {
+			return q, fmt.Errorf("Cursor does not match the sort order")
+		}
+		q.After = cursor
+	}
+
+	return q, nil
+}
+
+// parseFields reads the fields projection, returning nil for every field.
+func parseFields(r *http.Request) ([]string, error) {
+	v := r.URL.Query().Get("fields")
+	if v == "" {
+		return nil, nil
+	}
+
+	fields := strings.Split(v, ",")
+	for _, field := range fields {
+		if !slices.Contains(productFields, field) {
+			return nil, fmt.Errorf("Invalid field %q, expected some of %s", field, strings.Join(productFields, ", "))
+		}
+	}
+
+	return fields, nil
+}
+
+// project keeps only the given fields of each product.
+func project(products []types.Product, fields []string) ([]map[string]json.RawMessage, error) {
+	projected := make([]map[string]json.RawMessage, len(products))
+	for i, p := range products {
+		b, err := json.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(b, &all); err != nil {
+			return nil, err
+		}
+
+		projected[i] = map[string]json.RawMessage{}
+		for _, field := range fields {
+			projected[i][field] = all[field]
+		}
+	}
+
+	return projected, nil
+}
+
+// Cursors are opaque to clients: base64 encoded JSON.
+func encodeCursor(c *types.ProductCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*types.ProductCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cursor")
+	}
+
+	c := new(types.ProductCursor)
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("Invalid cursor")
+	}
+
+	return c, nil
+}
+
+// linkHeader builds an RFC 8288 Link header from rel to the query parameters
+// that change for that page.
+func linkHeader(u *url.URL, links map[string]map[string]string) string {
+	parts := []string{}
+	for _, rel := range []string{"first", "prev", "next", "last"} {
+		params, ok := links[rel]
+		if !ok {
+			continue
+		}
+
+		q := u.Query()
+		for k, v := range params {
+			if v == "" {
+				q.Del(k)
+			} else {
+				q.Set(k, v)
+			}
+		}
+
+		parts = append(parts, fmt.Sprintf(`<%s?%s>; rel="%s"`, u.Path, q.Encode(), rel))
+	}
+
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,179 @@
+package product
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// sortColumns maps the sort keys of types.ProductSortKeys to columns. Only
+// these columns are ever written into a query.
+var sortColumns = map[string]string{
+	"id":        "id",
+	"name":      "name",
+	"price":     "price",
+	"createdAt": "createdAt",
+}
+
+// productQueryBuilder collects the conditions of a product listing and their
+// arguments.
+type productQueryBuilder struct {
+	where []string
+	args  []any
+}
+
+func (b *productQueryBuilder) add(condition string, args ...any) {
+	b.where = append(b.where, condition)
+	b.args = append(b.args, args...)
+}
+
+// filter adds the conditions that both a page and its total count use.
+func (b *productQueryBuilder) filter(q types.ProductQuery) {
+	b.add("deletedAt IS NULL")
+
+	if q.MinPrice != nil {
+		b.add("price >= CAST(? AS DECIMAL(10, 2))", *q.MinPrice)
+	}
+	if q.MaxPrice != nil {
+		b.add("price <= CAST(? AS DECIMAL(10, 2))", *q.MaxPrice)
+	}
+	if q.InStock {
+		b.add(productQuantity + " > 0")
+	}
+	if q.CreatedSince != nil {
+		b.add("createdAt >= ?", *q.CreatedSince)
+	}
+	if q.CategoryID != 0 {
+		// The category and everything below it.
+		b.add("id IN (SELECT productId FROM product_categories WHERE categoryId IN ("+
+			"WITH RECURSIVE tree AS (SELECT id FROM categories WHERE id = ? "+
+			"UNION ALL SELECT c.id FROM categories c JOIN tree ON c.parentId = tree.id) "+
+			"SELECT id FROM tree))", q.CategoryID)
+	}
+}
+
+// after adds the keyset condition that starts a page after the cursor.
+func (b *productQueryBuilder) after(c *types.ProductCursor, column string) error {
+	op := ">"
+	if c.Desc {
+		op = "<"
+	}
+
+	if column == "id" {
+		b.add("id "+op+" ?", c.ID)
+		return nil
+	}
+
+	value, err := cursorValue(c)
+	if err != nil {
+		return err
+	}
+
+	placeholder := "?"
+	if column == "price" {
+		placeholder = "CAST(? AS DECIMAL(10, 2))"
+	}
+
+	b.add(fmt.Sprintf("(%[1]s %[2]s %[3]s OR (%[1]s = %[3]s AND id %[2]s ?))", column, op, placeholder), value, value, c.ID)
+	return nil
+}
+
+func (b *productQueryBuilder) whereClause() string {
+	return " WHERE " + strings.Join(b.where, " AND ")
+}
+
+// buildProductQuery returns the SELECT for a page of products.
+func buildProductQuery(q types.ProductQuery) (string, []any, error) {
+	sortBy := q.SortBy
+	if sortBy == "" {
+		sortBy = "id"
+	}
+
+	column, ok := sortColumns[sortBy]
+	if !ok {
+		return "", nil, fmt.Errorf("Invalid sort key %q", sortBy)
+	}
+
+	b := &productQueryBuilder{}
+	b.filter(q)
+
+	if q.After != nil {
+		if q.After.SortBy != sortBy || q.After.Desc != q.Desc {
+			return "", nil, fmt.Errorf("Cursor does not match the sort order")
+		}
+		if err := b.after(q.After, column); err != nil {
+			return "", nil, err
+		}
+	}
+
+	direction := "ASC"
+	if q.Desc {
+		direction = "DESC"
+	}
+
+	query := "SELECT " + productColumns + " FROM products" + b.whereClause()
+	if column == "id" {
+		query += " ORDER BY id " + direction
+	} else {
+		query += fmt.Sprintf(" ORDER BY %s %s, id %s", column, direction, direction)
+	}
+
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		b.args = append(b.args, q.Limit)
+
+		if q.Offset > 0 {
+			query += " OFFSET ?"
+			b.args = append(b.args, q.Offset)
+		}
+	}
+
+	return query, b.args, nil
+}
+
+// buildProductCountQuery returns the SELECT COUNT(*) of every product
+// matching the filters, whatever the page.
+func buildProductCountQuery(q types.ProductQuery) (string, []any) {
+	b := &productQueryBuilder{}
+	b.filter(q)
+
+	return "SELECT COUNT(*) FROM products" + b.whereClause(), b.args
+}
+
+// NewProductCursor returns the cursor for the page after p.
+func NewProductCursor(p types.Product, sortBy string, desc bool) *types.ProductCursor {
+	c := &types.ProductCursor{SortBy: sortBy, Desc: desc, ID: p.ID}
+
+	switch sortBy {
+	case "name":
+		c.Value = p.Name
+	case "price":
+		c.Value = p.Price.String()
+	case "createdAt":
+		c.Value = p.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	return c
+}
+
+// cursorValue converts the cursor's value back to its column's type.
+func cursorValue(c *types.ProductCursor) (any, error) {
+	switch c.SortBy {
+	case "price":
+		price, err := types.ParseMoney(c.Value, types.DefaultCurrency)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cursor")
+		}
+		return price, nil
+	case "createdAt":
+		createdAt, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cursor")
+		}
+		return createdAt, nil
+	default:
+		return c.Value, nil
+	}
+}
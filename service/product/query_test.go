@@ -0,0 +1,178 @@
+package product
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+func TestBuildProductQuery(t *testing.T) {
+	minPrice := types.NewMoney(1000, types.DefaultCurrency)
+	maxPrice := types.NewMoney(5000, types.DefaultCurrency)
+	since := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		query types.ProductQuery
+		sql   string
+		args  []any
+	}{
+		{
+			name:  "no filters",
+			query: types.ProductQuery{},
+			sql:   "SELECT " + productColumns + " FROM products WHERE deletedAt IS NULL ORDER BY id ASC",
+		},
+		{
+			name:  "minimum price",
+			query: types.ProductQuery{MinPrice: &minPrice},
+			sql:   "WHERE deletedAt IS NULL AND price >= CAST(? AS DECIMAL(10, 2)) ORDER BY id ASC",
+			args:  []any{minPrice},
+		},
+		{
+			name:  "maximum price",
+			query: types.ProductQuery{MaxPrice: &maxPrice},
+			sql:   "WHERE deletedAt IS NULL AND price <= CAST(? AS DECIMAL(10, 2)) ORDER BY id ASC",
+			args:  []any{maxPrice},
+		},
+		{
+			name:  "in stock",
+			query: types.ProductQuery{InStock: true},
+			sql:   "WHERE deletedAt IS NULL AND " + productQuantity + " > 0 ORDER BY id ASC",
+		},
+		{
+			name:  "created since",
+			query: types.ProductQuery{CreatedSince: &since},
+			sql:   "WHERE deletedAt IS NULL AND createdAt >= ? ORDER BY id ASC",
+			args:  []any{since},
+		},
+		{
+			name:  "category and its descendants",
+			query: types.ProductQuery{CategoryID: 3},
+			sql:   "WHERE deletedAt IS NULL AND id IN (SELECT productId FROM product_categories WHERE categoryId IN (WITH RECURSIVE tree AS (SELECT id FROM categories WHERE id = ? UNION ALL SELECT c.id FROM categories c JOIN tree ON c.parentId = tree.id) SELECT id FROM tree)) ORDER BY id ASC",
+			args:  []any{3},
+		},
+		{
+			name:  "every filter",
+			query: types.ProductQuery{MinPrice: &minPrice, MaxPrice: &maxPrice, InStock: true, CreatedSince: &since},
+			sql:   "WHERE deletedAt IS NULL AND price >= CAST(? AS DECIMAL(10, 2)) AND price <= CAST(? AS DECIMAL(10, 2)) AND " + productQuantity + " > 0 AND createdAt >= ? ORDER BY id ASC",
+			args:  []any{minPrice, maxPrice, since},
+		},
+		{
+			name:  "sort by name descending",
+			query: types.ProductQuery{SortBy: "name", Desc: true},
+			sql:   "WHERE deletedAt IS NULL ORDER BY name DESC, id DESC",
+		},
+		{
+			name:  "limit and offset",
+			query: types.ProductQuery{Limit: 21, Offset: 40},
+			sql:   "WHERE deletedAt IS NULL ORDER BY id ASC LIMIT ? OFFSET ?",
+			args:  []any{21, 40},
+		},
+		{
+			name:  "cursor by id",
+			query: types.ProductQuery{Limit: 21, After: &types.ProductCursor{SortBy: "id", ID: 7}},
+			sql:   "WHERE deletedAt IS NULL AND id > ? ORDER BY id ASC LIMIT ?",
+			args:  []any{7, 21},
+		},
+		{
+			name:  "cursor by id descending",
+			query: types.ProductQuery{Desc: true, After: &types.ProductCursor{SortBy: "id", Desc: true, ID: 7}},
+			sql:   "WHERE deletedAt IS NULL AND id < ? ORDER BY id DESC",
+			args:  []any{7},
+		},
+		{
+			name:  "cursor by name",
+			query: types.ProductQuery{SortBy: "name", InStock: true, After: &types.ProductCursor{SortBy: "name", Value: "Mug", ID: 7}},
+			sql:   "WHERE deletedAt IS NULL AND " + productQuantity + " > 0 AND (name > ? OR (name = ? AND id > ?)) ORDER BY name ASC, id ASC",
+			args:  []any{"Mug", "Mug", 7},
+		},
+		{
+			name:  "cursor by price descending",
+			query: types.ProductQuery{SortBy: "price", Desc: true, After: &types.ProductCursor{SortBy: "price", Desc: true, Value: "10.00", ID: 7}},
+			sql:   "WHERE deletedAt IS NULL AND (price < CAST(? AS DECIMAL(10, 2)) OR (price = CAST(? AS DECIMAL(10, 2)) AND id < ?)) ORDER BY price DESC, id DESC",
+			args:  []any{minPrice, minPrice, 7},
+		},
+		{
+			name:  "cursor by creation time",
+			query: types.ProductQuery{SortBy: "createdAt", After: &types.ProductCursor{SortBy: "createdAt", Value: since.Format(time.RFC3339Nano), ID: 7}},
+			sql:   "WHERE deletedAt IS NULL AND (createdAt > ? OR (createdAt = ? AND id > ?)) ORDER BY createdAt ASC, id ASC",
+			args:  []any{since, since, 7},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sql, args, err := buildProductQuery(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.HasSuffix(sql, tc.sql) {
+				t.Errorf("Expected the query to end with\n%s\ngot\n%s", tc.sql, sql)
+			}
+			if len(args) != 0 || len(tc.args) != 0 {
+				if !reflect.DeepEqual(args, tc.args) {
+					t.Errorf("Expected args %v, got %v", tc.args, args)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildProductQueryRejectsBadInput(t *testing.T) {
+	for name, q := range map[string]types.ProductQuery{
+		"unknown sort key":    {SortBy: "quantity; DROP TABLE products"},
+		"mismatched cursor":   {SortBy: "name", After: &types.ProductCursor{SortBy: "id", ID: 7}},
+		"reversed cursor":     {After: &types.ProductCursor{SortBy: "id", Desc: true, ID: 7}},
+		"invalid cursor time": {SortBy: "createdAt", After: &types.ProductCursor{SortBy: "createdAt", Value: "yesterday", ID: 7}},
+	} {
+		if _, _, err := buildProductQuery(q); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestBuildProductCountQuery(t *testing.T) {
+	minPrice := types.NewMoney(1000, types.DefaultCurrency)
+
+	// The page and cursor are left out of the count.
+	sql, args := buildProductCountQuery(types.ProductQuery{
+		MinPrice: &minPrice,
+		InStock:  true,
+		Limit:    21,
+		Offset:   20,
+		After:    &types.ProductCursor{SortBy: "id", ID: 7},
+	})
+
+	expected := "SELECT COUNT(*) FROM products WHERE deletedAt IS NULL AND price >= CAST(? AS DECIMAL(10, 2)) AND " + productQuantity + " > 0"
+	if sql != expected {
+		t.Errorf("Expected %q, got %q", expected, sql)
+	}
+	if !reflect.DeepEqual(args, []any{minPrice}) {
+		t.Errorf("Expected args [%v], got %v", minPrice, args)
+	}
+}
+
+func TestProductCursorRoundTrip(t *testing.T) {
+	p := types.Product{ID: 7, Name: "Mug", Price: types.NewMoney(1250, types.DefaultCurrency), CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)}
+
+	for _, sortBy := range types.ProductSortKeys {
+		c, err := decodeCursor(encodeCursor(NewProductCursor(p, sortBy, true)))
+		if err != nil {
+			t.Fatalf("%s: %v", sortBy, err)
+		}
+		if c.SortBy != sortBy || !c.Desc || c.ID != 7 {
+			t.Errorf("%s: unexpected cursor %+v", sortBy, c)
+		}
+		if _, err := cursorValue(c); err != nil {
+			t.Errorf("%s: %v", sortBy, err)
+		}
+	}
+
+	if _, err := decodeCursor("not a cursor!"); err == nil {
+		t.Error("Expected an invalid cursor to fail")
+	}
+}
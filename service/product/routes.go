@@ -2,35 +2,143 @@ package product
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
 	"github.com/joshbarros/golang-ecommerce-api/types"
 	"github.com/joshbarros/golang-ecommerce-api/utils"
 )
 
 type Handler struct {
-	store types.ProductStore
+	store        types.ProductStore
+	variantStore types.VariantStore
+	txManager    types.TxManager
+	userStore    types.UserStore
+	tokenStore   types.TokenStore
+	apiKeyStore  types.APIKeyStore
+	search       types.SearchIndex
 }
 
-func NewHandler(store types.ProductStore) *Handler {
-	return &Handler{store: store}
+func NewHandler(store types.ProductStore, variantStore types.VariantStore, txManager types.TxManager, userStore types.UserStore, tokenStore types.TokenStore, apiKeyStore types.APIKeyStore, search types.SearchIndex) *Handler {
+	return &Handler{
+		store:        store,
+		variantStore: variantStore,
+		txManager:    txManager,
+		userStore:    userStore,
+		tokenStore:   tokenStore,
+		apiKeyStore:  apiKeyStore,
+		search:       search,
+	}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/products", h.handleGetProduct).Methods(http.MethodGet)
-	router.HandleFunc("/products", h.handleCreateProduct).Methods(http.MethodPost)
+	router.HandleFunc("/products", h.adminOnly(h.handleCreateProduct)).Methods(http.MethodPost)
+	router.HandleFunc("/products/search", h.handleSearchProducts).Methods(http.MethodGet)
+	router.HandleFunc("/products/{id}", h.handleGetProductByID).Methods(http.MethodGet)
+	router.HandleFunc("/products/{id}", h.adminOnly(h.handleUpdateProduct)).Methods(http.MethodPatch)
+	router.HandleFunc("/products/{id}", h.adminOnly(h.handleDeleteProduct)).Methods(http.MethodDelete)
+	router.HandleFunc("/products/{id}/variants", h.adminOnly(h.handleSetVariants)).Methods(http.MethodPut)
+}
+
+// adminOnly guards catalog writes, which integrations may make with a
+// products:write API key owned by an admin.
+func (h *Handler) adminOnly(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return auth.WithJWTOrAPIKeyAuth(auth.RequireRole(handlerFunc, types.RoleAdmin), types.ScopeProductsWrite, h.userStore, h.tokenStore, h.apiKeyStore)
 }
 
+// handleGetProduct lists the catalog a page at a time. Pages are picked by
+// page number or, to page through a changing catalog without skipping or
+// repeating products, with the nextCursor of the previous page. Links to the
+// other pages are also sent in a Link header.
 func (h *Handler) handleGetProduct(w http.ResponseWriter, r *http.Request) {
-	products, err := h.store.GetProducts()
+	page, limit, err := utils.ParsePagination(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	q, err := parseProductQuery(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	fields, err := parseFields(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// One product more than asked for tells whether there is a next page.
+	q.Limit = limit + 1
+	if q.After == nil {
+		q.Offset = (page - 1) * limit
+	}
+
+	products, err := h.store.GetProducts(q)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, products)
+	total, err := h.store.CountProducts(q)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	var nextCursor string
+	if len(products) > limit {
+		products = products[:limit]
+		nextCursor = encodeCursor(NewProductCursor(products[limit-1], q.SortBy, q.Desc))
+	}
+
+	body := map[string]any{
+		"products":   products,
+		"limit":      limit,
+		"total":      total,
+		"nextCursor": nextCursor,
+	}
+
+	if fields != nil {
+		projected, err := project(products, fields)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		body["products"] = projected
+	}
+
+	links := map[string]map[string]string{}
+	if q.After == nil {
+		body["page"] = page
+
+		lastPage := max((total+limit-1)/limit, 1)
+		links["first"] = map[string]string{"page": "1"}
+		links["last"] = map[string]string{"page": strconv.Itoa(lastPage)}
+		if page > 1 {
+			links["prev"] = map[string]string{"page": strconv.Itoa(page - 1)}
+		}
+		if nextCursor != "" {
+			links["next"] = map[string]string{"page": strconv.Itoa(page + 1)}
+		}
+	} else {
+		links["first"] = map[string]string{"cursor": ""}
+		if nextCursor != "" {
+			links["next"] = map[string]string{"cursor": nextCursor}
+		}
+	}
+	w.Header().Set("Link", linkHeader(r.URL, links))
+
+	utils.WriteJSON(w, http.StatusOK, body)
 }
 
 func (h *Handler) handleCreateProduct(w http.ResponseWriter, r *http.Request) {
@@ -42,6 +150,8 @@ func (h *Handler) handleCreateProduct(w http.ResponseWriter, r *http.Request) {
 
 	// Clear ID to prevent conflicts, assuming ID is auto-incremented in the database
 	product.ID = 0
+	// A new product has no stock until it is received through the inventory
+	product.Quantity = 0
 
 	// Validate the product input
 	if err := validateProduct(product); err != nil {
@@ -49,26 +159,265 @@ func (h *Handler) handleCreateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create the product in the store
-	if err := h.store.CreateProduct(&product); err != nil {
+	// Create the product in the store, sold as a single variant until it is
+	// given options
+	err := h.txManager.WithTx(r.Context(), func(stores types.Stores) error {
+		if err := stores.Products.CreateProduct(&product); err != nil {
+			return err
+		}
+
+		return stores.Variants.CreateVariant(&types.ProductVariant{
+			ProductID: product.ID,
+			SKU:       defaultSKU(product.ID),
+		})
+	})
+	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
+	h.indexProduct(product)
+
 	// Return the created product, including the generated ID
 	utils.WriteJSON(w, http.StatusCreated, product)
 }
 
+// handleGetProductByID returns a product with its options and the variants
+// it is sold as.
+func (h *Handler) handleGetProductByID(w http.ResponseWriter, r *http.Request) {
+	product, ok := h.getProduct(w, r)
+	if !ok {
+		return
+	}
+
+	detail, err := h.getProductDetail(*product)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, detail)
+}
+
+// handleUpdateProduct partially updates a product. Its stock is left to the
+// inventory.
+func (h *Handler) handleUpdateProduct(w http.ResponseWriter, r *http.Request) {
+	var payload types.UpdateProductPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	product, ok := h.getProduct(w, r)
+	if !ok {
+		return
+	}
+
+	payload.Apply(product)
+
+	if err := validateProduct(*product); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.UpdateProduct(*product); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.indexProduct(*product)
+
+	utils.WriteJSON(w, http.StatusOK, product)
+}
+
+func (h *Handler) handleDeleteProduct(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid product ID"))
+		return
+	}
+
+	err = h.store.DeleteProduct(productID)
+	if errors.Is(err, types.ErrProductNotFound) {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.search.RemoveProduct(productID); err != nil {
+		log.Printf("Failed to remove product %d from the search index: %v", productID, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSetVariants replaces the options of a product and the variants it is
+// sold as, and returns the product with them.
+func (h *Handler) handleSetVariants(w http.ResponseWriter, r *http.Request) {
+	product, ok := h.getProduct(w, r)
+	if !ok {
+		return
+	}
+
+	var payload types.SetVariantsPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid payload %v", errors))
+		return
+	}
+
+	if err := validateVariants(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err := h.txManager.WithTx(r.Context(), func(stores types.Stores) error {
+		return saveVariants(stores.Variants, product.ID, payload)
+	})
+	if errors.Is(err, ErrSKUTaken) || errors.Is(err, types.ErrVariantNotFound) {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Read the product again for the stock of its new variants.
+	product, err = h.store.GetProductByID(product.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	detail, err := h.getProductDetail(*product)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, detail)
+}
+
+// handleSearchProducts finds products by the words of ?q=, the most relevant
+// first. Results are paged like the listing, with page and limit.
+func (h *Handler) handleSearchProducts(w http.ResponseWriter, r *http.Request) {
+	text := strings.TrimSpace(r.URL.Query().Get("q"))
+	if text == "" {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Missing search query q"))
+		return
+	}
+
+	page, limit, err := utils.ParsePagination(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	matches, err := h.search.SearchProducts(text, limit, (page-1)*limit)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	results := []types.SearchResult{}
+	if len(matches) > 0 {
+		ids := make([]int, len(matches))
+		for i, m := range matches {
+			ids[i] = m.ProductID
+		}
+
+		// The index only knows the text of products: their price and stock
+		// are read from the store.
+		products, err := h.store.GetProductsByID(ids)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		byID := make(map[int]types.Product, len(products))
+		for _, p := range products {
+			byID[p.ID] = p
+		}
+
+		for _, m := range matches {
+			// Products deleted since they were indexed are skipped.
+			if p, ok := byID[m.ProductID]; ok {
+				results = append(results, types.SearchResult{Product: p, Score: m.Score, Highlights: m.Highlights})
+			}
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"query":   text,
+		"page":    page,
+		"limit":   limit,
+		"results": results,
+	})
+}
+
+// getProduct loads the product named in the URL, writing a 404 if it does
+// not exist or was deleted.
+func (h *Handler) getProduct(w http.ResponseWriter, r *http.Request) (*types.Product, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid product ID"))
+		return nil, false
+	}
+
+	product, err := h.store.GetProductByID(id)
+	if errors.Is(err, types.ErrProductNotFound) {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return nil, false
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	return product, true
+}
+
+func (h *Handler) getProductDetail(product types.Product) (*types.ProductDetail, error) {
+	options, err := h.variantStore.GetProductOptions(product.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := h.variantStore.GetVariantsByProductID(product.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.ProductDetail{Product: product, Options: options, Variants: variants}, nil
+}
+
+// indexProduct keeps the search index in sync with a product write. The write
+// has already succeeded, so failures are only logged.
+func (h *Handler) indexProduct(p types.Product) {
+	if err := h.search.IndexProduct(p); err != nil {
+		log.Printf("Failed to index product %d: %v", p.ID, err)
+	}
+}
+
 // validateProduct checks if the product fields are valid
 func validateProduct(product types.Product) error {
 	if product.Name == "" {
 		return fmt.Errorf("Product name is required")
 	}
-	if product.Price <= 0 {
+	if product.Price.Amount <= 0 {
 		return fmt.Errorf("Product price must be greater than zero")
 	}
-	if product.Quantity < 0 {
-		return fmt.Errorf("Product quantity cannot be negative")
+	if product.Price.Currency != types.DefaultCurrency {
+		return fmt.Errorf("Product price must be in %s", types.DefaultCurrency)
 	}
 	return nil
 }
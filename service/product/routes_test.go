@@ -2,13 +2,19 @@ package product
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/service/search"
 	"github.com/joshbarros/golang-ecommerce-api/types"
 	"github.com/joshbarros/golang-ecommerce-api/utils"
 )
@@ -16,10 +22,47 @@ import (
 // Mock implementation of the ProductStore interface
 type mockProductStore struct {
 	products []types.Product
+	deleted  map[int]bool
 }
 
-func (m *mockProductStore) GetProducts() ([]types.Product, error) {
-	return m.products, nil
+// GetProducts pages through the products by ID; the filters are covered by
+// the query builder's tests.
+func (m *mockProductStore) GetProducts(q types.ProductQuery) ([]types.Product, error) {
+	result := []types.Product{}
+	for _, product := range m.products {
+		if m.deleted[product.ID] || (q.InStock && product.Quantity == 0) || (q.After != nil && product.ID <= q.After.ID) {
+			continue
+		}
+		result = append(result, product)
+	}
+
+	result = result[min(q.Offset, len(result)):]
+	if q.Limit > 0 && len(result) > q.Limit {
+		result = result[:q.Limit]
+	}
+	return result, nil
+}
+
+func (m *mockProductStore) CountProducts(q types.ProductQuery) (int, error) {
+	products, err := m.GetProducts(types.ProductQuery{InStock: q.InStock})
+	return len(products), err
+}
+
+func (m *mockProductStore) GetProductByID(id int) (*types.Product, error) {
+	for _, product := range m.products {
+		if product.ID == id && !m.deleted[id] {
+			return &product, nil
+		}
+	}
+	return nil, types.ErrProductNotFound
+}
+
+func (m *mockProductStore) DeleteProduct(id int) error {
+	if _, err := m.GetProductByID(id); err != nil {
+		return err
+	}
+	m.deleted[id] = true
+	return nil
 }
 
 func (m *mockProductStore) CreateProduct(product *types.Product) error {
@@ -58,14 +101,107 @@ func (m *mockProductStore) FailCreateProduct(product *types.Product) error {
 	return errors.New("failed to create product")
 }
 
+// Mock implementation of the VariantStore interface
+type mockVariantStore struct {
+	options  map[int][]types.ProductOption
+	variants []types.ProductVariant
+	deleted  map[int]bool
+}
+
+func newMockVariantStore() *mockVariantStore {
+	return &mockVariantStore{options: map[int][]types.ProductOption{}, deleted: map[int]bool{}}
+}
+
+func (m *mockVariantStore) GetProductOptions(productID int) ([]types.ProductOption, error) {
+	return append([]types.ProductOption{}, m.options[productID]...), nil
+}
+
+func (m *mockVariantStore) SetProductOptions(productID int, options []types.ProductOption) error {
+	m.options[productID] = options
+	return nil
+}
+
+func (m *mockVariantStore) GetVariantsByProductID(productID int) ([]types.ProductVariant, error) {
+	result := []types.ProductVariant{}
+	for _, v := range m.variants {
+		if v.ProductID == productID && !m.deleted[v.ID] {
+			result = append(result, v)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockVariantStore) GetVariantsByID(ids []int) ([]types.ProductVariant, error) {
+	result := []types.ProductVariant{}
+	for _, v := range m.variants {
+		if slices.Contains(ids, v.ID) && !m.deleted[v.ID] {
+			result = append(result, v)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockVariantStore) GetVariantBySKU(sku string) (*types.ProductVariant, error) {
+	for _, v := range m.variants {
+		if v.SKU == sku {
+			return &v, nil
+		}
+	}
+	return nil, types.ErrVariantNotFound
+}
+
+func (m *mockVariantStore) CreateVariant(variant *types.ProductVariant) error {
+	variant.ID = len(m.variants) + 1
+	m.variants = append(m.variants, *variant)
+	return nil
+}
+
+func (m *mockVariantStore) UpdateVariant(variant types.ProductVariant) error {
+	for i, v := range m.variants {
+		if v.ID == variant.ID {
+			m.variants[i] = variant
+			return nil
+		}
+	}
+	return types.ErrVariantNotFound
+}
+
+func (m *mockVariantStore) DeleteVariant(id int) error {
+	m.deleted[id] = true
+	return nil
+}
+
+// Mock TxManager running the unit of work directly against the mock stores
+type mockTxManager struct {
+	products *mockProductStore
+	variants *mockVariantStore
+}
+
+func (m *mockTxManager) WithTx(ctx context.Context, fn func(types.Stores) error) error {
+	return fn(types.Stores{Products: m.products, Variants: m.variants})
+}
+
+// newTestHandler serves the products of the store, each sold as the variant
+// with the same ID.
+func newTestHandler(productStore *mockProductStore) (*Handler, *mockVariantStore) {
+	variantStore := newMockVariantStore()
+	for _, p := range productStore.products {
+		variantStore.CreateVariant(&types.ProductVariant{ProductID: p.ID, SKU: defaultSKU(p.ID), Quantity: p.Quantity})
+	}
+
+	txManager := &mockTxManager{products: productStore, variants: variantStore}
+	return NewHandler(productStore, variantStore, txManager, nil, nil, nil, search.NewMemoryIndex()), variantStore
+}
+
 func TestProductServiceHandlers(t *testing.T) {
 	productStore := &mockProductStore{
 		products: []types.Product{
-			{ID: 1, Name: "Test Product 1", Price: 9.99, Quantity: 10},
-			{ID: 2, Name: "Test Product 2", Price: 19.99, Quantity: 20},
+			{ID: 1, Name: "Test Product 1", Price: types.NewMoney(999, types.DefaultCurrency), Quantity: 10},
+			{ID: 2, Name: "Test Product 2", Price: types.NewMoney(1999, types.DefaultCurrency), Quantity: 20},
 		},
+		deleted: map[int]bool{},
 	}
-	handler := NewHandler(productStore)
+	handler, variantStore := newTestHandler(productStore)
 
 	t.Run("Should get all products", func(t *testing.T) {
 		req, err := http.NewRequest(http.MethodGet, "/products", nil)
@@ -82,21 +218,22 @@ func TestProductServiceHandlers(t *testing.T) {
 			t.Errorf("Expected status code %d, got %d", http.StatusOK, rr.Code)
 		}
 
-		var products []types.Product
-		if err := json.NewDecoder(rr.Body).Decode(&products); err != nil {
+		var page struct {
+			Products []types.Product `json:"products"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
 			t.Fatal("Failed to decode JSON response")
 		}
 
-		if len(products) != 2 {
-			t.Errorf("Expected 2 products, got %d", len(products))
+		if len(page.Products) != 2 {
+			t.Errorf("Expected 2 products, got %d", len(page.Products))
 		}
 	})
 
 	t.Run("Should create a product successfully", func(t *testing.T) {
 		payload := types.Product{
-			Name:     "New Product",
-			Price:    29.99,
-			Quantity: 15,
+			Name:  "New Product",
+			Price: types.NewMoney(2999, types.DefaultCurrency),
 		}
 		marshalled, _ := json.Marshal(payload)
 
@@ -126,12 +263,44 @@ func TestProductServiceHandlers(t *testing.T) {
 		if createdProduct.Name != "New Product" {
 			t.Errorf("Expected product name to be 'New Product', got '%s'", createdProduct.Name)
 		}
+
+		variants, _ := variantStore.GetVariantsByProductID(createdProduct.ID)
+		if len(variants) != 1 || variants[0].SKU != defaultSKU(createdProduct.ID) {
+			t.Errorf("Expected a single variant, got %+v", variants)
+		}
+	})
+
+	t.Run("Should leave setting the stock to the inventory", func(t *testing.T) {
+		rr := serve(t, http.MethodPost, "/products", handler.handleCreateProduct, types.Product{
+			Name:     "Stocked Product",
+			Price:    types.NewMoney(2999, types.DefaultCurrency),
+			Quantity: 15,
+		})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+		}
+
+		var created types.Product
+		json.NewDecoder(rr.Body).Decode(&created)
+		if created.Quantity != 0 {
+			t.Errorf("Expected the product to start without stock, got %d", created.Quantity)
+		}
+
+		rr = serve(t, http.MethodPatch, "/products/2", handler.handleUpdateProduct, map[string]any{"quantity": 5})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+
+		product, _ := productStore.GetProductByID(2)
+		if product.Quantity != 20 {
+			t.Errorf("Expected the quantity to be kept, got %d", product.Quantity)
+		}
 	})
 
 	t.Run("Should fail to create a product with invalid data", func(t *testing.T) {
 		payload := types.Product{
 			Name:     "",
-			Price:    0,
+			Price:    types.NewMoney(0, types.DefaultCurrency),
 			Quantity: -1,
 		}
 		marshalled, _ := json.Marshal(payload)
@@ -188,7 +357,7 @@ func TestProductServiceHandlers(t *testing.T) {
 		payload := types.Product{
 			ID:       1,
 			Name:     "Updated Product",
-			Price:    29.99,
+			Price:    types.NewMoney(2999, types.DefaultCurrency),
 			Quantity: 15,
 		}
 		marshalled, _ := json.Marshal(payload)
@@ -227,4 +396,426 @@ func TestProductServiceHandlers(t *testing.T) {
 			t.Errorf("Expected product name to be 'Updated Product', got '%s'", updatedProduct.Name)
 		}
 	})
+
+	t.Run("Should get a product by ID", func(t *testing.T) {
+		rr := serve(t, http.MethodGet, "/products/2", handler.handleGetProductByID, nil)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var product types.Product
+		if err := json.NewDecoder(rr.Body).Decode(&product); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+
+		if product.ID != 2 || product.Name != "Test Product 2" {
+			t.Errorf("Unexpected product %+v", product)
+		}
+	})
+
+	t.Run("Should fail to get a product that does not exist", func(t *testing.T) {
+		rr := serve(t, http.MethodGet, "/products/42", handler.handleGetProductByID, nil)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("Should partially update a product", func(t *testing.T) {
+		rr := serve(t, http.MethodPatch, "/products/2", handler.handleUpdateProduct, map[string]any{
+			"price": map[string]any{"amount": 2499, "currency": "USD"},
+		})
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+
+		product, _ := productStore.GetProductByID(2)
+		if product.Name != "Test Product 2" {
+			t.Errorf("Expected the name to be kept, got '%s'", product.Name)
+		}
+		if product.Price != types.NewMoney(2499, types.DefaultCurrency) || product.Quantity != 20 {
+			t.Errorf("Expected the price to be updated and the quantity kept, got %s and %d", product.Price, product.Quantity)
+		}
+	})
+
+	t.Run("Should fail to update a product with invalid data", func(t *testing.T) {
+		rr := serve(t, http.MethodPatch, "/products/2", handler.handleUpdateProduct, map[string]any{"name": ""})
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+
+		product, _ := productStore.GetProductByID(2)
+		if product.Name != "Test Product 2" {
+			t.Errorf("Expected the product to be left untouched, got '%s'", product.Name)
+		}
+	})
+
+	t.Run("Should soft delete a product", func(t *testing.T) {
+		rr := serve(t, http.MethodDelete, "/products/2", handler.handleDeleteProduct, nil)
+
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+
+		for _, tc := range []struct {
+			method  string
+			handler http.HandlerFunc
+		}{
+			{http.MethodGet, handler.handleGetProductByID},
+			{http.MethodPatch, handler.handleUpdateProduct},
+			{http.MethodDelete, handler.handleDeleteProduct},
+		} {
+			rr := serve(t, tc.method, "/products/2", tc.handler, map[string]any{"name": "Revived"})
+			if rr.Code != http.StatusNotFound {
+				t.Errorf("%s: expected status code %d, got %d", tc.method, http.StatusNotFound, rr.Code)
+			}
+		}
+
+		products, _ := productStore.GetProducts(types.ProductQuery{})
+		for _, p := range products {
+			if p.ID == 2 {
+				t.Error("Expected the deleted product to be left out of the listing")
+			}
+		}
+	})
+}
+
+func TestProductListing(t *testing.T) {
+	productStore := &mockProductStore{deleted: map[int]bool{}}
+	for i := 1; i <= 5; i++ {
+		productStore.products = append(productStore.products, types.Product{ID: i, Name: fmt.Sprintf("Product %d", i), Quantity: i % 2})
+	}
+	handler, _ := newTestHandler(productStore)
+
+	type page struct {
+		Products   []map[string]any `json:"products"`
+		Page       int              `json:"page"`
+		Limit      int              `json:"limit"`
+		Total      int              `json:"total"`
+		NextCursor string           `json:"nextCursor"`
+	}
+
+	get := func(t *testing.T, path string) (page, *httptest.ResponseRecorder) {
+		t.Helper()
+
+		rr := serve(t, http.MethodGet, path, handler.handleGetProduct, nil)
+		var body page
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+				t.Fatal("Failed to decode JSON response")
+			}
+		}
+		return body, rr
+	}
+
+	t.Run("Should page by page number with links", func(t *testing.T) {
+		body, rr := get(t, "/products?page=2&limit=2")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if len(body.Products) != 2 || body.Products[0]["id"] != float64(3) {
+			t.Errorf("Expected products 3 and 4, got %v", body.Products)
+		}
+		if body.Page != 2 || body.Limit != 2 || body.Total != 5 || body.NextCursor == "" {
+			t.Errorf("Unexpected metadata %+v", body)
+		}
+
+		link := rr.Header().Get("Link")
+		for _, expected := range []string{
+			`</products?limit=2&page=1>; rel="first"`,
+			`</products?limit=2&page=1>; rel="prev"`,
+			`</products?limit=2&page=3>; rel="next"`,
+			`</products?limit=2&page=3>; rel="last"`,
+		} {
+			if !strings.Contains(link, expected) {
+				t.Errorf("Expected the Link header to contain %s, got %s", expected, link)
+			}
+		}
+	})
+
+	t.Run("Should page by cursor", func(t *testing.T) {
+		var ids []float64
+		path := "/products?limit=2"
+		for range 3 {
+			body, rr := get(t, path)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+			}
+			for _, p := range body.Products {
+				ids = append(ids, p["id"].(float64))
+			}
+			if body.NextCursor == "" {
+				break
+			}
+			path = "/products?limit=2&cursor=" + body.NextCursor
+		}
+
+		if !reflect.DeepEqual(ids, []float64{1, 2, 3, 4, 5}) {
+			t.Errorf("Expected every product once, got %v", ids)
+		}
+	})
+
+	t.Run("Should filter and project the listing", func(t *testing.T) {
+		body, rr := get(t, "/products?inStock=true&fields=id,name")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if len(body.Products) != 3 || body.Total != 3 {
+			t.Errorf("Expected the 3 products in stock, got %v", body.Products)
+		}
+		for _, p := range body.Products {
+			if len(p) != 2 || p["name"] == nil {
+				t.Errorf("Expected only the id and name, got %v", p)
+			}
+		}
+	})
+
+	t.Run("Should fail with invalid parameters", func(t *testing.T) {
+		cursor := encodeCursor(&types.ProductCursor{SortBy: "id", ID: 2})
+
+		for _, path := range []string{
+			"/products?minPrice=cheap",
+			"/products?maxPrice=-1",
+			"/products?inStock=maybe",
+			"/products?createdSince=yesterday",
+			"/products?category=kitchen",
+			"/products?sort=quantity",
+			"/products?fields=id,secret",
+			"/products?cursor=garbage",
+			"/products?sort=-id&cursor=" + cursor,
+			"/products?page=2&cursor=" + cursor,
+		} {
+			if _, rr := get(t, path); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status code %d, got %d", path, http.StatusBadRequest, rr.Code)
+			}
+		}
+	})
+}
+
+func TestSearchProducts(t *testing.T) {
+	productStore := &mockProductStore{deleted: map[int]bool{}}
+	handler, _ := newTestHandler(productStore)
+
+	for _, p := range []types.Product{
+		{Name: "Ceramic Mug", Description: "Holds coffee.", Price: types.NewMoney(1200, types.DefaultCurrency)},
+		{Name: "Travel Mug", Description: "Steel, with a lid.", Price: types.NewMoney(1800, types.DefaultCurrency)},
+		{Name: "Electric Kettle", Description: "Boils water.", Price: types.NewMoney(4500, types.DefaultCurrency)},
+	} {
+		if rr := serve(t, http.MethodPost, "/products", handler.handleCreateProduct, p); rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+	}
+
+	find := func(t *testing.T, path string) []types.SearchResult {
+		t.Helper()
+
+		rr := serve(t, http.MethodGet, path, handler.handleSearchProducts, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+
+		var body struct {
+			Results []types.SearchResult `json:"results"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+		return body.Results
+	}
+
+	t.Run("Should find products with highlights", func(t *testing.T) {
+		results := find(t, "/products/search?q=kettel")
+		if len(results) != 1 || results[0].Product.ID != 3 || results[0].Product.Price.Amount != 4500 {
+			t.Fatalf("Expected the kettle, got %+v", results)
+		}
+		if h := results[0].Highlights["name"]; h != "Electric <mark>Kettle</mark>" {
+			t.Errorf("Unexpected highlight %q", h)
+		}
+	})
+
+	t.Run("Should keep the index in sync with writes", func(t *testing.T) {
+		if rr := serve(t, http.MethodPatch, "/products/1", handler.handleUpdateProduct, map[string]any{"name": "Ceramic Teapot"}); rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if rr := serve(t, http.MethodDelete, "/products/2", handler.handleDeleteProduct, nil); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+
+		if results := find(t, "/products/search?q=mug"); len(results) != 0 {
+			t.Errorf("Expected no mugs left, got %+v", results)
+		}
+		if results := find(t, "/products/search?q=tea"); len(results) != 1 || results[0].Product.ID != 1 {
+			t.Errorf("Expected the renamed product, got %+v", results)
+		}
+	})
+
+	t.Run("Should fail without a query", func(t *testing.T) {
+		if rr := serve(t, http.MethodGet, "/products/search?q=+", handler.handleSearchProducts, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+}
+
+func TestProductVariants(t *testing.T) {
+	productStore := &mockProductStore{
+		products: []types.Product{
+			{ID: 1, Name: "Shirt", Price: types.NewMoney(1999, types.DefaultCurrency), Quantity: 6},
+			{ID: 2, Name: "Mug", Price: types.NewMoney(999, types.DefaultCurrency), Quantity: 3},
+		},
+		deleted: map[int]bool{},
+	}
+	handler, variantStore := newTestHandler(productStore)
+
+	medium := types.NewMoney(2199, types.DefaultCurrency)
+	sizes := types.SetVariantsPayload{
+		Options: []types.ProductOptionPayload{
+			{Name: "Size", Values: []string{"S", "M"}},
+			{Name: "Colour", Values: []string{"Blue"}},
+		},
+		Variants: []types.ProductVariantPayload{
+			{ID: 1, SKU: "SHIRT-S", Options: map[string]string{"Size": "S", "Colour": "Blue"}},
+			{SKU: "SHIRT-M", Price: &medium, Options: map[string]string{"Size": "M", "Colour": "Blue"}},
+		},
+	}
+
+	t.Run("Should replace the options and variants", func(t *testing.T) {
+		rr := serve(t, http.MethodPut, "/products/1/variants", handler.handleSetVariants, sizes)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+
+		var detail types.ProductDetail
+		if err := json.NewDecoder(rr.Body).Decode(&detail); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+
+		if len(detail.Options) != 2 || detail.Options[0].Name != "Size" || detail.Options[1].Position != 1 {
+			t.Errorf("Expected size then colour, got %+v", detail.Options)
+		}
+		if len(detail.Variants) != 2 || detail.Variants[0].ID != 1 || detail.Variants[0].SKU != "SHIRT-S" {
+			t.Fatalf("Expected the first variant to be kept as SHIRT-S, got %+v", detail.Variants)
+		}
+		if p := detail.Variants[1].UnitPrice(detail.Product); p != medium {
+			t.Errorf("Expected SHIRT-M to cost %s, got %s", medium, p)
+		}
+	})
+
+	t.Run("Should reject invalid variants", func(t *testing.T) {
+		zero := types.NewMoney(0, types.DefaultCurrency)
+		blueM := map[string]string{"Size": "M", "Colour": "Blue"}
+
+		for name, variants := range map[string][]types.ProductVariantPayload{
+			"unknown value":         {{SKU: "SHIRT-XL", Options: map[string]string{"Size": "XL", "Colour": "Blue"}}},
+			"missing option":        {{SKU: "SHIRT-M", Options: map[string]string{"Size": "M"}}},
+			"repeated options":      {{SKU: "SHIRT-M", Options: blueM}, {SKU: "SHIRT-M2", Options: blueM}},
+			"repeated SKU":          {{SKU: "SHIRT-M", Options: blueM}, {SKU: "SHIRT-M", Options: map[string]string{"Size": "S", "Colour": "Blue"}}},
+			"SKU of another":        {{SKU: "P2", Options: blueM}},
+			"variant of another":    {{ID: 2, SKU: "SHIRT-M", Options: blueM}},
+			"zero price":            {{SKU: "SHIRT-M", Price: &zero, Options: blueM}},
+			"no variants":           {},
+			"missing SKU":           {{Options: blueM}},
+			"options of no options": {{SKU: "SHIRT-M", Options: map[string]string{"Size": "M", "Colour": "Blue", "Fit": "Slim"}}},
+		} {
+			payload := types.SetVariantsPayload{Options: sizes.Options, Variants: variants}
+			if rr := serve(t, http.MethodPut, "/products/1/variants", handler.handleSetVariants, payload); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status code %d, got %d", name, http.StatusBadRequest, rr.Code)
+			}
+		}
+
+		if variants, _ := variantStore.GetVariantsByProductID(1); len(variants) != 2 {
+			t.Errorf("Expected the variants to be left untouched, got %+v", variants)
+		}
+	})
+
+	t.Run("Should delete variants left out and keep their SKU", func(t *testing.T) {
+		rr := serve(t, http.MethodPut, "/products/1/variants", handler.handleSetVariants, types.SetVariantsPayload{
+			Variants: []types.ProductVariantPayload{{ID: 1, SKU: "SHIRT"}},
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+
+		rr = serve(t, http.MethodGet, "/products/1", handler.handleGetProductByID, nil)
+		var detail types.ProductDetail
+		if err := json.NewDecoder(rr.Body).Decode(&detail); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+		if len(detail.Options) != 0 || len(detail.Variants) != 1 || detail.Variants[0].SKU != "SHIRT" {
+			t.Errorf("Expected a single variant without options, got %+v", detail)
+		}
+
+		rr = serve(t, http.MethodPut, "/products/1/variants", handler.handleSetVariants, types.SetVariantsPayload{
+			Variants: []types.ProductVariantPayload{{ID: 1, SKU: "SHIRT-M"}},
+		})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected the deleted variant's SKU to stay taken, got status code %d", rr.Code)
+		}
+	})
+
+	t.Run("Should fail for a product that does not exist", func(t *testing.T) {
+		if rr := serve(t, http.MethodPut, "/products/42/variants", handler.handleSetVariants, sizes); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
+func TestProductWritesRequireAuthentication(t *testing.T) {
+	productStore := &mockProductStore{deleted: map[int]bool{}}
+	router := mux.NewRouter()
+	handler, _ := newTestHandler(productStore)
+	handler.RegisterRoutes(router)
+
+	for _, tc := range []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/products"},
+		{http.MethodPatch, "/products/1"},
+		{http.MethodDelete, "/products/1"},
+		{http.MethodPut, "/products/1/variants"},
+	} {
+		req, err := http.NewRequest(tc.method, tc.path, bytes.NewBufferString(`{"name":"Sneaky"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected status code %d, got %d", tc.method, tc.path, http.StatusForbidden, rr.Code)
+		}
+	}
+
+	if len(productStore.products) != 0 {
+		t.Error("Expected no product to be created")
+	}
+}
+
+func serve(t *testing.T, method string, path string, handler http.HandlerFunc, payload any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	if payload != nil {
+		json.NewEncoder(&body).Encode(payload)
+	}
+
+	req, err := http.NewRequest(method, path, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/products", handler)
+	router.HandleFunc("/products/{id}", handler)
+	router.HandleFunc("/products/{id}/variants", handler)
+	router.ServeHTTP(rr, req)
+
+	return rr
 }
@@ -2,23 +2,40 @@ package product
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"strings"
 
+	"github.com/joshbarros/golang-ecommerce-api/db"
 	"github.com/joshbarros/golang-ecommerce-api/types"
 )
 
 type Store struct {
-	db *sql.DB
+	db db.DBTX
 }
 
-func NewStore(db *sql.DB) *Store {
+func NewStore(db db.DBTX) *Store {
 	return &Store{db: db}
 }
 
+// productQuantity is the stock of a product: the sum of the stock movements
+// of its variants.
+const productQuantity = "(SELECT COALESCE(SUM(m.quantity), 0) FROM stock_movements m " +
+	"JOIN product_variants v ON v.id = m.variantId WHERE v.productId = products.id AND v.deletedAt IS NULL)"
+
+// productColumns lists the columns read by scanRowsIntoProduct, in order.
+const productColumns = "id, name, description, image, price, " + productQuantity + " AS quantity, createdAt"
+
+// variantColumns lists the columns read by scanVariant, in order. The
+// quantity of a variant is the sum of its stock movements.
+const variantColumns = "id, productId, sku, price, " +
+	"(SELECT COALESCE(SUM(m.quantity), 0) FROM stock_movements m WHERE m.variantId = product_variants.id) AS quantity, image, options, createdAt"
+
+// GetProductsByID returns the products with the given IDs. Deleted products are
+// left out, so they can no longer be bought.
 func (s *Store) GetProductsByID(productIDs []int) ([]types.Product, error) {
 	placeholders := strings.Repeat(",?", len(productIDs)-1)
-	query := fmt.Sprintf("SELECT * FROM products WHERE id IN (?%s)", placeholders)
+	query := fmt.Sprintf("SELECT %s FROM products WHERE id IN (?%s) AND deletedAt IS NULL", productColumns, placeholders)
 
 	args := make([]interface{}, len(productIDs))
 	for i, v := range productIDs {
@@ -29,6 +46,7 @@ func (s *Store) GetProductsByID(productIDs []int) ([]types.Product, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	products := []types.Product{}
 	for rows.Next() {
@@ -43,11 +61,18 @@ func (s *Store) GetProductsByID(productIDs []int) ([]types.Product, error) {
 	return products, nil
 }
 
-func (s *Store) GetProducts() ([]types.Product, error) {
-	rows, err := s.db.Query("SELECT * FROM products")
+// GetProducts returns the page of the catalog selected by q.
+func (s *Store) GetProducts(q types.ProductQuery) ([]types.Product, error) {
+	query, args, err := buildProductQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	products := make([]types.Product, 0)
 	for rows.Next() {
@@ -59,12 +84,38 @@ func (s *Store) GetProducts() ([]types.Product, error) {
 		products = append(products, *p)
 	}
 
-	return products, nil
+	return products, rows.Err()
+}
+
+// CountProducts counts the products matching q's filters, ignoring its page.
+func (s *Store) CountProducts(q types.ProductQuery) (int, error) {
+	query, args := buildProductCountQuery(q)
+
+	var count int
+	err := s.db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
+func (s *Store) GetProductByID(id int) (*types.Product, error) {
+	rows, err := s.db.Query("SELECT "+productColumns+" FROM products WHERE id = ? AND deletedAt IS NULL", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, types.ErrProductNotFound
+	}
+
+	return scanRowsIntoProduct(rows)
 }
 
 func (s *Store) CreateProduct(product *types.Product) error {
-	query := "INSERT INTO products (name, description, image, price, quantity) VALUES (?, ?, ?, ?, ?)"
-	result, err := s.db.Exec(query, product.Name, product.Description, product.Image, product.Price, product.Quantity)
+	query := "INSERT INTO products (name, description, image, price) VALUES (?, ?, ?, ?)"
+	result, err := s.db.Exec(query, product.Name, product.Description, product.Image, product.Price)
 	if err != nil {
 		return err
 	}
@@ -80,8 +131,8 @@ func (s *Store) CreateProduct(product *types.Product) error {
 
 func (s *Store) UpdateProduct(product types.Product) error {
 	_, err := s.db.Exec(
-		"UPDATE products SET name = ?, price = ?, image = ?, description = ?, quantity = ? WHERE id = ?",
-		product.Name, product.Price, product.Image, product.Description, product.Quantity, product.ID,
+		"UPDATE products SET name = ?, price = ?, image = ?, description = ? WHERE id = ? AND deletedAt IS NULL",
+		product.Name, product.Price, product.Image, product.Description, product.ID,
 	)
 	if err != nil {
 		return err
@@ -90,6 +141,188 @@ func (s *Store) UpdateProduct(product types.Product) error {
 	return nil
 }
 
+// DeleteProduct soft deletes a product. The row is kept so order items keep
+// pointing at it, but it no longer shows up in the catalog.
+func (s *Store) DeleteProduct(id int) error {
+	res, err := s.db.Exec("UPDATE products SET deletedAt = CURRENT_TIMESTAMP WHERE id = ? AND deletedAt IS NULL", id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return types.ErrProductNotFound
+	}
+
+	return nil
+}
+
+// GetProductOptions returns the options of a product in display order.
+func (s *Store) GetProductOptions(productID int) ([]types.ProductOption, error) {
+	rows, err := s.db.Query(
+		"SELECT id, productId, name, optionValues, position FROM product_options WHERE productId = ? ORDER BY position, id",
+		productID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	options := make([]types.ProductOption, 0)
+	for rows.Next() {
+		var o types.ProductOption
+		var values []byte
+		if err := rows.Scan(&o.ID, &o.ProductID, &o.Name, &values, &o.Position); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(values, &o.Values); err != nil {
+			return nil, err
+		}
+
+		options = append(options, o)
+	}
+
+	return options, rows.Err()
+}
+
+// SetProductOptions replaces the options of a product, keeping the order they
+// are given in. It should run in a transaction.
+func (s *Store) SetProductOptions(productID int, options []types.ProductOption) error {
+	if _, err := s.db.Exec("DELETE FROM product_options WHERE productId = ?", productID); err != nil {
+		return err
+	}
+
+	for i, o := range options {
+		values, err := json.Marshal(o.Values)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.db.Exec(
+			"INSERT INTO product_options (productId, name, optionValues, position) VALUES (?, ?, ?, ?)",
+			productID, o.Name, values, i,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetVariantsByProductID returns the variants of a product that are for sale.
+func (s *Store) GetVariantsByProductID(productID int) ([]types.ProductVariant, error) {
+	rows, err := s.db.Query("SELECT "+variantColumns+" FROM product_variants WHERE productId = ? AND deletedAt IS NULL ORDER BY id", productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRowsIntoVariants(rows)
+}
+
+// GetVariantsByID returns the variants with the given IDs, leaving out deleted
+// ones like GetProductsByID.
+func (s *Store) GetVariantsByID(ids []int) ([]types.ProductVariant, error) {
+	if len(ids) == 0 {
+		return []types.ProductVariant{}, nil
+	}
+
+	placeholders := strings.Repeat(",?", len(ids)-1)
+	query := fmt.Sprintf("SELECT %s FROM product_variants WHERE id IN (?%s) AND deletedAt IS NULL", variantColumns, placeholders)
+
+	args := make([]interface{}, len(ids))
+	for i, v := range ids {
+		args[i] = v
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRowsIntoVariants(rows)
+}
+
+func (s *Store) GetVariantBySKU(sku string) (*types.ProductVariant, error) {
+	rows, err := s.db.Query("SELECT "+variantColumns+" FROM product_variants WHERE sku = ?", sku)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, types.ErrVariantNotFound
+	}
+
+	return scanVariant(rows)
+}
+
+func (s *Store) CreateVariant(v *types.ProductVariant) error {
+	options, err := json.Marshal(variantOptions(*v))
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.Exec(
+		"INSERT INTO product_variants (productId, sku, price, image, options) VALUES (?, ?, ?, ?, ?)",
+		v.ProductID, v.SKU, v.Price, v.Image, options,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	v.ID = int(id)
+	return nil
+}
+
+func (s *Store) UpdateVariant(v types.ProductVariant) error {
+	options, err := json.Marshal(variantOptions(v))
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(
+		"UPDATE product_variants SET sku = ?, price = ?, image = ?, options = ? WHERE id = ? AND deletedAt IS NULL",
+		v.SKU, v.Price, v.Image, options, v.ID,
+	)
+	return err
+}
+
+// DeleteVariant soft deletes a variant, like DeleteProduct, so order items
+// keep pointing at it.
+func (s *Store) DeleteVariant(id int) error {
+	res, err := s.db.Exec("UPDATE product_variants SET deletedAt = CURRENT_TIMESTAMP WHERE id = ? AND deletedAt IS NULL", id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return types.ErrVariantNotFound
+	}
+
+	return nil
+}
+
 func scanRowsIntoProduct(rows *sql.Rows) (*types.Product, error) {
 	product := new(types.Product)
 
@@ -109,3 +342,46 @@ func scanRowsIntoProduct(rows *sql.Rows) (*types.Product, error) {
 
 	return product, nil
 }
+
+func scanRowsIntoVariants(rows *sql.Rows) ([]types.ProductVariant, error) {
+	variants := make([]types.ProductVariant, 0)
+	for rows.Next() {
+		v, err := scanVariant(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		variants = append(variants, *v)
+	}
+
+	return variants, rows.Err()
+}
+
+func scanVariant(rows *sql.Rows) (*types.ProductVariant, error) {
+	v := new(types.ProductVariant)
+
+	var price sql.Null[types.Money]
+	var options []byte
+	err := rows.Scan(&v.ID, &v.ProductID, &v.SKU, &price, &v.Quantity, &v.Image, &options, &v.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if price.Valid {
+		v.Price = &price.V
+	}
+
+	if err := json.Unmarshal(options, &v.Options); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// variantOptions never stores a variant's options as JSON null.
+func variantOptions(v types.ProductVariant) map[string]string {
+	if v.Options == nil {
+		return map[string]string{}
+	}
+	return v.Options
+}
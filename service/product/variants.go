@@ -0,0 +1,157 @@
+package product
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+var ErrSKUTaken = errors.New("SKU is already taken")
+
+// defaultSKU is the SKU of the variant a product is created with, the same
+// one the variants migration gave existing products.
+func defaultSKU(productID int) string {
+	return fmt.Sprintf("P%d", productID)
+}
+
+// validateVariants checks that the options have distinct names and values,
+// and that each variant has a distinct SKU and a distinct combination of
+// option values, one for every option.
+func validateVariants(payload types.SetVariantsPayload) error {
+	values := map[string][]string{}
+	for _, o := range payload.Options {
+		if _, ok := values[o.Name]; ok {
+			return fmt.Errorf("Option %s is listed twice", o.Name)
+		}
+
+		sorted := slices.Clone(o.Values)
+		slices.Sort(sorted)
+		if len(slices.Compact(sorted)) != len(o.Values) {
+			return fmt.Errorf("Option %s has a value listed twice", o.Name)
+		}
+
+		values[o.Name] = o.Values
+	}
+
+	ids := map[int]bool{}
+	skus := map[string]bool{}
+	combinations := map[string]bool{}
+	for _, v := range payload.Variants {
+		if v.ID != 0 {
+			if ids[v.ID] {
+				return fmt.Errorf("Variant %d is listed twice", v.ID)
+			}
+			ids[v.ID] = true
+		}
+
+		if skus[v.SKU] {
+			return fmt.Errorf("SKU %s is used by two variants", v.SKU)
+		}
+		skus[v.SKU] = true
+
+		if v.Price != nil && v.Price.Amount <= 0 {
+			return fmt.Errorf("Variant %s price must be greater than zero", v.SKU)
+		}
+		if v.Price != nil && v.Price.Currency != types.DefaultCurrency {
+			return fmt.Errorf("Variant %s price must be in %s", v.SKU, types.DefaultCurrency)
+		}
+
+		if len(v.Options) != len(values) {
+			return fmt.Errorf("Variant %s must have one value for each option", v.SKU)
+		}
+
+		combination := make([]string, 0, len(payload.Options))
+		for _, o := range payload.Options {
+			value, ok := v.Options[o.Name]
+			if !ok {
+				return fmt.Errorf("Variant %s is missing option %s", v.SKU, o.Name)
+			}
+			if !slices.Contains(o.Values, value) {
+				return fmt.Errorf("Variant %s has unknown %s %s", v.SKU, o.Name, value)
+			}
+			combination = append(combination, value)
+		}
+
+		key := strings.Join(combination, "\x00")
+		if combinations[key] {
+			return fmt.Errorf("Variant %s has the same options as another variant", v.SKU)
+		}
+		combinations[key] = true
+	}
+
+	return nil
+}
+
+// saveVariants replaces the options and variants of a product with those of a
+// validated payload. Existing variants left out of the payload are deleted.
+// It should run in a transaction.
+func saveVariants(store types.VariantStore, productID int, payload types.SetVariantsPayload) error {
+	existing, err := store.GetVariantsByProductID(productID)
+	if err != nil {
+		return err
+	}
+
+	kept := map[int]bool{}
+	for _, v := range payload.Variants {
+		if v.ID == 0 {
+			continue
+		}
+		if !slices.ContainsFunc(existing, func(e types.ProductVariant) bool { return e.ID == v.ID }) {
+			return fmt.Errorf("%w: %d", types.ErrVariantNotFound, v.ID)
+		}
+		kept[v.ID] = true
+	}
+
+	// SKUs of deleted variants stay taken, so they keep identifying the
+	// variants of past orders.
+	for _, v := range payload.Variants {
+		taken, err := store.GetVariantBySKU(v.SKU)
+		if err == nil && taken.ID != v.ID {
+			return fmt.Errorf("%w: %s", ErrSKUTaken, v.SKU)
+		}
+		if err != nil && !errors.Is(err, types.ErrVariantNotFound) {
+			return err
+		}
+	}
+
+	options := make([]types.ProductOption, len(payload.Options))
+	for i, o := range payload.Options {
+		options[i] = types.ProductOption{ProductID: productID, Name: o.Name, Values: o.Values, Position: i}
+	}
+	if err := store.SetProductOptions(productID, options); err != nil {
+		return err
+	}
+
+	for _, v := range existing {
+		if !kept[v.ID] {
+			if err := store.DeleteVariant(v.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, v := range payload.Variants {
+		variant := types.ProductVariant{
+			ID:        v.ID,
+			ProductID: productID,
+			SKU:       v.SKU,
+			Price:     v.Price,
+			Image:     v.Image,
+			Options:   v.Options,
+		}
+
+		if v.ID == 0 {
+			err = store.CreateVariant(&variant)
+		} else {
+			err = store.UpdateVariant(variant)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
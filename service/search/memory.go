@@ -0,0 +1,140 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// MemoryIndex is an inverted index held in memory. It starts empty and only
+// knows the products it is given, which makes it suited to tests.
+type MemoryIndex struct {
+	mu       sync.RWMutex
+	docs     map[int]document
+	postings map[string]map[int]float64 // word -> product ID -> weighted count
+}
+
+type document struct {
+	name        string
+	description string
+}
+
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{docs: map[int]document{}, postings: map[string]map[int]float64{}}
+}
+
+func (i *MemoryIndex) IndexProduct(p types.Product) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.remove(p.ID)
+	i.docs[p.ID] = document{name: p.Name, description: p.Description}
+
+	i.add(p.ID, p.Name, nameWeight)
+	i.add(p.ID, p.Description, descriptionWeight)
+
+	return nil
+}
+
+func (i *MemoryIndex) RemoveProduct(id int) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.remove(id)
+	return nil
+}
+
+func (i *MemoryIndex) add(id int, text string, weight float64) {
+	for _, word := range tokenize(text) {
+		if i.postings[word] == nil {
+			i.postings[word] = map[int]float64{}
+		}
+		i.postings[word][id] += weight
+	}
+}
+
+func (i *MemoryIndex) remove(id int) {
+	doc, ok := i.docs[id]
+	if !ok {
+		return
+	}
+
+	for _, word := range tokenize(doc.name + " " + doc.description) {
+		delete(i.postings[word], id)
+		if len(i.postings[word]) == 0 {
+			delete(i.postings, word)
+		}
+	}
+	delete(i.docs, id)
+}
+
+// SearchProducts scores each product by how well, how often and how rarely
+// found each query word matches it, summed over the query words.
+func (i *MemoryIndex) SearchProducts(text string, limit int, offset int) ([]types.SearchMatch, error) {
+	q := parseQuery(text)
+	if len(q.words) == 0 {
+		return []types.SearchMatch{}, nil
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	var scores map[int]float64
+	for n := range q.words {
+		wordScores := map[int]float64{}
+
+		for word, postings := range i.postings {
+			weight := q.match(n, word)
+			if weight == 0 {
+				continue
+			}
+
+			idf := math.Log(1 + float64(len(i.docs))/float64(len(postings)))
+			for id, count := range postings {
+				// The count saturates, so repeating a word only helps so much.
+				score := weight * idf * count / (count + 1)
+				wordScores[id] = max(wordScores[id], score)
+			}
+		}
+
+		if scores == nil {
+			scores = wordScores
+			continue
+		}
+
+		// Every word of the query must match.
+		for id := range scores {
+			if score, ok := wordScores[id]; ok {
+				scores[id] += score
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	matches := make([]types.SearchMatch, 0, len(scores))
+	for id, score := range scores {
+		matches = append(matches, types.SearchMatch{ProductID: id, Score: score})
+	}
+
+	sort.Slice(matches, func(a, b int) bool {
+		if matches[a].Score != matches[b].Score {
+			return matches[a].Score > matches[b].Score
+		}
+		return matches[a].ProductID < matches[b].ProductID
+	})
+
+	matches = matches[min(offset, len(matches)):]
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	for n, m := range matches {
+		doc := i.docs[m.ProductID]
+		matches[n].Highlights = highlights(doc.name, doc.description, q)
+	}
+
+	return matches, nil
+}
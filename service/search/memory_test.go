@@ -0,0 +1,99 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+func TestMemoryIndex(t *testing.T) {
+	index := NewMemoryIndex()
+	for _, p := range []types.Product{
+		{ID: 1, Name: "Steel Water Bottle", Description: "Keeps drinks cold for a day."},
+		{ID: 2, Name: "Ceramic Mug", Description: "A mug for coffee or tea."},
+		{ID: 3, Name: "Travel Mug", Description: "Steel, with a lid."},
+		{ID: 4, Name: "Electric Kettle", Description: "Boils water for tea in minutes."},
+	} {
+		if err := index.IndexProduct(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := func(t *testing.T, query string) []int {
+		t.Helper()
+
+		matches, err := index.SearchProducts(query, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := []int{}
+		for _, m := range matches {
+			ids = append(ids, m.ProductID)
+		}
+		return ids
+	}
+
+	for _, tc := range []struct {
+		name     string
+		query    string
+		expected []int
+	}{
+		{"ranks name matches first", "mug", []int{2, 3}},
+		{"requires every word", "steel mug", []int{3}},
+		{"searches descriptions", "coffee", []int{2}},
+		{"tolerates typos", "kettel", []int{4}},
+		{"completes the last word", "ket", []int{4}},
+		{"only completes the last word", "ket water", []int{}},
+		{"ignores case and punctuation", "  WATER-bottle!", []int{1}},
+		{"finds nothing", "teapot", []int{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := ids(t, tc.query)
+			if len(got) != len(tc.expected) {
+				t.Fatalf("Expected %v, got %v", tc.expected, got)
+			}
+			for i := range got {
+				if got[i] != tc.expected[i] {
+					t.Fatalf("Expected %v, got %v", tc.expected, got)
+				}
+			}
+		})
+	}
+
+	t.Run("highlights the matched words", func(t *testing.T) {
+		matches, _ := index.SearchProducts("stel", 10, 0)
+		if len(matches) != 2 {
+			t.Fatalf("Expected 2 matches, got %+v", matches)
+		}
+
+		if h := matches[0].Highlights["name"]; h != "<mark>Steel</mark> Water Bottle" {
+			t.Errorf("Unexpected name highlight %q", h)
+		}
+		if _, ok := matches[0].Highlights["description"]; ok {
+			t.Error("Expected no description highlight")
+		}
+		if h := matches[1].Highlights["description"]; h != "<mark>Steel</mark>, with a lid." {
+			t.Errorf("Unexpected description highlight %q", h)
+		}
+	})
+
+	t.Run("pages the results", func(t *testing.T) {
+		matches, _ := index.SearchProducts("mug", 1, 1)
+		if len(matches) != 1 || matches[0].ProductID != 3 {
+			t.Errorf("Expected the second match, got %+v", matches)
+		}
+	})
+
+	t.Run("follows updates and removals", func(t *testing.T) {
+		index.IndexProduct(types.Product{ID: 2, Name: "Ceramic Teapot", Description: "Brews tea."})
+		index.RemoveProduct(3)
+
+		if got := ids(t, "mug"); len(got) != 0 {
+			t.Errorf("Expected no mugs left, got %v", got)
+		}
+		if got := ids(t, "teapot"); len(got) != 1 || got[0] != 2 {
+			t.Errorf("Expected the updated product, got %v", got)
+		}
+	})
+}
@@ -0,0 +1,206 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/joshbarros/golang-ecommerce-api/db"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// minWordLength mirrors innodb_ft_min_token_size: shorter words are left out
+// of FULLTEXT indexes, so they cannot be searched for.
+const minWordLength = 3
+
+// MySQLIndex searches the FULLTEXT indexes of the products table, which MySQL
+// keeps up to date by itself. MySQL has no typo tolerance, so misspelled
+// words are corrected against the words of the catalog, loaded on the first
+// search and extended by IndexProduct. Words only added by other instances
+// of the API are not corrected to until it restarts.
+type MySQLIndex struct {
+	db db.DBTX
+
+	mu    sync.Mutex
+	words *vocabulary // nil until loaded
+}
+
+func NewMySQLIndex(db db.DBTX) *MySQLIndex {
+	return &MySQLIndex{db: db}
+}
+
+func (i *MySQLIndex) IndexProduct(p types.Product) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.words != nil {
+		i.words.add(p.Name, p.Description)
+	}
+	return nil
+}
+
+// RemoveProduct has nothing to do: deleted products are filtered out of the
+// results, and their words may still be used by other products.
+func (i *MySQLIndex) RemoveProduct(id int) error {
+	return nil
+}
+
+func (i *MySQLIndex) SearchProducts(text string, limit int, offset int) ([]types.SearchMatch, error) {
+	q := parseQuery(text)
+	if len(q.words) == 0 {
+		return []types.SearchMatch{}, nil
+	}
+
+	// Only the candidates are taken under the lock; comparing them to the
+	// query is left until after, so searches do not wait on each other.
+	i.mu.Lock()
+	err := i.loadWords()
+	var candidates [][][]string
+	if err == nil {
+		candidates = i.words.candidates(q)
+	}
+	i.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	against := booleanQuery(q, candidates)
+
+	// Matches in the name count twice.
+	rows, err := i.db.Query(
+		`SELECT id, name, description,
+			MATCH(name) AGAINST(? IN BOOLEAN MODE) * 2 + MATCH(name, description) AGAINST(? IN BOOLEAN MODE) AS score
+		FROM products
+		WHERE MATCH(name, description) AGAINST(? IN BOOLEAN MODE) AND deletedAt IS NULL
+		ORDER BY score DESC, id ASC
+		LIMIT ? OFFSET ?`,
+		against, against, against, limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []types.SearchMatch{}
+	for rows.Next() {
+		var m types.SearchMatch
+		var name, description string
+		if err := rows.Scan(&m.ProductID, &name, &description, &m.Score); err != nil {
+			return nil, err
+		}
+
+		m.Highlights = highlights(name, description, q)
+		matches = append(matches, m)
+	}
+
+	return matches, rows.Err()
+}
+
+// loadWords loads the words of the catalog unless they already are. The
+// caller must hold i.mu.
+func (i *MySQLIndex) loadWords() error {
+	if i.words != nil {
+		return nil
+	}
+
+	rows, err := i.db.Query("SELECT name, description FROM products WHERE deletedAt IS NULL")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	words := newVocabulary()
+	for rows.Next() {
+		var name, description string
+		if err := rows.Scan(&name, &description); err != nil {
+			return err
+		}
+		words.add(name, description)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	i.words = words
+	return nil
+}
+
+// vocabulary holds the words of the catalog grouped by length, since a
+// correction is at most maxEdits letters longer or shorter than the word it
+// corrects. Words are only ever appended to their group, so a group taken
+// under the lock can be read after releasing it while others are added.
+type vocabulary struct {
+	seen     map[string]struct{}
+	byLength map[int][]string
+}
+
+func newVocabulary() *vocabulary {
+	return &vocabulary{seen: map[string]struct{}{}, byLength: map[int][]string{}}
+}
+
+func (v *vocabulary) add(texts ...string) {
+	for _, text := range texts {
+		for _, word := range tokenize(text) {
+			n := utf8.RuneCountInString(word)
+			if _, ok := v.seen[word]; ok || n < minWordLength {
+				continue
+			}
+
+			v.seen[word] = struct{}{}
+			v.byLength[n] = append(v.byLength[n], word)
+		}
+	}
+}
+
+// candidates returns, for each word of q, the groups of words long enough
+// and short enough to be one of its corrections.
+func (v *vocabulary) candidates(q query) [][][]string {
+	candidates := make([][][]string, len(q.words))
+	for n, w := range q.words {
+		length, edits := utf8.RuneCountInString(w), maxEdits(w)
+		if edits == 0 {
+			continue
+		}
+
+		for l := length - edits; l <= length+edits; l++ {
+			if words := v.byLength[l]; len(words) > 0 {
+				candidates[n] = append(candidates[n], words)
+			}
+		}
+	}
+	return candidates
+}
+
+// booleanQuery turns q into a MySQL boolean mode query requiring each of its
+// words, or one of their corrections found among its candidates. The last
+// word is also matched as a prefix. Words too short to be indexed are
+// dropped, except the last one since prefixes may be short.
+func booleanQuery(q query, candidates [][][]string) string {
+	groups := []string{}
+
+	for n, w := range q.words {
+		last := n == len(q.words)-1
+		if !last && utf8.RuneCountInString(w) < minWordLength {
+			continue
+		}
+
+		corrections := []string{}
+		for _, words := range candidates[n] {
+			for _, word := range words {
+				// Prefix matches are already covered by the wildcard.
+				if q.match(n, word) == typoWeight {
+					corrections = append(corrections, word)
+				}
+			}
+		}
+		sort.Strings(corrections)
+
+		if last {
+			w += "*"
+		}
+		groups = append(groups, "+("+strings.Join(append([]string{w}, corrections...), " ")+")")
+	}
+
+	return strings.Join(groups, " ")
+}
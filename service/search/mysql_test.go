@@ -0,0 +1,52 @@
+package search
+
+import "testing"
+
+func TestBooleanQuery(t *testing.T) {
+	words := newVocabulary()
+	words.add("Steel Water Bottle", "Electric Kettle", "Kettles and mugs")
+
+	for _, tc := range []struct {
+		query    string
+		expected string
+	}{
+		{"mug", "+(mug*)"},
+		{"steel bottle", "+(steel) +(bottle*)"},
+		{"kettel", "+(kettel* kettle)"},
+		{"a kettle", "+(kettle*)"},
+		{"kettle a", "+(kettle kettles) +(a*)"},
+		{"+water -steel*", "+(water) +(steel*)"},
+	} {
+		q := parseQuery(tc.query)
+		if against := booleanQuery(q, words.candidates(q)); against != tc.expected {
+			t.Errorf("booleanQuery(%q) = %q, expected %q", tc.query, against, tc.expected)
+		}
+	}
+}
+
+func TestVocabularyCandidates(t *testing.T) {
+	words := newVocabulary()
+	words.add("Kit Kettle Kettles", "Electric kettle", "Stainless")
+
+	q := parseQuery("kit kettel stainles")
+	candidates := words.candidates(q)
+
+	if len(candidates[0]) != 0 {
+		t.Errorf("Expected no candidates for a word too short for typos, got %v", candidates[0])
+	}
+
+	// kettel tolerates one typo, so only words of 5 to 7 letters.
+	expected := [][]string{{"kettle"}, {"kettles"}}
+	if got := candidates[1]; len(got) != len(expected) || got[0][0] != "kettle" || got[1][0] != "kettles" {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	// stainles tolerates two typos, so words of 6 to 10 letters.
+	found := 0
+	for _, group := range candidates[2] {
+		found += len(group)
+	}
+	if found != 4 {
+		t.Errorf("Expected kettle, kettles, electric and stainless, got %v", candidates[2])
+	}
+}
@@ -0,0 +1,169 @@
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Fields of a product are weighted so words in its name count twice as much
+// as words in its description.
+const (
+	nameWeight        = 2.0
+	descriptionWeight = 1.0
+)
+
+// Weights of the ways a query word can match a word of a product.
+const (
+	exactWeight  = 1.0
+	prefixWeight = 0.8
+	typoWeight   = 0.6
+)
+
+// isWordRune reports whether r is part of a word. Everything else, including
+// the operators of MySQL's boolean mode, separates words.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// tokenize splits text into lowercase words.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !isWordRune(r) })
+}
+
+// query is a parsed search query. Every word must match, allowing for typos,
+// and the last one also matches as a prefix so results show up while it is
+// being typed.
+type query struct {
+	words []string
+}
+
+func parseQuery(text string) query {
+	return query{words: tokenize(text)}
+}
+
+// match returns how well word matches the n-th word of the query, or 0.
+func (q query) match(n int, word string) float64 {
+	w := q.words[n]
+
+	if word == w {
+		return exactWeight
+	}
+	if n == len(q.words)-1 && strings.HasPrefix(word, w) {
+		return prefixWeight
+	}
+	if edits := maxEdits(w); edits > 0 && editDistance(w, word, edits) <= edits {
+		return typoWeight
+	}
+
+	return 0
+}
+
+// matches reports whether word matches any word of the query.
+func (q query) matches(word string) bool {
+	for n := range q.words {
+		if q.match(n, word) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// maxEdits is the number of typos tolerated in a query word: none in short
+// words, where a typo easily makes another word, one from 4 letters and two
+// from 8.
+func maxEdits(word string) int {
+	switch n := utf8.RuneCountInString(word); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// editDistance counts the insertions, deletions, substitutions and swaps of
+// adjacent letters turning a into b. Past limit it gives up and returns
+// limit+1.
+func editDistance(a string, b string, limit int) int {
+	s, t := []rune(a), []rune(b)
+	if d := len(s) - len(t); d > limit || -d > limit {
+		return limit + 1
+	}
+
+	// Three rows of the optimal string alignment matrix.
+	prev2 := make([]int, len(t)+1)
+	prev := make([]int, len(t)+1)
+	curr := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(s); i++ {
+		curr[0] = i
+		best := curr[0]
+
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+			best = min(best, curr[j])
+		}
+
+		if best > limit {
+			return limit + 1
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+
+	return min(prev[len(t)], limit+1)
+}
+
+// highlight HTML escapes text and wraps the words matching q in <mark> tags.
+// It reports whether any word matched.
+func highlight(text string, q query) (string, bool) {
+	var b strings.Builder
+	matched := false
+
+	for len(text) > 0 {
+		r, _ := utf8.DecodeRuneInString(text)
+		inWord := isWordRune(r)
+
+		end := strings.IndexFunc(text, func(r rune) bool { return isWordRune(r) != inWord })
+		if end < 0 {
+			end = len(text)
+		}
+
+		segment := text[:end]
+		text = text[end:]
+
+		if inWord && q.matches(strings.ToLower(segment)) {
+			b.WriteString("<mark>" + html.EscapeString(segment) + "</mark>")
+			matched = true
+		} else {
+			b.WriteString(html.EscapeString(segment))
+		}
+	}
+
+	return b.String(), matched
+}
+
+// highlights returns the fields of a product in which q matched, highlighted.
+func highlights(name string, description string, q query) map[string]string {
+	fields := map[string]string{}
+	if h, ok := highlight(name, q); ok {
+		fields["name"] = h
+	}
+	if h, ok := highlight(description, q); ok {
+		fields["description"] = h
+	}
+	return fields
+}
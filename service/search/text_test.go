@@ -0,0 +1,65 @@
+package search
+
+import "testing"
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     string
+		limit    int
+		expected int
+	}{
+		{"mug", "mug", 2, 0},
+		{"mug", "mugs", 2, 1},
+		{"kettle", "ketle", 2, 1},
+		{"kettle", "kettel", 2, 1},
+		{"kettle", "kattle", 2, 1},
+		{"headphones", "haedphnoes", 2, 2},
+		{"kettle", "teapot", 2, 3},
+		{"mug", "keyboard", 2, 3},
+		{"café", "cafe", 1, 1},
+	} {
+		if d := editDistance(tc.a, tc.b, tc.limit); d != tc.expected {
+			t.Errorf("editDistance(%q, %q, %d) = %d, expected %d", tc.a, tc.b, tc.limit, d, tc.expected)
+		}
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	q := parseQuery("Wireles head")
+
+	for _, tc := range []struct {
+		n        int
+		word     string
+		expected float64
+	}{
+		{0, "wireles", exactWeight},
+		{0, "wireless", typoWeight},
+		{0, "wirelessly", 0},
+		{1, "head", exactWeight},
+		{1, "headphones", prefixWeight},
+		{1, "heat", typoWeight},
+		{1, "help", 0},
+	} {
+		if weight := q.match(tc.n, tc.word); weight != tc.expected {
+			t.Errorf("match(%d, %q) = %v, expected %v", tc.n, tc.word, weight, tc.expected)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	q := parseQuery("steel mug")
+
+	h, ok := highlight("Steel <Travel> Mugs & Steely cups", q)
+	if !ok {
+		t.Fatal("Expected a match")
+	}
+
+	expected := "<mark>Steel</mark> &lt;Travel&gt; <mark>Mugs</mark> &amp; <mark>Steely</mark> cups"
+	if h != expected {
+		t.Errorf("Expected %q, got %q", expected, h)
+	}
+
+	if _, ok := highlight("Ceramic teapot", q); ok {
+		t.Error("Expected no match")
+	}
+}
@@ -0,0 +1,118 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+	"github.com/joshbarros/golang-ecommerce-api/utils"
+)
+
+type Handler struct {
+	txManager  types.TxManager
+	userStore  types.UserStore
+	tokenStore types.TokenStore
+}
+
+func NewHandler(txManager types.TxManager, userStore types.UserStore, tokenStore types.TokenStore) *Handler {
+	return &Handler{txManager: txManager, userStore: userStore, tokenStore: tokenStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/token/refresh", h.handleRefresh).Methods(http.MethodPost)
+	router.HandleFunc("/logout", auth.WithJWTAuth(h.handleLogout, h.userStore, h.tokenStore)).Methods(http.MethodPost)
+	router.HandleFunc("/logout-all", auth.WithJWTAuth(h.handleLogoutAll, h.userStore, h.tokenStore)).Methods(http.MethodPost)
+}
+
+func (h *Handler) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var payload types.RefreshTokenPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid payload %v", errors))
+		return
+	}
+
+	var tokens *types.TokenPair
+	var reused bool
+	err := h.txManager.WithTx(r.Context(), func(stores types.Stores) error {
+		var err error
+		tokens, err = auth.RefreshTokens(stores, payload.RefreshToken)
+		// The family has been revoked; commit that before reporting the reuse.
+		if errors.Is(err, auth.ErrRefreshTokenReused) {
+			reused = true
+			return nil
+		}
+		return err
+	})
+
+	switch {
+	case reused:
+		utils.WriteError(w, http.StatusUnauthorized, auth.ErrRefreshTokenReused)
+		return
+	case errors.Is(err, auth.ErrInvalidRefreshToken):
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	case err != nil:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, tokens)
+}
+
+// handleLogout ends the current session: the access token used for the
+// request and the refresh token family it was issued with.
+func (h *Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaimsFromContext(r.Context())
+
+	err := h.txManager.WithTx(r.Context(), func(stores types.Stores) error {
+		if err := stores.Tokens.RevokeAccessToken(claims.ID, claims.ExpiresAt.Time); err != nil {
+			return err
+		}
+
+		t, err := stores.Tokens.GetRefreshTokenByAccessTokenID(claims.ID)
+		if errors.Is(err, types.ErrRefreshTokenNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return stores.Tokens.RevokeRefreshTokenFamily(t.FamilyID)
+	})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleLogoutAll ends every session of the user.
+func (h *Handler) handleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	claims := auth.GetClaimsFromContext(r.Context())
+
+	err := h.txManager.WithTx(r.Context(), func(stores types.Stores) error {
+		if err := stores.Tokens.RevokeAccessToken(claims.ID, claims.ExpiresAt.Time); err != nil {
+			return err
+		}
+
+		return stores.Tokens.RevokeUserRefreshTokens(userID)
+	})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
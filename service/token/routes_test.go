@@ -0,0 +1,298 @@
+package token
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/config"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// Mock implementation of the TokenStore interface
+type mockTokenStore struct {
+	refreshTokens []types.RefreshToken
+	revoked       map[string]time.Time
+}
+
+func (m *mockTokenStore) CreateRefreshToken(t *types.RefreshToken) error {
+	t.ID = len(m.refreshTokens) + 1
+	m.refreshTokens = append(m.refreshTokens, *t)
+	return nil
+}
+
+func (m *mockTokenStore) find(match func(types.RefreshToken) bool) (*types.RefreshToken, error) {
+	for _, t := range m.refreshTokens {
+		if match(t) {
+			return &t, nil
+		}
+	}
+	return nil, types.ErrRefreshTokenNotFound
+}
+
+func (m *mockTokenStore) GetRefreshTokenByHash(hash string) (*types.RefreshToken, error) {
+	return m.find(func(t types.RefreshToken) bool { return t.TokenHash == hash })
+}
+
+func (m *mockTokenStore) GetRefreshTokenByAccessTokenID(jti string) (*types.RefreshToken, error) {
+	return m.find(func(t types.RefreshToken) bool { return t.AccessTokenID == jti })
+}
+
+func (m *mockTokenStore) MarkRefreshTokenUsed(id int) error {
+	t := &m.refreshTokens[id-1]
+	if t.UsedAt != nil || t.RevokedAt != nil {
+		return types.ErrRefreshTokenUsed
+	}
+	now := time.Now()
+	t.UsedAt = &now
+	return nil
+}
+
+func (m *mockTokenStore) revokeWhere(match func(types.RefreshToken) bool) {
+	now := time.Now()
+	for i, t := range m.refreshTokens {
+		if match(t) {
+			m.revoked[t.AccessTokenID] = t.AccessExpiresAt
+			if t.RevokedAt == nil {
+				m.refreshTokens[i].RevokedAt = &now
+			}
+		}
+	}
+}
+
+func (m *mockTokenStore) RevokeRefreshTokenFamily(familyID string) error {
+	m.revokeWhere(func(t types.RefreshToken) bool { return t.FamilyID == familyID })
+	return nil
+}
+
+func (m *mockTokenStore) RevokeUserRefreshTokens(userID int) error {
+	m.revokeWhere(func(t types.RefreshToken) bool { return t.UserID == userID })
+	return nil
+}
+
+func (m *mockTokenStore) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	m.revoked[jti] = expiresAt
+	return nil
+}
+
+func (m *mockTokenStore) IsAccessTokenRevoked(jti string) (bool, error) {
+	_, ok := m.revoked[jti]
+	return ok, nil
+}
+
+// Mock implementation of the UserStore interface
+type mockUserStore struct {
+	users map[int]*types.User
+}
+
+func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+	return nil, errors.New("user not found")
+}
+
+func (m *mockUserStore) GetUserByID(id int) (*types.User, error) {
+	if u, ok := m.users[id]; ok {
+		return u, nil
+	}
+	return nil, errors.New("user not found")
+}
+
+func (m *mockUserStore) CreateUser(user types.User) error {
+	return nil
+}
+
+func (m *mockUserStore) UpdateUserRole(userID int, role types.Role) error {
+	return nil
+}
+
+func (m *mockUserStore) UpdatePassword(userID int, password string) error {
+	return nil
+}
+
+func (m *mockUserStore) MarkUserVerified(userID int) error {
+	return nil
+}
+
+func (m *mockUserStore) UpdateProfile(userID int, firstName string, lastName string) error {
+	return nil
+}
+
+func (m *mockUserStore) UpdateEmail(userID int, email string) error {
+	return nil
+}
+
+func (m *mockUserStore) ScheduleDeletion(userID int, dueAt time.Time) error {
+	return nil
+}
+
+func (m *mockUserStore) CancelDeletion(userID int) error {
+	return nil
+}
+
+func (m *mockUserStore) GetUsersDueForDeletion(now time.Time) ([]types.User, error) {
+	return nil, nil
+}
+
+func (m *mockUserStore) AnonymizeUser(userID int) error {
+	return nil
+}
+
+// Mock TxManager that restores the token store when the unit of work fails
+type mockTxManager struct {
+	users  *mockUserStore
+	tokens *mockTokenStore
+}
+
+func (m *mockTxManager) WithTx(ctx context.Context, fn func(types.Stores) error) error {
+	refreshTokens := append([]types.RefreshToken(nil), m.tokens.refreshTokens...)
+	revoked := map[string]time.Time{}
+	for k, v := range m.tokens.revoked {
+		revoked[k] = v
+	}
+
+	if err := fn(types.Stores{Users: m.users, Tokens: m.tokens}); err != nil {
+		m.tokens.refreshTokens = refreshTokens
+		m.tokens.revoked = revoked
+		return err
+	}
+	return nil
+}
+
+func TestTokenServiceHandlers(t *testing.T) {
+	config.Envs.JWTSecret = "secret"
+	config.Envs.JWTExpirationInSeconds = 900
+	config.Envs.RefreshTokenExpInSeconds = 3600
+
+	user := &types.User{ID: 1, Email: "user@example.com", Role: types.RoleCustomer}
+
+	setup := func() (*mux.Router, *mockTokenStore) {
+		userStore := &mockUserStore{users: map[int]*types.User{1: user}}
+		tokenStore := &mockTokenStore{revoked: map[string]time.Time{}}
+
+		router := mux.NewRouter()
+		NewHandler(&mockTxManager{users: userStore, tokens: tokenStore}, userStore, tokenStore).RegisterRoutes(router)
+		return router, tokenStore
+	}
+
+	request := func(t *testing.T, router *mux.Router, path string, token string, payload any) *httptest.ResponseRecorder {
+		t.Helper()
+
+		var body bytes.Buffer
+		if payload != nil {
+			json.NewEncoder(&body).Encode(payload)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, path, &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	refresh := func(t *testing.T, router *mux.Router, refreshToken string) (*httptest.ResponseRecorder, types.TokenPair) {
+		t.Helper()
+
+		rr := request(t, router, "/token/refresh", "", types.RefreshTokenPayload{RefreshToken: refreshToken})
+
+		var pair types.TokenPair
+		if rr.Code == http.StatusOK {
+			json.NewDecoder(rr.Body).Decode(&pair)
+		}
+		return rr, pair
+	}
+
+	t.Run("Should exchange a refresh token for a new pair", func(t *testing.T) {
+		router, tokenStore := setup()
+		login, _ := auth.IssueTokens(tokenStore, user, "")
+
+		rr, pair := refresh(t, router, login.RefreshToken)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		if pair.Token == "" || pair.RefreshToken == "" || pair.RefreshToken == login.RefreshToken {
+			t.Errorf("Expected a new token pair, got %+v", pair)
+		}
+	})
+
+	t.Run("Should sign out the family when a refresh token is reused", func(t *testing.T) {
+		router, tokenStore := setup()
+		login, _ := auth.IssueTokens(tokenStore, user, "")
+
+		_, rotated := refresh(t, router, login.RefreshToken)
+		rr, _ := refresh(t, router, login.RefreshToken)
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+
+		if rr, _ := refresh(t, router, rotated.RefreshToken); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected the rotated refresh token to be revoked, got status code %d", rr.Code)
+		}
+		if rr := request(t, router, "/logout", rotated.Token, nil); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected the rotated access token to be revoked, got status code %d", rr.Code)
+		}
+	})
+
+	t.Run("Should reject an unknown refresh token", func(t *testing.T) {
+		router, _ := setup()
+
+		if rr, _ := refresh(t, router, "not-a-token"); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+		if rr := request(t, router, "/token/refresh", "", map[string]string{}); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("Should log out the current session only", func(t *testing.T) {
+		router, tokenStore := setup()
+		session, _ := auth.IssueTokens(tokenStore, user, "")
+		other, _ := auth.IssueTokens(tokenStore, user, "")
+
+		if rr := request(t, router, "/logout", session.Token, nil); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+
+		if rr := request(t, router, "/logout", session.Token, nil); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected the access token to be revoked, got status code %d", rr.Code)
+		}
+		if rr, _ := refresh(t, router, session.RefreshToken); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected the refresh token to be revoked, got status code %d", rr.Code)
+		}
+		if rr, _ := refresh(t, router, other.RefreshToken); rr.Code != http.StatusOK {
+			t.Errorf("Expected the other session to keep working, got status code %d", rr.Code)
+		}
+	})
+
+	t.Run("Should log out every session", func(t *testing.T) {
+		router, tokenStore := setup()
+		session, _ := auth.IssueTokens(tokenStore, user, "")
+		other, _ := auth.IssueTokens(tokenStore, user, "")
+
+		if rr := request(t, router, "/logout-all", session.Token, nil); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+
+		for _, pair := range []*types.TokenPair{session, other} {
+			if rr := request(t, router, "/logout", pair.Token, nil); rr.Code != http.StatusForbidden {
+				t.Errorf("Expected the access token to be revoked, got status code %d", rr.Code)
+			}
+			if rr, _ := refresh(t, router, pair.RefreshToken); rr.Code != http.StatusUnauthorized {
+				t.Errorf("Expected the refresh token to be revoked, got status code %d", rr.Code)
+			}
+		}
+	})
+}
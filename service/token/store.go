@@ -0,0 +1,255 @@
+package token
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/joshbarros/golang-ecommerce-api/db"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+type Store struct {
+	db db.DBTX
+}
+
+func NewStore(db db.DBTX) *Store {
+	return &Store{db: db}
+}
+
+const refreshTokenColumns = "id, userId, familyId, tokenHash, accessTokenId, accessExpiresAt, expiresAt, usedAt, revokedAt, createdAt"
+
+func (s *Store) CreateRefreshToken(t *types.RefreshToken) error {
+	res, err := s.db.Exec(
+		"INSERT INTO refresh_tokens (userId, familyId, tokenHash, accessTokenId, accessExpiresAt, expiresAt) VALUES (?, ?, ?, ?, ?, ?)",
+		t.UserID, t.FamilyID, t.TokenHash, t.AccessTokenID, t.AccessExpiresAt, t.ExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	t.ID = int(id)
+	return nil
+}
+
+func (s *Store) GetRefreshTokenByHash(hash string) (*types.RefreshToken, error) {
+	return s.getRefreshToken("SELECT "+refreshTokenColumns+" FROM refresh_tokens WHERE tokenHash = ?", hash)
+}
+
+func (s *Store) GetRefreshTokenByAccessTokenID(jti string) (*types.RefreshToken, error) {
+	return s.getRefreshToken("SELECT "+refreshTokenColumns+" FROM refresh_tokens WHERE accessTokenId = ?", jti)
+}
+
+func (s *Store) getRefreshToken(query string, arg any) (*types.RefreshToken, error) {
+	rows, err := s.db.Query(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, types.ErrRefreshTokenNotFound
+	}
+
+	return scanRowIntoRefreshToken(rows)
+}
+
+// MarkRefreshTokenUsed consumes a refresh token. Only one of several
+// concurrent calls for the same token succeeds; the others get
+// types.ErrRefreshTokenUsed.
+func (s *Store) MarkRefreshTokenUsed(id int) error {
+	res, err := s.db.Exec(
+		"UPDATE refresh_tokens SET usedAt = CURRENT_TIMESTAMP WHERE id = ? AND usedAt IS NULL AND revokedAt IS NULL",
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return types.ErrRefreshTokenUsed
+	}
+
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes every token rotated from the same login,
+// along with the access tokens issued with them. Run it in a transaction.
+func (s *Store) RevokeRefreshTokenFamily(familyID string) error {
+	_, err := s.db.Exec(
+		"INSERT IGNORE INTO revoked_tokens (jti, expiresAt) SELECT accessTokenId, accessExpiresAt FROM refresh_tokens WHERE familyId = ? AND accessExpiresAt > CURRENT_TIMESTAMP",
+		familyID,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("UPDATE refresh_tokens SET revokedAt = CURRENT_TIMESTAMP WHERE familyId = ? AND revokedAt IS NULL", familyID)
+	return err
+}
+
+// RevokeUserRefreshTokens signs a user out everywhere. Run it in a
+// transaction.
+func (s *Store) RevokeUserRefreshTokens(userID int) error {
+	_, err := s.db.Exec(
+		"INSERT IGNORE INTO revoked_tokens (jti, expiresAt) SELECT accessTokenId, accessExpiresAt FROM refresh_tokens WHERE userId = ? AND accessExpiresAt > CURRENT_TIMESTAMP",
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("UPDATE refresh_tokens SET revokedAt = CURRENT_TIMESTAMP WHERE userId = ? AND revokedAt IS NULL", userID)
+	return err
+}
+
+func (s *Store) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	_, err := s.db.Exec("INSERT IGNORE INTO revoked_tokens (jti, expiresAt) VALUES (?, ?)", jti, expiresAt)
+	return err
+}
+
+func (s *Store) IsAccessTokenRevoked(jti string) (bool, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?", jti).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+const userTokenColumns = "id, userId, purpose, tokenHash, expiresAt, usedAt, createdAt"
+
+// CreateUserToken stores a token. createdAt is set from the application clock,
+// like expiresAt, so the two can be compared with time.Now.
+func (s *Store) CreateUserToken(t *types.UserToken) error {
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now()
+	}
+
+	res, err := s.db.Exec(
+		"INSERT INTO user_tokens (userId, purpose, tokenHash, expiresAt, createdAt) VALUES (?, ?, ?, ?, ?)",
+		t.UserID, t.Purpose, t.TokenHash, t.ExpiresAt, t.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	t.ID = int(id)
+	return nil
+}
+
+func (s *Store) GetUserTokenByHash(purpose types.UserTokenPurpose, hash string) (*types.UserToken, error) {
+	return s.getUserToken("SELECT "+userTokenColumns+" FROM user_tokens WHERE purpose = ? AND tokenHash = ?", purpose, hash)
+}
+
+// GetLatestUserToken returns the token of a purpose most recently issued to
+// the user, used or not.
+func (s *Store) GetLatestUserToken(userID int, purpose types.UserTokenPurpose) (*types.UserToken, error) {
+	return s.getUserToken("SELECT "+userTokenColumns+" FROM user_tokens WHERE userId = ? AND purpose = ? ORDER BY createdAt DESC, id DESC LIMIT 1", userID, purpose)
+}
+
+func (s *Store) getUserToken(query string, args ...any) (*types.UserToken, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, types.ErrUserTokenNotFound
+	}
+
+	return scanRowIntoUserToken(rows)
+}
+
+// MarkUserTokenUsed consumes an emailed token. Only one of several concurrent
+// calls for the same token succeeds; the others get types.ErrUserTokenUsed.
+func (s *Store) MarkUserTokenUsed(id int) error {
+	res, err := s.db.Exec("UPDATE user_tokens SET usedAt = CURRENT_TIMESTAMP WHERE id = ? AND usedAt IS NULL", id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return types.ErrUserTokenUsed
+	}
+
+	return nil
+}
+
+// InvalidateUserTokens marks every unused token of a purpose as used, so only
+// the most recently emailed one works.
+func (s *Store) InvalidateUserTokens(userID int, purpose types.UserTokenPurpose) error {
+	_, err := s.db.Exec(
+		"UPDATE user_tokens SET usedAt = CURRENT_TIMESTAMP WHERE userId = ? AND purpose = ? AND usedAt IS NULL",
+		userID, purpose,
+	)
+	return err
+}
+
+func scanRowIntoUserToken(rows *sql.Rows) (*types.UserToken, error) {
+	t := new(types.UserToken)
+
+	err := rows.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Purpose,
+		&t.TokenHash,
+		&t.ExpiresAt,
+		&t.UsedAt,
+		&t.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func scanRowIntoRefreshToken(rows *sql.Rows) (*types.RefreshToken, error) {
+	t := new(types.RefreshToken)
+
+	err := rows.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.FamilyID,
+		&t.TokenHash,
+		&t.AccessTokenID,
+		&t.AccessExpiresAt,
+		&t.ExpiresAt,
+		&t.UsedAt,
+		&t.RevokedAt,
+		&t.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
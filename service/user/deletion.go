@@ -0,0 +1,200 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/joshbarros/golang-ecommerce-api/config"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// exportPageSize is how many orders ExportUser reads at a time.
+const exportPageSize = 100
+
+// ExportUser gathers the user's profile, addresses and orders.
+func ExportUser(ctx context.Context, txManager types.TxManager, userID int) (*types.UserExport, error) {
+	var export *types.UserExport
+	err := txManager.WithTx(ctx, func(stores types.Stores) error {
+		u, err := stores.Users.GetUserByID(userID)
+		if err != nil {
+			return err
+		}
+
+		addresses, err := stores.Addresses.GetAddressesByUserID(u.ID)
+		if err != nil {
+			return err
+		}
+
+		orders := []types.OrderDetail{}
+		for offset := 0; ; offset += exportPageSize {
+			page, err := stores.Orders.GetOrdersByUserID(u.ID, exportPageSize, offset)
+			if err != nil {
+				return err
+			}
+
+			for _, order := range page {
+				items, err := stores.Orders.GetOrderItems(order.ID)
+				if err != nil {
+					return err
+				}
+				orders = append(orders, types.OrderDetail{Order: order, Items: items})
+			}
+
+			if len(page) < exportPageSize {
+				break
+			}
+		}
+
+		export = &types.UserExport{
+			ExportedAt: time.Now(),
+			Profile:    *u,
+			Addresses:  addresses,
+			Orders:     orders,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// ScheduleDeletion asks for the user's account to be erased once
+// ACCOUNT_DELETION_GRACE_PERIOD has passed, and tells them by email so they
+// can cancel if they did not ask for it. Asking again keeps the first date.
+func ScheduleDeletion(ctx context.Context, txManager types.TxManager, mailer types.Mailer, userID int) (*types.User, error) {
+	return scheduleDeletion(ctx, txManager, mailer, userID, nil)
+}
+
+// RequestDeletion is ScheduleDeletion for users asking themselves. Erasure
+// cannot be undone once due, so a stolen access token is not enough: it takes
+// the current password, and a TOTP code with two-factor authentication.
+func RequestDeletion(ctx context.Context, txManager types.TxManager, mailer types.Mailer, userID int, payload types.DeleteAccountPayload) (*types.User, error) {
+	return scheduleDeletion(ctx, txManager, mailer, userID, func(stores types.Stores, u *types.User) error {
+		if !auth.ComparePasswords(u.Password, []byte(payload.CurrentPassword)) {
+			return ErrInvalidCurrentPassword
+		}
+
+		if u.TwoFactorEnabledAt == nil {
+			return nil
+		}
+		if payload.Code == "" {
+			return ErrInvalidTwoFactorCode
+		}
+		return verifyTOTP(stores, u, payload.Code)
+	})
+}
+
+// scheduleDeletion runs confirm, when given, in the same transaction before
+// scheduling the deletion.
+func scheduleDeletion(ctx context.Context, txManager types.TxManager, mailer types.Mailer, userID int, confirm func(types.Stores, *types.User) error) (*types.User, error) {
+	var user *types.User
+	var scheduled bool
+	err := txManager.WithTx(ctx, func(stores types.Stores) error {
+		u, err := stores.Users.GetUserByID(userID)
+		if err != nil {
+			return err
+		}
+
+		if confirm != nil {
+			if err := confirm(stores, u); err != nil {
+				return err
+			}
+		}
+
+		if u.DeletionDueAt == nil {
+			dueAt := time.Now().Add(time.Second * time.Duration(config.Envs.AccountDeletionGracePeriodInSeconds))
+			if err := stores.Users.ScheduleDeletion(u.ID, dueAt); err != nil {
+				return err
+			}
+			scheduled = true
+		}
+
+		user, err = stores.Users.GetUserByID(u.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if scheduled {
+		err := mailer.Send(ctx, types.Email{
+			To:      user.Email,
+			Subject: "Your account will be deleted",
+			Body: fmt.Sprintf(
+				"Hi %s,\n\nYour account and personal data will be deleted on %s. Your orders are kept without your name or email, as required for accounting.\n\nIf you did not ask for this, log in and cancel the deletion before then.",
+				user.FirstName, user.DeletionDueAt.Format("2 January 2006"),
+			),
+		})
+		if err != nil {
+			log.Printf("Failed to send a deletion email: %v", err)
+		}
+	}
+
+	return user, nil
+}
+
+// CancelDeletion keeps an account that was waiting to be erased.
+func CancelDeletion(ctx context.Context, txManager types.TxManager, userID int) error {
+	return txManager.WithTx(ctx, func(stores types.Stores) error {
+		return stores.Users.CancelDeletion(userID)
+	})
+}
+
+// EraseUser anonymizes the user right away and signs them out everywhere.
+func EraseUser(ctx context.Context, txManager types.TxManager, userID int) error {
+	return txManager.WithTx(ctx, func(stores types.Stores) error {
+		if err := stores.Users.AnonymizeUser(userID); err != nil {
+			return err
+		}
+
+		return stores.Tokens.RevokeUserRefreshTokens(userID)
+	})
+}
+
+// EraseDueUsers erases every user whose grace period ended before now, and
+// returns how many were erased.
+func EraseDueUsers(ctx context.Context, txManager types.TxManager, now time.Time) (int, error) {
+	var due []types.User
+	err := txManager.WithTx(ctx, func(stores types.Stores) error {
+		var err error
+		due, err = stores.Users.GetUsersDueForDeletion(now)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, u := range due {
+		if err := EraseUser(ctx, txManager, u.ID); err != nil {
+			return i, err
+		}
+	}
+
+	return len(due), nil
+}
+
+// RunUserErasure calls EraseDueUsers every interval until ctx is done.
+func RunUserErasure(ctx context.Context, txManager types.TxManager, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := EraseDueUsers(ctx, txManager, time.Now())
+		if err != nil {
+			log.Printf("Failed to erase deleted accounts: %v", err)
+		} else if n > 0 {
+			log.Printf("Erased %d deleted accounts", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
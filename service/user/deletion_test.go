@@ -0,0 +1,315 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/config"
+	"github.com/joshbarros/golang-ecommerce-api/mail"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// Mock implementation of the AddressStore interface, only listing addresses
+type mockAddressStore struct {
+	addresses []types.Address
+}
+
+func (m *mockAddressStore) GetAddressesByUserID(userID int) ([]types.Address, error) {
+	addresses := []types.Address{}
+	for _, a := range m.addresses {
+		if a.UserID == userID {
+			addresses = append(addresses, a)
+		}
+	}
+	return addresses, nil
+}
+
+func (m *mockAddressStore) GetAddressByID(id int) (*types.Address, error) {
+	return nil, types.ErrAddressNotFound
+}
+
+func (m *mockAddressStore) CreateAddress(*types.Address) error {
+	return nil
+}
+
+func (m *mockAddressStore) UpdateAddress(types.Address) error {
+	return nil
+}
+
+func (m *mockAddressStore) DeleteAddress(id int) error {
+	return nil
+}
+
+func (m *mockAddressStore) ClearDefaultShipping(userID int) error {
+	return nil
+}
+
+func (m *mockAddressStore) ClearDefaultBilling(userID int) error {
+	return nil
+}
+
+// Mock implementation of the OrderStore interface, only listing orders
+type mockOrderStore struct {
+	orders []types.Order
+	items  []types.OrderItem
+}
+
+func (m *mockOrderStore) CreateOrder(types.Order) (int, error) {
+	return 0, nil
+}
+
+func (m *mockOrderStore) CreateOrderItem(types.OrderItem) error {
+	return nil
+}
+
+func (m *mockOrderStore) GetOrdersByUserID(userID int, limit int, offset int) ([]types.Order, error) {
+	orders := []types.Order{}
+	for _, o := range m.orders {
+		if o.UserID == userID {
+			orders = append(orders, o)
+		}
+	}
+	if offset >= len(orders) {
+		return []types.Order{}, nil
+	}
+	return orders[offset:min(offset+limit, len(orders))], nil
+}
+
+func (m *mockOrderStore) CountOrdersByUserID(userID int) (int, error) {
+	orders, _ := m.GetOrdersByUserID(userID, len(m.orders), 0)
+	return len(orders), nil
+}
+
+func (m *mockOrderStore) GetOrderByID(id int) (*types.Order, error) {
+	return nil, types.ErrOrderNotFound
+}
+
+func (m *mockOrderStore) GetOrderItems(orderID int) ([]types.OrderItem, error) {
+	items := []types.OrderItem{}
+	for _, item := range m.items {
+		if item.OrderID == orderID {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func (m *mockOrderStore) UpdateOrderStatus(orderID int, from types.OrderStatus, to types.OrderStatus) error {
+	return nil
+}
+
+func (m *mockOrderStore) CreateOrderStatusChange(types.OrderStatusChange) error {
+	return nil
+}
+
+func TestAccountDeletion(t *testing.T) {
+	config.Envs.AccountDeletionGracePeriodInSeconds = 3600 * 24 * 30
+
+	hashedPassword, err := auth.HashPassword("correct-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, _ := auth.NewTOTPSecret()
+	enabledAt := time.Now()
+	userStore := &mockUserStore{users: map[string]*types.User{
+		"jane@example.com": {ID: 1, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Password: hashedPassword},
+		"john@example.com": {ID: 2, FirstName: "John", Email: "john@example.com", Role: types.RoleAdmin},
+		"ann@example.com":  {ID: 4, FirstName: "Ann", Email: "ann@example.com", Password: hashedPassword, TOTPSecret: secret, TwoFactorEnabledAt: &enabledAt},
+	}}
+	tokenStore := &mockTokenStore{}
+	mailer := mail.NewMemoryMailer()
+	orders := []types.Order{}
+	for id := 1; id <= exportPageSize+1; id++ {
+		orders = append(orders, types.Order{ID: id, UserID: 1, Status: types.OrderStatusDelivered})
+	}
+	txManager := &mockTxManager{
+		users:      userStore,
+		tokens:     tokenStore,
+		userTokens: &mockUserTokenStore{},
+		twoFactor:  &mockTwoFactorStore{users: userStore, lastStep: map[int]int64{}, recoveryCodes: map[int]map[string]bool{}},
+		addresses:  &mockAddressStore{addresses: []types.Address{{ID: 1, UserID: 1, City: "Lisbon"}, {ID: 2, UserID: 2}}},
+		orders: &mockOrderStore{
+			orders: orders,
+			items:  []types.OrderItem{{ID: 1, OrderID: 1, ProductName: "Mug", Quantity: 2}},
+		},
+	}
+	handler := NewHandler(userStore, tokenStore, txManager, mailer, auth.NewDefaultLoginThrottle())
+
+	authenticated := func(h http.HandlerFunc, method string, userID int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/me", nil)
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserKey, userID))
+
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	deleteMe := func(userID int, payload types.DeleteAccountPayload) *httptest.ResponseRecorder {
+		marshalled, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodDelete, "/me", bytes.NewBuffer(marshalled))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserKey, userID))
+
+		rr := httptest.NewRecorder()
+		handler.handleDeleteMe(rr, req)
+		return rr
+	}
+	confirmed := types.DeleteAccountPayload{CurrentPassword: "correct-password"}
+
+	asAdmin := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserKey, 2))
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		router.HandleFunc("/users/{id}", handler.handleDeleteUser)
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Should export the profile, addresses and every order", func(t *testing.T) {
+		rr := authenticated(handler.handleExport, http.MethodGet, 1)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if disposition := rr.Header().Get("Content-Disposition"); disposition != `attachment; filename="user-1-export.json"` {
+			t.Errorf("Unexpected Content-Disposition %q", disposition)
+		}
+
+		var export types.UserExport
+		if err := json.NewDecoder(rr.Body).Decode(&export); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+		if export.Profile.Email != "jane@example.com" {
+			t.Errorf("Unexpected profile %+v", export.Profile)
+		}
+		if len(export.Addresses) != 1 || export.Addresses[0].City != "Lisbon" {
+			t.Errorf("Unexpected addresses %+v", export.Addresses)
+		}
+		if len(export.Orders) != exportPageSize+1 {
+			t.Fatalf("Expected %d orders, got %d", exportPageSize+1, len(export.Orders))
+		}
+		if len(export.Orders[0].Items) != 1 || export.Orders[0].Items[0].ProductName != "Mug" {
+			t.Errorf("Unexpected items %+v", export.Orders[0].Items)
+		}
+	})
+
+	t.Run("Should not schedule the deletion without the current password", func(t *testing.T) {
+		for _, payload := range []types.DeleteAccountPayload{{}, {CurrentPassword: "wrong-password"}} {
+			if rr := deleteMe(1, payload); rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+			}
+		}
+		if userStore.users["jane@example.com"].DeletionDueAt != nil {
+			t.Error("Expected no deletion to be scheduled")
+		}
+	})
+
+	t.Run("Should take a two-factor code when it is enabled", func(t *testing.T) {
+		if rr := deleteMe(4, confirmed); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d without a code, got %d", http.StatusBadRequest, rr.Code)
+		}
+		if rr := deleteMe(4, types.DeleteAccountPayload{CurrentPassword: "correct-password", Code: "000000"}); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d with a wrong code, got %d", http.StatusBadRequest, rr.Code)
+		}
+		if userStore.users["ann@example.com"].DeletionDueAt != nil {
+			t.Fatal("Expected no deletion to be scheduled")
+		}
+
+		code, _ := auth.TOTPCode(secret, time.Now())
+		if rr := deleteMe(4, types.DeleteAccountPayload{CurrentPassword: "correct-password", Code: code}); rr.Code != http.StatusAccepted {
+			t.Errorf("Expected status code %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+		}
+		if userStore.users["ann@example.com"].DeletionDueAt == nil {
+			t.Error("Expected the deletion to be scheduled")
+		}
+		CancelDeletion(context.Background(), txManager, 4)
+	})
+
+	t.Run("Should schedule the deletion after the grace period", func(t *testing.T) {
+		rr := deleteMe(1, confirmed)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("Expected status code %d, got %d", http.StatusAccepted, rr.Code)
+		}
+
+		dueAt := userStore.users["jane@example.com"].DeletionDueAt
+		if dueAt == nil || time.Until(*dueAt) < 29*24*time.Hour {
+			t.Fatalf("Expected the deletion to be due in 30 days, got %v", dueAt)
+		}
+		if _, ok := mailer.Last("jane@example.com"); !ok {
+			t.Error("Expected the user to be told by email")
+		}
+
+		if n, err := EraseDueUsers(context.Background(), txManager, time.Now()); err != nil || n != 0 {
+			t.Errorf("Expected nothing to be erased during the grace period, got %d, %v", n, err)
+		}
+
+		deleteMe(1, confirmed)
+		if again := userStore.users["jane@example.com"].DeletionDueAt; !again.Equal(*dueAt) {
+			t.Errorf("Expected asking again to keep %v, got %v", dueAt, again)
+		}
+	})
+
+	t.Run("Should cancel the deletion", func(t *testing.T) {
+		rr := authenticated(handler.handleCancelDeletion, http.MethodPost, 1)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+		if userStore.users["jane@example.com"].DeletionDueAt != nil {
+			t.Error("Expected the deletion to be cancelled")
+		}
+	})
+
+	t.Run("Should anonymize the user once the grace period ends", func(t *testing.T) {
+		deleteMe(1, confirmed)
+
+		n, err := EraseDueUsers(context.Background(), txManager, time.Now().Add(31*24*time.Hour))
+		if err != nil || n != 1 {
+			t.Fatalf("Expected 1 user to be erased, got %d, %v", n, err)
+		}
+
+		u, err := userStore.GetUserByID(1)
+		if err != nil {
+			t.Fatal("Expected the user row to be kept for their orders")
+		}
+		if u.DeletedAt == nil || u.Email == "jane@example.com" || u.FirstName == "Jane" {
+			t.Errorf("Expected the user to be anonymized, got %+v", u)
+		}
+		if len(tokenStore.signedOut) != 1 || tokenStore.signedOut[0] != 1 {
+			t.Errorf("Expected the user to be signed out everywhere, got %v", tokenStore.signedOut)
+		}
+
+		if rr := asAdmin("/users/1"); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected an erased user to be missing, got %d", rr.Code)
+		}
+	})
+
+	t.Run("Should let an admin erase a user right away", func(t *testing.T) {
+		userStore.users["jim@example.com"] = &types.User{ID: 3, FirstName: "Jim", Email: "jim@example.com"}
+
+		if rr := asAdmin("/users/3"); rr.Code != http.StatusAccepted {
+			t.Fatalf("Expected status code %d, got %d", http.StatusAccepted, rr.Code)
+		}
+		if userStore.users["jim@example.com"].DeletionDueAt == nil {
+			t.Error("Expected the deletion to be scheduled")
+		}
+
+		if rr := asAdmin("/users/3?immediate=true"); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+		if _, exists := userStore.users["jim@example.com"]; exists {
+			t.Error("Expected the user to be anonymized")
+		}
+
+		if rr := asAdmin("/users/42"); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
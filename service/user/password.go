@@ -0,0 +1,119 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/joshbarros/golang-ecommerce-api/config"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// ErrInvalidResetToken is returned for password reset tokens that are unknown,
+// expired or already used.
+var ErrInvalidResetToken = errors.New("Invalid or expired password reset token")
+
+// RequestPasswordReset emails a reset link to the user registered with email.
+// Issuing a link invalidates the previous ones. Unknown emails and service
+// accounts are silently ignored, so callers must answer the same way whatever
+// the outcome.
+func RequestPasswordReset(ctx context.Context, txManager types.TxManager, mailer types.Mailer, email string) error {
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	var user *types.User
+	err = txManager.WithTx(ctx, func(stores types.Stores) error {
+		u, err := stores.Users.GetUserByEmail(email)
+		if errors.Is(err, types.ErrUserNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if u.ServiceAccount {
+			return nil
+		}
+
+		if err := stores.UserTokens.InvalidateUserTokens(u.ID, types.UserTokenPasswordReset); err != nil {
+			return err
+		}
+
+		err = stores.UserTokens.CreateUserToken(&types.UserToken{
+			UserID:    u.ID,
+			Purpose:   types.UserTokenPasswordReset,
+			TokenHash: hash,
+			ExpiresAt: time.Now().Add(time.Second * time.Duration(config.Envs.PasswordResetTTLInSeconds)),
+		})
+		if err != nil {
+			return err
+		}
+
+		user = u
+		return nil
+	})
+	if err != nil || user == nil {
+		return err
+	}
+
+	return mailer.Send(ctx, types.Email{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nOpen the link below to choose a new password. It expires in %d minutes and can only be used once.\n\n%s?token=%s\n\nIf you did not ask to reset your password, you can ignore this email.",
+			user.FirstName, config.Envs.PasswordResetTTLInSeconds/60, config.Envs.PasswordResetURL, token,
+		),
+	})
+}
+
+// ResetPassword sets a new password for the owner of a reset token, consuming
+// the token and signing the user out of every session.
+func ResetPassword(ctx context.Context, txManager types.TxManager, token string, password string) error {
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return txManager.WithTx(ctx, func(stores types.Stores) error {
+		t, err := stores.UserTokens.GetUserTokenByHash(types.UserTokenPasswordReset, auth.HashOpaqueToken(token))
+		if errors.Is(err, types.ErrUserTokenNotFound) {
+			return ErrInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+
+		if t.UsedAt != nil || !t.ExpiresAt.After(time.Now()) {
+			return ErrInvalidResetToken
+		}
+
+		u, err := stores.Users.GetUserByID(t.UserID)
+		if err != nil {
+			return err
+		}
+		if u.ServiceAccount {
+			return ErrInvalidResetToken
+		}
+
+		err = stores.UserTokens.MarkUserTokenUsed(t.ID)
+		if errors.Is(err, types.ErrUserTokenUsed) {
+			return ErrInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := stores.Users.UpdatePassword(t.UserID, hashedPassword); err != nil {
+			return err
+		}
+
+		if err := stores.UserTokens.InvalidateUserTokens(t.UserID, types.UserTokenPasswordReset); err != nil {
+			return err
+		}
+
+		return stores.Tokens.RevokeUserRefreshTokens(t.UserID)
+	})
+}
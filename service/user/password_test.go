@@ -0,0 +1,406 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/mail"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// Mock implementation of the UserTokenStore interface
+type mockUserTokenStore struct {
+	tokens []types.UserToken
+}
+
+func (m *mockUserTokenStore) CreateUserToken(t *types.UserToken) error {
+	t.ID = len(m.tokens) + 1
+	t.CreatedAt = time.Now()
+	m.tokens = append(m.tokens, *t)
+	return nil
+}
+
+func (m *mockUserTokenStore) GetUserTokenByHash(purpose types.UserTokenPurpose, hash string) (*types.UserToken, error) {
+	for _, t := range m.tokens {
+		if t.Purpose == purpose && t.TokenHash == hash {
+			return &t, nil
+		}
+	}
+	return nil, types.ErrUserTokenNotFound
+}
+
+func (m *mockUserTokenStore) GetLatestUserToken(userID int, purpose types.UserTokenPurpose) (*types.UserToken, error) {
+	for i := len(m.tokens) - 1; i >= 0; i-- {
+		if t := m.tokens[i]; t.UserID == userID && t.Purpose == purpose {
+			return &t, nil
+		}
+	}
+	return nil, types.ErrUserTokenNotFound
+}
+
+func (m *mockUserTokenStore) MarkUserTokenUsed(id int) error {
+	if m.tokens[id-1].UsedAt != nil {
+		return types.ErrUserTokenUsed
+	}
+	now := time.Now()
+	m.tokens[id-1].UsedAt = &now
+	return nil
+}
+
+func (m *mockUserTokenStore) InvalidateUserTokens(userID int, purpose types.UserTokenPurpose) error {
+	now := time.Now()
+	for i, t := range m.tokens {
+		if t.UserID == userID && t.Purpose == purpose && t.UsedAt == nil {
+			m.tokens[i].UsedAt = &now
+		}
+	}
+	return nil
+}
+
+type mockTxManager struct {
+	users      *mockUserStore
+	tokens     *mockTokenStore
+	userTokens *mockUserTokenStore
+	twoFactor  *mockTwoFactorStore
+	addresses  *mockAddressStore
+	orders     *mockOrderStore
+}
+
+func (m *mockTxManager) WithTx(ctx context.Context, fn func(types.Stores) error) error {
+	return fn(types.Stores{
+		Users:      m.users,
+		Addresses:  m.addresses,
+		Orders:     m.orders,
+		Tokens:     m.tokens,
+		UserTokens: m.userTokens,
+		TwoFactor:  m.twoFactor,
+	})
+}
+
+var tokenPattern = regexp.MustCompile(`token=(\S+)`)
+
+// tokenFromEmail extracts the token from the link in an email.
+func tokenFromEmail(t *testing.T, email types.Email) string {
+	t.Helper()
+
+	match := tokenPattern.FindStringSubmatch(email.Body)
+	if match == nil {
+		t.Fatalf("Expected the email to contain a link with a token, got %q", email.Body)
+	}
+	return match[1]
+}
+
+// runInline stands in for Handler.async, so the reset email is sent by the
+// time the request returns.
+func runInline(work func()) {
+	work()
+}
+
+func TestPasswordReset(t *testing.T) {
+	hashedPassword, err := auth.HashPassword("old-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userStore := &mockUserStore{users: map[string]*types.User{
+		"jane@example.com": {ID: 1, FirstName: "Jane", Email: "jane@example.com", Password: hashedPassword},
+	}}
+	tokenStore := &mockTokenStore{}
+	userTokenStore := &mockUserTokenStore{}
+	mailer := mail.NewMemoryMailer()
+	txManager := &mockTxManager{users: userStore, tokens: tokenStore, userTokens: userTokenStore}
+	handler := NewHandler(userStore, tokenStore, txManager, mailer, auth.NewDefaultLoginThrottle())
+	handler.async = runInline
+	clock := &fakeClock{now: time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)}
+	handler.resetThrottle = auth.NewPasswordResetThrottle(clock)
+
+	post := func(path string, payload any) *httptest.ResponseRecorder {
+		marshalled, _ := json.Marshal(payload)
+		req, err := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		handler.RegisterRoutes(router)
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	forgot := func(address string) string {
+		t.Helper()
+
+		// Wait out the throttle from the previous request.
+		clock.now = clock.now.Add(time.Hour)
+
+		sent := len(mailer.Sent())
+		rr := post("/password/forgot", types.ForgotPasswordPayload{Email: address})
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("Expected status code %d, got %d", http.StatusAccepted, rr.Code)
+		}
+
+		if len(mailer.Sent()) == sent {
+			return ""
+		}
+
+		email, _ := mailer.Last(address)
+		return tokenFromEmail(t, email)
+	}
+
+	reset := func(token string, password string) int {
+		return post("/password/reset", types.ResetPasswordPayload{Token: token, Password: password}).Code
+	}
+
+	t.Run("Should answer the same for unknown emails", func(t *testing.T) {
+		if token := forgot("nobody@example.com"); token != "" {
+			t.Error("Expected no email to be sent")
+		}
+	})
+
+	t.Run("Should only store a hash of the token", func(t *testing.T) {
+		token := forgot("jane@example.com")
+
+		stored := userTokenStore.tokens[len(userTokenStore.tokens)-1]
+		if stored.TokenHash == token || stored.TokenHash != auth.HashOpaqueToken(token) {
+			t.Errorf("Expected the hash of the token to be stored, got %q", stored.TokenHash)
+		}
+		if _, ok := mailer.Last("jane@example.com"); !ok {
+			t.Error("Expected the email to go to the user")
+		}
+	})
+
+	t.Run("Should reset the password and sign the user out", func(t *testing.T) {
+		token := forgot("jane@example.com")
+
+		if code := reset(token, "new-password"); code != http.StatusNoContent {
+			t.Fatalf("Expected status code %d, got %d", http.StatusNoContent, code)
+		}
+
+		if !auth.ComparePasswords(userStore.users["jane@example.com"].Password, []byte("new-password")) {
+			t.Error("Expected the new password to be stored")
+		}
+		if len(tokenStore.signedOut) != 1 || tokenStore.signedOut[0] != 1 {
+			t.Errorf("Expected the user's sessions to be revoked, got %v", tokenStore.signedOut)
+		}
+	})
+
+	t.Run("Should not accept a token twice", func(t *testing.T) {
+		token := forgot("jane@example.com")
+
+		if code := reset(token, "new-password"); code != http.StatusNoContent {
+			t.Fatalf("Expected status code %d, got %d", http.StatusNoContent, code)
+		}
+		if code := reset(token, "stolen-password"); code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, code)
+		}
+	})
+
+	t.Run("Should only accept the latest token", func(t *testing.T) {
+		first := forgot("jane@example.com")
+		forgot("jane@example.com")
+
+		if code := reset(first, "new-password"); code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, code)
+		}
+	})
+
+	t.Run("Should reject expired and unknown tokens", func(t *testing.T) {
+		token := forgot("jane@example.com")
+		userTokenStore.tokens[len(userTokenStore.tokens)-1].ExpiresAt = time.Now().Add(-time.Second)
+
+		if code := reset(token, "new-password"); code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, code)
+		}
+		if code := reset("not-a-token", "new-password"); code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, code)
+		}
+	})
+}
+
+func TestForgotPasswordThrottling(t *testing.T) {
+	userStore := &mockUserStore{users: map[string]*types.User{
+		"jane@example.com": {ID: 1, FirstName: "Jane", Email: "jane@example.com"},
+	}}
+	mailer := mail.NewMemoryMailer()
+	txManager := &mockTxManager{users: userStore, tokens: &mockTokenStore{}, userTokens: &mockUserTokenStore{}}
+	handler := NewHandler(userStore, &mockTokenStore{}, txManager, mailer, auth.NewDefaultLoginThrottle())
+	handler.async = runInline
+	clock := &fakeClock{now: time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)}
+	handler.resetThrottle = auth.NewPasswordResetThrottle(clock)
+
+	forgot := func(email string, ip string) *httptest.ResponseRecorder {
+		marshalled, _ := json.Marshal(types.ForgotPasswordPayload{Email: email})
+		req := httptest.NewRequest(http.MethodPost, "/password/forgot", bytes.NewBuffer(marshalled))
+		req.RemoteAddr = ip + ":1234"
+
+		rr := httptest.NewRecorder()
+		handler.handleForgotPassword(rr, req)
+		return rr
+	}
+
+	t.Run("Should limit the links sent to an email", func(t *testing.T) {
+		if rr := forgot("jane@example.com", "192.0.2.1"); rr.Code != http.StatusAccepted {
+			t.Fatalf("Expected status code %d, got %d", http.StatusAccepted, rr.Code)
+		}
+
+		rr := forgot("JANE@example.com", "192.0.2.2")
+		if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "60" {
+			t.Fatalf("Expected to wait a minute, got status code %d and Retry-After %q", rr.Code, rr.Header().Get("Retry-After"))
+		}
+		if sent := len(mailer.Sent()); sent != 1 {
+			t.Errorf("Expected 1 email, got %d", sent)
+		}
+
+		clock.now = clock.now.Add(time.Minute)
+		if rr := forgot("jane@example.com", "192.0.2.1"); rr.Code != http.StatusAccepted {
+			t.Errorf("Expected status code %d after the wait, got %d", http.StatusAccepted, rr.Code)
+		}
+	})
+
+	t.Run("Should limit the requests from an IP", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			if rr := forgot(fmt.Sprintf("user%d@example.com", i), "198.51.100.7"); rr.Code != http.StatusAccepted {
+				t.Fatalf("Request %d: expected status code %d, got %d", i+1, http.StatusAccepted, rr.Code)
+			}
+		}
+
+		forgot("user10@example.com", "198.51.100.7")
+		if rr := forgot("user11@example.com", "198.51.100.7"); rr.Code != http.StatusTooManyRequests {
+			t.Errorf("Expected status code %d, got %d", http.StatusTooManyRequests, rr.Code)
+		}
+		if rr := forgot("user11@example.com", "198.51.100.8"); rr.Code != http.StatusAccepted {
+			t.Errorf("Expected another IP to be let through, got status code %d", rr.Code)
+		}
+	})
+}
+
+// blockingMailer holds every email until release is closed.
+type blockingMailer struct {
+	release chan struct{}
+	sent    chan types.Email
+}
+
+func (m *blockingMailer) Send(ctx context.Context, email types.Email) error {
+	<-m.release
+	m.sent <- email
+	return nil
+}
+
+func TestForgotPasswordDoesNotWaitForTheEmail(t *testing.T) {
+	userStore := &mockUserStore{users: map[string]*types.User{
+		"jane@example.com": {ID: 1, FirstName: "Jane", Email: "jane@example.com"},
+	}}
+	mailer := &blockingMailer{release: make(chan struct{}), sent: make(chan types.Email, 1)}
+	txManager := &mockTxManager{users: userStore, tokens: &mockTokenStore{}, userTokens: &mockUserTokenStore{}}
+	handler := NewHandler(userStore, &mockTokenStore{}, txManager, mailer, auth.NewDefaultLoginThrottle())
+
+	marshalled, _ := json.Marshal(types.ForgotPasswordPayload{Email: "jane@example.com"})
+	req, err := http.NewRequest(http.MethodPost, "/password/forgot", bytes.NewBuffer(marshalled))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status code %d, got %d", http.StatusAccepted, rr.Code)
+	}
+
+	close(mailer.release)
+	if email := <-mailer.sent; email.To != "jane@example.com" {
+		t.Errorf("Expected the email to go to the user, got %q", email.To)
+	}
+}
+
+func TestRequestPasswordResetReturnsStoreErrors(t *testing.T) {
+	userStore := &mockUserStore{users: map[string]*types.User{}}
+	txManager := &mockTxManager{users: userStore, userTokens: &mockUserTokenStore{}}
+	mailer := mail.NewMemoryMailer()
+
+	if err := RequestPasswordReset(context.Background(), txManager, mailer, "nobody@example.com"); err != nil {
+		t.Errorf("Expected unknown emails to be ignored, got %v", err)
+	}
+
+	userStore.err = errors.New("connection refused")
+	if err := RequestPasswordReset(context.Background(), txManager, mailer, "nobody@example.com"); err == nil {
+		t.Error("Expected the store error to be returned")
+	}
+
+	if len(mailer.Sent()) != 0 {
+		t.Errorf("Expected no email to be sent, got %d", len(mailer.Sent()))
+	}
+}
+
+func TestServiceAccountsCannotSignIn(t *testing.T) {
+	hashedPassword, err := auth.HashPassword("leaked-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userStore := &mockUserStore{users: map[string]*types.User{
+		"warehouse@example.com": {ID: 1, FirstName: "Warehouse", Email: "warehouse@example.com", Password: hashedPassword, Role: types.RoleAdmin, ServiceAccount: true},
+	}}
+	tokenStore := &mockTokenStore{}
+	userTokenStore := &mockUserTokenStore{}
+	mailer := mail.NewMemoryMailer()
+	txManager := &mockTxManager{users: userStore, tokens: tokenStore, userTokens: userTokenStore}
+	handler := NewHandler(userStore, tokenStore, txManager, mailer, auth.NewDefaultLoginThrottle())
+	handler.async = runInline
+
+	post := func(path string, payload any) *httptest.ResponseRecorder {
+		marshalled, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBuffer(marshalled))
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		handler.RegisterRoutes(router)
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Should not log in", func(t *testing.T) {
+		rr := post("/login", types.LoginUserPayload{Email: "warehouse@example.com", Password: "leaked-password"})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("Should not be sent a reset link", func(t *testing.T) {
+		if rr := post("/password/forgot", types.ForgotPasswordPayload{Email: "warehouse@example.com"}); rr.Code != http.StatusAccepted {
+			t.Fatalf("Expected status code %d, got %d", http.StatusAccepted, rr.Code)
+		}
+
+		if sent := len(mailer.Sent()); sent != 0 {
+			t.Errorf("Expected no email, got %d", sent)
+		}
+		if len(userTokenStore.tokens) != 0 {
+			t.Errorf("Expected no reset token, got %d", len(userTokenStore.tokens))
+		}
+	})
+
+	t.Run("Should not accept a reset token issued before", func(t *testing.T) {
+		token, hash, _ := auth.NewOpaqueToken()
+		userTokenStore.CreateUserToken(&types.UserToken{UserID: 1, Purpose: types.UserTokenPasswordReset, TokenHash: hash, ExpiresAt: time.Now().Add(time.Hour)})
+
+		if rr := post("/password/reset", types.ResetPasswordPayload{Token: token, Password: "new-password"}); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+		if !auth.ComparePasswords(userStore.users["warehouse@example.com"].Password, []byte("leaked-password")) {
+			t.Error("Expected the password to be left alone")
+		}
+	})
+}
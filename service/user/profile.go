@@ -0,0 +1,136 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// ErrEmailTaken is returned when changing to an email another user has.
+var ErrEmailTaken = errors.New("Email is already in use")
+
+// ErrInvalidCurrentPassword is returned when a password or email change does
+// not come with the user's current password.
+var ErrInvalidCurrentPassword = errors.New("Invalid current password")
+
+// UpdateProfile applies the fields set in the payload. Changing the email
+// takes the current password, so a stolen access token is not enough to
+// redirect password resets, and invalidates the links mailed to the old
+// address. The old address is told about the change, and
+// the new one has to be verified again, so a verification email is sent to
+// it right away.
+func UpdateProfile(ctx context.Context, txManager types.TxManager, mailer types.Mailer, userID int, payload types.UpdateProfilePayload) (*types.User, error) {
+	var user *types.User
+	var previousEmail string
+	err := txManager.WithTx(ctx, func(stores types.Stores) error {
+		u, err := stores.Users.GetUserByID(userID)
+		if err != nil {
+			return err
+		}
+
+		if payload.FirstName != nil || payload.LastName != nil {
+			firstName, lastName := u.FirstName, u.LastName
+			if payload.FirstName != nil {
+				firstName = *payload.FirstName
+			}
+			if payload.LastName != nil {
+				lastName = *payload.LastName
+			}
+
+			if err := stores.Users.UpdateProfile(u.ID, firstName, lastName); err != nil {
+				return err
+			}
+		}
+
+		if payload.Email != nil && *payload.Email != u.Email {
+			if !auth.ComparePasswords(u.Password, []byte(payload.CurrentPassword)) {
+				return ErrInvalidCurrentPassword
+			}
+
+			if other, err := stores.Users.GetUserByEmail(*payload.Email); err == nil && other.ID != u.ID {
+				return ErrEmailTaken
+			}
+
+			previousEmail = u.Email
+			if err := stores.Users.UpdateEmail(u.ID, *payload.Email); err != nil {
+				return err
+			}
+
+			// Links already mailed to the old address stop working with it.
+			for _, purpose := range []types.UserTokenPurpose{types.UserTokenPasswordReset, types.UserTokenEmailVerification} {
+				if err := stores.UserTokens.InvalidateUserTokens(u.ID, purpose); err != nil {
+					return err
+				}
+			}
+		}
+
+		user, err = stores.Users.GetUserByID(u.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The email is changed either way; the user can ask for another link.
+	if previousEmail != "" {
+		err := mailer.Send(ctx, types.Email{
+			To:      previousEmail,
+			Subject: "Your email was changed",
+			Body: fmt.Sprintf(
+				"Hi %s,\n\nThe email of your account was changed to %s. If you did not change it, please contact us right away.",
+				user.FirstName, user.Email,
+			),
+		})
+		if err != nil {
+			log.Printf("Failed to notify %s of an email change: %v", previousEmail, err)
+		}
+
+		if err := sendVerificationEmail(ctx, txManager, mailer, user.ID, false); err != nil {
+			log.Printf("Failed to send a verification email: %v", err)
+		}
+	}
+
+	return user, nil
+}
+
+// ChangePassword sets a new password once the current one is confirmed. Like
+// a reset, it signs the user out everywhere, and returns fresh tokens for the
+// session that made the change.
+func ChangePassword(ctx context.Context, txManager types.TxManager, userID int, currentPassword string, newPassword string) (*types.TokenPair, error) {
+	hashedPassword, err := auth.HashPassword(newPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens *types.TokenPair
+	err = txManager.WithTx(ctx, func(stores types.Stores) error {
+		u, err := stores.Users.GetUserByID(userID)
+		if err != nil {
+			return err
+		}
+
+		if !auth.ComparePasswords(u.Password, []byte(currentPassword)) {
+			return ErrInvalidCurrentPassword
+		}
+
+		if err := stores.Users.UpdatePassword(u.ID, hashedPassword); err != nil {
+			return err
+		}
+
+		if err := stores.Tokens.RevokeUserRefreshTokens(u.ID); err != nil {
+			return err
+		}
+
+		tokens, err = auth.IssueTokens(stores.Tokens, u, "")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
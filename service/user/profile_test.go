@@ -0,0 +1,196 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joshbarros/golang-ecommerce-api/config"
+	"github.com/joshbarros/golang-ecommerce-api/mail"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// failingMailer fails to send every email.
+type failingMailer struct{}
+
+func (failingMailer) Send(ctx context.Context, email types.Email) error {
+	return errors.New("mail server unavailable")
+}
+
+func TestProfile(t *testing.T) {
+	config.Envs.EmailVerificationResendIntervalInSeconds = 60
+
+	hashedPassword, err := auth.HashPassword("correct-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifiedAt := time.Now()
+	userStore := &mockUserStore{users: map[string]*types.User{
+		"jane@example.com": {ID: 1, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Password: hashedPassword, VerifiedAt: &verifiedAt},
+		"john@example.com": {ID: 2, FirstName: "John", Email: "john@example.com"},
+	}}
+	tokenStore := &mockTokenStore{}
+	mailer := mail.NewMemoryMailer()
+	userTokenStore := &mockUserTokenStore{}
+	txManager := &mockTxManager{users: userStore, tokens: tokenStore, userTokens: userTokenStore}
+	handler := NewHandler(userStore, tokenStore, txManager, mailer, auth.NewDefaultLoginThrottle())
+
+	authenticated := func(h http.HandlerFunc, method string, payload any) *httptest.ResponseRecorder {
+		marshalled, _ := json.Marshal(payload)
+		req := httptest.NewRequest(method, "/me", bytes.NewBuffer(marshalled))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserKey, 1))
+
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	name := func(s string) *string { return &s }
+
+	t.Run("Should return the current user", func(t *testing.T) {
+		rr := authenticated(handler.handleGetMe, http.MethodGet, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var u types.User
+		if err := json.NewDecoder(rr.Body).Decode(&u); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+		if u.ID != 1 || u.Email != "jane@example.com" {
+			t.Errorf("Unexpected user %+v", u)
+		}
+		if bytes.Contains(rr.Body.Bytes(), []byte(hashedPassword)) {
+			t.Error("Expected the password hash to be left out")
+		}
+	})
+
+	t.Run("Should only update the given fields", func(t *testing.T) {
+		rr := authenticated(handler.handleUpdateMe, http.MethodPatch, types.UpdateProfilePayload{FirstName: name("Janet")})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		u := userStore.users["jane@example.com"]
+		if u.FirstName != "Janet" || u.LastName != "Doe" {
+			t.Errorf("Expected Janet Doe, got %s %s", u.FirstName, u.LastName)
+		}
+		if u.VerifiedAt == nil {
+			t.Error("Expected the email to stay verified")
+		}
+		if sent := len(mailer.Sent()); sent != 0 {
+			t.Errorf("Expected no email, got %d", sent)
+		}
+	})
+
+	t.Run("Should fail if the payload is invalid", func(t *testing.T) {
+		rr := authenticated(handler.handleUpdateMe, http.MethodPatch, types.UpdateProfilePayload{Email: name("not-an-email")})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("Should fail to change the email without the current password", func(t *testing.T) {
+		for _, password := range []string{"", "wrong-password"} {
+			rr := authenticated(handler.handleUpdateMe, http.MethodPatch, types.UpdateProfilePayload{Email: name("mallory@example.com"), CurrentPassword: password})
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+			}
+		}
+
+		if _, ok := userStore.users["jane@example.com"]; !ok {
+			t.Error("Expected the email to be kept")
+		}
+		if sent := len(mailer.Sent()); sent != 0 {
+			t.Errorf("Expected no email, got %d", sent)
+		}
+	})
+
+	t.Run("Should fail if the email belongs to another user", func(t *testing.T) {
+		rr := authenticated(handler.handleUpdateMe, http.MethodPatch, types.UpdateProfilePayload{Email: name("john@example.com"), CurrentPassword: "correct-password"})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+		if userStore.users["john@example.com"].ID != 2 {
+			t.Error("Expected the other user to keep their email")
+		}
+	})
+
+	t.Run("Should require verifying a new email and notify the old one", func(t *testing.T) {
+		previous := "jane@example.com"
+		for _, email := range []string{"jane@example.net", "jane@example.org"} {
+			rr := authenticated(handler.handleUpdateMe, http.MethodPatch, types.UpdateProfilePayload{Email: name(email), CurrentPassword: "correct-password"})
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+			}
+
+			u, ok := userStore.users[email]
+			if !ok {
+				t.Fatalf("Expected the email to change to %s", email)
+			}
+			if u.VerifiedAt != nil {
+				t.Error("Expected the new email to be unverified")
+			}
+			if _, ok := mailer.Last(email); !ok {
+				t.Errorf("Expected a verification email to %s", email)
+			}
+			if notice, ok := mailer.Last(previous); !ok || !strings.Contains(notice.Body, email) {
+				t.Errorf("Expected %s to be told about the change, got %q", previous, notice.Body)
+			}
+			previous = email
+		}
+	})
+
+	t.Run("Should invalidate the links mailed to the old email", func(t *testing.T) {
+		userTokenStore.CreateUserToken(&types.UserToken{UserID: 1, Purpose: types.UserTokenPasswordReset, TokenHash: "reset", ExpiresAt: time.Now().Add(time.Hour)})
+		mailed := len(userTokenStore.tokens)
+		failing := NewHandler(userStore, tokenStore, txManager, failingMailer{}, auth.NewDefaultLoginThrottle())
+
+		rr := authenticated(failing.handleUpdateMe, http.MethodPatch, types.UpdateProfilePayload{Email: name("jane@example.info"), CurrentPassword: "correct-password"})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		for _, token := range userTokenStore.tokens[:mailed] {
+			if token.UserID == 1 && token.UsedAt == nil {
+				t.Errorf("Expected the %s token to be invalidated, got %+v", token.Purpose, token)
+			}
+		}
+	})
+
+	t.Run("Should fail to change the password without the current one", func(t *testing.T) {
+		rr := authenticated(handler.handleChangePassword, http.MethodPost, types.ChangePasswordPayload{CurrentPassword: "wrong-password", NewPassword: "new-password"})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+		if len(tokenStore.signedOut) != 0 {
+			t.Errorf("Expected no one to be signed out, got %v", tokenStore.signedOut)
+		}
+	})
+
+	t.Run("Should change the password and sign out other sessions", func(t *testing.T) {
+		rr := authenticated(handler.handleChangePassword, http.MethodPost, types.ChangePasswordPayload{CurrentPassword: "correct-password", NewPassword: "new-password"})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var tokens types.TokenPair
+		if err := json.NewDecoder(rr.Body).Decode(&tokens); err != nil || tokens.Token == "" {
+			t.Errorf("Expected tokens, got %s", rr.Body.String())
+		}
+		if !auth.ComparePasswords(userStore.users["jane@example.info"].Password, []byte("new-password")) {
+			t.Error("Expected the password to change")
+		}
+		if len(tokenStore.signedOut) != 1 {
+			t.Errorf("Expected the user to be signed out everywhere, got %v", tokenStore.signedOut)
+		}
+	})
+}
@@ -1,28 +1,62 @@
 package user
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
-	"github.com/joshbarros/golang-ecommerce-api/config"
 	"github.com/joshbarros/golang-ecommerce-api/service/auth"
 	"github.com/joshbarros/golang-ecommerce-api/types"
 	"github.com/joshbarros/golang-ecommerce-api/utils"
 )
 
 type Handler struct {
-	store types.UserStore
+	store      types.UserStore
+	tokenStore types.TokenStore
+	txManager  types.TxManager
+	mailer     types.Mailer
+	throttle   *auth.LoginThrottle
+
+	// resetThrottle counts password reset requests. Tests swap it for one
+	// with a fake clock.
+	resetThrottle *auth.LoginThrottle
+
+	// async runs work that finishes after the response is written. Tests swap
+	// it to run the work before returning.
+	async func(work func())
 }
 
-func NewHandler(store types.UserStore) *Handler {
-	return &Handler{store: store}
+func NewHandler(store types.UserStore, tokenStore types.TokenStore, txManager types.TxManager, mailer types.Mailer, throttle *auth.LoginThrottle) *Handler {
+	return &Handler{store: store, tokenStore: tokenStore, txManager: txManager, mailer: mailer, throttle: throttle, resetThrottle: auth.NewDefaultPasswordResetThrottle(), async: goAsync}
+}
+
+func goAsync(work func()) {
+	go work()
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/login", h.handleLogin).Methods("POST")
+	router.HandleFunc("/login/2fa", h.handleTwoFactorLogin).Methods("POST")
 	router.HandleFunc("/register", h.handleRegister).Methods("POST")
+	router.HandleFunc("/password/forgot", h.handleForgotPassword).Methods("POST")
+	router.HandleFunc("/password/reset", h.handleResetPassword).Methods("POST")
+	router.HandleFunc("/verify-email", h.handleVerifyEmail).Methods("GET")
+	router.HandleFunc("/verify-email/resend", auth.WithJWTAuth(h.handleResendVerification, h.store, h.tokenStore)).Methods("POST")
+	router.HandleFunc("/me", auth.WithJWTAuth(h.handleGetMe, h.store, h.tokenStore)).Methods("GET")
+	router.HandleFunc("/me", auth.WithJWTAuth(h.handleUpdateMe, h.store, h.tokenStore)).Methods("PATCH")
+	router.HandleFunc("/me", auth.WithJWTAuth(h.handleDeleteMe, h.store, h.tokenStore)).Methods("DELETE")
+	router.HandleFunc("/me/deletion/cancel", auth.WithJWTAuth(h.handleCancelDeletion, h.store, h.tokenStore)).Methods("POST")
+	router.HandleFunc("/me/export", auth.WithJWTAuth(h.handleExport, h.store, h.tokenStore)).Methods("GET")
+	router.HandleFunc("/me/password", auth.WithJWTAuth(h.handleChangePassword, h.store, h.tokenStore)).Methods("POST")
+	router.HandleFunc("/me/2fa/setup", auth.WithJWTAuth(h.handleTwoFactorSetup, h.store, h.tokenStore)).Methods("POST")
+	router.HandleFunc("/me/2fa/confirm", auth.WithJWTAuth(h.handleTwoFactorConfirm, h.store, h.tokenStore)).Methods("POST")
+	router.HandleFunc("/users/{id}", auth.WithJWTAuth(auth.RequireRole(h.handleDeleteUser, types.RoleAdmin), h.store, h.tokenStore)).Methods("DELETE")
 }
 
 func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
@@ -38,25 +72,51 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := utils.ClientIP(r)
+	if wait := h.throttle.Wait(payload.Email, ip); wait > 0 {
+		utils.WriteTooManyRequests(w, wait, fmt.Errorf("Too many failed logins, try again later"))
+		return
+	}
+
 	u, err := h.store.GetUserByEmail(payload.Email)
-	if err != nil {
+	if err != nil || u.ServiceAccount {
+		// Spend as long as for a wrong password, so the response time does
+		// not tell whether the email is registered.
+		auth.CompareDummyPassword([]byte(payload.Password))
+		h.throttle.Fail(payload.Email, ip)
 		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid Email or Password"))
 		return
 	}
 
 	if !auth.ComparePasswords(u.Password, []byte(payload.Password)) {
+		h.throttle.Fail(payload.Email, ip)
 		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid Email or Password"))
 		return
 	}
 
-	secret := []byte(config.Envs.JWTSecret)
-	token, err := auth.CreateJWT(secret, u.ID)
+	// With two-factor authentication the failures are only forgotten once the
+	// second factor is given too, or logging in again would reset the
+	// account's backoff between guesses at the code.
+	if u.TwoFactorEnabledAt != nil {
+		challenge, err := StartTwoFactorLogin(r.Context(), h.txManager, u.ID)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, challenge)
+		return
+	}
+
+	h.throttle.Succeed(payload.Email)
+
+	tokens, err := auth.IssueTokens(h.tokenStore, u, "")
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, map[string]string{"token": token})
+	utils.WriteJSON(w, http.StatusOK, tokens)
 }
 
 func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
@@ -89,6 +149,7 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 		LastName:  payload.LastName,
 		Email:     payload.Email,
 		Password:  hashedPassword,
+		Role:      types.RoleCustomer,
 	})
 
 	if err != nil {
@@ -96,5 +157,439 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The account exists either way; the user can ask for another email.
+	if u, err := h.store.GetUserByEmail(payload.Email); err != nil {
+		log.Printf("Failed to send a verification email: %v", err)
+	} else if err := SendVerificationEmail(r.Context(), h.txManager, h.mailer, u.ID); err != nil {
+		log.Printf("Failed to send a verification email: %v", err)
+	}
+
 	utils.WriteJSON(w, http.StatusCreated, nil)
 }
+
+// handleForgotPassword answers 202 whether or not the email is registered, so
+// it cannot be used to find out which accounts exist. The reset link is
+// issued and emailed in the background, so the response does not take longer
+// for registered emails either. Requests are throttled per email and per IP.
+func (h *Handler) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var payload types.ForgotPasswordPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid payload %v", errors))
+		return
+	}
+
+	ip := utils.ClientIP(r)
+	if wait := h.resetThrottle.Wait(payload.Email, ip); wait > 0 {
+		utils.WriteTooManyRequests(w, wait, fmt.Errorf("Too many password reset requests, try again later"))
+		return
+	}
+
+	// Every request counts, whether or not the email is registered, so the
+	// throttle does not tell which accounts exist either.
+	h.resetThrottle.Fail(payload.Email, ip)
+
+	ctx := context.WithoutCancel(r.Context())
+	h.async(func() {
+		if err := RequestPasswordReset(ctx, h.txManager, h.mailer, payload.Email); err != nil {
+			log.Printf("Failed to send a password reset email: %v", err)
+		}
+	})
+
+	utils.WriteJSON(w, http.StatusAccepted, nil)
+}
+
+func (h *Handler) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var payload types.ResetPasswordPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid payload %v", errors))
+		return
+	}
+
+	err := ResetPassword(r.Context(), h.txManager, payload.Token, payload.Password)
+	if errors.Is(err, ErrInvalidResetToken) {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Missing token"))
+		return
+	}
+
+	err := VerifyEmail(r.Context(), h.txManager, token)
+	if errors.Is(err, ErrInvalidVerificationToken) {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "Email verified"})
+}
+
+func (h *Handler) handleResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	var throttled *ThrottledError
+
+	err := SendVerificationEmail(r.Context(), h.txManager, h.mailer, userID)
+	switch {
+	case errors.As(err, &throttled):
+		utils.WriteTooManyRequests(w, throttled.RetryAfter, err)
+		return
+	case errors.Is(err, ErrEmailAlreadyVerified):
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	case err != nil:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, nil)
+}
+
+func (h *Handler) handleGetMe(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	u, err := h.store.GetUserByID(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, u)
+}
+
+// handleUpdateMe counts wrong current passwords given with a new email as
+// failed logins, like handleChangePassword.
+func (h *Handler) handleUpdateMe(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	var payload types.UpdateProfilePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid payload %v", errors))
+		return
+	}
+
+	u, err := h.store.GetUserByID(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	ip := utils.ClientIP(r)
+	if payload.Email != nil {
+		if wait := h.throttle.Wait(u.Email, ip); wait > 0 {
+			utils.WriteTooManyRequests(w, wait, fmt.Errorf("Too many failed attempts, try again later"))
+			return
+		}
+	}
+
+	updated, err := UpdateProfile(r.Context(), h.txManager, h.mailer, userID, payload)
+	if errors.Is(err, ErrInvalidCurrentPassword) {
+		h.throttle.Fail(u.Email, ip)
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if errors.Is(err, ErrEmailTaken) {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("User with email %s already exists", *payload.Email))
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, updated)
+}
+
+// handleChangePassword counts wrong current passwords as failed logins, so a
+// stolen access token cannot be used to guess the password.
+func (h *Handler) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	var payload types.ChangePasswordPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid payload %v", errors))
+		return
+	}
+
+	u, err := h.store.GetUserByID(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	ip := utils.ClientIP(r)
+	if wait := h.throttle.Wait(u.Email, ip); wait > 0 {
+		utils.WriteTooManyRequests(w, wait, fmt.Errorf("Too many failed attempts, try again later"))
+		return
+	}
+
+	tokens, err := ChangePassword(r.Context(), h.txManager, userID, payload.CurrentPassword, payload.NewPassword)
+	if errors.Is(err, ErrInvalidCurrentPassword) {
+		h.throttle.Fail(u.Email, ip)
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.throttle.Succeed(u.Email)
+	utils.WriteJSON(w, http.StatusOK, tokens)
+}
+
+func (h *Handler) handleExport(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	export, err := ExportUser(r.Context(), h.txManager, userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-export.json"`, userID))
+	utils.WriteJSON(w, http.StatusOK, export)
+}
+
+// handleDeleteMe schedules the account for erasure. The user stays logged in
+// so they can still export their data or cancel until the grace period ends.
+// handleDeleteMe counts a wrong password or code as a failed login, like
+// handleChangePassword.
+func (h *Handler) handleDeleteMe(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	var payload types.DeleteAccountPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid payload %v", errors))
+		return
+	}
+
+	u, err := h.store.GetUserByID(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	ip := utils.ClientIP(r)
+	if wait := h.throttle.Wait(u.Email, ip); wait > 0 {
+		utils.WriteTooManyRequests(w, wait, fmt.Errorf("Too many failed attempts, try again later"))
+		return
+	}
+
+	scheduled, err := RequestDeletion(r.Context(), h.txManager, h.mailer, userID, payload)
+	if errors.Is(err, ErrInvalidCurrentPassword) || errors.Is(err, ErrInvalidTwoFactorCode) {
+		h.throttle.Fail(u.Email, ip)
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, scheduled)
+}
+
+func (h *Handler) handleCancelDeletion(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	if err := CancelDeletion(r.Context(), h.txManager, userID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDeleteUser lets an admin act on an erasure request received another
+// way. It schedules the deletion like the user would, or with
+// ?immediate=true erases the account right away.
+func (h *Handler) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid user ID"))
+		return
+	}
+
+	u, err := h.store.GetUserByID(userID)
+	if err != nil || u.DeletedAt != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("User not found"))
+		return
+	}
+
+	if r.URL.Query().Get("immediate") == "true" {
+		if err := EraseUser(r.Context(), h.txManager, u.ID); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	u, err = ScheduleDeletion(r.Context(), h.txManager, h.mailer, u.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, u)
+}
+
+// handleTwoFactorLogin completes a login started by handleLogin for users with
+// two-factor authentication. Wrong codes count as failed logins.
+func (h *Handler) handleTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	var payload types.TwoFactorLoginPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid payload %v", errors))
+		return
+	}
+
+	ip := utils.ClientIP(r)
+
+	var tokens *types.TokenPair
+	var email string
+	var wait time.Duration
+	err := h.txManager.WithTx(r.Context(), func(stores types.Stores) error {
+		u, challenge, err := GetTwoFactorLoginUser(stores, payload.TwoFactorToken)
+		if err != nil {
+			return err
+		}
+
+		email = u.Email
+		if wait = h.throttle.Wait(u.Email, ip); wait > 0 {
+			return nil
+		}
+
+		if err := VerifySecondFactor(stores, u, payload.Code, payload.RecoveryCode); err != nil {
+			return err
+		}
+
+		err = stores.UserTokens.MarkUserTokenUsed(challenge.ID)
+		if errors.Is(err, types.ErrUserTokenUsed) {
+			return ErrInvalidTwoFactorToken
+		}
+		if err != nil {
+			return err
+		}
+
+		tokens, err = auth.IssueTokens(stores.Tokens, u, "")
+		return err
+	})
+
+	switch {
+	case wait > 0:
+		utils.WriteTooManyRequests(w, wait, fmt.Errorf("Too many failed logins, try again later"))
+		return
+	case errors.Is(err, ErrInvalidTwoFactorCode):
+		h.throttle.Fail(email, ip)
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	case errors.Is(err, ErrInvalidTwoFactorToken):
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	case err != nil:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.throttle.Succeed(email)
+	utils.WriteJSON(w, http.StatusOK, tokens)
+}
+
+func (h *Handler) handleTwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	setup, err := SetupTwoFactor(r.Context(), h.txManager, userID)
+	if errors.Is(err, ErrTwoFactorAlreadyEnabled) {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, setup)
+}
+
+func (h *Handler) handleTwoFactorConfirm(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	var payload types.TwoFactorConfirmPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid payload %v", errors))
+		return
+	}
+
+	codes, err := ConfirmTwoFactor(r.Context(), h.txManager, userID, payload.Code)
+	switch {
+	case errors.Is(err, ErrTwoFactorAlreadyEnabled):
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	case errors.Is(err, ErrTwoFactorNotStarted), errors.Is(err, ErrInvalidTwoFactorCode):
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, codes)
+}
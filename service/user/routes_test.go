@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/mail"
 	"github.com/joshbarros/golang-ecommerce-api/service/auth"
 	"github.com/joshbarros/golang-ecommerce-api/types"
 )
@@ -16,13 +19,17 @@ import (
 // Mock implementation of UserStore with in-memory data
 type mockUserStore struct {
 	users map[string]*types.User
+	err   error
 }
 
 func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
 	if user, exists := m.users[email]; exists {
 		return user, nil
 	}
-	return nil, errors.New("user not found")
+	return nil, types.ErrUserNotFound
 }
 
 func (m *mockUserStore) GetUserByID(id int) (*types.User, error) {
@@ -31,7 +38,7 @@ func (m *mockUserStore) GetUserByID(id int) (*types.User, error) {
 			return user, nil
 		}
 	}
-	return nil, errors.New("user not found")
+	return nil, types.ErrUserNotFound
 }
 
 func (m *mockUserStore) CreateUser(user types.User) error {
@@ -41,13 +48,159 @@ func (m *mockUserStore) CreateUser(user types.User) error {
 	if m.users == nil {
 		m.users = make(map[string]*types.User)
 	}
+	user.ID = len(m.users) + 1
 	m.users[user.Email] = &user
 	return nil
 }
 
+func (m *mockUserStore) UpdateUserRole(userID int, role types.Role) error {
+	for _, user := range m.users {
+		if user.ID == userID {
+			user.Role = role
+			return nil
+		}
+	}
+	return errors.New("user not found")
+}
+
+func (m *mockUserStore) UpdatePassword(userID int, password string) error {
+	for _, user := range m.users {
+		if user.ID == userID {
+			user.Password = password
+			return nil
+		}
+	}
+	return errors.New("user not found")
+}
+
+func (m *mockUserStore) MarkUserVerified(userID int) error {
+	for _, user := range m.users {
+		if user.ID == userID {
+			now := time.Now()
+			user.VerifiedAt = &now
+			return nil
+		}
+	}
+	return errors.New("user not found")
+}
+
+func (m *mockUserStore) UpdateProfile(userID int, firstName string, lastName string) error {
+	for _, user := range m.users {
+		if user.ID == userID {
+			user.FirstName = firstName
+			user.LastName = lastName
+			return nil
+		}
+	}
+	return errors.New("user not found")
+}
+
+func (m *mockUserStore) UpdateEmail(userID int, email string) error {
+	for old, user := range m.users {
+		if user.ID == userID {
+			delete(m.users, old)
+			user.Email = email
+			user.VerifiedAt = nil
+			m.users[email] = user
+			return nil
+		}
+	}
+	return errors.New("user not found")
+}
+
+func (m *mockUserStore) ScheduleDeletion(userID int, dueAt time.Time) error {
+	user, err := m.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	user.DeletionDueAt = &dueAt
+	return nil
+}
+
+func (m *mockUserStore) CancelDeletion(userID int) error {
+	user, err := m.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	user.DeletionDueAt = nil
+	return nil
+}
+
+func (m *mockUserStore) GetUsersDueForDeletion(now time.Time) ([]types.User, error) {
+	due := []types.User{}
+	for _, user := range m.users {
+		if user.DeletionDueAt != nil && !user.DeletionDueAt.After(now) && user.DeletedAt == nil {
+			due = append(due, *user)
+		}
+	}
+	return due, nil
+}
+
+func (m *mockUserStore) AnonymizeUser(userID int) error {
+	for old, user := range m.users {
+		if user.ID == userID {
+			now := time.Now()
+			delete(m.users, old)
+			user.FirstName, user.LastName = "Deleted", "User"
+			user.Email = fmt.Sprintf("deleted-%d@invalid", user.ID)
+			user.Password = ""
+			user.DeletionDueAt = nil
+			user.DeletedAt = &now
+			m.users[user.Email] = user
+			return nil
+		}
+	}
+	return errors.New("user not found")
+}
+
+// Mock implementation of the TokenStore interface, only recording refresh
+// tokens and the users signed out everywhere
+type mockTokenStore struct {
+	refreshTokens []types.RefreshToken
+	signedOut     []int
+}
+
+func (m *mockTokenStore) CreateRefreshToken(t *types.RefreshToken) error {
+	t.ID = len(m.refreshTokens) + 1
+	m.refreshTokens = append(m.refreshTokens, *t)
+	return nil
+}
+
+func (m *mockTokenStore) GetRefreshTokenByHash(hash string) (*types.RefreshToken, error) {
+	return nil, types.ErrRefreshTokenNotFound
+}
+
+func (m *mockTokenStore) GetRefreshTokenByAccessTokenID(jti string) (*types.RefreshToken, error) {
+	return nil, types.ErrRefreshTokenNotFound
+}
+
+func (m *mockTokenStore) MarkRefreshTokenUsed(id int) error {
+	return nil
+}
+
+func (m *mockTokenStore) RevokeRefreshTokenFamily(familyID string) error {
+	return nil
+}
+
+func (m *mockTokenStore) RevokeUserRefreshTokens(userID int) error {
+	m.signedOut = append(m.signedOut, userID)
+	return nil
+}
+
+func (m *mockTokenStore) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	return nil
+}
+
+func (m *mockTokenStore) IsAccessTokenRevoked(jti string) (bool, error) {
+	return false, nil
+}
+
 func TestUserServiceHandlers(t *testing.T) {
 	userStore := &mockUserStore{}
-	handler := NewHandler(userStore)
+	tokenStore := &mockTokenStore{}
+	mailer := mail.NewMemoryMailer()
+	txManager := &mockTxManager{users: userStore, tokens: tokenStore, userTokens: &mockUserTokenStore{}}
+	handler := NewHandler(userStore, tokenStore, txManager, mailer, auth.NewDefaultLoginThrottle())
 
 	t.Run("Should fail if the payload is invalid", func(t *testing.T) {
 		payload := types.RegisterUserPayload{
@@ -99,7 +252,19 @@ func TestUserServiceHandlers(t *testing.T) {
 		}
 
 		if _, exists := userStore.users[payload.Email]; !exists {
-			t.Errorf("Expected user to be created in the store")
+			t.Fatalf("Expected user to be created in the store")
+		}
+
+		if role := userStore.users[payload.Email].Role; role != types.RoleCustomer {
+			t.Errorf("Expected new users to be customers, got %q", role)
+		}
+
+		if userStore.users[payload.Email].VerifiedAt != nil {
+			t.Error("Expected new users to be unverified")
+		}
+
+		if _, ok := mailer.Last(payload.Email); !ok {
+			t.Error("Expected a verification email to be sent")
 		}
 	})
 
@@ -224,14 +389,91 @@ func TestUserServiceHandlers(t *testing.T) {
 			t.Errorf("Expected status code %d, got %d", http.StatusOK, rr.Code)
 		}
 
-		var response map[string]string
+		var response types.TokenPair
 		err = json.NewDecoder(rr.Body).Decode(&response)
 		if err != nil {
 			t.Fatal("Failed to decode JSON response")
 		}
 
-		if _, ok := response["token"]; !ok {
+		if response.Token == "" {
 			t.Error("Expected a JWT token in the response")
 		}
+
+		if response.RefreshToken == "" || len(tokenStore.refreshTokens) != 1 {
+			t.Error("Expected a refresh token to be issued and stored")
+		}
+	})
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestLoginThrottling(t *testing.T) {
+	hashedPassword, err := auth.HashPassword("correct-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userStore := &mockUserStore{users: map[string]*types.User{
+		"jane@example.com": {ID: 1, Email: "jane@example.com", Password: hashedPassword, Role: types.RoleCustomer},
+	}}
+	clock := &fakeClock{now: time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)}
+	throttle := auth.NewLoginThrottle(clock,
+		auth.ThrottlePolicy{FreeAttempts: 2, BaseDelay: time.Second, LockoutAfter: 4, LockoutDuration: 10 * time.Minute},
+		auth.ThrottlePolicy{FreeAttempts: 2, BaseDelay: time.Second, LockoutAfter: 100, LockoutDuration: 10 * time.Minute},
+	)
+	handler := NewHandler(userStore, &mockTokenStore{}, nil, nil, throttle)
+
+	login := func(email string, password string) *httptest.ResponseRecorder {
+		marshalled, _ := json.Marshal(types.LoginUserPayload{Email: email, Password: password})
+		req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(marshalled))
+
+		rr := httptest.NewRecorder()
+		handler.handleLogin(rr, req)
+		return rr
+	}
+
+	t.Run("Should back off and then lock the account", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			if rr := login("jane@example.com", "wrong-password"); rr.Code != http.StatusBadRequest {
+				t.Fatalf("Attempt %d: expected status code %d, got %d", i+1, http.StatusBadRequest, rr.Code)
+			}
+		}
+
+		rr := login("jane@example.com", "correct-password")
+		if rr.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected status code %d, got %d", http.StatusTooManyRequests, rr.Code)
+		}
+		if retryAfter := rr.Header().Get("Retry-After"); retryAfter != "1" {
+			t.Errorf("Expected Retry-After 1, got %q", retryAfter)
+		}
+
+		clock.now = clock.now.Add(time.Second)
+		login("jane@example.com", "wrong-password")
+
+		rr = login("jane@example.com", "correct-password")
+		if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "600" {
+			t.Fatalf("Expected a 10 minute lockout, got status code %d and Retry-After %q", rr.Code, rr.Header().Get("Retry-After"))
+		}
+
+		clock.now = clock.now.Add(10 * time.Minute)
+		if rr := login("jane@example.com", "correct-password"); rr.Code != http.StatusOK {
+			t.Errorf("Expected login to work after the lockout, got status code %d", rr.Code)
+		}
+	})
+
+	t.Run("Should count failures for unknown emails", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			login("nobody@example.com", "wrong-password")
+		}
+
+		if rr := login("nobody@example.com", "wrong-password"); rr.Code != http.StatusTooManyRequests {
+			t.Errorf("Expected status code %d, got %d", http.StatusTooManyRequests, rr.Code)
+		}
 	})
 }
@@ -0,0 +1,45 @@
+package user
+
+import (
+	"fmt"
+
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+	"github.com/joshbarros/golang-ecommerce-api/utils"
+)
+
+// CreateAdmin bootstraps an administrator. A user that already exists with the
+// payload's email is promoted and keeps their password; otherwise a new admin
+// account is created.
+func CreateAdmin(store types.UserStore, payload types.RegisterUserPayload) (*types.User, error) {
+	if u, err := store.GetUserByEmail(payload.Email); err == nil {
+		if err := store.UpdateUserRole(u.ID, types.RoleAdmin); err != nil {
+			return nil, err
+		}
+
+		u.Role = types.RoleAdmin
+		return u, nil
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		return nil, fmt.Errorf("Invalid payload %v", err)
+	}
+
+	hashedPassword, err := auth.HashPassword(payload.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.CreateUser(types.User{
+		FirstName: payload.FirstName,
+		LastName:  payload.LastName,
+		Email:     payload.Email,
+		Password:  hashedPassword,
+		Role:      types.RoleAdmin,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return store.GetUserByEmail(payload.Email)
+}
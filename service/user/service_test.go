@@ -0,0 +1,63 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+func TestCreateAdmin(t *testing.T) {
+	payload := types.RegisterUserPayload{
+		FirstName: "Ada",
+		LastName:  "Admin",
+		Email:     "admin@example.com",
+		Password:  "s3cret-password",
+	}
+
+	t.Run("Should create a new admin", func(t *testing.T) {
+		store := &mockUserStore{}
+
+		u, err := CreateAdmin(store, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if u.Role != types.RoleAdmin || store.users[payload.Email].Role != types.RoleAdmin {
+			t.Errorf("Expected the user to be an admin, got %q", u.Role)
+		}
+		if !auth.ComparePasswords(store.users[payload.Email].Password, []byte(payload.Password)) {
+			t.Error("Expected the password to be hashed and stored")
+		}
+	})
+
+	t.Run("Should promote an existing user and keep their password", func(t *testing.T) {
+		store := &mockUserStore{users: map[string]*types.User{
+			payload.Email: {ID: 7, Email: payload.Email, Password: "existing-hash", Role: types.RoleCustomer},
+		}}
+
+		withoutPassword := payload
+		withoutPassword.Password = ""
+
+		u, err := CreateAdmin(store, withoutPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if u.ID != 7 || store.users[payload.Email].Role != types.RoleAdmin {
+			t.Errorf("Expected user 7 to be promoted, got %+v", store.users[payload.Email])
+		}
+		if store.users[payload.Email].Password != "existing-hash" {
+			t.Error("Expected the password to be left untouched")
+		}
+	})
+
+	t.Run("Should require a password for a new admin", func(t *testing.T) {
+		withoutPassword := payload
+		withoutPassword.Password = ""
+
+		if _, err := CreateAdmin(&mockUserStore{}, withoutPassword); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+}
@@ -2,24 +2,29 @@ package user
 
 import (
 	"database/sql"
-	"fmt"
+	"time"
 
+	"github.com/joshbarros/golang-ecommerce-api/db"
 	"github.com/joshbarros/golang-ecommerce-api/types"
 )
 
 type Store struct {
-	db *sql.DB
+	db db.DBTX
 }
 
-func NewStore(db *sql.DB) *Store {
+func NewStore(db db.DBTX) *Store {
 	return &Store{db: db}
 }
 
+// userColumns lists the columns read by scanRowIntoUser, in order.
+const userColumns = "id, firstName, lastName, email, password, role, serviceAccount, verifiedAt, totpSecret, twoFactorEnabledAt, deletionDueAt, deletedAt, createdAt"
+
 func (s *Store) GetUserByEmail(email string) (*types.User, error) {
-	rows, err := s.db.Query("SELECT * FROM users WHERE email = ?", email)
+	rows, err := s.db.Query("SELECT "+userColumns+" FROM users WHERE email = ?", email)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	u := new(types.User)
 	for rows.Next() {
@@ -30,7 +35,7 @@ func (s *Store) GetUserByEmail(email string) (*types.User, error) {
 	}
 
 	if u.ID == 0 {
-		return nil, fmt.Errorf("User not found!")
+		return nil, types.ErrUserNotFound
 	}
 
 	return u, nil
@@ -45,6 +50,13 @@ func scanRowIntoUser(rows *sql.Rows) (*types.User, error) {
 		&user.LastName,
 		&user.Email,
 		&user.Password,
+		&user.Role,
+		&user.ServiceAccount,
+		&user.VerifiedAt,
+		&user.TOTPSecret,
+		&user.TwoFactorEnabledAt,
+		&user.DeletionDueAt,
+		&user.DeletedAt,
 		&user.CreatedAt,
 	)
 
@@ -56,10 +68,11 @@ func scanRowIntoUser(rows *sql.Rows) (*types.User, error) {
 }
 
 func (s *Store) GetUserByID(id int) (*types.User, error) {
-	rows, err := s.db.Query("SELECT * FROM users WHERE id = ?", id)
+	rows, err := s.db.Query("SELECT "+userColumns+" FROM users WHERE id = ?", id)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	u := new(types.User)
 	for rows.Next() {
@@ -70,16 +83,20 @@ func (s *Store) GetUserByID(id int) (*types.User, error) {
 	}
 
 	if u.ID == 0 {
-		return nil, fmt.Errorf("User not found!")
+		return nil, types.ErrUserNotFound
 	}
 
 	return u, nil
 }
 
 func (s *Store) CreateUser(user types.User) error {
+	if user.Role == "" {
+		user.Role = types.RoleCustomer
+	}
+
 	_, err := s.db.Exec(
-		"INSERT INTO users(firstName, lastName, email, password) VALUES (?, ?, ?, ?)",
-		user.FirstName, user.LastName, user.Email, user.Password,
+		"INSERT INTO users(firstName, lastName, email, password, role, serviceAccount) VALUES (?, ?, ?, ?, ?, ?)",
+		user.FirstName, user.LastName, user.Email, user.Password, user.Role, user.ServiceAccount,
 	)
 	if err != nil {
 		return err
@@ -87,3 +104,159 @@ func (s *Store) CreateUser(user types.User) error {
 
 	return nil
 }
+
+func (s *Store) UpdateUserRole(userID int, role types.Role) error {
+	_, err := s.db.Exec("UPDATE users SET role = ? WHERE id = ?", role, userID)
+	return err
+}
+
+// UpdatePassword stores a new password hash for the user.
+func (s *Store) UpdatePassword(userID int, password string) error {
+	_, err := s.db.Exec("UPDATE users SET password = ? WHERE id = ?", password, userID)
+	return err
+}
+
+// MarkUserVerified records that the user confirmed their email. Verifying an
+// already verified user keeps the original time.
+func (s *Store) MarkUserVerified(userID int) error {
+	_, err := s.db.Exec("UPDATE users SET verifiedAt = CURRENT_TIMESTAMP WHERE id = ? AND verifiedAt IS NULL", userID)
+	return err
+}
+
+// SetTOTPSecret starts two-factor setup, replacing any secret from an earlier
+// setup that was never confirmed.
+func (s *Store) SetTOTPSecret(userID int, secret string) error {
+	_, err := s.db.Exec("UPDATE users SET totpSecret = ?, totpLastStep = 0 WHERE id = ? AND twoFactorEnabledAt IS NULL", secret, userID)
+	return err
+}
+
+func (s *Store) EnableTOTP(userID int) error {
+	_, err := s.db.Exec("UPDATE users SET twoFactorEnabledAt = CURRENT_TIMESTAMP WHERE id = ? AND totpSecret <> ''", userID)
+	return err
+}
+
+// UseTOTPStep records the time step of an accepted TOTP code. A step that is
+// not newer than the last one accepted returns types.ErrTOTPCodeUsed, so a
+// code cannot be replayed, even by concurrent requests.
+func (s *Store) UseTOTPStep(userID int, step int64) error {
+	res, err := s.db.Exec("UPDATE users SET totpLastStep = ? WHERE id = ? AND totpLastStep < ?", step, userID, step)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return types.ErrTOTPCodeUsed
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes drops the user's recovery codes and stores new ones.
+// Run it in a transaction.
+func (s *Store) ReplaceRecoveryCodes(userID int, hashes []string) error {
+	if _, err := s.db.Exec("DELETE FROM recovery_codes WHERE userId = ?", userID); err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		if _, err := s.db.Exec("INSERT INTO recovery_codes (userId, codeHash) VALUES (?, ?)", userID, hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode consumes a recovery code, returning
+// types.ErrRecoveryCodeNotFound if it is unknown or already used.
+func (s *Store) UseRecoveryCode(userID int, hash string) error {
+	res, err := s.db.Exec(
+		"UPDATE recovery_codes SET usedAt = CURRENT_TIMESTAMP WHERE userId = ? AND codeHash = ? AND usedAt IS NULL",
+		userID, hash,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return types.ErrRecoveryCodeNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) UpdateProfile(userID int, firstName string, lastName string) error {
+	_, err := s.db.Exec("UPDATE users SET firstName = ?, lastName = ? WHERE id = ?", firstName, lastName, userID)
+	return err
+}
+
+// UpdateEmail changes the user's email, which must then be verified again.
+func (s *Store) UpdateEmail(userID int, email string) error {
+	_, err := s.db.Exec("UPDATE users SET email = ?, verifiedAt = NULL WHERE id = ?", email, userID)
+	return err
+}
+
+func (s *Store) ScheduleDeletion(userID int, dueAt time.Time) error {
+	_, err := s.db.Exec("UPDATE users SET deletionDueAt = ? WHERE id = ? AND deletedAt IS NULL", dueAt, userID)
+	return err
+}
+
+func (s *Store) CancelDeletion(userID int) error {
+	_, err := s.db.Exec("UPDATE users SET deletionDueAt = NULL WHERE id = ? AND deletedAt IS NULL", userID)
+	return err
+}
+
+func (s *Store) GetUsersDueForDeletion(now time.Time) ([]types.User, error) {
+	rows, err := s.db.Query("SELECT "+userColumns+" FROM users WHERE deletionDueAt <= ? AND deletedAt IS NULL ORDER BY deletionDueAt", now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []types.User{}
+	for rows.Next() {
+		u, err := scanRowIntoUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+
+	return users, rows.Err()
+}
+
+// AnonymizeUser replaces the user's name and email with placeholders, makes
+// the account impossible to log in to, and drops their addresses, recovery
+// codes, emailed tokens, API keys and stored idempotent responses. Orders
+// keep their address snapshot, which is needed for accounting. Run it in a
+// transaction.
+func (s *Store) AnonymizeUser(userID int) error {
+	_, err := s.db.Exec(
+		`UPDATE users SET firstName = 'Deleted', lastName = 'User', email = CONCAT('deleted-', id, '@invalid'),
+			password = '', role = ?, verifiedAt = NULL, totpSecret = '', totpLastStep = 0, twoFactorEnabledAt = NULL,
+			deletionDueAt = NULL, deletedAt = CURRENT_TIMESTAMP
+		WHERE id = ? AND deletedAt IS NULL`,
+		types.RoleCustomer, userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"addresses", "recovery_codes", "user_tokens", "api_keys", "idempotency_keys"} {
+		if _, err := s.db.Exec("DELETE FROM "+table+" WHERE userId = ?", userID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/joshbarros/golang-ecommerce-api/config"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// ErrTwoFactorAlreadyEnabled is returned when setting up two-factor
+// authentication for a user who already has it.
+var ErrTwoFactorAlreadyEnabled = errors.New("Two-factor authentication is already enabled")
+
+// ErrTwoFactorNotStarted is returned when confirming two-factor setup before
+// starting it.
+var ErrTwoFactorNotStarted = errors.New("Start two-factor setup first")
+
+// ErrInvalidTwoFactorCode is returned for wrong, reused or expired TOTP codes
+// and unknown or used recovery codes.
+var ErrInvalidTwoFactorCode = errors.New("Invalid two-factor code")
+
+// ErrInvalidTwoFactorToken is returned when the token handed out by login is
+// unknown, expired or already used.
+var ErrInvalidTwoFactorToken = errors.New("Invalid or expired two-factor token, please log in again")
+
+const (
+	twoFactorLoginTTL = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+// SetupTwoFactor generates a TOTP secret for the user. It is not used until
+// ConfirmTwoFactor proves the user's authenticator app has it.
+func SetupTwoFactor(ctx context.Context, txManager types.TxManager, userID int) (*types.TwoFactorSetup, error) {
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	var setup *types.TwoFactorSetup
+	err = txManager.WithTx(ctx, func(stores types.Stores) error {
+		u, err := stores.Users.GetUserByID(userID)
+		if err != nil {
+			return err
+		}
+
+		if u.TwoFactorEnabledAt != nil {
+			return ErrTwoFactorAlreadyEnabled
+		}
+
+		if err := stores.TwoFactor.SetTOTPSecret(u.ID, secret); err != nil {
+			return err
+		}
+
+		setup = &types.TwoFactorSetup{
+			Secret: secret,
+			URI:    auth.TOTPURI(secret, u.Email, config.Envs.TwoFactorIssuer),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return setup, nil
+}
+
+// ConfirmTwoFactor enables two-factor authentication once the user enters a
+// code from their app. It returns recovery codes, which are only ever shown
+// here, and signs the user out everywhere so every session goes through the
+// second factor.
+func ConfirmTwoFactor(ctx context.Context, txManager types.TxManager, userID int, code string) (*types.RecoveryCodes, error) {
+	codes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+
+	err = txManager.WithTx(ctx, func(stores types.Stores) error {
+		u, err := stores.Users.GetUserByID(userID)
+		if err != nil {
+			return err
+		}
+
+		if u.TwoFactorEnabledAt != nil {
+			return ErrTwoFactorAlreadyEnabled
+		}
+		if u.TOTPSecret == "" {
+			return ErrTwoFactorNotStarted
+		}
+
+		if err := verifyTOTP(stores, u, code); err != nil {
+			return err
+		}
+
+		if err := stores.TwoFactor.EnableTOTP(u.ID); err != nil {
+			return err
+		}
+
+		if err := stores.TwoFactor.ReplaceRecoveryCodes(u.ID, hashes); err != nil {
+			return err
+		}
+
+		return stores.Tokens.RevokeUserRefreshTokens(u.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.RecoveryCodes{Codes: codes}, nil
+}
+
+// StartTwoFactorLogin hands out the short-lived token that, together with a
+// second factor, completes the login of a user whose password was checked.
+func StartTwoFactorLogin(ctx context.Context, txManager types.TxManager, userID int) (*types.TwoFactorChallenge, error) {
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = txManager.WithTx(ctx, func(stores types.Stores) error {
+		return stores.UserTokens.CreateUserToken(&types.UserToken{
+			UserID:    userID,
+			Purpose:   types.UserTokenTwoFactorLogin,
+			TokenHash: hash,
+			ExpiresAt: time.Now().Add(twoFactorLoginTTL),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.TwoFactorChallenge{TwoFactorRequired: true, TwoFactorToken: token}, nil
+}
+
+// GetTwoFactorLoginUser returns the user a two-factor login token was issued
+// to, and the token itself.
+func GetTwoFactorLoginUser(stores types.Stores, token string) (*types.User, *types.UserToken, error) {
+	t, err := stores.UserTokens.GetUserTokenByHash(types.UserTokenTwoFactorLogin, auth.HashOpaqueToken(token))
+	if errors.Is(err, types.ErrUserTokenNotFound) {
+		return nil, nil, ErrInvalidTwoFactorToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if t.UsedAt != nil || !t.ExpiresAt.After(time.Now()) {
+		return nil, nil, ErrInvalidTwoFactorToken
+	}
+
+	u, err := stores.Users.GetUserByID(t.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if u.TwoFactorEnabledAt == nil {
+		return nil, nil, ErrInvalidTwoFactorToken
+	}
+
+	return u, t, nil
+}
+
+// VerifySecondFactor checks a TOTP code, or else consumes a recovery code.
+func VerifySecondFactor(stores types.Stores, u *types.User, code string, recoveryCode string) error {
+	if code != "" {
+		return verifyTOTP(stores, u, code)
+	}
+
+	err := stores.TwoFactor.UseRecoveryCode(u.ID, auth.HashRecoveryCode(recoveryCode))
+	if errors.Is(err, types.ErrRecoveryCodeNotFound) {
+		return ErrInvalidTwoFactorCode
+	}
+	return err
+}
+
+func verifyTOTP(stores types.Stores, u *types.User, code string) error {
+	step, ok := auth.ValidateTOTP(u.TOTPSecret, code, time.Now())
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	err := stores.TwoFactor.UseTOTPStep(u.ID, step)
+	if errors.Is(err, types.ErrTOTPCodeUsed) {
+		return ErrInvalidTwoFactorCode
+	}
+	return err
+}
@@ -0,0 +1,278 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/mail"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// Mock implementation of the TwoFactorStore interface, writing through to the
+// users of a mockUserStore
+type mockTwoFactorStore struct {
+	users         *mockUserStore
+	lastStep      map[int]int64
+	recoveryCodes map[int]map[string]bool
+}
+
+func (m *mockTwoFactorStore) user(id int) (*types.User, error) {
+	for _, u := range m.users.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return nil, errors.New("user not found")
+}
+
+func (m *mockTwoFactorStore) SetTOTPSecret(userID int, secret string) error {
+	u, err := m.user(userID)
+	if err != nil {
+		return err
+	}
+	u.TOTPSecret = secret
+	m.lastStep[userID] = 0
+	return nil
+}
+
+func (m *mockTwoFactorStore) EnableTOTP(userID int) error {
+	u, err := m.user(userID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	u.TwoFactorEnabledAt = &now
+	return nil
+}
+
+func (m *mockTwoFactorStore) UseTOTPStep(userID int, step int64) error {
+	if step <= m.lastStep[userID] {
+		return types.ErrTOTPCodeUsed
+	}
+	m.lastStep[userID] = step
+	return nil
+}
+
+func (m *mockTwoFactorStore) ReplaceRecoveryCodes(userID int, hashes []string) error {
+	m.recoveryCodes[userID] = map[string]bool{}
+	for _, hash := range hashes {
+		m.recoveryCodes[userID][hash] = true
+	}
+	return nil
+}
+
+func (m *mockTwoFactorStore) UseRecoveryCode(userID int, hash string) error {
+	if !m.recoveryCodes[userID][hash] {
+		return types.ErrRecoveryCodeNotFound
+	}
+	delete(m.recoveryCodes[userID], hash)
+	return nil
+}
+
+func TestTwoFactorAuthentication(t *testing.T) {
+	hashedPassword, err := auth.HashPassword("correct-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userStore := &mockUserStore{users: map[string]*types.User{
+		"jane@example.com": {ID: 1, Email: "jane@example.com", Password: hashedPassword, Role: types.RoleAdmin},
+	}}
+	tokenStore := &mockTokenStore{}
+	txManager := &mockTxManager{
+		users:      userStore,
+		tokens:     tokenStore,
+		userTokens: &mockUserTokenStore{},
+		twoFactor:  &mockTwoFactorStore{users: userStore, lastStep: map[int]int64{}, recoveryCodes: map[int]map[string]bool{}},
+	}
+	handler := NewHandler(userStore, tokenStore, txManager, mail.NewMemoryMailer(), auth.NewDefaultLoginThrottle())
+
+	authenticated := func(h http.HandlerFunc, payload any) *httptest.ResponseRecorder {
+		marshalled, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, "/me/2fa", bytes.NewBuffer(marshalled))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserKey, 1))
+
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	post := func(path string, payload any) *httptest.ResponseRecorder {
+		marshalled, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBuffer(marshalled))
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		handler.RegisterRoutes(router)
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	login := func() string {
+		t.Helper()
+
+		rr := post("/login", types.LoginUserPayload{Email: "jane@example.com", Password: "correct-password"})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var challenge types.TwoFactorChallenge
+		if err := json.NewDecoder(rr.Body).Decode(&challenge); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+		if !challenge.TwoFactorRequired || challenge.TwoFactorToken == "" {
+			t.Fatalf("Expected a two-factor challenge, got %+v", challenge)
+		}
+		return challenge.TwoFactorToken
+	}
+
+	var setup types.TwoFactorSetup
+	var confirmCode string
+	var recoveryCodes types.RecoveryCodes
+
+	t.Run("Should return an otpauth URI", func(t *testing.T) {
+		rr := authenticated(handler.handleTwoFactorSetup, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if err := json.NewDecoder(rr.Body).Decode(&setup); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+		if setup.Secret == "" || setup.URI == "" {
+			t.Errorf("Unexpected setup %+v", setup)
+		}
+		if userStore.users["jane@example.com"].TwoFactorEnabledAt != nil {
+			t.Error("Expected two-factor authentication to stay disabled until confirmed")
+		}
+	})
+
+	t.Run("Should reject a wrong confirmation code", func(t *testing.T) {
+		rr := authenticated(handler.handleTwoFactorConfirm, types.TwoFactorConfirmPayload{Code: "000000"})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("Should enable two-factor authentication and sign out other sessions", func(t *testing.T) {
+		confirmCode, _ = auth.TOTPCode(setup.Secret, time.Now())
+
+		rr := authenticated(handler.handleTwoFactorConfirm, types.TwoFactorConfirmPayload{Code: confirmCode})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if err := json.NewDecoder(rr.Body).Decode(&recoveryCodes); err != nil {
+			t.Fatal("Failed to decode JSON response")
+		}
+		if len(recoveryCodes.Codes) != recoveryCodeCount {
+			t.Errorf("Expected %d recovery codes, got %d", recoveryCodeCount, len(recoveryCodes.Codes))
+		}
+		if userStore.users["jane@example.com"].TwoFactorEnabledAt == nil {
+			t.Error("Expected two-factor authentication to be enabled")
+		}
+		if len(tokenStore.signedOut) != 1 {
+			t.Errorf("Expected the user to be signed out everywhere, got %v", tokenStore.signedOut)
+		}
+
+		if rr := authenticated(handler.handleTwoFactorSetup, nil); rr.Code != http.StatusConflict {
+			t.Errorf("Expected setting up again to fail with %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+
+	t.Run("Should require a second factor to log in", func(t *testing.T) {
+		token := login()
+
+		if rr := post("/login/2fa", types.TwoFactorLoginPayload{TwoFactorToken: token, Code: confirmCode}); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected a replayed code to fail with %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+
+		next, _ := auth.TOTPCode(setup.Secret, time.Now().Add(30*time.Second))
+		rr := post("/login/2fa", types.TwoFactorLoginPayload{TwoFactorToken: token, Code: next})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+
+		var tokens types.TokenPair
+		if err := json.NewDecoder(rr.Body).Decode(&tokens); err != nil || tokens.Token == "" {
+			t.Errorf("Expected tokens, got %s", rr.Body.String())
+		}
+
+		if rr := post("/login/2fa", types.TwoFactorLoginPayload{TwoFactorToken: token, RecoveryCode: recoveryCodes.Codes[0]}); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected a used token to fail with %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("Should accept each recovery code once", func(t *testing.T) {
+		rr := post("/login/2fa", types.TwoFactorLoginPayload{TwoFactorToken: login(), RecoveryCode: recoveryCodes.Codes[0]})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		rr = post("/login/2fa", types.TwoFactorLoginPayload{TwoFactorToken: login(), RecoveryCode: recoveryCodes.Codes[0]})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("Should reject an unknown token", func(t *testing.T) {
+		rr := post("/login/2fa", types.TwoFactorLoginPayload{TwoFactorToken: "not-a-token", Code: "123456"})
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("Should fail if both a code and a recovery code are given", func(t *testing.T) {
+		rr := post("/login/2fa", types.TwoFactorLoginPayload{TwoFactorToken: login(), Code: "123456", RecoveryCode: recoveryCodes.Codes[1]})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+	t.Run("Should keep failed codes across logins", func(t *testing.T) {
+		handler := NewHandler(userStore, tokenStore, txManager, mail.NewMemoryMailer(), auth.NewDefaultLoginThrottle())
+
+		// Every request comes from another IP, so only the account's
+		// failures can slow the guesses down.
+		requests := 0
+		post := func(path string, payload any) *httptest.ResponseRecorder {
+			requests++
+			marshalled, _ := json.Marshal(payload)
+			req := httptest.NewRequest(http.MethodPost, path, bytes.NewBuffer(marshalled))
+			req.RemoteAddr = fmt.Sprintf("198.51.100.%d:1234", requests)
+
+			rr := httptest.NewRecorder()
+			router := mux.NewRouter()
+			handler.RegisterRoutes(router)
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		for i := 0; i < 4; i++ {
+			rr := post("/login", types.LoginUserPayload{Email: "jane@example.com", Password: "correct-password"})
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Login %d: expected status code %d, got %d", i+1, http.StatusOK, rr.Code)
+			}
+
+			var challenge types.TwoFactorChallenge
+			json.NewDecoder(rr.Body).Decode(&challenge)
+
+			if rr := post("/login/2fa", types.TwoFactorLoginPayload{TwoFactorToken: challenge.TwoFactorToken, Code: "000000"}); rr.Code != http.StatusBadRequest {
+				t.Fatalf("Login %d: expected a wrong code to fail with %d, got %d", i+1, http.StatusBadRequest, rr.Code)
+			}
+		}
+
+		rr := post("/login", types.LoginUserPayload{Email: "jane@example.com", Password: "correct-password"})
+		if rr.Code != http.StatusTooManyRequests {
+			t.Errorf("Expected the account to be throttled with %d, got %d", http.StatusTooManyRequests, rr.Code)
+		}
+	})
+}
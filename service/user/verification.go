@@ -0,0 +1,124 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/joshbarros/golang-ecommerce-api/config"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+// ErrInvalidVerificationToken is returned for verification tokens that are
+// unknown, expired or already used.
+var ErrInvalidVerificationToken = errors.New("Invalid or expired verification token")
+
+// ErrEmailAlreadyVerified is returned when asking to verify an email that
+// already is.
+var ErrEmailAlreadyVerified = errors.New("Email is already verified")
+
+// ThrottledError is returned when a verification email was sent too recently.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("Please wait %d seconds before asking for another email", int(e.RetryAfter.Round(time.Second).Seconds()))
+}
+
+// SendVerificationEmail emails the user a link to confirm their address.
+// Sending a link invalidates the previous ones, and only one link is sent
+// every EMAIL_VERIFICATION_RESEND_INTERVAL.
+func SendVerificationEmail(ctx context.Context, txManager types.TxManager, mailer types.Mailer, userID int) error {
+	return sendVerificationEmail(ctx, txManager, mailer, userID, true)
+}
+
+// sendVerificationEmail skips the resend interval when throttle is false, as
+// when the user has just changed their email.
+func sendVerificationEmail(ctx context.Context, txManager types.TxManager, mailer types.Mailer, userID int, throttle bool) error {
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	var user *types.User
+	err = txManager.WithTx(ctx, func(stores types.Stores) error {
+		u, err := stores.Users.GetUserByID(userID)
+		if err != nil {
+			return err
+		}
+
+		if u.VerifiedAt != nil {
+			return ErrEmailAlreadyVerified
+		}
+
+		latest, err := stores.UserTokens.GetLatestUserToken(u.ID, types.UserTokenEmailVerification)
+		if err != nil && !errors.Is(err, types.ErrUserTokenNotFound) {
+			return err
+		}
+		if err == nil && throttle {
+			interval := time.Second * time.Duration(config.Envs.EmailVerificationResendIntervalInSeconds)
+			if wait := time.Until(latest.CreatedAt.Add(interval)); wait > 0 {
+				return &ThrottledError{RetryAfter: wait}
+			}
+		}
+
+		if err := stores.UserTokens.InvalidateUserTokens(u.ID, types.UserTokenEmailVerification); err != nil {
+			return err
+		}
+
+		err = stores.UserTokens.CreateUserToken(&types.UserToken{
+			UserID:    u.ID,
+			Purpose:   types.UserTokenEmailVerification,
+			TokenHash: hash,
+			ExpiresAt: time.Now().Add(time.Second * time.Duration(config.Envs.EmailVerificationTTLInSeconds)),
+		})
+		if err != nil {
+			return err
+		}
+
+		user = u
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(ctx, types.Email{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nOpen the link below to verify your email. It expires in %d hours.\n\n%s?token=%s",
+			user.FirstName, config.Envs.EmailVerificationTTLInSeconds/3600, config.Envs.EmailVerificationURL, token,
+		),
+	})
+}
+
+// VerifyEmail marks the owner of a verification token as verified.
+func VerifyEmail(ctx context.Context, txManager types.TxManager, token string) error {
+	return txManager.WithTx(ctx, func(stores types.Stores) error {
+		t, err := stores.UserTokens.GetUserTokenByHash(types.UserTokenEmailVerification, auth.HashOpaqueToken(token))
+		if errors.Is(err, types.ErrUserTokenNotFound) {
+			return ErrInvalidVerificationToken
+		}
+		if err != nil {
+			return err
+		}
+
+		if t.UsedAt != nil || !t.ExpiresAt.After(time.Now()) {
+			return ErrInvalidVerificationToken
+		}
+
+		err = stores.UserTokens.MarkUserTokenUsed(t.ID)
+		if errors.Is(err, types.ErrUserTokenUsed) {
+			return ErrInvalidVerificationToken
+		}
+		if err != nil {
+			return err
+		}
+
+		return stores.Users.MarkUserVerified(t.UserID)
+	})
+}
@@ -0,0 +1,129 @@
+package user
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/joshbarros/golang-ecommerce-api/config"
+	"github.com/joshbarros/golang-ecommerce-api/mail"
+	"github.com/joshbarros/golang-ecommerce-api/service/auth"
+	"github.com/joshbarros/golang-ecommerce-api/types"
+)
+
+func TestEmailVerification(t *testing.T) {
+	config.Envs.EmailVerificationResendIntervalInSeconds = 60
+	config.Envs.EmailVerificationURL = "https://shop.example.com/verify-email"
+
+	userStore := &mockUserStore{users: map[string]*types.User{
+		"jane@example.com": {ID: 1, FirstName: "Jane", Email: "jane@example.com"},
+	}}
+	tokenStore := &mockTokenStore{}
+	userTokenStore := &mockUserTokenStore{}
+	mailer := mail.NewMemoryMailer()
+	txManager := &mockTxManager{users: userStore, tokens: tokenStore, userTokens: userTokenStore}
+	handler := NewHandler(userStore, tokenStore, txManager, mailer, auth.NewDefaultLoginThrottle())
+
+	verify := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/verify-email?token="+url.QueryEscape(token), nil)
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		router.HandleFunc("/verify-email", handler.handleVerifyEmail)
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	resend := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/verify-email/resend", nil)
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserKey, 1))
+
+		rr := httptest.NewRecorder()
+		handler.handleResendVerification(rr, req)
+		return rr
+	}
+
+	t.Run("Should send a link and throttle resending it", func(t *testing.T) {
+		if rr := resend(); rr.Code != http.StatusAccepted {
+			t.Fatalf("Expected status code %d, got %d", http.StatusAccepted, rr.Code)
+		}
+
+		rr := resend()
+		if rr.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected status code %d, got %d", http.StatusTooManyRequests, rr.Code)
+		}
+		if retryAfter := rr.Header().Get("Retry-After"); retryAfter != "60" {
+			t.Errorf("Expected Retry-After 60, got %q", retryAfter)
+		}
+		if sent := len(mailer.Sent()); sent != 1 {
+			t.Errorf("Expected 1 email, got %d", sent)
+		}
+
+		email, _ := mailer.Last("jane@example.com")
+		if !strings.Contains(email.Body, "https://shop.example.com/verify-email?token=") {
+			t.Errorf("Expected a link to EMAIL_VERIFICATION_URL, got %q", email.Body)
+		}
+	})
+
+	t.Run("Should only accept the latest link", func(t *testing.T) {
+		first, _ := mailer.Last("jane@example.com")
+		userTokenStore.tokens[len(userTokenStore.tokens)-1].CreatedAt = time.Now().Add(-time.Minute)
+
+		if rr := resend(); rr.Code != http.StatusAccepted {
+			t.Fatalf("Expected status code %d, got %d", http.StatusAccepted, rr.Code)
+		}
+
+		if code := verify(tokenFromEmail(t, first)); code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, code)
+		}
+		if userStore.users["jane@example.com"].VerifiedAt != nil {
+			t.Error("Expected the user to stay unverified")
+		}
+	})
+
+	t.Run("Should reject expired links", func(t *testing.T) {
+		latest, _ := mailer.Last("jane@example.com")
+		userTokenStore.tokens[len(userTokenStore.tokens)-1].ExpiresAt = time.Now().Add(-time.Second)
+		defer func() {
+			userTokenStore.tokens[len(userTokenStore.tokens)-1].ExpiresAt = time.Now().Add(time.Hour)
+		}()
+
+		if code := verify(tokenFromEmail(t, latest)); code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, code)
+		}
+	})
+
+	t.Run("Should verify the email", func(t *testing.T) {
+		latest, _ := mailer.Last("jane@example.com")
+
+		if code := verify(tokenFromEmail(t, latest)); code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, code)
+		}
+		if userStore.users["jane@example.com"].VerifiedAt == nil {
+			t.Error("Expected the user to be verified")
+		}
+
+		if code := verify(tokenFromEmail(t, latest)); code != http.StatusBadRequest {
+			t.Errorf("Expected a used link to be rejected, got status code %d", code)
+		}
+	})
+
+	t.Run("Should not resend once verified", func(t *testing.T) {
+		userTokenStore.tokens[len(userTokenStore.tokens)-1].CreatedAt = time.Now().Add(-time.Minute)
+
+		if rr := resend(); rr.Code != http.StatusConflict {
+			t.Errorf("Expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+
+	t.Run("Should fail without a token", func(t *testing.T) {
+		if code := verify(""); code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, code)
+		}
+	})
+}
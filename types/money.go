@@ -0,0 +1,236 @@
+package types
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// DefaultCurrency is the currency of every amount stored in the database. The
+// DECIMAL columns hold no currency of their own.
+const DefaultCurrency = "USD"
+
+// currencyExponents lists the ISO 4217 currencies whose minor unit is not a
+// hundredth of the major unit.
+var currencyExponents = map[string]int{
+	"BHD": 3,
+	"CLP": 0,
+	"ISK": 0,
+	"JOD": 3,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"OMR": 3,
+	"TND": 3,
+	"VND": 0,
+}
+
+// Money is an exact amount of a currency, held as an integer number of minor
+// units (cents for USD).
+//
+// Rounding rules: only parsing rounds. A decimal with more fractional digits
+// than the currency allows is rounded half away from zero to the nearest minor
+// unit, which is what MySQL does when writing to a DECIMAL column. Add and Mul
+// are exact and fail rather than overflow, so a total is always exactly the
+// sum of its lines.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// ParseMoney parses a decimal string such as "19.99" into currency's minor
+// units, rounding as described on Money.
+func ParseMoney(s string, currency string) (Money, error) {
+	if err := validateCurrency(currency); err != nil {
+		return Money{}, err
+	}
+
+	s = strings.TrimSpace(s)
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || strings.Contains(s, "/") {
+		return Money{}, fmt.Errorf("Invalid amount %q", s)
+	}
+
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(currencyExponent(currency))), nil)
+	r.Mul(r, new(big.Rat).SetInt(scale))
+
+	num, den := r.Num(), r.Denom()
+	q, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Abs(rem).Lsh(rem, 1).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(int64(num.Sign())))
+	}
+
+	if !q.IsInt64() {
+		return Money{}, fmt.Errorf("Amount %q is out of range", s)
+	}
+
+	return Money{Amount: q.Int64(), Currency: currency}, nil
+}
+
+// String formats the amount as a decimal in major units, without the currency.
+func (m Money) String() string {
+	exp := currencyExponent(m.Currency)
+
+	sign := ""
+	abs := uint64(m.Amount)
+	if m.Amount < 0 {
+		sign = "-"
+		abs = uint64(-(m.Amount + 1)) + 1
+	}
+
+	if exp == 0 {
+		return fmt.Sprintf("%s%d", sign, abs)
+	}
+
+	scale := uint64(math.Pow10(exp))
+	return fmt.Sprintf("%s%d.%0*d", sign, abs/scale, exp, abs%scale)
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Add returns m + o. Both amounts must be in the same currency.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("Cannot add %s to %s", o.Currency, m.Currency)
+	}
+
+	sum := m.Amount + o.Amount
+	if (o.Amount > 0 && sum < m.Amount) || (o.Amount < 0 && sum > m.Amount) {
+		return Money{}, fmt.Errorf("Amount out of range")
+	}
+
+	return Money{Amount: sum, Currency: m.Currency}, nil
+}
+
+// Mul returns m multiplied by an integer quantity.
+func (m Money) Mul(n int64) (Money, error) {
+	if m.Amount != 0 && n != 0 {
+		product := m.Amount * n
+		if product/n != m.Amount || (m.Amount == -1 && n == math.MinInt64) || (n == -1 && m.Amount == math.MinInt64) {
+			return Money{}, fmt.Errorf("Amount out of range")
+		}
+		return Money{Amount: product, Currency: m.Currency}, nil
+	}
+
+	return Money{Amount: 0, Currency: m.Currency}, nil
+}
+
+type moneyJSON struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// MarshalJSON encodes the amount in minor units, e.g.
+// {"amount": 1999, "currency": "USD"} for $19.99.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.Amount, Currency: m.Currency})
+}
+
+// UnmarshalJSON accepts the object written by MarshalJSON. For older clients
+// it also accepts a bare decimal number or string in major units of
+// DefaultCurrency, read from its literal text so no float rounding happens.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	trimmed := strings.TrimSpace(string(data))
+
+	if strings.HasPrefix(trimmed, "{") {
+		var v moneyJSON
+		dec := json.NewDecoder(strings.NewReader(trimmed))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&v); err != nil {
+			return fmt.Errorf("Invalid money value: %v", err)
+		}
+
+		if v.Currency == "" {
+			v.Currency = DefaultCurrency
+		}
+		if err := validateCurrency(v.Currency); err != nil {
+			return err
+		}
+
+		*m = Money{Amount: v.Amount, Currency: v.Currency}
+		return nil
+	}
+
+	if trimmed == "null" {
+		return nil
+	}
+
+	if strings.HasPrefix(trimmed, `"`) {
+		if err := json.Unmarshal(data, &trimmed); err != nil {
+			return err
+		}
+	}
+
+	parsed, err := ParseMoney(trimmed, DefaultCurrency)
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+// Scan reads a DECIMAL column as an amount of DefaultCurrency.
+func (m *Money) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case int64:
+		s = fmt.Sprintf("%d", v)
+	case float64:
+		s = fmt.Sprintf("%.*f", currencyExponent(DefaultCurrency)+1, v)
+	default:
+		return fmt.Errorf("Cannot scan %T into Money", src)
+	}
+
+	parsed, err := ParseMoney(s, DefaultCurrency)
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+// Value writes the amount as a decimal string so the driver never goes
+// through a float.
+func (m Money) Value() (driver.Value, error) {
+	if m.Currency != "" && m.Currency != DefaultCurrency {
+		return nil, fmt.Errorf("Cannot store an amount in %s, only %s is supported", m.Currency, DefaultCurrency)
+	}
+
+	return m.String(), nil
+}
+
+func currencyExponent(currency string) int {
+	if exp, ok := currencyExponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+func validateCurrency(currency string) error {
+	if len(currency) != 3 {
+		return fmt.Errorf("Invalid currency %q", currency)
+	}
+
+	for _, c := range currency {
+		if c < 'A' || c > 'Z' {
+			return fmt.Errorf("Invalid currency %q", currency)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+package types
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"testing"
+	"testing/quick"
+)
+
+func TestParseMoneyRounding(t *testing.T) {
+	tests := []struct {
+		input    string
+		currency string
+		expected int64
+	}{
+		{"19.99", "USD", 1999},
+		{"19.9", "USD", 1990},
+		{"19", "USD", 1900},
+		{"0.005", "USD", 1},
+		{"0.0049", "USD", 0},
+		{"1.235", "USD", 124},
+		{"1.245", "USD", 125},
+		{"-0.005", "USD", -1},
+		{"-1.234", "USD", -123},
+		{"1e2", "USD", 10000},
+		{"1234.5", "JPY", 1235},
+		{"1.2345", "KWD", 1235},
+	}
+
+	for _, tt := range tests {
+		m, err := ParseMoney(tt.input, tt.currency)
+		if err != nil {
+			t.Errorf("ParseMoney(%q): unexpected error %v", tt.input, err)
+			continue
+		}
+		if m.Amount != tt.expected || m.Currency != tt.currency {
+			t.Errorf("ParseMoney(%q, %s): expected %d, got %d %s", tt.input, tt.currency, tt.expected, m.Amount, m.Currency)
+		}
+	}
+}
+
+func TestParseMoneyRejectsInvalidInput(t *testing.T) {
+	for _, input := range []string{"", "abc", "1/3", "1.2.3", "99999999999999999999"} {
+		if _, err := ParseMoney(input, "USD"); err == nil {
+			t.Errorf("ParseMoney(%q): expected an error", input)
+		}
+	}
+
+	for _, currency := range []string{"", "usd", "US", "U5D"} {
+		if _, err := ParseMoney("1", currency); err == nil {
+			t.Errorf("ParseMoney with currency %q: expected an error", currency)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		money    Money
+		expected string
+	}{
+		{NewMoney(1999, "USD"), "19.99"},
+		{NewMoney(5, "USD"), "0.05"},
+		{NewMoney(-5, "USD"), "-0.05"},
+		{NewMoney(1235, "JPY"), "1235"},
+		{NewMoney(1235, "KWD"), "1.235"},
+		{NewMoney(math.MinInt64, "USD"), "-92233720368547758.08"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.money.String(); got != tt.expected {
+			t.Errorf("Expected %q, got %q", tt.expected, got)
+		}
+	}
+}
+
+func TestMoneyStringParseRoundTrip(t *testing.T) {
+	property := func(amount int64) bool {
+		m := NewMoney(amount, "USD")
+		parsed, err := ParseMoney(m.String(), "USD")
+		return err == nil && parsed == m
+	}
+
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMoneyJSONRoundTrip(t *testing.T) {
+	property := func(amount int64) bool {
+		m := NewMoney(amount, "EUR")
+		data, err := json.Marshal(m)
+		if err != nil {
+			return false
+		}
+
+		var decoded Money
+		return json.Unmarshal(data, &decoded) == nil && decoded == m
+	}
+
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMoneyUnmarshalLegacyDecimal(t *testing.T) {
+	tests := map[string]int64{
+		`29.99`:                               2999,
+		`"29.99"`:                             2999,
+		`0.1`:                                 10,
+		`{"amount": 2999}`:                    2999,
+		`{"amount": 2999, "currency": "USD"}`: 2999,
+	}
+
+	for input, expected := range tests {
+		var m Money
+		if err := json.Unmarshal([]byte(input), &m); err != nil {
+			t.Errorf("%s: unexpected error %v", input, err)
+			continue
+		}
+		if m.Amount != expected || m.Currency != DefaultCurrency {
+			t.Errorf("%s: expected %d %s, got %d %s", input, expected, DefaultCurrency, m.Amount, m.Currency)
+		}
+	}
+
+	for _, input := range []string{`{"amount": 1.5}`, `{"amount": 1, "currency": "usd"}`, `{"amount": 1, "cents": 2}`, `"abc"`} {
+		var m Money
+		if err := json.Unmarshal([]byte(input), &m); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
+
+func TestMoneyScanValue(t *testing.T) {
+	property := func(amount int64) bool {
+		m := NewMoney(amount, DefaultCurrency)
+		v, err := m.Value()
+		if err != nil {
+			return false
+		}
+
+		var scanned Money
+		return scanned.Scan([]byte(v.(string))) == nil && scanned == m
+	}
+
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := NewMoney(100, "EUR").Value(); err == nil {
+		t.Error("Expected an error storing a currency other than the default")
+	}
+}
+
+// TestMoneySumMatchesDecimalSum checks that summing line totals in minor units
+// gives exactly the same result as summing the decimal prices with arbitrary
+// precision.
+func TestMoneySumMatchesDecimalSum(t *testing.T) {
+	type line struct {
+		Cents    uint32
+		Quantity uint16
+	}
+
+	property := func(lines []line) bool {
+		total := NewMoney(0, "USD")
+		exact := new(big.Rat)
+
+		for _, l := range lines {
+			price := NewMoney(int64(l.Cents), "USD")
+
+			lineTotal, err := price.Mul(int64(l.Quantity))
+			if err != nil {
+				return false
+			}
+			total, err = total.Add(lineTotal)
+			if err != nil {
+				return false
+			}
+
+			decimal, ok := new(big.Rat).SetString(price.String())
+			if !ok {
+				return false
+			}
+			exact.Add(exact, decimal.Mul(decimal, new(big.Rat).SetInt64(int64(l.Quantity))))
+		}
+
+		expected, ok := new(big.Rat).SetString(total.String())
+		return ok && expected.Cmp(exact) == 0
+	}
+
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMoneyArithmeticErrors(t *testing.T) {
+	if _, err := NewMoney(1, "USD").Add(NewMoney(1, "EUR")); err == nil {
+		t.Error("Expected an error adding different currencies")
+	}
+
+	if _, err := NewMoney(math.MaxInt64, "USD").Add(NewMoney(1, "USD")); err == nil {
+		t.Error("Expected an error on addition overflow")
+	}
+
+	if _, err := NewMoney(math.MaxInt64/2+1, "USD").Mul(2); err == nil {
+		t.Error("Expected an error on multiplication overflow")
+	}
+
+	if _, err := NewMoney(math.MinInt64, "USD").Mul(-1); err == nil {
+		t.Error("Expected an error on multiplication overflow")
+	}
+}
@@ -1,64 +1,974 @@
 package types
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"time"
 )
 
+// ErrInsufficientStock is returned when a product does not have enough units
+// left to satisfy a stock decrement.
+var ErrInsufficientStock = errors.New("Insufficient stock")
+
+// ErrProductNotFound is returned when a product does not exist or was deleted.
+var ErrProductNotFound = errors.New("Product not found")
+
+// ErrVariantNotFound is returned when a product variant does not exist or was
+// deleted.
+var ErrVariantNotFound = errors.New("Variant not found")
+
+// ErrWarehouseNotFound is returned when a warehouse does not exist.
+var ErrWarehouseNotFound = errors.New("Warehouse not found")
+
+// ErrLocationNotFound is returned when a warehouse location does not exist.
+var ErrLocationNotFound = errors.New("Location not found")
+
+// ErrUserNotFound is returned when no user has the given email or ID.
+var ErrUserNotFound = errors.New("User not found")
+
+// ErrOrderNotFound is returned when an order does not exist.
+var ErrOrderNotFound = errors.New("Order not found")
+
+// ErrAddressNotFound is returned when an address does not exist.
+var ErrAddressNotFound = errors.New("Address not found")
+
+// ErrIdempotencyKeyExists is returned when an idempotency key has already been
+// recorded for the same user.
+var ErrIdempotencyKeyExists = errors.New("Idempotency key already exists")
+
+// ErrIdempotencyKeyNotFound is returned when no idempotency key was recorded.
+var ErrIdempotencyKeyNotFound = errors.New("Idempotency key not found")
+
+// ErrRefreshTokenNotFound is returned when no refresh token has the given hash.
+var ErrRefreshTokenNotFound = errors.New("Refresh token not found")
+
+// ErrRefreshTokenUsed is returned when a refresh token has already been
+// exchanged or revoked.
+var ErrRefreshTokenUsed = errors.New("Refresh token was already used")
+
+// ErrUserTokenNotFound is returned when no emailed token has the given hash.
+var ErrUserTokenNotFound = errors.New("Token not found")
+
+// ErrUserTokenUsed is returned when an emailed token has already been used.
+var ErrUserTokenUsed = errors.New("Token was already used")
+
+// ErrTOTPCodeUsed is returned when a TOTP code's time step is not newer than
+// the last one accepted for the user.
+var ErrTOTPCodeUsed = errors.New("TOTP code was already used")
+
+// ErrRecoveryCodeNotFound is returned when a recovery code is unknown or was
+// already used.
+var ErrRecoveryCodeNotFound = errors.New("Recovery code not found")
+
+// ErrAPIKeyNotFound is returned when no API key has the given hash or ID.
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// ErrCategoryNotFound is returned when a category does not exist.
+var ErrCategoryNotFound = errors.New("Category not found")
+
+// ErrOrderStatusConflict is returned when an order's status changed between
+// reading it and writing the new one.
+var ErrOrderStatusConflict = errors.New("Order status was changed concurrently")
+
 type UserStore interface {
 	GetUserByEmail(email string) (*User, error)
 	GetUserByID(id int) (*User, error)
 	CreateUser(User) error
+	UpdateUserRole(userID int, role Role) error
+	UpdatePassword(userID int, password string) error
+	MarkUserVerified(userID int) error
+	UpdateProfile(userID int, firstName string, lastName string) error
+	UpdateEmail(userID int, email string) error
+	// ScheduleDeletion marks the user to be erased once dueAt has passed.
+	ScheduleDeletion(userID int, dueAt time.Time) error
+	CancelDeletion(userID int) error
+	GetUsersDueForDeletion(now time.Time) ([]User, error)
+	// AnonymizeUser scrubs the user's personal data, keeping the row so
+	// their orders stay intact.
+	AnonymizeUser(userID int) error
 }
 
 type ProductStore interface {
-	GetProducts() ([]Product, error)
+	GetProducts(ProductQuery) ([]Product, error)
+	CountProducts(ProductQuery) (int, error)
 	GetProductsByID(ps []int) ([]Product, error)
+	GetProductByID(id int) (*Product, error)
 	CreateProduct(*Product) error
 	UpdateProduct(Product) error
+	DeleteProduct(id int) error
+}
+
+// VariantStore keeps the options products come in and their variants, which
+// are what is stocked and sold. The stock of variants is read from the
+// InventoryStore's ledger.
+type VariantStore interface {
+	GetProductOptions(productID int) ([]ProductOption, error)
+	// SetProductOptions replaces the options of a product.
+	SetProductOptions(productID int, options []ProductOption) error
+	GetVariantsByProductID(productID int) ([]ProductVariant, error)
+	GetVariantsByID(ids []int) ([]ProductVariant, error)
+	// GetVariantBySKU also finds deleted variants, whose SKU stays taken.
+	GetVariantBySKU(sku string) (*ProductVariant, error)
+	CreateVariant(*ProductVariant) error
+	UpdateVariant(ProductVariant) error
+	DeleteVariant(id int) error
+}
+
+// InventoryStore keeps warehouses, their locations and the ledger of stock
+// movements. Movements are only ever added: the stock on hand of a variant at
+// a location is the sum of its movements there.
+type InventoryStore interface {
+	GetWarehouses() ([]Warehouse, error)
+	GetWarehouseByID(id int) (*Warehouse, error)
+	GetWarehouseByCode(code string) (*Warehouse, error)
+	CreateWarehouse(*Warehouse) error
+	// GetLocations returns every location, those of warehouses allocated
+	// from first ahead.
+	GetLocations() ([]Location, error)
+	GetLocationByID(id int) (*Location, error)
+	CreateLocation(*Location) error
+	// LockVariants keeps other transactions from moving stock of the variants,
+	// and of every variant of the products, until the current one ends. It
+	// must run in a transaction, before anything reads the stock.
+	LockVariants(ctx context.Context, variantIDs []int, productIDs []int) error
+	// GetStockLevels reads the latest committed ledger, whatever snapshot the
+	// transaction has.
+	GetStockLevels(variantIDs []int) ([]StockLevel, error)
+	AddMovements(ctx context.Context, movements []StockMovement) error
+	GetMovements(MovementQuery) ([]StockMovement, error)
+	CountMovements(MovementQuery) (int, error)
+	GetMovementsByOrderID(orderID int) ([]StockMovement, error)
+}
+
+// AllocationStrategy picks the locations a sale takes quantity units of a
+// variant from, given the variant's stock levels. It fails with
+// ErrInsufficientStock when they do not hold enough together.
+type AllocationStrategy interface {
+	Allocate(levels []StockLevel, quantity int) ([]Allocation, error)
+}
+
+// CategoryStore keeps the category tree and which categories each product is
+// in.
+type CategoryStore interface {
+	GetCategories() ([]Category, error)
+	GetCategoryByID(id int) (*Category, error)
+	GetCategoryBySlug(slug string) (*Category, error)
+	CreateCategory(*Category) error
+	UpdateCategory(Category) error
+	DeleteCategory(id int) error
+	GetCategoriesByProductID(productID int) ([]Category, error)
+	SetProductCategories(productID int, categoryIDs []int) error
+}
+
+// SearchIndex finds products by the words in their name and description. It
+// must be told about every product written, with IndexProduct, and deleted,
+// with RemoveProduct.
+type SearchIndex interface {
+	IndexProduct(Product) error
+	RemoveProduct(id int) error
+	SearchProducts(query string, limit int, offset int) ([]SearchMatch, error)
 }
 
 type OrderStore interface {
 	CreateOrder(Order) (int, error)
 	CreateOrderItem(OrderItem) error
+	GetOrdersByUserID(userID int, limit int, offset int) ([]Order, error)
+	CountOrdersByUserID(userID int) (int, error)
+	GetOrderByID(id int) (*Order, error)
+	GetOrderItems(orderID int) ([]OrderItem, error)
+	UpdateOrderStatus(orderID int, from OrderStatus, to OrderStatus) error
+	CreateOrderStatusChange(OrderStatusChange) error
+}
+
+type AddressStore interface {
+	GetAddressesByUserID(userID int) ([]Address, error)
+	GetAddressByID(id int) (*Address, error)
+	CreateAddress(*Address) error
+	UpdateAddress(Address) error
+	DeleteAddress(id int) error
+	ClearDefaultShipping(userID int) error
+	ClearDefaultBilling(userID int) error
+}
+
+type IdempotencyStore interface {
+	GetIdempotencyKey(userID int, key string) (*IdempotencyKey, error)
+	CreateIdempotencyKey(IdempotencyKey) error
+	CompleteIdempotencyKey(userID int, key string, statusCode int, body []byte) error
+	DeleteIdempotencyKey(userID int, key string) error
+	DeleteExpiredIdempotencyKeys(now time.Time) (int64, error)
+}
+
+// TokenStore keeps refresh tokens and the denylist of revoked access tokens.
+// Revoking refresh tokens also denylists the access tokens issued with them.
+type TokenStore interface {
+	CreateRefreshToken(*RefreshToken) error
+	GetRefreshTokenByHash(hash string) (*RefreshToken, error)
+	GetRefreshTokenByAccessTokenID(jti string) (*RefreshToken, error)
+	MarkRefreshTokenUsed(id int) error
+	RevokeRefreshTokenFamily(familyID string) error
+	RevokeUserRefreshTokens(userID int) error
+	RevokeAccessToken(jti string, expiresAt time.Time) error
+	IsAccessTokenRevoked(jti string) (bool, error)
+}
+
+// UserTokenStore keeps the single-use tokens emailed to users, such as
+// password reset tokens.
+type UserTokenStore interface {
+	CreateUserToken(*UserToken) error
+	GetUserTokenByHash(purpose UserTokenPurpose, hash string) (*UserToken, error)
+	GetLatestUserToken(userID int, purpose UserTokenPurpose) (*UserToken, error)
+	MarkUserTokenUsed(id int) error
+	InvalidateUserTokens(userID int, purpose UserTokenPurpose) error
+}
+
+// APIKeyStore keeps the keys integrations use instead of logging in. Only a
+// hash of each key is kept.
+type APIKeyStore interface {
+	CreateAPIKey(*APIKey) error
+	GetAPIKeyByHash(hash string) (*APIKey, error)
+	GetAPIKeyByID(id int) (*APIKey, error)
+	GetAPIKeysByUserID(userID int) ([]APIKey, error)
+	RevokeAPIKey(id int) error
+	// TouchAPIKey records that the key was used.
+	TouchAPIKey(id int) error
+}
+
+// TwoFactorStore keeps users' TOTP secrets and recovery codes.
+type TwoFactorStore interface {
+	// SetTOTPSecret stores a secret that is not enabled until EnableTOTP.
+	SetTOTPSecret(userID int, secret string) error
+	EnableTOTP(userID int) error
+	UseTOTPStep(userID int, step int64) error
+	ReplaceRecoveryCodes(userID int, hashes []string) error
+	UseRecoveryCode(userID int, hash string) error
+}
+
+// Mailer sends transactional email.
+type Mailer interface {
+	Send(ctx context.Context, email Email) error
+}
+
+// Stores groups the stores that can take part in a single transaction.
+type Stores struct {
+	Users      UserStore
+	Products   ProductStore
+	Variants   VariantStore
+	Inventory  InventoryStore
+	Orders     OrderStore
+	Addresses  AddressStore
+	Tokens     TokenStore
+	UserTokens UserTokenStore
+	TwoFactor  TwoFactorStore
+	Categories CategoryStore
+}
+
+// TxManager runs a unit of work against stores that share one transaction,
+// committing only if fn succeeds.
+type TxManager interface {
+	WithTx(ctx context.Context, fn func(Stores) error) error
+}
+
+type Role string
+
+const (
+	RoleCustomer Role = "customer"
+	RoleStaff    Role = "staff"
+	RoleAdmin    Role = "admin"
+)
+
+func (r Role) IsValid() bool {
+	switch r {
+	case RoleCustomer, RoleStaff, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// AllowsScope reports whether the keys of a user with the role may have the
+// scope, so a key never grants more than its owner's login would.
+func (r Role) AllowsScope(s Scope) bool {
+	if s == ScopeProductsWrite {
+		return r == RoleAdmin
+	}
+	return r.IsValid() && s.IsValid()
+}
+
+type OrderStatus string
+
+const (
+	OrderStatusPending   OrderStatus = "pending"
+	OrderStatusPaid      OrderStatus = "paid"
+	OrderStatusFulfilled OrderStatus = "fulfilled"
+	OrderStatusShipped   OrderStatus = "shipped"
+	OrderStatusDelivered OrderStatus = "delivered"
+	OrderStatusCompleted OrderStatus = "completed"
+	OrderStatusCancelled OrderStatus = "cancelled"
+	OrderStatusRefunded  OrderStatus = "refunded"
+)
+
+// orderTransitions lists, for every status, the statuses an order may move to
+// next. Statuses without an entry are final.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusPending:   {OrderStatusPaid, OrderStatusCancelled},
+	OrderStatusPaid:      {OrderStatusFulfilled, OrderStatusRefunded},
+	OrderStatusFulfilled: {OrderStatusShipped, OrderStatusRefunded},
+	OrderStatusShipped:   {OrderStatusDelivered, OrderStatusCompleted},
+	OrderStatusDelivered: {OrderStatusCompleted, OrderStatusRefunded},
+	OrderStatusCompleted: {OrderStatusRefunded},
+}
+
+func (s OrderStatus) IsValid() bool {
+	switch s {
+	case OrderStatusPending, OrderStatusPaid, OrderStatusFulfilled, OrderStatusShipped,
+		OrderStatusDelivered, OrderStatusCompleted, OrderStatusCancelled, OrderStatusRefunded:
+		return true
+	}
+	return false
+}
+
+func (s OrderStatus) CanTransitionTo(to OrderStatus) bool {
+	for _, next := range orderTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ReturnsStock reports whether moving an order from s to to gives its items
+// back to stock: it is cancelled, or refunded before it shipped.
+func (s OrderStatus) ReturnsStock(to OrderStatus) bool {
+	switch to {
+	case OrderStatusCancelled:
+		return true
+	case OrderStatusRefunded:
+		return s == OrderStatusPaid || s == OrderStatusFulfilled
+	}
+	return false
 }
 
 type Order struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"userID"`
-	Total     float64   `json:"total"`
-	Status    string    `json:"status"`
-	Address   string    `json:"address"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID        int         `json:"id"`
+	UserID    int         `json:"userID"`
+	Total     Money       `json:"total"`
+	Status    OrderStatus `json:"status"`
+	Address   string      `json:"address"`
+	CreatedAt time.Time   `json:"createdAt"`
+}
+
+// OrderStatusChange is one entry of an order's status history. ChangedBy is
+// the ID of the user who made the change, or 0 when the system made it.
+type OrderStatusChange struct {
+	ID         int         `json:"id"`
+	OrderID    int         `json:"orderID"`
+	FromStatus OrderStatus `json:"fromStatus"`
+	ToStatus   OrderStatus `json:"toStatus"`
+	ChangedBy  int         `json:"changedBy"`
+	Note       string      `json:"note"`
+	CreatedAt  time.Time   `json:"createdAt"`
 }
 
+// OrderItem keeps a snapshot of the product name and image and of the
+// variant's SKU and options taken at checkout, so order history stays
+// accurate when the catalog changes.
 type OrderItem struct {
-	ID        int       `json:"id"`
-	OrderID   int       `json:"orderID"`
-	ProductID int       `json:"productID"`
-	Quantity  int       `json:"quantity"`
-	Price     float64   `json:"price"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID           int               `json:"id"`
+	OrderID      int               `json:"orderID"`
+	ProductID    int               `json:"productID"`
+	VariantID    int               `json:"variantID"`
+	SKU          string            `json:"sku"`
+	ProductName  string            `json:"productName"`
+	ProductImage string            `json:"productImage"`
+	Options      map[string]string `json:"options"`
+	Quantity     int               `json:"quantity"`
+	Price        Money             `json:"price"`
+	CreatedAt    time.Time         `json:"createdAt"`
 }
 
+type OrderDetail struct {
+	Order
+	Items []OrderItem `json:"items"`
+}
+
+// Product is sold as one or more variants. Price is the price of variants
+// without their own, and Quantity the stock of all its variants together.
 type Product struct {
 	ID          int       `json:"id"`
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	Image       string    `json:"image"`
-	Price       float64   `json:"price"`
+	Price       Money     `json:"price"`
 	Quantity    int       `json:"quantity"`
 	CreatedAt   time.Time `json:"createdAt"`
 }
 
-type User struct {
+// ProductOption is a way a product varies, like its size, and the values it
+// comes in.
+type ProductOption struct {
+	ID        int      `json:"id"`
+	ProductID int      `json:"productID"`
+	Name      string   `json:"name"`
+	Values    []string `json:"values"`
+	Position  int      `json:"position"`
+}
+
+// ProductVariant is a product in one value of each of its options, e.g. a
+// shirt in size M and colour blue. Products without options have a single
+// variant with no options. Quantity is its stock on hand at every location.
+type ProductVariant struct {
+	ID        int               `json:"id"`
+	ProductID int               `json:"productID"`
+	SKU       string            `json:"sku"`
+	Price     *Money            `json:"price"`
+	Quantity  int               `json:"quantity"`
+	Image     string            `json:"image"`
+	Options   map[string]string `json:"options"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// UnitPrice is the variant's own price, or else the price of its product.
+func (v ProductVariant) UnitPrice(product Product) Money {
+	if v.Price != nil {
+		return *v.Price
+	}
+	return product.Price
+}
+
+// Warehouse holds stock in one or more locations. Checkout allocates from
+// warehouses with a lower Priority first.
+type Warehouse struct {
 	ID        int       `json:"id"`
-	FirstName string    `json:"firstName"`
-	LastName  string    `json:"lastName"`
-	Email     string    `json:"email"`
-	Password  string    `json:"-"`
+	Code      string    `json:"code"`
+	Name      string    `json:"name"`
+	Priority  int       `json:"priority"`
 	CreatedAt time.Time `json:"createdAt"`
 }
 
+// WarehouseDetail is a warehouse with its locations.
+type WarehouseDetail struct {
+	Warehouse
+	Locations []Location `json:"locations"`
+}
+
+// Location is a place in a warehouse stock is kept in, like an aisle or a bin.
+type Location struct {
+	ID          int       `json:"id"`
+	WarehouseID int       `json:"warehouseID"`
+	Code        string    `json:"code"`
+	Name        string    `json:"name"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type StockMovementType string
+
+const (
+	MovementReceipt    StockMovementType = "receipt"
+	MovementSale       StockMovementType = "sale"
+	MovementReturn     StockMovementType = "return"
+	MovementAdjustment StockMovementType = "adjustment"
+	MovementTransfer   StockMovementType = "transfer"
+)
+
+func (t StockMovementType) IsValid() bool {
+	switch t {
+	case MovementReceipt, MovementSale, MovementReturn, MovementAdjustment, MovementTransfer:
+		return true
+	}
+	return false
+}
+
+// StockMovement is an entry of the stock ledger. Quantity is positive for
+// stock coming into the location and negative for stock leaving it; a
+// transfer is two movements. Sales and their returns keep the OrderID.
+type StockMovement struct {
+	ID         int               `json:"id"`
+	VariantID  int               `json:"variantID"`
+	LocationID int               `json:"locationID"`
+	Type       StockMovementType `json:"type"`
+	Quantity   int               `json:"quantity"`
+	OrderID    *int              `json:"orderID"`
+	Note       string            `json:"note"`
+	CreatedBy  *int              `json:"createdBy"`
+	CreatedAt  time.Time         `json:"createdAt"`
+}
+
+// MovementQuery selects a page of the ledger, newest first. Zero values mean
+// no filter.
+type MovementQuery struct {
+	VariantID  int
+	LocationID int
+	Limit      int
+	Offset     int
+}
+
+// StockLevel is the stock on hand of a variant at a location, with the
+// priority of the location's warehouse.
+type StockLevel struct {
+	VariantID   int `json:"variantID"`
+	LocationID  int `json:"locationID"`
+	WarehouseID int `json:"warehouseID"`
+	Priority    int `json:"priority"`
+	Quantity    int `json:"quantity"`
+}
+
+// Allocation is the stock a sale takes from a location.
+type Allocation struct {
+	LocationID int
+	Quantity   int
+}
+
+// ProductDetail is a product with its options and variants.
+type ProductDetail struct {
+	Product
+	Options  []ProductOption  `json:"options"`
+	Variants []ProductVariant `json:"variants"`
+}
+
+// ProductSortKeys are the product fields listings can be sorted by.
+var ProductSortKeys = []string{"id", "name", "price", "createdAt"}
+
+// ProductQuery selects a page of the catalog. Zero values mean no filter;
+// CategoryID also matches the category's descendants. Pages are either Offset
+// products in, or start after the After cursor.
+type ProductQuery struct {
+	MinPrice     *Money
+	MaxPrice     *Money
+	InStock      bool
+	CreatedSince *time.Time
+	CategoryID   int
+	SortBy       string
+	Desc         bool
+	Limit        int
+	Offset       int
+	After        *ProductCursor
+}
+
+// ProductCursor marks the last product of a page: its value for the sort key
+// and its ID, which breaks ties.
+type ProductCursor struct {
+	SortBy string `json:"s"`
+	Desc   bool   `json:"d"`
+	Value  string `json:"v"`
+	ID     int    `json:"i"`
+}
+
+// SearchMatch is a product found by a SearchIndex, most relevant first.
+// Highlights holds the matching fields with the matched words wrapped in
+// <mark> tags, HTML escaped.
+type SearchMatch struct {
+	ProductID  int
+	Score      float64
+	Highlights map[string]string
+}
+
+// SearchResult is a SearchMatch with its product, as sent to clients.
+type SearchResult struct {
+	Product    Product           `json:"product"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+// Category is a node of the category tree. Categories without a parent are
+// at the top; siblings are shown in order of Position, then ID.
+type Category struct {
+	ID        int       `json:"id"`
+	ParentID  *int      `json:"parentId"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	Position  int       `json:"position"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CategoryNode is a category with its subcategories, as in the nested tree.
+type CategoryNode struct {
+	Category
+	Children []CategoryNode `json:"children"`
+}
+
+type User struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+	Password  string `json:"-"`
+	Role      Role   `json:"role"`
+	// ServiceAccount users only act through API keys: they cannot log in or
+	// reset a password.
+	ServiceAccount bool       `json:"serviceAccount"`
+	VerifiedAt     *time.Time `json:"verifiedAt"`
+	// TOTPSecret is set once two-factor setup starts; it is only in use
+	// when TwoFactorEnabledAt is set.
+	TOTPSecret         string     `json:"-"`
+	TwoFactorEnabledAt *time.Time `json:"twoFactorEnabledAt"`
+	// DeletionDueAt is set while the user waits out the grace period before
+	// their account is erased, and DeletedAt once it has been.
+	DeletionDueAt *time.Time `json:"deletionDueAt"`
+	DeletedAt     *time.Time `json:"-"`
+	CreatedAt     time.Time  `json:"createdAt"`
+}
+
+// UserExport is everything kept about a user, as handed to them on request.
+type UserExport struct {
+	ExportedAt time.Time     `json:"exportedAt"`
+	Profile    User          `json:"profile"`
+	Addresses  []Address     `json:"addresses"`
+	Orders     []OrderDetail `json:"orders"`
+}
+
+type Address struct {
+	ID                int       `json:"id"`
+	UserID            int       `json:"userID"`
+	Name              string    `json:"name"`
+	Line1             string    `json:"line1"`
+	Line2             string    `json:"line2"`
+	City              string    `json:"city"`
+	Region            string    `json:"region"`
+	PostalCode        string    `json:"postalCode"`
+	Country           string    `json:"country"`
+	IsDefaultShipping bool      `json:"isDefaultShipping"`
+	IsDefaultBilling  bool      `json:"isDefaultBilling"`
+	CreatedAt         time.Time `json:"createdAt"`
+}
+
+// Format renders the address as the multi-line text stored on orders.
+func (a Address) Format() string {
+	lines := []string{a.Name, a.Line1}
+	if a.Line2 != "" {
+		lines = append(lines, a.Line2)
+	}
+
+	city := a.City
+	if a.Region != "" {
+		city += ", " + a.Region
+	}
+	lines = append(lines, city+" "+a.PostalCode, a.Country)
+
+	return strings.Join(lines, "\n")
+}
+
+type AddressPayload struct {
+	Name              string `json:"name" validate:"required,max=255"`
+	Line1             string `json:"line1" validate:"required,max=255"`
+	Line2             string `json:"line2" validate:"max=255"`
+	City              string `json:"city" validate:"required,max=255"`
+	Region            string `json:"region" validate:"max=255"`
+	PostalCode        string `json:"postalCode" validate:"required,max=32"`
+	Country           string `json:"country" validate:"required,iso3166_1_alpha2"`
+	IsDefaultShipping bool   `json:"isDefaultShipping"`
+	IsDefaultBilling  bool   `json:"isDefaultBilling"`
+}
+
+func (p AddressPayload) ToAddress(userID int) Address {
+	return Address{
+		UserID:            userID,
+		Name:              p.Name,
+		Line1:             p.Line1,
+		Line2:             p.Line2,
+		City:              p.City,
+		Region:            p.Region,
+		PostalCode:        p.PostalCode,
+		Country:           p.Country,
+		IsDefaultShipping: p.IsDefaultShipping,
+		IsDefaultBilling:  p.IsDefaultBilling,
+	}
+}
+
+// RefreshToken is a single-use token exchanged for a new access and refresh
+// token pair. Every token rotated from the same login shares a FamilyID, so a
+// reused token can sign out the whole chain. Only a hash of the token is kept.
+type RefreshToken struct {
+	ID              int
+	UserID          int
+	FamilyID        string
+	TokenHash       string
+	AccessTokenID   string
+	AccessExpiresAt time.Time
+	ExpiresAt       time.Time
+	UsedAt          *time.Time
+	RevokedAt       *time.Time
+	CreatedAt       time.Time
+}
+
+type UserTokenPurpose string
+
+const (
+	UserTokenPasswordReset     UserTokenPurpose = "password_reset"
+	UserTokenEmailVerification UserTokenPurpose = "email_verification"
+	UserTokenTwoFactorLogin    UserTokenPurpose = "two_factor_login"
+)
+
+// UserToken is a single-use token emailed to a user, e.g. to reset their
+// password or verify their email. Only a hash of the token is kept.
+type UserToken struct {
+	ID        int
+	UserID    int
+	Purpose   UserTokenPurpose
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// Scope limits what an API key may do. Sessions are not scoped.
+type Scope string
+
+const (
+	ScopeProductsWrite Scope = "products:write"
+	ScopeOrdersRead    Scope = "orders:read"
+	ScopeOrdersWrite   Scope = "orders:write"
+)
+
+func (s Scope) IsValid() bool {
+	switch s {
+	case ScopeProductsWrite, ScopeOrdersRead, ScopeOrdersWrite:
+		return true
+	}
+	return false
+}
+
+// APIKey lets an integration act as its owner, a user or service account,
+// within its scopes. Prefix is the start of the key, kept to tell keys apart.
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"userID"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []Scope    `json:"scopes"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+func (k APIKey) HasScope(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CreatedAPIKey is returned once, when a key is created; afterwards only its
+// prefix can be seen.
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type Email struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// TokenPair is returned on login and refresh. Token is the access token.
+type TokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int64  `json:"expiresIn"`
+}
+
+// TwoFactorChallenge is returned by login instead of tokens when the user has
+// two-factor authentication enabled. The token is exchanged for access and
+// refresh tokens together with a TOTP or recovery code.
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"twoFactorRequired"`
+	TwoFactorToken    string `json:"twoFactorToken"`
+}
+
+// TwoFactorSetup holds a new TOTP secret and the otpauth:// URI to show as a
+// QR code.
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauthURI"`
+}
+
+// RecoveryCodes are shown once, when two-factor authentication is enabled.
+type RecoveryCodes struct {
+	Codes []string `json:"recoveryCodes"`
+}
+
+// IdempotencyKey records the first response to a request made with a given
+// Idempotency-Key header. A StatusCode of 0 means the request is in flight.
+type IdempotencyKey struct {
+	UserID       int
+	Key          string
+	RequestHash  string
+	StatusCode   int
+	ResponseBody []byte
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+// UpdateProductPayload is a partial product update. Fields left out of the
+// request are nil and keep their current value. Stock is not part of it: it
+// only changes through movements in the inventory.
+type UpdateProductPayload struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Image       *string `json:"image"`
+	Price       *Money  `json:"price"`
+}
+
+// Apply copies the fields set in the payload onto product.
+func (p UpdateProductPayload) Apply(product *Product) {
+	if p.Name != nil {
+		product.Name = *p.Name
+	}
+	if p.Description != nil {
+		product.Description = *p.Description
+	}
+	if p.Image != nil {
+		product.Image = *p.Image
+	}
+	if p.Price != nil {
+		product.Price = *p.Price
+	}
+}
+
+// SetVariantsPayload replaces the options and variants of a product. Variants
+// with an ID are updated, those without one are created, and existing
+// variants left out are deleted.
+type SetVariantsPayload struct {
+	Options  []ProductOptionPayload  `json:"options" validate:"dive"`
+	Variants []ProductVariantPayload `json:"variants" validate:"required,min=1,dive"`
+}
+
+type ProductOptionPayload struct {
+	Name   string   `json:"name" validate:"required,max=64"`
+	Values []string `json:"values" validate:"required,min=1,dive,required,max=64"`
+}
+
+// ProductVariantPayload has a value for each of the product's options. Price
+// is left out for variants sold at the product's price. Stock is received
+// through the inventory.
+type ProductVariantPayload struct {
+	ID      int               `json:"id"`
+	SKU     string            `json:"sku" validate:"required,max=64"`
+	Price   *Money            `json:"price"`
+	Image   string            `json:"image" validate:"max=255"`
+	Options map[string]string `json:"options"`
+}
+
+type WarehousePayload struct {
+	Code     string `json:"code" validate:"required,max=32"`
+	Name     string `json:"name" validate:"required,max=255"`
+	Priority int    `json:"priority"`
+}
+
+type LocationPayload struct {
+	Code string `json:"code" validate:"required,max=32"`
+	Name string `json:"name" validate:"required,max=255"`
+}
+
+// StockAdjustmentPayload records stock received into a location, or a
+// correction of its stock either way, e.g. after counting it.
+type StockAdjustmentPayload struct {
+	VariantID  int               `json:"variantID" validate:"required"`
+	LocationID int               `json:"locationID" validate:"required"`
+	Type       StockMovementType `json:"type" validate:"required,oneof=receipt adjustment"`
+	Quantity   int               `json:"quantity" validate:"required"`
+	Note       string            `json:"note" validate:"max=255"`
+}
+
+// StockTransferPayload moves stock of a variant between two locations.
+type StockTransferPayload struct {
+	VariantID      int    `json:"variantID" validate:"required"`
+	FromLocationID int    `json:"fromLocationID" validate:"required"`
+	ToLocationID   int    `json:"toLocationID" validate:"required,nefield=FromLocationID"`
+	Quantity       int    `json:"quantity" validate:"required,gt=0"`
+	Note           string `json:"note" validate:"max=255"`
+}
+
+// UpdateOrderStatusPayload is used by staff to move an order along its
+// lifecycle.
+type UpdateOrderStatusPayload struct {
+	Status OrderStatus `json:"status" validate:"required"`
+	Note   string      `json:"note" validate:"max=255"`
+}
+
+type RefreshTokenPayload struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+type ForgotPasswordPayload struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordPayload struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=3,max=130"`
+}
+
+// UpdateProfilePayload is a partial profile update. Fields left out of the
+// request are nil and keep their current value. Changing the email takes the
+// current password too.
+type UpdateProfilePayload struct {
+	FirstName       *string `json:"firstName" validate:"omitempty,min=1,max=255"`
+	LastName        *string `json:"lastName" validate:"omitempty,min=1,max=255"`
+	Email           *string `json:"email" validate:"omitempty,email,max=255"`
+	CurrentPassword string  `json:"currentPassword"`
+}
+
+type ChangePasswordPayload struct {
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+	NewPassword     string `json:"newPassword" validate:"required,min=3,max=130"`
+}
+
+// DeleteAccountPayload confirms the user's identity before their account is
+// scheduled for erasure. Code is a TOTP code, needed with two-factor
+// authentication enabled.
+type DeleteAccountPayload struct {
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+	Code            string `json:"code" validate:"omitempty,len=6,numeric"`
+}
+
+type TwoFactorConfirmPayload struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+// TwoFactorLoginPayload completes a login with either a TOTP code or a
+// recovery code.
+type TwoFactorLoginPayload struct {
+	TwoFactorToken string `json:"twoFactorToken" validate:"required"`
+	Code           string `json:"code" validate:"required_without=RecoveryCode,excluded_with=RecoveryCode"`
+	RecoveryCode   string `json:"recoveryCode"`
+}
+
+// CategoryPayload creates or replaces a category. The slug is derived from
+// the name when left out.
+type CategoryPayload struct {
+	ParentID *int   `json:"parentId"`
+	Name     string `json:"name" validate:"required,max=255"`
+	Slug     string `json:"slug" validate:"max=255"`
+	Position int    `json:"position"`
+}
+
+// SetProductCategoriesPayload replaces the categories a product is in.
+type SetProductCategoriesPayload struct {
+	CategoryIDs []int `json:"categoryIds" validate:"required"`
+}
+
+// CreateAPIKeyPayload creates a key for the caller, or for UserID when an
+// admin creates it.
+type CreateAPIKeyPayload struct {
+	Name      string     `json:"name" validate:"required,max=255"`
+	Scopes    []Scope    `json:"scopes" validate:"required,min=1"`
+	UserID    int        `json:"userID"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// CreateServiceAccountPayload creates a user that can only act through API
+// keys. The email should reach whoever runs the integration.
+type CreateServiceAccountPayload struct {
+	Name  string `json:"name" validate:"required,max=255"`
+	Email string `json:"email" validate:"required,email,max=255"`
+	Role  Role   `json:"role" validate:"required"`
+}
+
 type RegisterUserPayload struct {
 	FirstName string `json:"firstName" validate:"required"`
 	LastName  string `json:"lastName" validate:"required"`
@@ -71,11 +981,19 @@ type LoginUserPayload struct {
 	Password string `json:"password" validate:"required,min=3,max=130"`
 }
 
+// CartItem is a quantity of a product variant. A product with a single
+// variant may be given by its ProductID alone.
 type CartItem struct {
 	ProductID int `json:"productID"`
+	VariantID int `json:"variantID"`
 	Quantity  int `json:"quantity"`
 }
 
+// CartCheckoutPayload ships either to a new address or to one of the user's
+// saved addresses. When neither is given the user's default shipping address
+// is used.
 type CartCheckoutPayload struct {
-	Items []CartItem `json:"items" validate:"required"`
+	Items             []CartItem      `json:"items" validate:"required"`
+	ShippingAddress   *AddressPayload `json:"shippingAddress" validate:"omitempty,excluded_with=ShippingAddressID"`
+	ShippingAddressID int             `json:"shippingAddressID" validate:"omitempty,gt=0"`
 }
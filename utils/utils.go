@@ -3,13 +3,24 @@ package utils
 import (
 	"encoding/json"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/joshbarros/golang-ecommerce-api/config"
 )
 
 var Validate = validator.New()
 
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
 func ParseJSON(r *http.Request, payload any) error {
 	if r.Body == nil {
 		return fmt.Errorf("Missing request body!")
@@ -28,3 +39,95 @@ func WriteJSON(w http.ResponseWriter, status int, v any) error {
 func WriteError(w http.ResponseWriter, status int, err error) {
 	WriteJSON(w, status, map[string]string{"error": err.Error()})
 }
+
+// WriteTooManyRequests answers 429 with a Retry-After header telling the
+// client how many seconds to wait.
+func WriteTooManyRequests(w http.ResponseWriter, retryAfter time.Duration, err error) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	WriteError(w, http.StatusTooManyRequests, err)
+}
+
+// ClientIP returns the IP address the request came from. X-Forwarded-For is
+// only followed through the proxies listed in TRUSTED_PROXIES, since any
+// client can set it: the address returned is the last one in the chain that
+// is not a trusted proxy.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !trustedProxy(host) {
+		return host
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		host = hop
+		if !trustedProxy(hop) {
+			break
+		}
+	}
+
+	return host
+}
+
+// trustedProxy reports whether TRUSTED_PROXIES lists ip, as an address or
+// within a CIDR range.
+func trustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+
+	for _, proxy := range strings.Split(config.Envs.TrustedProxies, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if other := net.ParseIP(proxy); other != nil && other.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePagination reads the page and limit query parameters, defaulting to the
+// first page of DefaultPageSize items and capping limit at MaxPageSize. Pages
+// whose offset, (page-1)*limit, would not fit in an int32 are rejected.
+func ParsePagination(r *http.Request) (page int, limit int, err error) {
+	page, limit = 1, DefaultPageSize
+
+	if v := r.URL.Query().Get("page"); v != "" {
+		page, err = strconv.Atoi(v)
+		if err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("Invalid page %q", v)
+		}
+	}
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return 0, 0, fmt.Errorf("Invalid limit %q", v)
+		}
+	}
+
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	if page-1 > math.MaxInt32/limit {
+		return 0, 0, fmt.Errorf("Page %d is out of range", page)
+	}
+
+	return page, limit, nil
+}
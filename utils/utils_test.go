@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joshbarros/golang-ecommerce-api/config"
+)
+
+func TestClientIP(t *testing.T) {
+	config.Envs.TrustedProxies = "10.0.0.0/8, 192.168.1.1"
+	t.Cleanup(func() { config.Envs.TrustedProxies = "" })
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		expected   string
+	}{
+		{"direct client", "203.0.113.7:5123", nil, "203.0.113.7"},
+		{"forwarded header from an untrusted client", "203.0.113.7:5123", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:443", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed hop before the proxy", "10.1.2.3:443", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "192.168.1.1:443", []string{"198.51.100.1, 10.0.0.5", "10.9.9.9"}, "198.51.100.1"},
+		{"trusted proxy without the header", "10.1.2.3:443", nil, "10.1.2.3"},
+		{"malformed hop", "10.1.2.3:443", []string{"198.51.100.1, garbage"}, "10.1.2.3"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for _, v := range tc.forwarded {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+
+			if ip := ClientIP(req); ip != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, ip)
+			}
+		})
+	}
+}
+
+func TestParsePagination(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		page  int
+		limit int
+		fails bool
+	}{
+		{"defaults", "", 1, DefaultPageSize, false},
+		{"page and limit", "page=3&limit=5", 3, 5, false},
+		{"limit above the maximum", "limit=1000", 1, MaxPageSize, false},
+		{"page zero", "page=0", 0, 0, true},
+		{"negative limit", "limit=-1", 0, 0, true},
+		{"page whose offset overflows", fmt.Sprintf("page=%d&limit=100", math.MaxInt64), 0, 0, true},
+		{"page past the largest offset", fmt.Sprintf("page=%d&limit=100", math.MaxInt32/100+2), 0, 0, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/?"+tc.query, nil)
+
+			page, limit, err := ParsePagination(req)
+			if tc.fails {
+				if err == nil {
+					t.Errorf("Expected an error, got page %d and limit %d", page, limit)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page != tc.page || limit != tc.limit {
+				t.Errorf("Expected page %d and limit %d, got %d and %d", tc.page, tc.limit, page, limit)
+			}
+		})
+	}
+}